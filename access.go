@@ -0,0 +1,112 @@
+package geerpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// accessList 连接的访问控制列表，创建后只读
+type accessList struct {
+	allow []*net.IPNet // 为空表示允许所有地址
+	deny  []*net.IPNet
+}
+
+// SetAccessLists 设置连接的白名单与黑名单，可以在服务期间随时调用，只影响之后接受的连接
+// 每一项为 CIDR（如 "10.0.0.0/8"、"fd00::/8"）或单个 IP；黑名单优先于白名单，白名单为空表示允许所有地址
+// 非 IP 地址的连接（如 unix socket）不受限制。列表中有无法解析的项时返回错误，原有配置保持不变
+func (server *Server) SetAccessLists(allow, deny []string) error {
+	list := new(accessList)
+	var err error
+	if list.allow, err = parseCIDRs(allow); err != nil {
+		return err
+	}
+	if list.deny, err = parseCIDRs(deny); err != nil {
+		return err
+	}
+	if len(list.allow) == 0 && len(list.deny) == 0 {
+		list = nil
+	}
+	server.access.Store(list)
+	return nil
+}
+
+// allowed 判断是否接受来自 addr 的连接
+func (server *Server) allowed(addr net.Addr) bool {
+	list, _ := server.access.Load().(*accessList)
+	if list == nil {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return true
+	}
+	return list.allows(ip)
+}
+
+func (l *accessList) allows(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4 // IPv4-mapped IPv6 地址按 IPv4 匹配
+	}
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs 解析 CIDR 或单个 IP，IPv4-mapped IPv6 网段转换为对应的 IPv4 网段
+func parseCIDRs(items []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("rpc server: invalid IP address %q in access list", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: invalid CIDR %q in access list", item)
+		}
+		if ones, bits := n.Mask.Size(); bits == 128 && ones >= 96 && n.IP.To4() != nil {
+			n = &net.IPNet{IP: n.IP.To4(), Mask: net.CIDRMask(ones-96, 32)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// accept 检查新连接是否在访问控制列表允许的范围内，拒绝时关闭连接并返回 false
+func (server *Server) accept(conn net.Conn) bool {
+	if server.allowed(conn.RemoteAddr()) {
+		return true
+	}
+	atomic.AddUint64(&server.stats.denied, 1)
+	server.log().Debug("rpc server: connection denied by access list", "remote", conn.RemoteAddr())
+	_ = conn.Close()
+	return false
+}
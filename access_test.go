@@ -0,0 +1,106 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_AccessLists(t *testing.T) {
+	dial := func(t *testing.T, server *Server) error {
+		client, err := Dial("tcp", waitForAddr(t, server))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	}
+	start := func(t *testing.T, opts ServerOptions) *Server {
+		server := NewServerWithOptions(opts)
+		assert.Nil(t, server.Register(new(Foo)))
+		go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+		return server
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		server := start(t, ServerOptions{AllowCIDRs: []string{"127.0.0.0/8"}})
+		defer func() { _ = server.Close() }()
+		assert.Nil(t, dial(t, server))
+		assert.Equal(t, uint64(0), server.Stats().DeniedConnections)
+	})
+	t.Run("not in allowlist", func(t *testing.T) {
+		server := start(t, ServerOptions{AllowCIDRs: []string{"10.0.0.0/8"}})
+		defer func() { _ = server.Close() }()
+		assert.NotNil(t, dial(t, server))
+		assert.Equal(t, uint64(1), server.Stats().DeniedConnections)
+	})
+	t.Run("deny takes precedence", func(t *testing.T) {
+		server := start(t, ServerOptions{AllowCIDRs: []string{"127.0.0.0/8"}, DenyCIDRs: []string{"127.0.0.1"}})
+		defer func() { _ = server.Close() }()
+		assert.NotNil(t, dial(t, server))
+		assert.Equal(t, uint64(1), server.Stats().DeniedConnections)
+	})
+	t.Run("update at runtime", func(t *testing.T) {
+		server := start(t, ServerOptions{})
+		defer func() { _ = server.Close() }()
+		assert.Nil(t, dial(t, server))
+		assert.Nil(t, server.SetAccessLists(nil, []string{"127.0.0.0/8"}))
+		assert.NotNil(t, dial(t, server))
+		assert.EqualError(t, server.SetAccessLists([]string{"bad"}, nil), `rpc server: invalid IP address "bad" in access list`)
+		assert.NotNil(t, dial(t, server), "invalid lists leave the previous configuration in place")
+		assert.Nil(t, server.SetAccessLists(nil, nil))
+		assert.Nil(t, dial(t, server))
+		assert.Equal(t, uint64(2), server.Stats().DeniedConnections)
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		server := NewServerWithOptions(ServerOptions{DenyCIDRs: []string{"10.0.0.0/33"}})
+		const msg = `rpc server: invalid CIDR "10.0.0.0/33" in access list`
+		assert.EqualError(t, server.ListenAndServe("tcp", "127.0.0.1:0"), msg)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		assert.EqualError(t, server.Serve(l), msg)
+		_, err = l.Accept()
+		assert.NotNil(t, err, "the listener is closed")
+
+		// ServeConn 不服务连接，直接关闭
+		client, conn := net.Pipe()
+		go server.ServeConn(conn)
+		_, err = NewClient(client, DefaultOption)
+		if err == nil {
+			_, err = client.Read(make([]byte, 1))
+		}
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAccessList_Allows(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.SetAccessLists(
+		[]string{"127.0.0.0/8", "::1", "::ffff:10.0.0.0/104", "fd00::/8"},
+		[]string{"10.1.0.0/16", "fd00::bad"},
+	))
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"127.0.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::1", true},
+		{"::2", false},
+		{"10.2.3.4", true},
+		{"::ffff:10.2.3.4", true},
+		{"10.1.2.3", false},
+		{"::ffff:10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"fd00::1", true},
+		{"fd00::bad", false},
+	}
+	for _, tt := range tests {
+		addr := &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 1234}
+		assert.Equal(t, tt.allowed, server.allowed(addr), tt.ip)
+	}
+	assert.True(t, server.allowed(&net.UnixAddr{Name: "/tmp/rpc.sock", Net: "unix"}), "non-IP addresses are not restricted")
+}
@@ -0,0 +1,58 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// AccessEntry 描述一次处理完成的请求
+type AccessEntry struct {
+	ServiceMethod string
+	RemoteAddr    net.Addr      // 对端地址，连接不是 net.Conn 时为 nil
+	Duration      time.Duration // 从开始处理到方法返回（或超时）的时间
+	Err           error         // 方法返回的错误，处理超时时为 ErrHandleTimeout
+}
+
+// accessLogBox 包装回调，使 atomic.Value 可以存储 nil 回调
+type accessLogBox struct {
+	f func(ctx context.Context, e AccessEntry)
+}
+
+// SetAccessLog 设置请求处理完成后的回调，f 为 nil 表示不再回调
+// ctx 为请求的 context，包含 Propagator 传递的值与令牌对应的身份，可以用于输出请求ID等字段；
+// 回调在发送响应的 goroutine 中同步调用，应尽快返回。没有调用到方法的请求（如方法不存在、参数校验失败）不会回调；
+// 请求量较大时可以用 SetAccessLogSampling 只记录部分请求
+func (server *Server) SetAccessLog(f func(ctx context.Context, e AccessEntry)) {
+	server.accessLog.Store(accessLogBox{f: f})
+}
+
+// finishRequest 在请求处理完成、响应发送之后结束服务端 span 并调用 SetAccessLog 设置的回调，回调 panic 时仅记录日志
+func (server *Server) finishRequest(ctx context.Context, sc *serverConn, serviceMethod string, d time.Duration, err error) {
+	server.endSpan(ctx, err)
+	box, _ := server.accessLog.Load().(accessLogBox)
+	if box.f == nil {
+		return
+	}
+	atomic.AddUint64(&server.stats.logTotal, 1)
+	if s, _ := server.sampling.Load().(*sampler); !s.sample(serviceMethod, d, err) {
+		return
+	}
+	atomic.AddUint64(&server.stats.logged, 1)
+	defer func() {
+		if r := recover(); r != nil {
+			sc.log.Error("rpc server: access log panic", "method", serviceMethod, "panic", r)
+		}
+	}()
+	box.f(ctx, AccessEntry{ServiceMethod: serviceMethod, RemoteAddr: sc.info.RemoteAddr, Duration: d, Err: err})
+}
+
+// requestContext 返回请求的 context，附加了 Propagator 传递的值、令牌对应的身份与调用链的 span
+func (server *Server) requestContext(ctx context.Context, sc *serverConn, req *request) context.Context {
+	ctx = server.extract(ctx, req.h.Metadata)
+	if req.identity != nil {
+		ctx = context.WithValue(ctx, identityKey{}, req.identity)
+	}
+	return server.startSpan(ctx, sc, req)
+}
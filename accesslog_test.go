@@ -0,0 +1,50 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_SetAccessLog(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(&Cooperative{exited: make(chan error, 1)}))
+	entries := make(chan AccessEntry, 10)
+	server.SetAccessLog(func(ctx context.Context, e AccessEntry) {
+		entries <- e
+		if e.ServiceMethod == "Foo.Sum" {
+			panic("recovered by the server")
+		}
+	})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	e := <-entries
+	assert.Equal(t, "Foo.Sum", e.ServiceMethod)
+	assert.Nil(t, e.Err)
+	assert.NotNil(t, e.RemoteAddr)
+
+	assert.NotNil(t, client.Call(ctx, "Cooperative.Wait", time.Second, &reply))
+	e = <-entries
+	assert.Equal(t, "Cooperative.Wait", e.ServiceMethod)
+	assert.True(t, errors.Is(e.Err, ErrHandleTimeout), e.Err)
+	assert.True(t, e.Duration >= 50*time.Millisecond, e.Duration)
+
+	// 没有调用到方法的请求不回调
+	assert.NotNil(t, client.Call(ctx, "Foo.Missing", Args{}, &reply))
+	server.SetAccessLog(nil)
+	assert.Nil(t, client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 0, len(entries))
+}
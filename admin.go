@@ -0,0 +1,148 @@
+package geerpc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AdminService EnableAdmin 注册的管理服务的名称
+const AdminService = "Admin"
+
+// AdminLogLevel Admin.SetLogLevel 的参数
+type AdminLogLevel struct {
+	Level string // 如 "debug"、"warn"，见 ParseLogLevel
+}
+
+// AdminSlowThreshold Admin.SetSlowThreshold 的参数
+type AdminSlowThreshold struct {
+	Threshold time.Duration // 0 表示只上报处理超时的请求
+}
+
+// AdminRateLimit Admin.SetRateLimit 的参数，也用于 AdminConfig 中列出服务的并发限制
+type AdminRateLimit struct {
+	Service       string        // 服务名，带版本的服务为 "Foo@v2"
+	MaxConcurrent int           // 0 表示取消限制
+	MaxWait       time.Duration // 见 ServiceOptions.MaxWait
+}
+
+// AdminConfig Admin.GetConfig 的应答
+type AdminConfig struct {
+	Options  ServerOptions    // 生效中的服务器配置，SlowRequestThreshold 为当前的值，不包括 SigningKeys
+	LogLevel string           // 当前的日志级别
+	Limits   []AdminRateLimit // 设置了并发限制的服务，按服务名排序
+}
+
+// adminService 在运行时修改服务器配置的管理服务
+type adminService struct {
+	server    *Server
+	authorize func(id Identity) error
+}
+
+// EnableAdmin 注册名为 AdminService 的管理服务，可以在运行时修改日志级别、慢请求阈值与服务的并发限制
+// 管理服务的方法只接受携带有效令牌的请求，需要先调用 EnableTokenAuth；authorize 不为 nil 时还需要通过它的检查，
+// 返回错误时拒绝请求。每次修改都会以 Info 级别输出到日志，包括调用方的身份，管理服务的请求不会被访问日志采样掉
+func (server *Server) EnableAdmin(authorize func(id Identity) error) error {
+	return server.RegisterName(AdminService, &adminService{server: server, authorize: authorize})
+}
+
+// check 检查调用方的身份并返回
+func (a *adminService) check(ctx context.Context) (Identity, error) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	if a.authorize != nil {
+		if err := a.authorize(id); err != nil {
+			return Identity{}, fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+		}
+	}
+	return id, nil
+}
+
+// changed 记录一次配置修改
+func (a *adminService) changed(id Identity, setting string, keyvals ...interface{}) {
+	keyvals = append([]interface{}{"setting", setting, "subject", id.Subject}, keyvals...)
+	a.server.log().Info("rpc server: admin config changed", keyvals...)
+}
+
+// SetLogLevel 修改服务器的日志级别
+func (a *adminService) SetLogLevel(ctx context.Context, args AdminLogLevel, reply *bool) error {
+	id, err := a.check(ctx)
+	if err != nil {
+		return err
+	}
+	level, err := ParseLogLevel(args.Level)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	old := a.server.LogLevel()
+	// 在新旧级别中较低的一个生效时输出日志，尽量保证修改本身被记录
+	if level < old {
+		a.server.SetLogLevel(level)
+		a.changed(id, "log_level", "old", old, "new", level)
+	} else {
+		a.changed(id, "log_level", "old", old, "new", level)
+		a.server.SetLogLevel(level)
+	}
+	*reply = true
+	return nil
+}
+
+// SetSlowThreshold 修改慢请求阈值，保留之前设置的上报回调
+func (a *adminService) SetSlowThreshold(ctx context.Context, args AdminSlowThreshold, reply *bool) error {
+	id, err := a.check(ctx)
+	if err != nil {
+		return err
+	}
+	if args.Threshold < 0 {
+		return fmt.Errorf("%w: negative threshold %s", ErrInvalidArgument, args.Threshold)
+	}
+	var report func(SlowRequest)
+	if cfg, _ := a.server.slow.Load().(*slowConfig); cfg != nil {
+		report = cfg.report
+	}
+	a.changed(id, "slow_threshold", "old", a.server.slowThreshold(), "new", args.Threshold)
+	a.server.SetSlowRequestThreshold(args.Threshold, report)
+	*reply = true
+	return nil
+}
+
+// SetRateLimit 修改服务的并发限制，见 Server.SetServiceLimit
+func (a *adminService) SetRateLimit(ctx context.Context, args AdminRateLimit, reply *bool) error {
+	id, err := a.check(ctx)
+	if err != nil {
+		return err
+	}
+	if args.MaxConcurrent < 0 || args.MaxWait < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidArgument)
+	}
+	if err := a.server.SetServiceLimit(args.Service, args.MaxConcurrent, args.MaxWait); err != nil {
+		return err
+	}
+	a.changed(id, "rate_limit", "service", args.Service, "max_concurrent", args.MaxConcurrent, "max_wait", args.MaxWait)
+	*reply = true
+	return nil
+}
+
+// GetConfig 返回生效中的配置，参数不使用
+func (a *adminService) GetConfig(ctx context.Context, _ string, reply *AdminConfig) error {
+	if _, err := a.check(ctx); err != nil {
+		return err
+	}
+	opts := a.server.opts
+	opts.SigningKeys = nil
+	opts.SlowRequestThreshold = a.server.slowThreshold()
+	cfg := AdminConfig{Options: opts, LogLevel: a.server.LogLevel().String()}
+	a.server.serviceMap.Range(func(key, val interface{}) bool {
+		if l, _ := val.(*service).limit.Load().(*serviceLimit); l != nil {
+			max, maxWait := l.limits()
+			cfg.Limits = append(cfg.Limits, AdminRateLimit{Service: key.(string), MaxConcurrent: max, MaxWait: maxWait})
+		}
+		return true
+	})
+	sort.Slice(cfg.Limits, func(i, j int) bool { return cfg.Limits[i].Service < cfg.Limits[j].Service })
+	*reply = cfg
+	return nil
+}
@@ -0,0 +1,157 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startAdminServer(t *testing.T) (*Server, *recordLogger, chan SlowRequest) {
+	tokens := NewMemoryTokens(time.Hour, func(cred Credentials) (Identity, error) {
+		return Identity{Subject: cred.Username}, nil
+	})
+	server := NewServerWithOptions(ServerOptions{DefaultHandleTimeout: time.Second, SigningKeys: [][]byte{[]byte("k")}})
+	assert.Nil(t, server.Register(new(Stat)))
+	assert.Nil(t, server.RegisterWithOptions(new(Report), ServiceOptions{MaxConcurrent: 1}))
+	assert.Nil(t, server.EnableTokenAuth(tokens))
+	assert.Nil(t, server.EnableAdmin(func(id Identity) error {
+		if id.Subject != "alice" {
+			return errors.New("not an admin")
+		}
+		return nil
+	}))
+	logs := new(recordLogger)
+	server.SetLogger(logs)
+	slow := make(chan SlowRequest, 10)
+	server.SetSlowRequestThreshold(0, func(r SlowRequest) { slow <- r })
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	waitForAddr(t, server)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, logs, slow
+}
+
+func dialAdmin(t *testing.T, server *Server, user string) *Client {
+	client, err := Dial("tcp", server.Addr().String(), &Option{MagicNumber: MagicNumber, SigningKeys: [][]byte{[]byte("k")}})
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	client.UseTokenAuth(NewTokenAuth(Credentials{Username: user}))
+	return client
+}
+
+func TestAdmin_SetLogLevel(t *testing.T) {
+	server, logs, _ := startAdminServer(t)
+	client := dialAdmin(t, server, "alice")
+	ctx := context.Background()
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "Stat.Fast", 1, &reply))
+	_, ok := logs.find("rpc server: handle request")
+	assert.False(t, ok, "debug logs are filtered at the default level")
+
+	var done bool
+	assert.Nil(t, client.Call(ctx, "Admin.SetLogLevel", AdminLogLevel{Level: "debug"}, &done))
+	assert.True(t, done)
+	assert.Equal(t, LogLevelDebug, server.LogLevel())
+	e, ok := logs.find("rpc server: admin config changed")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", e.fields["subject"])
+	assert.Equal(t, "log_level", e.fields["setting"])
+
+	assert.Nil(t, client.Call(ctx, "Stat.Fast", 1, &reply))
+	_, ok = logs.find("rpc server: handle request")
+	assert.True(t, ok, "debug logs are written after the change")
+
+	err := client.Call(ctx, "Admin.SetLogLevel", AdminLogLevel{Level: "verbose"}, &done)
+	assert.True(t, errors.Is(err, ErrInvalidArgument), err)
+	assert.Equal(t, LogLevelDebug, server.LogLevel())
+}
+
+func TestAdmin_SetSlowThreshold(t *testing.T) {
+	server, _, slow := startAdminServer(t)
+	client := dialAdmin(t, server, "alice")
+	ctx := context.Background()
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "Stat.Slow", 20*time.Millisecond, &reply))
+	var done bool
+	assert.Nil(t, client.Call(ctx, "Admin.SetSlowThreshold", AdminSlowThreshold{Threshold: 10 * time.Millisecond}, &done))
+	assert.Equal(t, 0, len(slow), "no slow requests are reported before the change")
+
+	assert.Nil(t, client.Call(ctx, "Stat.Fast", 1, &reply))
+	assert.Nil(t, client.Call(ctx, "Stat.Slow", 20*time.Millisecond, &reply))
+	r := <-slow
+	assert.Equal(t, "Stat.Slow", r.ServiceMethod)
+	assert.Equal(t, 0, len(slow), "the fast request stays under the new threshold")
+}
+
+func TestAdmin_SetRateLimitAndGetConfig(t *testing.T) {
+	server, _, _ := startAdminServer(t)
+	client := dialAdmin(t, server, "alice")
+	ctx := context.Background()
+
+	var done bool
+	assert.Nil(t, client.Call(ctx, "Admin.SetRateLimit", AdminRateLimit{Service: "Report", MaxConcurrent: 2}, &done))
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, client.Call(ctx, "Report.Generate", 50*time.Millisecond, new(int)))
+		}()
+	}
+	wg.Wait()
+	err := client.Call(ctx, "Admin.SetRateLimit", AdminRateLimit{Service: "Missing", MaxConcurrent: 1}, &done)
+	assert.NotNil(t, err)
+
+	var cfg AdminConfig
+	assert.Nil(t, client.Call(ctx, "Admin.GetConfig", "", &cfg))
+	assert.Equal(t, "INFO", cfg.LogLevel)
+	assert.Equal(t, time.Second, cfg.Options.DefaultHandleTimeout)
+	assert.Nil(t, cfg.Options.SigningKeys, "signing keys are never returned")
+	assert.Equal(t, []AdminRateLimit{{Service: "Report", MaxConcurrent: 2}}, cfg.Limits)
+}
+
+func TestAdmin_Authorization(t *testing.T) {
+	server, _, _ := startAdminServer(t)
+	entries := make(chan string, 10)
+	server.SetAccessLog(func(ctx context.Context, e AccessEntry) {
+		id, _ := IdentityFromContext(ctx)
+		entries <- e.ServiceMethod + " " + id.Subject
+	})
+	server.SetAccessLogSampling(&AccessLogSampling{Rate: 0})
+	ctx := context.Background()
+
+	var done bool
+	bob := dialAdmin(t, server, "bob")
+	err := bob.Call(ctx, "Admin.SetLogLevel", AdminLogLevel{Level: "error"}, &done)
+	assert.True(t, errors.Is(err, ErrPermissionDenied), err)
+	assert.Equal(t, LogLevelInfo, server.LogLevel())
+
+	alice := dialAdmin(t, server, "alice")
+	assert.Nil(t, alice.Call(ctx, "Admin.SetLogLevel", AdminLogLevel{Level: "error"}, &done))
+	assert.Equal(t, LogLevelError, server.LogLevel())
+	var reply int
+	assert.Nil(t, alice.Call(ctx, "Stat.Fast", 1, &reply))
+
+	// 管理服务的请求不会被采样掉，访问日志中可以取得调用方的身份
+	assert.Equal(t, "Admin.SetLogLevel bob", <-entries)
+	assert.Equal(t, "Admin.SetLogLevel alice", <-entries)
+	// 两次登录与 Stat.Fast 被采样掉，但仍然计入 AccessLogTotal
+	assert.Eventually(t, func() bool { return server.Stats().AccessLogTotal == 5 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(2), server.Stats().AccessLogged)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for s, want := range map[string]LogLevel{"debug": LogLevelDebug, "INFO": LogLevelInfo, "Warning": LogLevelWarn, "error": LogLevelError} {
+		l, err := ParseLogLevel(s)
+		assert.Nil(t, err)
+		assert.Equal(t, want, l)
+	}
+	_, err := ParseLogLevel("trace")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,227 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenMetadata 请求头 Metadata 中令牌使用的键名
+const tokenMetadata = "authorization"
+
+// authService 令牌认证服务注册使用的服务名
+const authService = "Auth"
+
+var (
+	// ErrUnauthenticated 请求没有携带令牌或令牌无效，可以用 errors.Is 判断
+	ErrUnauthenticated = errors.New("rpc server: unauthenticated")
+	// ErrTokenExpired 令牌已过期，客户端启用 TokenAuth 时会自动重新登录
+	ErrTokenExpired = errors.New("rpc server: token expired")
+)
+
+// Credentials Auth.Login 的参数
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Token Auth.Login 的应答
+type Token struct {
+	Value     string
+	ExpiresAt time.Time // 零值表示不过期
+}
+
+// Identity 令牌对应的调用方身份，方法可以通过 IdentityFromContext 获取
+type Identity struct {
+	Subject string
+	Claims  map[string]string
+}
+
+// TokenValidator 签发并校验令牌，可以替换为 JWT 等实现
+type TokenValidator interface {
+	// Login 校验凭据并签发令牌
+	Login(cred Credentials) (Token, error)
+	// Validate 校验令牌并返回调用方的身份，令牌过期时返回 ErrTokenExpired
+	Validate(token string) (Identity, error)
+}
+
+// tokenAuth 服务端的令牌认证配置
+type tokenAuth struct {
+	validator TokenValidator
+	public    map[string]bool // 不需要令牌的服务
+}
+
+// Auth 令牌认证服务，由 EnableTokenAuth 注册
+type Auth struct {
+	validator TokenValidator
+}
+
+// Login 校验凭据并签发令牌
+func (a *Auth) Login(cred Credentials, token *Token) error {
+	t, err := a.validator.Login(cred)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	*token = t
+	return nil
+}
+
+// EnableTokenAuth 注册 Auth 服务并要求之后的每个请求都在元数据中携带有效的令牌
+// Auth 服务与 public 中列出的服务（如健康检查服务）不需要令牌；令牌对应的身份通过 IdentityFromContext 获取
+func (server *Server) EnableTokenAuth(v TokenValidator, public ...string) error {
+	if err := server.RegisterName(authService, &Auth{validator: v}); err != nil {
+		return err
+	}
+	a := &tokenAuth{validator: v, public: map[string]bool{authService: true}}
+	for _, name := range public {
+		a.public[name] = true
+	}
+	server.tokenAuth.Store(a)
+	return nil
+}
+
+// authenticate 校验请求携带的令牌，通过时将身份记录到请求中
+func (server *Server) authenticate(sc *serverConn, req *request) error {
+	a, _ := server.tokenAuth.Load().(*tokenAuth)
+	if a == nil || a.public[req.svc.name] {
+		return nil
+	}
+	token := req.h.Metadata[tokenMetadata]
+	if token == "" {
+		return ErrUnauthenticated
+	}
+	id, err := a.validator.Validate(token)
+	if err != nil {
+		sc.log.Debug("rpc server: invalid token", "seq", req.h.Seq, "method", req.h.ServiceMethod, "err", err)
+		if errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrUnauthenticated) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	req.identity = &id
+	return nil
+}
+
+type identityKey struct{}
+
+// IdentityFromContext 返回请求令牌对应的身份，服务器没有启用令牌认证或方法不需要令牌时 ok 为 false
+func IdentityFromContext(ctx context.Context) (id Identity, ok bool) {
+	p, ok := ctx.Value(identityKey{}).(*Identity)
+	if !ok {
+		return Identity{}, false
+	}
+	return *p, true
+}
+
+// MemoryTokens 在内存中签发随机令牌的 TokenValidator，令牌在服务器重启后失效
+type MemoryTokens struct {
+	ttl   time.Duration
+	check func(cred Credentials) (Identity, error)
+
+	mu     sync.Mutex
+	tokens map[string]memoryToken
+}
+
+type memoryToken struct {
+	id      Identity
+	expires time.Time
+}
+
+// NewMemoryTokens 返回 MemoryTokens，check 校验凭据并返回对应的身份，签发的令牌在 ttl 后过期
+func NewMemoryTokens(ttl time.Duration, check func(cred Credentials) (Identity, error)) *MemoryTokens {
+	return &MemoryTokens{ttl: ttl, check: check, tokens: make(map[string]memoryToken)}
+}
+
+// Login 校验凭据并签发新令牌，同时清理已过期的令牌
+func (m *MemoryTokens) Login(cred Credentials) (Token, error) {
+	id, err := m.check(cred)
+	if err != nil {
+		return Token{}, err
+	}
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return Token{}, err
+	}
+	t := Token{Value: hex.EncodeToString(b[:]), ExpiresAt: time.Now().Add(m.ttl)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, v := range m.tokens {
+		if !now.Before(v.expires) {
+			delete(m.tokens, k)
+		}
+	}
+	m.tokens[t.Value] = memoryToken{id: id, expires: t.ExpiresAt}
+	return t, nil
+}
+
+// Validate 校验令牌并返回签发时的身份
+func (m *MemoryTokens) Validate(token string) (Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[token]
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	if !time.Now().Before(t.expires) {
+		delete(m.tokens, token)
+		return Identity{}, ErrTokenExpired
+	}
+	return t.id, nil
+}
+
+// Expire 使令牌立即过期，持有该令牌的客户端下次调用时会收到 ErrTokenExpired
+func (m *MemoryTokens) Expire(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tokens[token]; ok {
+		t.expires = time.Now()
+		m.tokens[token] = t
+	}
+}
+
+// TokenAuth 客户端的令牌认证，第一次调用前使用 Credentials 调用 Auth.Login 获取令牌，之后每个调用自动携带
+// Call 收到 ErrTokenExpired 时重新登录并重试一次；Go 与流式调用只携带当前的令牌，不会重试
+type TokenAuth struct {
+	cred Credentials
+
+	mu    sync.Mutex // 同一时刻只有一个调用在登录
+	token string
+}
+
+// NewTokenAuth 返回使用 cred 登录的 TokenAuth，通过 Client.UseTokenAuth 启用
+func NewTokenAuth(cred Credentials) *TokenAuth {
+	return &TokenAuth{cred: cred}
+}
+
+// UseTokenAuth 为客户端之后的所有调用启用令牌认证，a 为 nil 表示不再携带令牌
+func (client *Client) UseTokenAuth(a *TokenAuth) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.auth = a
+}
+
+// attachToken 在调用的元数据中设置令牌，还没有令牌或令牌为 stale 时先登录
+func (client *Client) attachToken(ctx context.Context, call *Call, stale string) (string, error) {
+	client.mu.Lock()
+	a := client.auth
+	client.mu.Unlock()
+	if a == nil || call.ServiceMethod == authService+".Login" {
+		return "", nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" || a.token == stale {
+		var t Token
+		if err := client.call(ctx, newCall(authService+".Login", a.cred, &t, nil, nil)); err != nil {
+			return "", err
+		}
+		a.token = t.Value
+	}
+	WithMetadata(tokenMetadata, a.token)(call)
+	return a.token, nil
+}
@@ -0,0 +1,104 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Profile int
+
+func (p Profile) Whoami(ctx context.Context, args struct{}, reply *string) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return errors.New("no identity")
+	}
+	*reply = id.Subject + "/" + id.Claims["tenant"]
+	return nil
+}
+
+func startAuthServer(t *testing.T) (*Server, *MemoryTokens, *int32) {
+	var logins int32
+	tokens := NewMemoryTokens(time.Hour, func(cred Credentials) (Identity, error) {
+		atomic.AddInt32(&logins, 1)
+		if cred.Password != "secret" {
+			return Identity{}, errors.New("wrong password")
+		}
+		return Identity{Subject: cred.Username, Claims: map[string]string{"tenant": "acme"}}, nil
+	})
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Profile)))
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(new(Numbers)))
+	assert.Nil(t, server.EnableTokenAuth(tokens, "Foo"))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	waitForAddr(t, server)
+	return server, tokens, &logins
+}
+
+func TestTokenAuth(t *testing.T) {
+	server, tokens, logins := startAuthServer(t)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply string
+	err = client.Call(ctx, "Profile.Whoami", struct{}{}, &reply)
+	assert.True(t, errors.Is(err, ErrUnauthenticated), "no token")
+	var sum int
+	assert.Nil(t, client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum), "public service")
+
+	client.UseTokenAuth(NewTokenAuth(Credentials{Username: "alice", Password: "secret"}))
+	assert.Nil(t, client.Call(ctx, "Profile.Whoami", struct{}{}, &reply))
+	assert.Equal(t, "alice/acme", reply)
+	assert.Nil(t, client.Call(ctx, "Profile.Whoami", struct{}{}, &reply))
+	assert.Equal(t, int32(1), atomic.LoadInt32(logins), "the token is reused")
+	token := client.auth.token
+
+	// 令牌在服务端过期后，客户端重新登录并重试
+	tokens.Expire(token)
+	reply = ""
+	assert.Nil(t, client.Call(ctx, "Profile.Whoami", struct{}{}, &reply))
+	assert.Equal(t, "alice/acme", reply)
+	assert.Equal(t, int32(2), atomic.LoadInt32(logins))
+	assert.NotEqual(t, token, client.auth.token)
+
+	// 流式调用与 Go 同样携带令牌
+	stream, err := client.CallStream(ctx, "Numbers.Count", CountArgs{N: 2})
+	assert.Nil(t, err)
+	for err == nil {
+		err = stream.Recv(new(int))
+	}
+	assert.Equal(t, io.EOF, err)
+	call := <-client.Go("Profile.Whoami", struct{}{}, &reply, nil).Done
+	assert.Nil(t, call.Error)
+
+	// 其他连接上使用同一令牌
+	other, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = other.Close() }()
+	err = other.Call(ctx, "Profile.Whoami", struct{}{}, &reply, WithMetadata(tokenMetadata, client.auth.token))
+	assert.Nil(t, err)
+	err = other.Call(ctx, "Profile.Whoami", struct{}{}, &reply, WithMetadata(tokenMetadata, "forged"))
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+}
+
+func TestTokenAuth_LoginFailed(t *testing.T) {
+	server, _, _ := startAuthServer(t)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	client.UseTokenAuth(NewTokenAuth(Credentials{Username: "alice", Password: "guess"}))
+	err = client.Call(context.Background(), "Profile.Whoami", struct{}{}, new(string))
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+	assert.EqualError(t, err, "rpc server: unauthenticated: wrong password")
+}
@@ -0,0 +1,128 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// defaultBroadcastTimeout Broadcast 写入单个连接的默认超时时间
+const defaultBroadcastTimeout = time.Second
+
+// ErrBroadcastTimeout 连接在超时时间内没有完成推送时 Broadcast 记录的错误
+var ErrBroadcastTimeout = errors.New("rpc server: broadcast write timeout")
+
+// Broadcast 向所有已完成握手且满足 filter 的连接推送一帧消息，filter 为 nil 时推送给所有连接
+// 推送帧的 Seq 为0，ServiceMethod 为 method，客户端通过 Client.OnPush 接收
+// 各连接并发写入，写入慢的连接不会阻塞其他连接；忙于发送其他数据的连接超时后被跳过，写入超时的连接会被关闭，避免写入一半的帧破坏后续数据
+// 返回每个被推送连接的结果，键为连接ID，成功时为 nil
+func (server *Server) Broadcast(method string, body interface{}, filter func(ConnInfo) bool) map[uint64]error {
+	timeout := server.opts.BroadcastTimeout
+	if timeout <= 0 {
+		timeout = defaultBroadcastTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.broadcast(ctx, method, body, filter, true)
+}
+
+// broadcast 向满足 filter 的连接推送一帧，ctx 结束时还没有拿到 sending 锁的连接被跳过
+// closeOnTimeout 为 true 时写入同样受 ctx 的截止时间限制，没有按时写完的连接会被关闭
+func (server *Server) broadcast(ctx context.Context, method string, body interface{}, filter func(ConnInfo) bool, closeOnTimeout bool) map[uint64]error {
+	server.mu.Lock()
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	server.mu.Unlock()
+	targets := conns[:0]
+	for _, sc := range conns {
+		if filter == nil || filter(sc.info) {
+			targets = append(targets, sc)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[uint64]error, len(targets))
+	for _, sc := range targets {
+		wg.Add(1)
+		go func(sc *serverConn) {
+			defer wg.Done()
+			err := server.push(ctx, sc, method, body, closeOnTimeout)
+			if err == errNotReady {
+				return
+			}
+			mu.Lock()
+			results[sc.info.ID] = err
+			mu.Unlock()
+		}(sc)
+	}
+	wg.Wait()
+	return results
+}
+
+// errNotReady 连接尚未完成握手，跳过推送
+var errNotReady = errors.New("rpc server: connection not ready")
+
+// push 在 ctx 结束前向连接推送一帧
+// ctx 结束前没有拿到 sending 锁（例如连接正在发送一个很大的响应）时跳过该连接并返回 ErrBroadcastTimeout，连接不受影响；
+// closeOnTimeout 为 true 时开始写入后设置写超时，写入没有按时完成的连接被关闭，避免写入一半的帧破坏后续数据，
+// 否则不等待已经开始的写入完成，也不关闭连接；任何情况下写入本身失败都会关闭连接
+func (server *Server) push(ctx context.Context, sc *serverConn, method string, body interface{}, closeOnTimeout bool) error {
+	deadline, hasDeadline := ctx.Deadline()
+	var state int32 // pushWaiting、pushWriting 或 pushSkipped
+	done := make(chan error, 1)
+	go func() {
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		if !atomic.CompareAndSwapInt32(&state, pushWaiting, pushWriting) {
+			return
+		}
+		if sc.codec == nil {
+			done <- errNotReady
+			return
+		}
+		if nc, _ := sc.rwc.(net.Conn); nc != nil && closeOnTimeout && hasDeadline {
+			_ = nc.SetWriteDeadline(deadline)
+			defer func() { _ = nc.SetWriteDeadline(time.Time{}) }()
+		}
+		err := sc.codec.Write(&codec.Header{ServiceMethod: method, HasBody: true}, body)
+		if err != nil {
+			sc.log.Warn("rpc server: push failed, closing connection", "method", method, "err", err)
+			_ = sc.rwc.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = ErrBroadcastTimeout
+		}
+		return err
+	case <-ctx.Done():
+	}
+	if atomic.CompareAndSwapInt32(&state, pushWaiting, pushSkipped) {
+		sc.log.Debug("rpc server: push skipped, connection is busy", "method", method)
+		return ErrBroadcastTimeout
+	}
+	if closeOnTimeout {
+		// 写入已经开始但没有按时完成，帧可能只写了一半，关闭连接；rwc 不支持写超时时也借此中断写入
+		sc.log.Warn("rpc server: push timed out, closing connection", "method", method)
+		_ = sc.rwc.Close()
+	}
+	return ErrBroadcastTimeout
+}
+
+// push 中推送的状态
+const (
+	pushWaiting int32 = iota // 等待 sending 锁
+	pushWriting              // 已经拿到锁并开始写入
+	pushSkipped              // 等待锁超时，放弃推送
+)
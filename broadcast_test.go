@@ -0,0 +1,107 @@
+package geerpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+func TestServer_Broadcast(t *testing.T) {
+	server := NewServerWithOptions(ServerOptions{BroadcastTimeout: time.Second})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	event := bytes.Repeat([]byte("e"), 8<<20) // 足够大，能填满不读取数据的连接的缓冲区
+	received := make(chan string, 3)
+	var locals []string
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		client, err := NewClient(conn, DefaultOption)
+		assert.Nil(t, err)
+		defer func() { _ = client.Close() }()
+		client.OnPush(func(method string, decode func(interface{}) error) {
+			var body []byte
+			if err := decode(&body); err == nil && bytes.Equal(body, event) {
+				received <- method
+			}
+		})
+		locals = append(locals, conn.LocalAddr().String())
+	}
+	// 完成握手后不再读取数据的连接
+	stalled, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = stalled.Close() }()
+	assert.Nil(t, json.NewEncoder(stalled).Encode(DefaultOption))
+	time.Sleep(100 * time.Millisecond)
+
+	ids := make(map[string]uint64)
+	start := time.Now()
+	results := server.Broadcast("Event.Notify", event, func(info ConnInfo) bool {
+		ids[info.RemoteAddr.String()] = info.ID
+		return info.RemoteAddr.String() != locals[0] // 排除第一个客户端
+	})
+	assert.True(t, time.Since(start) < 2*time.Second, "a stalled connection must not block the broadcast")
+
+	assert.Equal(t, 3, len(results))
+	_, ok := results[ids[locals[0]]]
+	assert.False(t, ok, "filtered connection must not be pushed")
+	assert.Nil(t, results[ids[locals[1]]])
+	assert.Nil(t, results[ids[locals[2]]])
+	assert.Equal(t, ErrBroadcastTimeout, results[ids[stalled.LocalAddr().String()]])
+
+	for i := 0; i < 2; i++ {
+		select {
+		case method := <-received:
+			assert.Equal(t, "Event.Notify", method)
+		case <-time.After(2 * time.Second):
+			t.Fatal("client did not receive the broadcast")
+		}
+	}
+	select {
+	case <-received:
+		t.Fatal("filtered client received the broadcast")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServer_BroadcastSkipsBusyConn(t *testing.T) {
+	server := NewServerWithOptions(ServerOptions{BroadcastTimeout: 100 * time.Millisecond})
+	assert.Nil(t, server.Register(new(Blob)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, json.NewEncoder(conn).Encode(DefaultOption))
+	time.Sleep(50 * time.Millisecond)
+
+	// 请求一个填满缓冲区的响应后暂不读取，服务端一直持有 sending 锁
+	size := 8 << 20
+	cc := codec.NewGobCodec(conn)
+	assert.Nil(t, cc.Write(&codec.Header{ServiceMethod: "Blob.Repeat", Seq: 1, HasBody: true}, size))
+	time.Sleep(200 * time.Millisecond)
+
+	results := server.Broadcast("Event.Notify", "event", nil)
+	assert.Equal(t, 1, len(results))
+	for _, err := range results {
+		assert.Equal(t, ErrBroadcastTimeout, err)
+	}
+
+	// 被跳过的连接没有关闭，响应完整送达
+	var h codec.Header
+	var reply string
+	assert.Nil(t, cc.ReadHeader(&h))
+	assert.Equal(t, "", h.Error)
+	assert.Nil(t, cc.ReadBody(&reply))
+	assert.Equal(t, size, len(reply))
+}
@@ -0,0 +1,24 @@
+package geerpc
+
+import "context"
+
+// Caller 按服务名与方法名发起调用的客户端，cmd/geerpc-gen 生成的客户端通过该接口调用；
+// *xclient.XClient 实现了该接口，*Client 使用 ClientCaller 转换
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+// clientCaller 通过 *Client 调用的 Caller
+type clientCaller struct {
+	client *Client
+	opts   []CallOption
+}
+
+// ClientCaller 返回通过 client 调用的 Caller，每次调用都使用 opts
+func ClientCaller(client *Client, opts ...CallOption) Caller {
+	return &clientCaller{client: client, opts: opts}
+}
+
+func (c *clientCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return c.client.Call(ctx, serviceMethod, args, reply, c.opts...)
+}
@@ -0,0 +1,28 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCaller(t *testing.T) {
+	server, tokens, _ := startAuthServer(t)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	token, err := tokens.Login(Credentials{Username: "alice", Password: "secret"})
+	assert.Nil(t, err)
+
+	var reply string
+	err = ClientCaller(client).Call(context.Background(), "Profile.Whoami", struct{}{}, &reply)
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+
+	// 每次调用都使用创建时传入的选项
+	caller := ClientCaller(client, WithMetadata(tokenMetadata, token.Value))
+	assert.Nil(t, caller.Call(context.Background(), "Profile.Whoami", struct{}{}, &reply))
+	assert.Equal(t, "alice/acme", reply)
+}
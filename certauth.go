@@ -0,0 +1,49 @@
+package geerpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPermissionDenied 请求未通过授权时返回的错误，可以用 errors.Is 判断，连接不会因此断开
+var ErrPermissionDenied = errors.New("rpc server: permission denied")
+
+// CertAuthorizer 按客户端证书授权请求，chains 为连接验证通过的证书链（非 TLS 连接或客户端没有提供证书时为 nil）
+// 返回错误时拒绝该请求
+type CertAuthorizer func(chains [][]*x509.Certificate, serviceMethod string) error
+
+// SetCertAuthorizer 设置按客户端证书授权请求的回调，每个请求在调用方法之前检查一次，f 为 nil 表示不检查
+// 需要验证客户端证书时，在 ServeTLS 的 config 中设置 ClientAuth 为 tls.RequireAndVerifyClientCert 并提供 ClientCAs
+func (server *Server) SetCertAuthorizer(f CertAuthorizer) {
+	server.certAuth.Store(f)
+}
+
+// authorize 调用 CertAuthorizer，回调 panic 同样视为拒绝
+func (server *Server) authorize(sc *serverConn, serviceMethod string) (err error) {
+	f, _ := server.certAuth.Load().(CertAuthorizer)
+	if f == nil {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: authorizer panic: %v", ErrPermissionDenied, r)
+		}
+	}()
+	if err = f(sc.info.VerifiedChains, serviceMethod); err != nil {
+		sc.log.Debug("rpc server: permission denied", "method", serviceMethod, "err", err)
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	}
+	return nil
+}
+
+// tlsHandshake 在服务连接之前完成 TLS 握手，以便在 ConnInfo 中记录客户端的证书链
+func (server *Server) tlsHandshake(conn *tls.Conn) error {
+	if server.opts.HandshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(server.opts.HandshakeTimeout))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	return conn.Handshake()
+}
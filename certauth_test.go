@@ -0,0 +1,91 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_SetCertAuthorizer(t *testing.T) {
+	ca := newTestCA(t)
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(new(Numbers)))
+	connected := make(chan ConnInfo, 3)
+	server.OnConnect(func(conn ConnInfo) (context.Context, error) {
+		connected <- conn
+		return nil, nil
+	})
+	server.SetCertAuthorizer(func(chains [][]*x509.Certificate, serviceMethod string) error {
+		if len(chains) == 0 {
+			return errors.New("no client certificate")
+		}
+		if cn := chains[0][0].Subject.CommonName; serviceMethod == "Foo.Sum" && cn != "alice" {
+			return errors.New(cn + " may not call " + serviceMethod)
+		}
+		return nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		_ = server.ServeTLS(l, &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, "localhost")},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.pool,
+		})
+	}()
+	defer func() { _ = server.Close() }()
+	addr := waitForAddr(t, server)
+
+	dial := func(t *testing.T, cn string) *Client {
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue(t, cn)}})
+		assert.Nil(t, err)
+		assert.Equal(t, cn, (<-connected).VerifiedChains[0][0].Subject.CommonName)
+		return client
+	}
+	count := func(client *Client) error {
+		stream, err := client.CallStream(context.Background(), "Numbers.Count", CountArgs{N: 3})
+		if err != nil {
+			return err
+		}
+		for err == nil {
+			err = stream.Recv(new(int))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	t.Run("allowed identity", func(t *testing.T) {
+		client := dial(t, "alice")
+		defer func() { _ = client.Close() }()
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 3, reply)
+		assert.Nil(t, count(client))
+	})
+	t.Run("denied identity", func(t *testing.T) {
+		client := dial(t, "bob")
+		defer func() { _ = client.Close() }()
+		err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+		assert.True(t, errors.Is(err, ErrPermissionDenied))
+		assert.EqualError(t, err, "rpc server: permission denied: bob may not call Foo.Sum")
+		assert.Nil(t, count(client), "other methods and the connection remain usable")
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		if err == nil {
+			// TLS 1.3 中客户端在服务端验证证书之前就完成了握手，错误在第一次调用时出现
+			err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+			_ = client.Close()
+		}
+		assert.NotNil(t, err)
+	})
+}
@@ -3,6 +3,7 @@ package geerpc
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,8 +11,11 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"runtime/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yqchilde/gee-rpc/codec"
@@ -19,14 +23,46 @@ import (
 
 type Call struct {
 	Seq           uint64
-	ServiceMethod string      // format "<service>.<method>"
-	Args          interface{} // 函数参数
-	Reply         interface{} // 函数回复
-	Error         error       // 发生错误时set
-	Done          chan *Call  // 会话完成时通知对方
+	ServiceMethod string            // format "<service>.<method>"
+	Args          interface{}       // 函数参数
+	Reply         interface{}       // 函数回复
+	Error         error             // 发生错误时set
+	Done          chan *Call        // 会话完成时通知对方
+	Priority      uint8             // 请求优先级，数值越大越优先，只在服务端排队时生效
+	Metadata      map[string]string // 随请求发送的元数据
+
+	stream   *ClientStream // 流式调用，不为 nil 时调用结束通知 stream 而不是 Done
+	deadline time.Time     // 调用 ctx 的截止时间，发送时换算为剩余时间传递给服务端
+}
+
+// CallOption 单次调用的选项
+type CallOption func(*Call)
+
+// WithMetadata 在请求的元数据中设置 key 为 value
+func WithMetadata(key, value string) CallOption {
+	return func(call *Call) {
+		if call.Metadata == nil {
+			call.Metadata = make(map[string]string)
+		}
+		call.Metadata[key] = value
+	}
+}
+
+// WithPriority 设置请求优先级，服务端启用 worker 池或服务并发限制且需要排队时，优先处理高优先级的请求
+func WithPriority(p uint8) CallOption {
+	return func(call *Call) { call.Priority = p }
 }
 
 func (c *Call) done() {
+	if c.stream != nil {
+		err := c.Error
+		if err == io.EOF {
+			// 流式调用中 io.EOF 表示正常结束，连接断开需要与之区分
+			err = io.ErrUnexpectedEOF
+		}
+		c.stream.finish(err)
+		return
+	}
 	c.Done <- c
 }
 
@@ -40,12 +76,32 @@ type Client struct {
 	pending  map[uint64]*Call // 存储未处理完的请求，键是编号，值是Call实例
 	closing  bool             // 用户主动关闭的，为true时Client处于不可用的转态
 	shutdown bool             // 为true时一般是有错误发生，为true时Client处于不可用的转态
+	rejected error            // 服务端拒绝握手时的错误，之后的调用都返回该错误
+	br       *bufio.Reader    // 编解码器读取的缓冲，用于识别服务端的拒绝帧，为nil时不检查
+	onPush   func(method string, decode func(interface{}) error)
+	auth     *TokenAuth    // 令牌认证，为nil时调用不携带令牌
+	drained  bool          // 服务端已通知连接即将关闭
+	draining chan struct{} // drained 为 true 时关闭
+	dynamic  sync.Map      // CallDynamic 通过反射服务获取的方法类型，键为 serviceMethod
+
+	propagators atomic.Value // []Propagator，SetPropagators 设置的 Propagator
+	spanHook    atomic.Value // spanHookBox，客户端 span 的回调
+	peer        string       // 服务端地址，用于 span
 }
 
 var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// HandshakeError 服务端拒绝握手（如 MagicNumber 或 CodecType 不匹配）时返回的错误
+type HandshakeError struct {
+	Reason string // 服务端给出的原因
+}
+
+func (e *HandshakeError) Error() string {
+	return "rpc client: server rejected handshake: " + e.Reason
+}
+
 // Close 关闭连接
 func (client *Client) Close() error {
 	client.mu.Lock()
@@ -57,18 +113,34 @@ func (client *Client) Close() error {
 	return client.c.Close()
 }
 
-// IsAvailable 检查客户端是否被关闭
+// IsAvailable 检查客户端是否可用，客户端被关闭、连接断开或服务端通知正在关闭时返回 false
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.drained
+}
+
+// Pending 返回已经发出、还没有收到响应的调用数
+func (client *Client) Pending() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
 }
 
 // registerCall 将参数call添加到client.pending中，并更新client.seq
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if client.rejected != nil {
+		return 0, client.rejected
+	}
+	if client.closing {
+		return 0, ErrShutdown
+	}
+	if client.drained {
+		return 0, ErrServerDraining
+	}
+	if client.shutdown {
 		return 0, ErrShutdown
 	}
 	call.Seq = client.seq
@@ -94,6 +166,9 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
+	if _, ok := err.(*HandshakeError); ok {
+		client.rejected = err
+	}
 	for _, call := range client.pending {
 		call.Error = err
 		call.done()
@@ -101,6 +176,14 @@ func (client *Client) terminateCalls(err error) {
 }
 
 func (client *Client) send(call *Call) {
+	if err := client.write(call); err != nil {
+		call.Error = err
+		call.done()
+	}
+}
+
+// write 注册并发送请求，返回错误时 call 已不在 pending 中
+func (client *Client) write(call *Call) error {
 	// make sure that the client will send a complete request
 	client.sending.Lock()
 	defer client.sending.Unlock()
@@ -108,42 +191,92 @@ func (client *Client) send(call *Call) {
 	// register this call
 	seq, err := client.registerCall(call)
 	if err != nil {
-		call.Error = err
-		call.done()
-		return
+		return err
 	}
 
 	// parse request header
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.HasBody = true
+	client.header.Priority = call.Priority
+	client.header.Stream = call.stream != nil
+	client.header.More = call.stream != nil && call.stream.sending
+	client.header.Metadata = call.Metadata
+	client.header.Timeout = 0
+	if !call.deadline.IsZero() {
+		client.header.Timeout = int64(time.Until(call.deadline))
+		if client.header.Timeout <= 0 {
+			client.header.Timeout = 1 // 已经过期，服务端不再执行方法
+		}
+	}
 
 	// encode and send the request
 	if err := client.c.Write(&client.header, call.Args); err != nil {
-		call := client.removeCall(seq)
-		// call可能为nil，这通常意味着写入部分失败，客户端已收到响应并已处理
-		if call != nil {
-			call.Error = err
-			call.done()
+		// call可能已被移除，这通常意味着写入部分失败，客户端已收到响应并已处理
+		if client.removeCall(seq) != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// writeFrame 发送一个不对应新请求的帧，如流式调用的后续帧
+func (client *Client) writeFrame(h *codec.Header, body interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return client.c.Write(h, body)
+}
+
+// serverError 将响应头中的错误转换为 *ServerError
+func serverError(h *codec.Header) error {
+	return &ServerError{
+		Code:       ErrorCode(h.Code),
+		Message:    h.Error,
+		RetryAfter: time.Duration(h.RetryAfter) * time.Millisecond,
+	}
 }
 
 func (client *Client) receive() {
 	var err error
+	if client.br != nil {
+		err = readRejection(client.br)
+	}
 	for err == nil {
 		var h codec.Header
 		if err = client.c.ReadHeader(&h); err != nil {
+			if err == ErrSignatureInvalid {
+				err = client.invalidSignature(&h)
+				continue
+			}
 			break
 		}
+		if h.Stream {
+			err = client.receiveStream(&h)
+			continue
+		}
 		call := client.removeCall(h.Seq)
 		switch {
+		case call == nil && h.Seq == 0 && h.ServiceMethod == drainMethod:
+			err = client.drain(&h)
+		case call == nil && h.Seq == 0:
+			err = client.push(&h)
 		case call == nil:
 			err = client.c.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = serverError(&h)
 			err = client.c.ReadBody(nil)
 			call.done()
+		case h.Compressed:
+			var data []byte
+			if err = client.c.ReadBody(&data); err == nil {
+				if derr := codec.DecompressBody(client.opt.CodecType, data, call.Reply); derr != nil {
+					call.Error = errors.New("reading body " + derr.Error())
+				}
+			} else {
+				call.Error = errors.New("reading body " + err.Error())
+			}
+			call.done()
 		default:
 			err = client.c.ReadBody(call.Reply)
 			if err != nil {
@@ -157,7 +290,65 @@ func (client *Client) receive() {
 	client.terminateCalls(err)
 }
 
-func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+// OnPush 设置接收服务端推送（Server.Broadcast）的回调
+// f 在接收响应的 goroutine 中同步调用，decode 只能在 f 返回前调用一次，用于解码推送的内容；
+// f 不应阻塞，否则会延迟后续响应的接收。未设置回调时推送的内容被丢弃
+func (client *Client) OnPush(f func(method string, decode func(interface{}) error)) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.onPush = f
+}
+
+// push 将推送帧交给 OnPush 回调，返回读取body时的错误
+func (client *Client) push(h *codec.Header) error {
+	client.mu.Lock()
+	f := client.onPush
+	client.mu.Unlock()
+	if f == nil {
+		return client.c.ReadBody(nil)
+	}
+	var read bool
+	var err error
+	f(h.ServiceMethod, func(v interface{}) error {
+		if read {
+			return errors.New("rpc client: push body already decoded")
+		}
+		read = true
+		err = client.c.ReadBody(v)
+		return err
+	})
+	if !read {
+		return client.c.ReadBody(nil)
+	}
+	return err
+}
+
+// readRejection 检查服务端发送的第一帧是否为拒绝帧，是则返回 *HandshakeError
+// 旧版本的服务端不会发送拒绝帧，此时只会直接关闭连接
+func readRejection(br *bufio.Reader) error {
+	b, _ := br.Peek(len(rejectPrefix))
+	if string(b) != rejectPrefix {
+		return nil
+	}
+	var r rejection
+	if err := json.NewDecoder(br).Decode(&r); err != nil {
+		return err
+	}
+	return &HandshakeError{Reason: r.Reason}
+}
+
+func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	call := newCall(serviceMethod, args, reply, done, opts)
+	if _, err := client.attachToken(context.Background(), call, ""); err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+	client.send(call)
+	return call
+}
+
+func newCall(serviceMethod string, args, reply interface{}, done chan *Call, opts []CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -169,16 +360,54 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Reply:         reply,
 		Done:          done,
 	}
-	client.send(call)
+	for _, opt := range opts {
+		opt(call)
+	}
 	return call
 }
 
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+// Call 调用方法并等待结果，ctx 带有截止时间时，剩余时间随请求传递给服务端，服务端据此限制方法的处理时间
+// 启用 TokenAuth 时，令牌过期会重新登录并重试一次
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) (err error) {
+	ctx, span := client.startSpan(ctx, serviceMethod)
+	defer func() { span.end(ctx, err) }()
+	call := newCall(serviceMethod, args, reply, make(chan *Call, 1), opts)
+	client.inject(ctx, call)
+	token, err := client.attachToken(ctx, call, "")
+	if err != nil {
+		return err
+	}
+	err = client.call(ctx, call)
+	if token != "" && errors.Is(err, ErrTokenExpired) {
+		call = newCall(serviceMethod, args, reply, make(chan *Call, 1), opts)
+		client.inject(ctx, call)
+		if _, err = client.attachToken(ctx, call, token); err != nil {
+			return err
+		}
+		err = client.call(ctx, call)
+	}
+	return err
+}
+
+// call 发送 call 并等待结果，启用了 EnablePprofLabels 时等待期间附加 pprof 标签
+func (client *Client) call(ctx context.Context, call *Call) (err error) {
+	if client.opt.EnablePprofLabels {
+		pprof.Do(ctx, pprof.Labels(PprofLabelMethod, call.ServiceMethod), func(ctx context.Context) {
+			err = client.wait(ctx, call)
+		})
+		return err
+	}
+	return client.wait(ctx, call)
+}
+
+// wait 发送 call 并等待结果
+func (client *Client) wait(ctx context.Context, call *Call) error {
+	call.deadline, _ = ctx.Deadline()
+	client.send(call)
 	select {
 	case <-ctx.Done():
 		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		return fmt.Errorf("rpc client: call failed: %w", ctx.Err())
 	case done := <-call.Done:
 		return done.Error
 	}
@@ -203,23 +432,36 @@ func NewClient(conn net.Conn, opt *Option) (client *Client, err error) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err = fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error:", err)
+		clientLog.Error("rpc client: codec error", "remote", conn.RemoteAddr(), "err", err)
 		return
 	}
 	// send options with server
 	if err = json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		clientLog.Error("rpc client: options error", "remote", conn.RemoteAddr(), "err", err)
 		return
 	}
-	return newClientCodec(f(conn), opt), nil
+	br := bufio.NewReader(conn)
+	var wc io.WriteCloser = conn
+	if opt.WriteCoalesceWindow > 0 {
+		wc = newCoalescingWriter(conn, opt.WriteCoalesceWindow, opt.WriteCoalesceMaxBytes)
+	}
+	c := f(&bufferedConn{Reader: br, WriteCloser: wc})
+	if len(opt.SigningKeys) > 0 {
+		c = newSignedCodec(c, opt.CodecType, opt.SigningKeys)
+	}
+	client = newClientCodec(c, opt, br)
+	client.peer = conn.RemoteAddr().String()
+	return client, nil
 }
 
-func newClientCodec(c codec.Codec, opt *Option) *Client {
+func newClientCodec(c codec.Codec, opt *Option, br *bufio.Reader) *Client {
 	client := &Client{
-		c:       c,
-		opt:     opt,
-		seq:     1, // seq 从1开始调用，0为无效的调用
-		pending: make(map[uint64]*Call),
+		c:        c,
+		opt:      opt,
+		seq:      1, // seq 从1开始调用，0为无效的调用
+		pending:  make(map[uint64]*Call),
+		draining: make(chan struct{}),
+		br:       br,
 	}
 	go client.receive()
 	return client
@@ -237,7 +479,15 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	var proxy *url.URL
+	dialNetwork, dialAddress := network, address
+	if opt.ProxyURL != "" {
+		if proxy, err = parseProxyURL(opt.ProxyURL); err != nil {
+			return nil, err
+		}
+		dialNetwork, dialAddress = "tcp", proxy.Host
+	}
+	conn, err := net.DialTimeout(dialNetwork, dialAddress, opt.ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -247,8 +497,15 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 		}
 	}()
 
-	ch := make(chan clientResult)
+	// 带缓冲，超时返回后握手的 goroutine 仍可以发送结果并退出
+	ch := make(chan clientResult, 1)
 	go func() {
+		if proxy != nil {
+			if err := proxyConnect(conn, proxy, address, opt.ConnectTimeout); err != nil {
+				ch <- clientResult{err: err}
+				return
+			}
+		}
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
 	}()
@@ -256,8 +513,16 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 		result := <-ch
 		return result.client, result.err
 	}
+	timer := time.NewTimer(opt.ConnectTimeout)
+	defer timer.Stop()
 	select {
-	case <-time.After(opt.ConnectTimeout):
+	case <-timer.C:
+		go func() {
+			// 关闭连接后握手很快失败；恰好在超时后完成的握手需要关闭创建的客户端
+			if result := <-ch; result.client != nil {
+				_ = result.client.Close()
+			}
+		}()
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
 	case result := <-ch:
 		return result.client, result.err
@@ -268,29 +533,118 @@ func Dial(network, address string, opts ...*Option) (*Client, error) {
 	return dialTimeout(NewClient, network, address, opts...)
 }
 
+// DialTLS 通过 TLS 连接到指定网络地址的 RPC 服务器
+// config.ServerName 为空时使用 address 中的主机名
+func DialTLS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	config, err := clientTLSConfig(address, config)
+	if err != nil {
+		return nil, err
+	}
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return NewClient(tlsConn, opt)
+	}, network, address, opts...)
+}
+
+// DialHTTPS 通过 HTTPS 连接到 HTTP RPC 服务器：先完成 TLS 握手，再发送 CONNECT 请求与 Option，整个过程受 ConnectTimeout 限制
+// 证书校验失败时返回 TLS 握手的错误（可以用 errors.As 判断 x509 的错误类型），服务端拒绝 CONNECT 时返回 *HTTPStatusError
+func DialHTTPS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	config, err := clientTLSConfig(address, config)
+	if err != nil {
+		return nil, err
+	}
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return newHTTPClient(tlsConn, defaultRPCPath, opt)
+	}, network, address, opts...)
+}
+
+// clientTLSConfig 返回客户端使用的 TLS 配置，config.ServerName 为空时使用 address 中的主机名
+func clientTLSConfig(address string, config *tls.Config) (*tls.Config, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return config, nil
+}
+
 // NewHTTPClient 通过HTTP作为传输协议实例一个Client
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
+	return newHTTPClient(conn, defaultRPCPath, opt)
+}
+
+func newHTTPClient(conn net.Conn, path string, opt *Option) (*Client, error) {
+	// CONNECT 的请求与响应受 ConnectTimeout 限制，完成后清除，避免影响之后的 RPC 通信
+	if opt.ConnectTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(opt.ConnectTimeout))
+	}
+	request := fmt.Sprintf("CONNECT %s HTTP/1.0\n", path)
+	if opt.HTTPAuthorization != "" {
+		request += "Authorization: " + opt.HTTPAuthorization + "\n"
+	}
+	_, _ = io.WriteString(conn, request+"\n")
 
 	// 在切换到RPC协议之前必须保证HTTP请求的成功
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if opt.ConnectTimeout > 0 {
+		_ = conn.SetDeadline(time.Time{})
+	}
 	if err == nil && resp.Status == connected {
 		return NewClient(conn, opt)
 	}
 	if err == nil {
-		err = errors.New("unexpected HTTP response: " + resp.Status)
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPReason))
+		err = &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Reason: strings.TrimSpace(string(reason))}
 	}
 	return nil, err
 }
 
+// HTTPStatusError HTTP 服务端没有接受 CONNECT 请求时返回的错误，与 TLS 证书校验等连接错误区分
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string // 如 "404 Not Found"
+	Reason     string // 响应体的开头部分，如 401 时 SetHTTPAuth 回调返回的错误，可能为空
+}
+
+// maxHTTPReason HTTPStatusError.Reason 最多读取的字节数
+const maxHTTPReason = 256
+
+func (e *HTTPStatusError) Error() string {
+	if e.Reason != "" {
+		return "unexpected HTTP response: " + e.Status + ": " + e.Reason
+	}
+	return "unexpected HTTP response: " + e.Status
+}
+
 // DialHTTP 连接到指定网络地址的 HTTP RPC 服务器，侦听默认 HTTP RPC 路径。
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	return DialHTTPPath(network, address, defaultRPCPath, opts...)
+}
+
+// DialHTTPPath 与 DialHTTP 相同，但向服务端 HandleHTTPWithPaths 注册的 path 发送 CONNECT 请求
+func DialHTTPPath(network, address, path string, opts ...*Option) (*Client, error) {
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		return newHTTPClient(conn, path, opt)
+	}, network, address, opts...)
 }
 
 // XDial 根据第一个参数 rpcAddr 调用不同的函数连接到 RPC 服务器。
 // rpcAddr 是表示 rpc 服务器的通用格式 (protocol@addr)
-// 例如 http@10.0.0.1:7001、tcp@10.0.0.1:9999、unix@tmpgeerpc.sock
+// 例如 http@10.0.0.1:7001、https@10.0.0.1:7443、tcp@10.0.0.1:9999、unix@tmpgeerpc.sock
+// https 使用 Option.TLSConfig，为 nil 时使用系统的根证书校验服务端
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -300,6 +654,12 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "https":
+		var config *tls.Config
+		if len(opts) > 0 && opts[0] != nil {
+			config = opts[0].TLSConfig
+		}
+		return DialHTTPS("tcp", addr, config, opts...)
 	default:
 		// tpc, unix or other transport protocol
 		return Dial(protocol, addr, opts...)
@@ -2,9 +2,16 @@ package geerpc
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -86,7 +93,8 @@ func TestXDial(t *testing.T) {
 		go func() {
 			l, err := net.Listen("tcp", addr)
 			if err != nil {
-				t.Fatal("failed to listen tcp")
+				t.Error("failed to listen tcp")
+				return
 			}
 			ch <- struct{}{}
 			HandleHTTP()
@@ -101,7 +109,8 @@ func TestXDial(t *testing.T) {
 		go func() {
 			l, err := net.Listen("tcp", addr)
 			if err != nil {
-				t.Fatal("failed to listen tcp")
+				t.Error("failed to listen tcp")
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
@@ -115,3 +124,232 @@ func TestXDial(t *testing.T) {
 		assert.NotNil(t, err, "failed to connect tcp")
 	})
 }
+
+func TestClient_HandshakeRejected(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	t.Run("invalid magic number", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{MagicNumber: 0x1234})
+		assert.Nil(t, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		var herr *HandshakeError
+		assert.True(t, errors.As(err, &herr), "expect a handshake error, got %v", err)
+		assert.EqualError(t, err, "rpc client: server rejected handshake: invalid magic number 1234")
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		assert.Equal(t, herr, err, "later calls should report the same rejection")
+	})
+	t.Run("invalid codec type frame", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+		assert.Nil(t, json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, CodecType: "application/x-unknown"}))
+		var r rejection
+		assert.Nil(t, json.NewDecoder(conn).Decode(&r))
+		assert.Equal(t, "invalid codec type application/x-unknown", r.Reason)
+	})
+	t.Run("client reads rejection frame", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		go func() {
+			var opt Option
+			_ = json.NewDecoder(serverConn).Decode(&opt)
+			_ = json.NewEncoder(serverConn).Encode(&rejection{Reason: "invalid codec type application/json"})
+			_, _ = io.Copy(io.Discard, serverConn)
+		}()
+		defer func() { _ = serverConn.Close() }()
+		client, err := NewClient(clientConn, DefaultOption)
+		assert.Nil(t, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		assert.EqualError(t, err, "rpc client: server rejected handshake: invalid codec type application/json")
+	})
+}
+
+func TestDialHTTPS(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	config := &tls.Config{RootCAs: roots}
+
+	for name, dial := range map[string]func() (*Client, error){
+		"DialHTTPS": func() (*Client, error) { return DialHTTPS("tcp", addr, config) },
+		"XDial": func() (*Client, error) {
+			return XDial("https@"+addr, &Option{MagicNumber: MagicNumber, TLSConfig: config})
+		},
+	} {
+		client, err := dial()
+		assert.Nil(t, err, name)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: 1, Num2: 2}, &reply), name)
+		assert.Equal(t, 103, reply, name)
+		_ = client.Close()
+	}
+
+	_, err := DialHTTPS("tcp", addr, &tls.Config{})
+	var unknown x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &unknown), "certificate error: %v", err)
+	var statusErr *HTTPStatusError
+	assert.False(t, errors.As(err, &statusErr))
+
+	notFound := httptest.NewTLSServer(http.NotFoundHandler())
+	defer notFound.Close()
+	roots.AddCert(notFound.Certificate())
+	_, err = DialHTTPS("tcp", notFound.Listener.Addr().String(), config)
+	assert.True(t, errors.As(err, &statusErr), "HTTP error: %v", err)
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	assert.EqualError(t, err, "unexpected HTTP response: 404 Not Found: 404 page not found")
+}
+
+// connectProxy 测试用的 HTTP CONNECT 代理，要求 Basic 认证，拒绝连接 forbidden 地址
+type connectProxy struct {
+	auth      string
+	forbidden string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Host == p.forbidden {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		_ = target.Close()
+		return
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		_, _ = io.Copy(target, conn)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+	_ = conn.Close()
+}
+
+func TestDial_Proxy(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	defer func() { _ = server.Close() }()
+	rpcAddr := waitForAddr(t, server)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	httpAddr := httpServer.Listener.Addr().String()
+
+	proxy := httptest.NewServer(&connectProxy{auth: "Basic dXNlcjpwYXNz", forbidden: "10.0.0.1:80"})
+	defer proxy.Close()
+	proxyURL := "http://user:pass@" + proxy.Listener.Addr().String()
+	opt := func(proxyURL string) *Option {
+		return &Option{MagicNumber: MagicNumber, ConnectTimeout: time.Second, ProxyURL: proxyURL}
+	}
+
+	for name, dial := range map[string]func() (*Client, error){
+		"Dial":     func() (*Client, error) { return Dial("tcp", rpcAddr, opt(proxyURL)) },
+		"DialHTTP": func() (*Client, error) { return DialHTTP("tcp", httpAddr, opt(proxyURL)) },
+	} {
+		client, err := dial()
+		assert.Nil(t, err, name)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: 1, Num2: 2}, &reply), name)
+		assert.Equal(t, 103, reply, name)
+		_ = client.Close()
+	}
+
+	var statusErr *HTTPStatusError
+	_, err := Dial("tcp", rpcAddr, opt("http://"+proxy.Listener.Addr().String()))
+	assert.True(t, errors.As(err, &statusErr))
+	assert.EqualError(t, err, "rpc client: proxy CONNECT "+rpcAddr+": unexpected HTTP response: 407 Proxy Authentication Required")
+	_, err = Dial("tcp", "10.0.0.1:80", opt(proxyURL))
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+	_, err = Dial("tcp", rpcAddr, opt("socks5://"+proxy.Listener.Addr().String()))
+	assert.EqualError(t, err, `rpc client: unsupported proxy URL "socks5://`+proxy.Listener.Addr().String()+`", expect http://host:port`)
+}
+
+func TestDialHTTP_ConnectTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() { _ = l.Close() }()
+	go func() {
+		// 接受连接但从不响应 CONNECT
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+
+	before := runtime.NumGoroutine()
+	start := time.Now()
+	_, err = DialHTTP("tcp", l.Addr().String(), &Option{MagicNumber: MagicNumber, ConnectTimeout: 200 * time.Millisecond})
+	assert.NotNil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "handshake goroutine exits after the timeout")
+
+	// CONNECT 交换本身也受 ConnectTimeout 限制
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_, err = newHTTPClient(conn, defaultRPCPath, &Option{MagicNumber: MagicNumber, ConnectTimeout: 100 * time.Millisecond})
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "%v", err)
+}
+
+// Gate 的方法在 release 关闭后返回
+type Gate struct{ release chan struct{} }
+
+func (g *Gate) Wait(args int, reply *int) error {
+	<-g.release
+	return nil
+}
+
+func TestClient_Pending(t *testing.T) {
+	gate := &Gate{release: make(chan struct{})}
+	server := NewServer()
+	assert.Nil(t, server.Register(gate))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	assert.Equal(t, 0, client.Pending())
+	calls := []*Call{
+		client.Go("Gate.Wait", 0, new(int), nil),
+		client.Go("Gate.Wait", 0, new(int), nil),
+	}
+	assert.Equal(t, 2, client.Pending())
+	close(gate.release)
+	for _, call := range calls {
+		<-call.Done
+		assert.Nil(t, call.Error)
+	}
+	assert.Equal(t, 0, client.Pending())
+}
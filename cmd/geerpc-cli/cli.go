@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/codec"
+	"github.com/yqchilde/gee-rpc/dynamic"
+)
+
+// 退出码
+const (
+	exitOK        = 0
+	exitServer    = 1 // 服务端返回了错误
+	exitUsage     = 2 // 用法或参数错误
+	exitTransport = 3 // 连接、传输错误或超时
+)
+
+// metadata 可以重复的 -metadata key=val 标志
+type metadata map[string]string
+
+func (m metadata) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metadata) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("want key=val, got %q", s)
+	}
+	m[s[:i]] = s[i+1:]
+	return nil
+}
+
+// cli 一次命令行调用的配置
+type cli struct {
+	timeout  time.Duration
+	codec    string
+	metadata metadata
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// exitError 带退出码的错误
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func usageError(format string, args ...interface{}) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// run 执行命令行 args，返回退出码
+func run(args []string, stdout, stderr io.Writer) int {
+	c := &cli{metadata: make(metadata), stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("geerpc-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout of the whole command, including dialing")
+	fs.StringVar(&c.codec, "codec", "json", "codec to use: json or gob")
+	fs.Var(c.metadata, "metadata", "request metadata as key=val, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: geerpc-cli [flags] call <protocol@addr> <Service.Method> [json-args]")
+		fmt.Fprintln(stderr, "       geerpc-cli [flags] list <protocol@addr> [Service]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	sub := fs.Arg(0)
+	// 子命令之后同样可以设置标志
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return exitUsage
+	}
+	var err error
+	switch sub {
+	case "call":
+		err = c.call(fs.Args())
+	case "list":
+		err = c.list(fs.Args())
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(stderr, "geerpc-cli:", err)
+	var ee *exitError
+	var se *geerpc.ServerError
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.As(err, &se), errors.Is(err, geerpc.ErrReflectionUnavailable):
+		return exitServer
+	default:
+		return exitTransport
+	}
+}
+
+// dial 连接到 addr，返回的 ctx 在超时后取消
+func (c *cli) dial(addr string) (*geerpc.Client, context.Context, context.CancelFunc, error) {
+	opt := *geerpc.DefaultOption
+	switch c.codec {
+	case "json":
+		opt.CodecType = codec.JsonType
+	case "gob":
+		opt.CodecType = codec.GobType
+	default:
+		return nil, nil, nil, usageError("unknown codec %q, want json or gob", c.codec)
+	}
+	opt.ConnectTimeout = c.timeout
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	client, err := geerpc.XDial(addr, &opt)
+	if err != nil {
+		cancel()
+		if strings.Contains(err.Error(), "wrong format") {
+			return nil, nil, nil, &exitError{code: exitUsage, err: err}
+		}
+		return nil, nil, nil, err
+	}
+	return client, ctx, cancel, nil
+}
+
+func (c *cli) callOptions() []geerpc.CallOption {
+	opts := make([]geerpc.CallOption, 0, len(c.metadata))
+	for k, v := range c.metadata {
+		opts = append(opts, geerpc.WithMetadata(k, v))
+	}
+	return opts
+}
+
+// call 调用 args[1]，参数为 args[2]
+func (c *cli) call(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return usageError("call wants <protocol@addr> <Service.Method> [json-args]")
+	}
+	addr, serviceMethod, argJSON := args[0], args[1], "null"
+	if len(args) == 3 {
+		argJSON = args[2]
+	}
+	if !json.Valid([]byte(argJSON)) {
+		return usageError("arguments are not valid JSON: %s", argJSON)
+	}
+	client, ctx, cancel, err := c.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer func() { _ = client.Close() }()
+
+	var argv, replyv interface{}
+	if c.codec == "json" {
+		// JSON 编解码器直接发送参数，由服务端解码为方法的参数类型
+		var reply json.RawMessage
+		argv, replyv = json.RawMessage(argJSON), &reply
+	} else {
+		md, err := client.Describe(ctx, serviceMethod, c.callOptions()...)
+		if err != nil {
+			return fmt.Errorf("describe %s: %w", serviceMethod, err)
+		}
+		if md.Stream != "" {
+			return usageError("%s is a %s streaming method", serviceMethod, md.Stream)
+		}
+		argType, err := md.Arg.GoType()
+		if err != nil {
+			return usageError("%v", err)
+		}
+		replyType, err := md.Reply.GoType()
+		if err != nil {
+			return usageError("%v", err)
+		}
+		arg, err := dynamic.Unmarshal(argType, []byte(argJSON))
+		if err != nil {
+			return usageError("%v", err)
+		}
+		argv, replyv = arg.Interface(), reflect.New(replyType).Interface()
+	}
+	if err := client.Call(ctx, serviceMethod, argv, replyv, c.callOptions()...); err != nil {
+		return err
+	}
+	return c.print(replyv)
+}
+
+// list 列出服务与方法，args[1] 不为空时只列出该服务
+func (c *cli) list(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("list wants <protocol@addr> [Service]")
+	}
+	var name string
+	if len(args) == 2 {
+		name = args[1]
+	}
+	client, ctx, cancel, err := c.dial(args[0])
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer func() { _ = client.Close() }()
+
+	services, err := client.Services(ctx, c.callOptions()...)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, s := range services {
+		if name != "" && s.Name != name {
+			continue
+		}
+		found = true
+		if s.Version != "" {
+			fmt.Fprintf(c.stdout, "%s@%s\n", s.Name, s.Version)
+		} else {
+			fmt.Fprintln(c.stdout, s.Name)
+		}
+		for _, m := range s.Methods {
+			fmt.Fprintf(c.stdout, "  %s\n", signature(m))
+		}
+	}
+	if name != "" && !found {
+		return &exitError{code: exitServer, err: fmt.Errorf("service %s not found", name)}
+	}
+	return nil
+}
+
+// signature 返回方法的签名，如 "Sum(main.Args) int"、"Count(main.Args) stream"
+func signature(m geerpc.MethodDescription) string {
+	arg, reply := "stream", "stream"
+	if m.Arg != nil {
+		arg = m.Arg.String()
+	}
+	if m.Reply != nil {
+		reply = m.Reply.String()
+	}
+	return m.Name + "(" + arg + ") " + reply
+}
+
+// print 以缩进的 JSON 输出应答
+func (c *cli) print(reply interface{}) error {
+	data, err := json.MarshalIndent(reply, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.stdout, "%s\n", data)
+	return err
+}
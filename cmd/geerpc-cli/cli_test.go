@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Pair struct {
+	Sum     int
+	Tag     string
+	Touched bool
+}
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Pair(ctx context.Context, args Args, reply *Pair) error {
+	id, _ := geerpc.IdentityFromContext(ctx)
+	*reply = Pair{Sum: args.Num1 + args.Num2, Tag: id.Subject, Touched: true}
+	return nil
+}
+
+func (f Foo) Fail(args Args, reply *int) error {
+	return errors.New("boom")
+}
+
+// subjectTokens 以令牌本身作为身份的 TokenValidator
+type subjectTokens struct{}
+
+func (subjectTokens) Login(cred geerpc.Credentials) (geerpc.Token, error) {
+	return geerpc.Token{Value: cred.Username}, nil
+}
+
+func (subjectTokens) Validate(token string) (geerpc.Identity, error) {
+	return geerpc.Identity{Subject: token}, nil
+}
+
+func startServer(t *testing.T, reflection, auth bool) string {
+	server := geerpc.NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	if reflection {
+		assert.Nil(t, server.EnableReflection())
+	}
+	if auth {
+		assert.Nil(t, server.EnableTokenAuth(subjectTokens{}, geerpc.ReflectionService))
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = server.Serve(l) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+func runCLI(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestCall(t *testing.T) {
+	addr := startServer(t, true, false)
+	for _, c := range []string{"json", "gob"} {
+		t.Run(c, func(t *testing.T) {
+			code, out, stderr := runCLI("-codec", c, "call", addr, "Foo.Sum", `{"Num1":1,"Num2":2}`)
+			assert.Equal(t, exitOK, code, stderr)
+			assert.Equal(t, "3\n", out)
+
+			code, out, stderr = runCLI("call", "-codec", c, addr, "Foo.Pair", `{"Num1":2,"Num2":2}`)
+			assert.Equal(t, exitOK, code, stderr)
+			assert.Equal(t, "{\n  \"Sum\": 4,\n  \"Tag\": \"\",\n  \"Touched\": true\n}\n", out)
+
+			code, _, stderr = runCLI("-codec", c, "call", addr, "Foo.Fail", `{}`)
+			assert.Equal(t, exitServer, code)
+			assert.Contains(t, stderr, "boom")
+
+			code, _, stderr = runCLI("-codec", c, "call", addr, "Foo.Missing", `{}`)
+			assert.Equal(t, exitServer, code)
+			assert.Contains(t, stderr, "can't find method Missing")
+		})
+	}
+
+	code, _, stderr := runCLI("-codec", "gob", "call", addr, "Foo.Sum", `{"Num3":1}`)
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown field "Num3"`)
+
+	code, _, _ = runCLI("call", addr, "Foo.Sum", `{"Num1":`)
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("-codec", "xml", "call", addr, "Foo.Sum")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("call", "127.0.0.1:1", "Foo.Sum")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("-timeout", "1s", "call", "tcp@127.0.0.1:1", "Foo.Sum")
+	assert.Equal(t, exitTransport, code)
+	code, _, _ = runCLI("ping", addr)
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestCall_Metadata(t *testing.T) {
+	addr := startServer(t, true, true)
+	code, out, stderr := runCLI("-codec", "gob", "call", "-metadata", "authorization=alice", addr, "Foo.Pair", `{"Num1":2}`)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, out, `"Tag": "alice"`)
+
+	code, _, stderr = runCLI("call", addr, "Foo.Pair", `{"Num1":2}`)
+	assert.Equal(t, exitServer, code)
+	assert.Contains(t, stderr, "unauthenticated")
+	code, _, _ = runCLI("call", "-metadata", "authorization", addr, "Foo.Pair")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestList(t *testing.T) {
+	addr := startServer(t, true, false)
+	code, out, stderr := runCLI("list", addr, "Foo")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, `Foo
+  Fail(main.Args) int
+  Pair(main.Args) main.Pair
+  Sum(main.Args) int
+`, out)
+
+	code, out, _ = runCLI("-codec", "gob", "list", addr)
+	assert.Equal(t, exitOK, code)
+	assert.True(t, strings.Contains(out, "\nReflection\n"), out)
+
+	code, _, _ = runCLI("list", addr, "Bar")
+	assert.Equal(t, exitServer, code)
+
+	// 服务端没有开启反射服务
+	addr = startServer(t, false, false)
+	code, _, stderr = runCLI("list", addr)
+	assert.Equal(t, exitServer, code)
+	assert.Contains(t, stderr, "server does not expose the reflection service")
+	code, _, _ = runCLI("-codec", "gob", "call", addr, "Foo.Sum", `{"Num1":1}`)
+	assert.Equal(t, exitServer, code)
+	code, out, _ = runCLI("call", addr, "Foo.Sum", `{"Num1":1}`)
+	assert.Equal(t, exitOK, code, "the JSON codec does not need reflection")
+	assert.Equal(t, "1\n", out)
+}
@@ -0,0 +1,26 @@
+// Command geerpc-cli 从命令行调用 geerpc 服务，用于调试
+//
+// 用法：
+//
+//	geerpc-cli [flags] call <protocol@addr> <Service.Method> [json-args]
+//	geerpc-cli [flags] list <protocol@addr> [Service]
+//
+// 地址与 geerpc.XDial 相同，如 tcp@127.0.0.1:9999、http@127.0.0.1:9999。
+// 参数为 JSON，省略时为 null，应答以 JSON 输出到标准输出。
+// 默认使用 JSON 编解码器，参数直接交给服务端解码；-codec gob 时先通过服务端的反射服务
+// （Server.EnableReflection）获取参数与应答的类型，再按类型构造参数。list 同样依赖反射服务。
+//
+// 标志可以放在子命令之前或紧跟在子命令之后：
+//
+//	-timeout 5s        整个调用（包括连接）的超时时间
+//	-codec json|gob    编解码器
+//	-metadata key=val  随请求发送的元数据，可以重复
+//
+// 退出码：0 成功；1 服务端返回了错误（包括方法不存在与没有开启反射服务）；2 用法或参数错误；3 连接、传输错误或超时
+package main
+
+import "os"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
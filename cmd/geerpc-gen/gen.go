@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const geerpcPath = "github.com/yqchilde/gee-rpc"
+
+// config 生成的配置，见命令的说明
+type config struct {
+	Dir    string
+	Types  []string
+	Pkg    string
+	Import string
+}
+
+// method 生成的客户端中的一个方法
+type method struct {
+	Name  string
+	Args  string // 参数的类型
+	Reply string // 返回值的类型，即应答指针指向的类型
+}
+
+// service 一个服务类型及其方法
+type service struct {
+	Name    string
+	Methods []method
+}
+
+// generator 从服务包的源文件收集方法与用到的导入
+type generator struct {
+	fset    *token.FileSet
+	pkgName string          // 服务包的包名
+	qualify bool            // 是否以服务包的包名限定其中的类型
+	imports map[string]bool // 生成的代码需要的导入，值为 "name path" 或 "path"
+}
+
+// generate 解析 cfg.Dir 中的服务并返回格式化后的客户端代码
+func generate(cfg config) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, cfg.Dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect one package in %s, found %d", cfg.Dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	g := &generator{fset: fset, pkgName: pkg.Name, qualify: cfg.Import != "", imports: make(map[string]bool)}
+	services, err := g.collect(pkg, cfg.Types)
+	if err != nil {
+		return nil, err
+	}
+	outPkg := cfg.Pkg
+	if outPkg == "" {
+		outPkg = pkg.Name
+	}
+	if g.qualify {
+		g.addImport(pkg.Name, cfg.Import)
+	}
+	return g.render(outPkg, services)
+}
+
+// collect 按 types 的顺序返回各个类型的方法，文件按名称排序，方法按在文件中出现的顺序
+func (g *generator) collect(pkg *ast.Package, types []string) ([]service, error) {
+	byType := make(map[string][]method)
+	wanted := make(map[string]bool)
+	for _, name := range types {
+		wanted[name] = true
+	}
+	names := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	found := make(map[string]bool)
+	for _, name := range names {
+		f := pkg.Files[name]
+		imports := fileImports(f)
+		for _, decl := range f.Decls {
+			if ts, ok := typeSpecs(decl); ok {
+				for _, spec := range ts {
+					found[spec.Name.Name] = true
+				}
+			}
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || !fn.Name.IsExported() {
+				continue
+			}
+			recv := receiverName(fn.Recv.List[0].Type)
+			if !wanted[recv] {
+				continue
+			}
+			if m, ok := g.method(fn, imports); ok {
+				byType[recv] = append(byType[recv], m)
+			}
+		}
+	}
+	services := make([]service, 0, len(types))
+	for _, name := range types {
+		if !found[name] {
+			return nil, fmt.Errorf("type %s not found in package %s", name, pkg.Name)
+		}
+		if len(byType[name]) == 0 {
+			return nil, fmt.Errorf("type %s has no methods suitable for RPC", name)
+		}
+		services = append(services, service{Name: name, Methods: byType[name]})
+	}
+	return services, nil
+}
+
+// method 与 Server.Register 相同，只接受 func([ctx context.Context,] args T, reply *R) error 形式的非流式方法
+func (g *generator) method(fn *ast.FuncDecl, imports map[string]string) (method, bool) {
+	results := fn.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return method{}, false
+	}
+	if id, ok := results.List[0].Type.(*ast.Ident); !ok || id.Name != "error" {
+		return method{}, false
+	}
+	var params []ast.Expr
+	for _, field := range fn.Type.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	switch {
+	case len(params) == 3 && isSelector(params[0], imports, "context", "Context"):
+		params = params[1:]
+	case len(params) != 2:
+		return method{}, false
+	}
+	args, reply := params[0], params[1]
+	for _, t := range params {
+		if !exportedOrBuiltin(t) {
+			return method{}, false
+		}
+		if star, ok := t.(*ast.StarExpr); ok {
+			for _, stream := range []string{"ServerStream", "ServerRecvStream", "BidiStream"} {
+				if isSelector(star.X, imports, geerpcPath, stream) {
+					return method{}, false
+				}
+			}
+		}
+	}
+	if star, ok := reply.(*ast.StarExpr); ok {
+		reply = star.X
+	}
+	argsType, ok1 := g.typeString(args, imports)
+	replyType, ok2 := g.typeString(reply, imports)
+	if !ok1 || !ok2 {
+		return method{}, false
+	}
+	return method{Name: fn.Name.Name, Args: argsType, Reply: replyType}, true
+}
+
+// typeString 返回 expr 在生成的代码中的写法，并记录用到的导入；引用了未知的包时返回 false
+func (g *generator) typeString(expr ast.Expr, imports map[string]string) (string, bool) {
+	ok := true
+	expr = g.rewrite(expr, imports, &ok)
+	if !ok {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, g.fset, expr); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// rewrite 返回 expr 的副本，需要时以服务包的包名限定其中的类型
+func (g *generator) rewrite(expr ast.Expr, imports map[string]string, ok *bool) ast.Expr {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch {
+		case !g.qualify:
+		case t.IsExported():
+			return &ast.SelectorExpr{X: ast.NewIdent(g.pkgName), Sel: ast.NewIdent(t.Name)}
+		case !predeclared[t.Name]:
+			// 其他包无法引用服务包中未导出的类型
+			*ok = false
+		}
+		return ast.NewIdent(t.Name)
+	case *ast.SelectorExpr:
+		x, isIdent := t.X.(*ast.Ident)
+		if !isIdent {
+			*ok = false
+			return t
+		}
+		p, found := imports[x.Name]
+		if !found {
+			*ok = false
+			return t
+		}
+		g.addImport(x.Name, p)
+		return &ast.SelectorExpr{X: ast.NewIdent(x.Name), Sel: ast.NewIdent(t.Sel.Name)}
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: g.rewrite(t.X, imports, ok)}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Len: t.Len, Elt: g.rewrite(t.Elt, imports, ok)}
+	case *ast.MapType:
+		return &ast.MapType{Key: g.rewrite(t.Key, imports, ok), Value: g.rewrite(t.Value, imports, ok)}
+	case *ast.ChanType:
+		return &ast.ChanType{Dir: t.Dir, Value: g.rewrite(t.Value, imports, ok)}
+	case *ast.InterfaceType:
+		if t.Methods == nil || len(t.Methods.List) == 0 {
+			return &ast.InterfaceType{Methods: &ast.FieldList{}}
+		}
+	case *ast.StructType:
+		if t.Fields == nil || len(t.Fields.List) == 0 {
+			return &ast.StructType{Fields: &ast.FieldList{}}
+		}
+	}
+	// 其他的类型字面量不做改写，只在生成到服务所在的包中时使用
+	if g.qualify {
+		*ok = false
+	}
+	return expr
+}
+
+// addImport 记录生成的代码需要导入 p，name 与路径的最后一个元素不同时使用别名
+func (g *generator) addImport(name, p string) {
+	if p == geerpcPath || p == "context" {
+		return
+	}
+	if name == path.Base(p) {
+		g.imports[strconv.Quote(p)] = true
+		return
+	}
+	g.imports[name+" "+strconv.Quote(p)] = true
+}
+
+// render 生成客户端代码
+func (g *generator) render(pkgName string, services []service) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by geerpc-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n\t\"context\"\n", pkgName)
+	var std, others []string
+	for imp := range g.imports {
+		p, _ := strconv.Unquote(imp[strings.IndexByte(imp, '"'):])
+		if strings.Contains(strings.SplitN(p, "/", 2)[0], ".") {
+			others = append(others, imp)
+		} else {
+			std = append(std, imp)
+		}
+	}
+	others = append(others, "geerpc "+strconv.Quote(geerpcPath))
+	sort.Strings(std)
+	sort.Slice(others, func(i, j int) bool { return importPath(others[i]) < importPath(others[j]) })
+	for _, imp := range std {
+		fmt.Fprintf(&buf, "\t%s\n", imp)
+	}
+	buf.WriteString("\n")
+	for _, imp := range others {
+		fmt.Fprintf(&buf, "\t%s\n", imp)
+	}
+	buf.WriteString(")\n")
+	for _, s := range services {
+		recv := receiver(s.Name)
+		fmt.Fprintf(&buf, "\n// %[1]sClient %[1]s 服务的客户端\ntype %[1]sClient struct {\n\tc geerpc.Caller\n}\n", s.Name)
+		fmt.Fprintf(&buf, "\n// New%[1]sClient 返回通过 c 调用 %[1]s 服务的客户端，c 可以是 *xclient.XClient 或 geerpc.ClientCaller(client)\n", s.Name)
+		fmt.Fprintf(&buf, "func New%[1]sClient(c geerpc.Caller) *%[1]sClient {\n\treturn &%[1]sClient{c: c}\n}\n", s.Name)
+		for _, m := range s.Methods {
+			fmt.Fprintf(&buf, "\n// %s 调用 %s.%s\n", m.Name, s.Name, m.Name)
+			fmt.Fprintf(&buf, "func (%s *%sClient) %s(ctx context.Context, args %s) (%s, error) {\n", recv, s.Name, m.Name, m.Args, m.Reply)
+			fmt.Fprintf(&buf, "\tvar reply %s\n\terr := %s.c.Call(ctx, %q, args, &reply)\n\treturn reply, err\n}\n", m.Reply, recv, s.Name+"."+m.Name)
+		}
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// importPath 返回 addImport 记录的导入中的路径
+func importPath(imp string) string {
+	p, _ := strconv.Unquote(imp[strings.IndexByte(imp, '"'):])
+	return p
+}
+
+// receiver 返回类型名的首字母小写作为接收者的名称
+func receiver(typeName string) string {
+	return string(unicode.ToLower([]rune(typeName)[0]))
+}
+
+// fileImports 返回文件中导入的包名到路径的映射，没有别名时以路径的最后一个元素作为包名
+func fileImports(f *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = p
+	}
+	return imports
+}
+
+// typeSpecs 返回 decl 中声明的类型
+func typeSpecs(decl ast.Decl) ([]*ast.TypeSpec, bool) {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || gen.Tok != token.TYPE {
+		return nil, false
+	}
+	specs := make([]*ast.TypeSpec, 0, len(gen.Specs))
+	for _, spec := range gen.Specs {
+		specs = append(specs, spec.(*ast.TypeSpec))
+	}
+	return specs, true
+}
+
+// receiverName 返回接收者的类型名，*T 与 T 都返回 T
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// isSelector 判断 expr 是否为 imports 中路径为 p 的包的 name
+func isSelector(expr ast.Expr, imports map[string]string, p, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && imports[x.Name] == p
+}
+
+// exportedOrBuiltin 与 Server.Register 相同：指针、其他包的类型与类型字面量都可以使用，
+// 只有本包中未导出的命名类型不能使用
+func exportedOrBuiltin(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	if !ok {
+		return true
+	}
+	return id.IsExported() || predeclared[id.Name]
+}
+
+// predeclared 预声明的类型
+var predeclared = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true, "int16": true, "int32": true,
+	"int64": true, "rune": true, "string": true, "uint": true, "uint8": true, "uint16": true,
+	"uint32": true, "uint64": true, "uintptr": true,
+}
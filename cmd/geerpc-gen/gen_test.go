@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// golden 比较 got 与 testdata 中的 name，设置 -update 时改为写入
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(path, got, 0644))
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := generate(config{Dir: "testdata/shop", Types: []string{"Shop", "Admin"}})
+	assert.Nil(t, err)
+	golden(t, "shop_client.golden", src)
+}
+
+func TestGenerate_OtherPackage(t *testing.T) {
+	src, err := generate(config{Dir: "testdata/shop", Types: []string{"Shop"}, Pkg: "shopclient", Import: "example.com/shop"})
+	assert.Nil(t, err)
+	golden(t, "shopclient.golden", src)
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	_, err := generate(config{Dir: "testdata/shop", Types: []string{"Missing"}})
+	assert.EqualError(t, err, "type Missing not found in package shop")
+	_, err = generate(config{Dir: "testdata/shop", Types: []string{"Item"}})
+	assert.EqualError(t, err, "type Item has no methods suitable for RPC")
+	_, err = generate(config{Dir: "testdata/missing", Types: []string{"Shop"}})
+	assert.NotNil(t, err)
+}
+
+// TestGenerate_Example 确保 example/arith 中提交的生成代码是最新的，该包的测试对运行中的服务器调用生成的客户端
+func TestGenerate_Example(t *testing.T) {
+	dir := filepath.Join("..", "..", "example", "arith")
+	src, err := generate(config{Dir: dir, Types: []string{"Arith"}})
+	assert.Nil(t, err)
+	want, err := ioutil.ReadFile(filepath.Join(dir, "arith_client.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(want), string(src), "run go generate ./example/arith")
+}
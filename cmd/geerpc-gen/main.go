@@ -0,0 +1,57 @@
+// Command geerpc-gen 为 geerpc 服务生成类型安全的客户端代码
+//
+// 用法：
+//
+//	geerpc-gen -type Foo[,Bar] [-dir .] [-output foo_client.go] [-pkg name -import path]
+//
+// 从 -dir 中的 Go 源文件（不含测试文件）查找各个类型的导出方法，与 Server.Register 的规则相同，
+// 只使用 func(args T, reply *R) error 与 func(ctx context.Context, args T, reply *R) error 形式的方法，
+// 跳过流式方法。每个类型生成一个 FooClient，方法形如：
+//
+//	func (f *FooClient) Sum(ctx context.Context, args Args) (int, error)
+//
+// 生成的客户端通过 geerpc.Caller 调用，可以使用 *xclient.XClient 或 geerpc.ClientCaller(client)。
+// 默认生成到服务所在的包中；设置 -import 时生成到名为 -pkg 的其他包中，服务包中的类型以包名限定。
+// 只能找到在 -dir 中声明的方法，嵌入其他包的类型提升的方法需要按名称调用。
+//
+// 通常在服务的源文件中添加：
+//
+//	//go:generate go run github.com/yqchilde/gee-rpc/cmd/geerpc-gen -type Foo
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("geerpc-gen: ")
+	var (
+		types  = flag.String("type", "", "comma-separated list of service type names; required")
+		dir    = flag.String("dir", ".", "directory of the service package")
+		output = flag.String("output", "", "output file name; default <dir>/<first type>_client.go")
+		pkg    = flag.String("pkg", "", "package name of the generated file; default the service package")
+		imp    = flag.String("import", "", "import path of the service package when generating into another package")
+	)
+	flag.Parse()
+	if *types == "" {
+		flag.Usage()
+		log.Fatal("-type is required")
+	}
+	cfg := config{Dir: *dir, Types: strings.Split(*types, ","), Pkg: *pkg, Import: *imp}
+	src, err := generate(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := *output
+	if path == "" {
+		path = filepath.Join(*dir, strings.ToLower(cfg.Types[0])+"_client.go")
+	}
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	stdtime "time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+type Item struct {
+	Name  string
+	Price int
+}
+
+type Order struct {
+	Items []Item
+	At    stdtime.Time
+}
+
+type receipt struct{ Total int }
+
+type Shop struct{}
+
+// Total 不使用 context
+func (s *Shop) Total(order Order, reply *int) error {
+	for _, item := range order.Items {
+		*reply += item.Price
+	}
+	return nil
+}
+
+// Lookup 使用 context，应答为切片
+func (s Shop) Lookup(ctx context.Context, names []string, reply *[]Item) error {
+	return nil
+}
+
+// Prices 应答为 map，参数为指针
+func (s *Shop) Prices(req *Order, reply map[string]int) error { return nil }
+
+// Deadline 参数与应答来自其他包
+func (s *Shop) Deadline(order Order, reply *stdtime.Time) error { return nil }
+
+// 以下方法不会生成
+
+func (s *Shop) private(order Order, reply *int) error { return nil }
+
+func (s *Shop) Receipt(order Order, reply receipt) error { return nil }
+
+func (s *Shop) NoError(order Order, reply *int) {}
+
+func (s *Shop) TooMany(a, b int, reply *int) error { return nil }
+
+func (s *Shop) Watch(ctx context.Context, order Order, stream *geerpc.ServerStream) error { return nil }
+
+func (s *Shop) Chat(stream *geerpc.BidiStream) error { return errors.New("unused") }
+
+type Admin int
+
+func (a *Admin) Reset(force bool, reply *bool) error { return nil }
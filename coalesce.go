@@ -0,0 +1,136 @@
+package geerpc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCoalesceMaxBytes 合并写入时缓冲区的默认上限
+const defaultCoalesceMaxBytes = 32 << 10
+
+// coalescingWriter 合并短时间内的多次写入，减少写连接的系统调用
+// 写入的数据先追加到缓冲区，由每个连接一个的 flusher goroutine 在 window 之后写入连接；
+// 缓冲区超过 maxBytes 时由写入方立即写入连接。写连接失败后关闭连接，之后的写入都返回该错误
+type coalescingWriter struct {
+	flushes  uint64 // 写入连接的次数，原子访问，放在首位保证 32 位平台上的对齐
+	wc       io.WriteCloser
+	window   time.Duration
+	maxBytes int
+
+	writing sync.Mutex // 保证缓冲的数据按顺序写入连接
+
+	mu    sync.Mutex // protect following
+	buf   []byte     // 等待写入连接的数据
+	spare []byte     // 上一次写入连接后留下的缓冲区，下一次交换使用
+	err   error      // 写连接的错误或关闭后的 net.ErrClosed
+
+	kick      chan struct{} // 缓冲区从空变为非空时通知 flusher
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newCoalescingWriter 返回合并写入 wc 的 coalescingWriter 并启动 flusher，maxBytes 不大于0时使用默认值
+func newCoalescingWriter(wc io.WriteCloser, window time.Duration, maxBytes int) *coalescingWriter {
+	if maxBytes <= 0 {
+		maxBytes = defaultCoalesceMaxBytes
+	}
+	w := &coalescingWriter{
+		wc:       wc,
+		window:   window,
+		maxBytes: maxBytes,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Write 将 p 追加到缓冲区，缓冲区超过上限时立即写入连接
+func (w *coalescingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if w.err != nil {
+		err := w.err
+		w.mu.Unlock()
+		return 0, err
+	}
+	first := len(w.buf) == 0
+	w.buf = append(w.buf, p...)
+	full := len(w.buf) >= w.maxBytes
+	w.mu.Unlock()
+	if full {
+		return len(p), w.flush()
+	}
+	if first {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// flush 将缓冲区中的数据写入连接，写入期间其他 goroutine 可以继续向另一个缓冲区追加
+func (w *coalescingWriter) flush() error {
+	w.writing.Lock()
+	defer w.writing.Unlock()
+	w.mu.Lock()
+	if len(w.buf) == 0 || w.err != nil {
+		err := w.err
+		w.mu.Unlock()
+		return err
+	}
+	buf := w.buf
+	w.buf, w.spare = w.spare[:0], nil
+	w.mu.Unlock()
+
+	_, err := w.wc.Write(buf)
+	atomic.AddUint64(&w.flushes, 1)
+	w.mu.Lock()
+	if cap(buf) <= 4*w.maxBytes {
+		w.spare = buf[:0] // 偶尔的大响应不长期占用内存
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	if err != nil {
+		_ = w.wc.Close()
+	}
+	return err
+}
+
+// loop 在缓冲区变为非空后等待 window 再写入连接，直到 Close
+func (w *coalescingWriter) loop() {
+	timer := time.NewTimer(w.window)
+	timer.Stop()
+	for {
+		select {
+		case <-w.kick:
+		case <-w.done:
+			return
+		}
+		timer.Reset(w.window)
+		select {
+		case <-timer.C:
+		case <-w.done:
+			timer.Stop()
+			return
+		}
+		_ = w.flush()
+	}
+}
+
+// Close 写入缓冲区中剩余的数据，停止 flusher 并关闭连接
+func (w *coalescingWriter) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	_ = w.flush()
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = net.ErrClosed
+	}
+	w.mu.Unlock()
+	return w.wc.Close()
+}
@@ -0,0 +1,172 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCountingConn 记录写入连接的次数
+type writeCountingConn struct {
+	net.Conn
+	writes *uint64
+}
+
+func (c writeCountingConn) Write(p []byte) (int, error) {
+	atomic.AddUint64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+// writeCountingListener 接受的连接都是 writeCountingConn
+type writeCountingListener struct {
+	net.Listener
+	writes uint64
+}
+
+func (l *writeCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return writeCountingConn{Conn: conn, writes: &l.writes}, nil
+}
+
+// memConn 将写入的数据保存在内存中
+type memConn struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writes int
+	closed bool
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return c.buf.Write(p)
+}
+
+func (c *memConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *memConn) get() (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.writes
+}
+
+func TestCoalescingWriter(t *testing.T) {
+	conn := new(memConn)
+	w := newCoalescingWriter(conn, 20*time.Millisecond, 8)
+	for _, s := range []string{"a", "b", "c"} {
+		n, err := w.Write([]byte(s))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+	}
+	data, writes := conn.get()
+	assert.Equal(t, "", data, "small writes wait for the window")
+	assert.Eventually(t, func() bool { data, writes = conn.get(); return data == "abc" }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, writes)
+
+	// 超过上限时立即写入
+	_, err := w.Write([]byte("0123456789"))
+	assert.Nil(t, err)
+	data, writes = conn.get()
+	assert.Equal(t, "abc0123456789", data)
+	assert.Equal(t, 2, writes)
+
+	// Close 写入剩余的数据
+	_, _ = w.Write([]byte("z"))
+	assert.Nil(t, w.Close())
+	data, _ = conn.get()
+	assert.Equal(t, "abc0123456789z", data)
+	assert.True(t, conn.closed)
+	_, err = w.Write([]byte("x"))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&w.flushes))
+}
+
+func startCoalescingServer(t testing.TB, window time.Duration) (*Server, *writeCountingListener) {
+	server := NewServerWithOptions(ServerOptions{WriteCoalesceWindow: window})
+	_ = server.Register(new(Foo))
+	_ = server.Register(&Cooperative{exited: make(chan error, 1)})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &writeCountingListener{Listener: l}
+	go server.Accept(cl)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, cl
+}
+
+func TestServer_WriteCoalesce(t *testing.T) {
+	_, l := startCoalescingServer(t, 2*time.Millisecond)
+	client, err := Dial("tcp", l.Addr().String(), &Option{MagicNumber: MagicNumber, WriteCoalesceWindow: time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	const n = 200
+	calls := make([]*Call, n)
+	replies := make([]int, n)
+	for i := range calls {
+		calls[i] = client.Go("Foo.Sum", Args{Num1: i, Num2: 1}, &replies[i], make(chan *Call, 1))
+	}
+	for i, call := range calls {
+		<-call.Done
+		assert.Nil(t, call.Error)
+		assert.Equal(t, i+1, replies[i])
+	}
+	writes := atomic.LoadUint64(&l.writes)
+	assert.True(t, writes < n/2, "responses should be coalesced, got %d writes for %d responses", writes, n)
+}
+
+func TestServer_WriteCoalesceShutdown(t *testing.T) {
+	server, l := startCoalescingServer(t, 500*time.Millisecond)
+	client, err := Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	start := time.Now()
+	call := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil)
+	time.Sleep(50 * time.Millisecond) // 响应已经进入缓冲区
+	assert.Nil(t, server.Shutdown(context.Background()))
+	<-call.Done
+	assert.Nil(t, call.Error, "buffered responses are flushed before the connection closes")
+	assert.Equal(t, 3, *call.Reply.(*int))
+	assert.True(t, time.Since(start) < 400*time.Millisecond, time.Since(start))
+}
+
+func benchmarkCoalesce(b *testing.B, window time.Duration) {
+	_, l := startCoalescingServer(b, window)
+	client, err := Dial("tcp", l.Addr().String(), &Option{MagicNumber: MagicNumber, WriteCoalesceWindow: window})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			_ = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadUint64(&l.writes))/float64(b.N), "flushes/op")
+}
+
+func BenchmarkServer_WriteCoalesce(b *testing.B) {
+	b.Run("immediate", func(b *testing.B) { benchmarkCoalesce(b, 0) })
+	b.Run("100us", func(b *testing.B) { benchmarkCoalesce(b, 100*time.Microsecond) })
+}
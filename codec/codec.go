@@ -4,10 +4,23 @@ import "io"
 
 type Header struct {
 	ServiceMethod string
-	Seq           uint64
+	Seq           uint64 // 请求序号，从1开始；服务端推送（Server.Broadcast）的帧为0
 	Error         string
+	Code          int               // 错误码，0 表示未分类的错误
+	HasBody       bool              // header之后是否跟随body，错误响应没有body
+	Compressed    bool              // body 是否为 CompressBody 压缩后的 []byte
+	Priority      uint8             // 请求优先级，数值越大越优先
+	RetryAfter    uint32            // 错误响应建议客户端等待多少毫秒后重试，0表示不提示
+	Stream        bool              // 流式调用的帧，body 为 Marshal 编码的 []byte
+	More          bool              // 流式调用中发送方还会继续发送该 Seq 的帧，为 false 表示发送方向结束
+	Timeout       int64             // 客户端 ctx 在发送请求时的剩余时间，单位纳秒，0表示没有截止时间；使用剩余时间而不是绝对时间以避免时钟偏差
+	Metadata      map[string]string // 请求附带的元数据，如幂等键
+	Signature     []byte            // 启用请求签名时帧的 HMAC-SHA256 签名，覆盖除本字段外的所有字段与编码后的body
 }
 
+// Codec 每次 ReadHeader 之后都需要调用一次 ReadBody，传入 nil 表示丢弃body
+// header.HasBody 为 false 时编解码器可以不写入也不读取body；
+// gob 编解码器为兼容不设置 HasBody 的旧版本，始终写入并读取body
 type Codec interface {
 	io.Closer                         // 一个可关闭的io
 	ReadHeader(*Header) error         // 用于读header
@@ -21,7 +34,7 @@ type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // todo not implemented
+	JsonType Type = "application/json"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -29,4 +42,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
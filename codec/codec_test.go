@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bufferConn 将写入的数据保存在内存中，并可以再次读出
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+func TestCodec_NoBody(t *testing.T) {
+	for typ, f := range NewCodecFuncMap {
+		t.Run(string(typ), func(t *testing.T) {
+			var conn bufferConn
+			c := f(&conn)
+			assert.Nil(t, c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1, Error: "failed"}, nil))
+			assert.Nil(t, c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2, HasBody: true}, 3))
+
+			var h Header
+			assert.Nil(t, c.ReadHeader(&h))
+			assert.Equal(t, uint64(1), h.Seq)
+			assert.False(t, h.HasBody)
+			assert.Nil(t, c.ReadBody(nil))
+
+			var reply int
+			assert.Nil(t, c.ReadHeader(&h))
+			assert.Equal(t, uint64(2), h.Seq)
+			assert.True(t, h.HasBody)
+			assert.Equal(t, "", h.Error)
+			assert.Nil(t, c.ReadBody(&reply))
+			assert.Equal(t, 3, reply)
+		})
+	}
+}
+
+func TestCompressBody(t *testing.T) {
+	for _, typ := range []Type{GobType, JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			_, compressed, err := CompressBody(typ, "small", 64)
+			assert.Nil(t, err)
+			assert.False(t, compressed)
+
+			large := bytes.Repeat([]byte("geerpc"), 1000)
+			data, compressed, err := CompressBody(typ, large, 64)
+			assert.Nil(t, err)
+			assert.True(t, compressed)
+			assert.True(t, len(data) < len(large)/10)
+
+			var out []byte
+			assert.Nil(t, DecompressBody(typ, data, &out))
+			assert.Equal(t, large, out)
+		})
+	}
+}
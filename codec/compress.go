@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	bufPool  = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	gzipPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+)
+
+// marshal 使用 t 对应的格式将 body 独立编码到 buf
+// gob 每次使用新的 Encoder，编码结果包含类型信息，可以单独解码
+func marshal(t Type, buf *bytes.Buffer, body interface{}) error {
+	switch t {
+	case GobType:
+		return gob.NewEncoder(buf).Encode(body)
+	case JsonType:
+		return json.NewEncoder(buf).Encode(body)
+	default:
+		return fmt.Errorf("rpc codec: unsupported codec type %s", t)
+	}
+}
+
+// Marshal 使用 t 对应的格式将 v 编码为可以单独解码的字节
+func Marshal(t Type, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshal(t, &buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 解码 Marshal 返回的数据
+func Unmarshal(t Type, data []byte, v interface{}) error {
+	return unmarshal(t, bytes.NewReader(data), v)
+}
+
+func unmarshal(t Type, r io.Reader, v interface{}) error {
+	switch t {
+	case GobType:
+		return gob.NewDecoder(r).Decode(v)
+	case JsonType:
+		return json.NewDecoder(r).Decode(v)
+	default:
+		return fmt.Errorf("rpc codec: unsupported codec type %s", t)
+	}
+}
+
+// CompressBody 先将 body 编码到缓冲区，编码后的大小超过 minBytes 时返回 gzip 压缩后的数据
+// 不需要压缩时 compressed 为 false，调用方应按原样发送 body
+func CompressBody(t Type, body interface{}, minBytes int) (data []byte, compressed bool, err error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err = marshal(t, buf, body); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() <= minBytes {
+		return nil, false, nil
+	}
+	out := new(bytes.Buffer)
+	zw := gzipPool.Get().(*gzip.Writer)
+	defer func() {
+		zw.Reset(io.Discard)
+		gzipPool.Put(zw)
+	}()
+	zw.Reset(out)
+	if _, err = buf.WriteTo(zw); err != nil {
+		return nil, false, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), true, nil
+}
+
+// DecompressBody 解压 CompressBody 返回的数据并解码到 body
+func DecompressBody(t Type, data []byte, body interface{}) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = zr.Close() }()
+	return unmarshal(t, zr, body)
+}
@@ -31,6 +31,8 @@ func (g *GobCodec) Close() error {
 }
 
 func (g *GobCodec) ReadHeader(header *Header) error {
+	// gob 不传输零值字段，先清空header避免残留上一次的值
+	*header = Header{}
 	return g.dec.Decode(header)
 }
 
@@ -49,6 +51,10 @@ func (g *GobCodec) Write(header *Header, body interface{}) (err error) {
 		log.Println("rpc codec: gob error encoding header:", err)
 		return err
 	}
+	if body == nil {
+		// 旧版本的对端总会读取body，没有body时写入一个空的占位值
+		body = struct{}{}
+	}
 	if err := g.enc.Encode(body); err != nil {
 		log.Println("rpc codec: gob error encoding body:", err)
 		return err
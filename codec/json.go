@@ -0,0 +1,74 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+type JsonCodec struct {
+	conn    io.ReadWriteCloser // 用于构建函数传入
+	buf     *bufio.Writer      // 为了防止阻塞而创建的带缓冲的Writer
+	dec     *json.Decoder      // json对应的Decoder
+	enc     *json.Encoder      // json对应的Encoder
+	hasBody bool               // 最近读取的header之后是否跟随body
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (j *JsonCodec) Close() error {
+	return j.conn.Close()
+}
+
+func (j *JsonCodec) ReadHeader(header *Header) error {
+	*header = Header{}
+	err := j.dec.Decode(header)
+	j.hasBody = err == nil && header.HasBody
+	return err
+}
+
+// ReadBody 读取header之后的body，header.HasBody 为 false 时不读取任何数据
+func (j *JsonCodec) ReadBody(body interface{}) error {
+	if !j.hasBody {
+		return nil
+	}
+	j.hasBody = false
+	if body == nil {
+		var discard json.RawMessage
+		return j.dec.Decode(&discard)
+	}
+	return j.dec.Decode(body)
+}
+
+// Write 写入header，仅当 header.HasBody 为 true 时写入body
+func (j *JsonCodec) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		_ = j.buf.Flush()
+		if err != nil {
+			_ = j.Close()
+		}
+	}()
+	if err := j.enc.Encode(header); err != nil {
+		log.Println("rpc codec: json error encoding header:", err)
+		return err
+	}
+	if !header.HasBody {
+		return nil
+	}
+	if err := j.enc.Encode(body); err != nil {
+		log.Println("rpc codec: json error encoding body:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+type Blob int
+
+func (b Blob) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+// countingConn 统计从连接中读取的字节数
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func TestServer_CompressMinBytes(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Blob))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			nc, err := net.Dial("tcp", addr)
+			assert.Nil(t, err)
+			conn := &countingConn{Conn: nc}
+			client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodecType: typ, CompressMinBytes: 1024})
+			assert.Nil(t, err)
+			defer func() { _ = client.Close() }()
+
+			var small string
+			assert.Nil(t, client.Call(context.Background(), "Blob.Repeat", 10, &small))
+			assert.Equal(t, strings.Repeat("x", 10), small)
+
+			before := atomic.LoadInt64(&conn.read)
+			var large string
+			assert.Nil(t, client.Call(context.Background(), "Blob.Repeat", 100000, &large))
+			assert.Equal(t, 100000, len(large))
+			assert.True(t, atomic.LoadInt64(&conn.read)-before < 10000, "large reply should be compressed")
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+					var reply string
+					assert.Nil(t, client.Call(context.Background(), "Blob.Repeat", n, &reply))
+					assert.Equal(t, n, len(reply))
+				}(i * 500)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -1,24 +1,37 @@
 package geerpc
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"text/template"
+	"time"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	{{range .Services}}
 	<hr>
-	Service {{.Name}}
+	Service {{.Name}}{{if .Version}} version {{.Version}}{{end}} (in flight: {{.InFlight}}{{if .MaxConcurrent}} / {{.MaxConcurrent}}{{end}})
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>Timeouts</th>
+		<th align=center>Avg</th><th align=center>Min</th><th align=center>Max</th><th align=center>P50</th><th align=center>P99</th>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{.Name}}({{.ArgType}}{{if .ReplyType}}, {{.ReplyType}}{{end}}) error</td>
+			<td align=center>{{.Calls}}</td>
+			<td align=center>{{.Errors}}</td>
+			<td align=center>{{.Timeouts}}</td>
+			<td align=center>{{.Avg}}</td>
+			<td align=center>{{.Min}}</td>
+			<td align=center>{{.Max}}</td>
+			<td align=center>{{.P50}}</td>
+			<td align=center>{{.P99}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -28,27 +41,151 @@ const debugText = `<html>
 
 var debug = template.Must(template.New("RPC Debug").Parse(debugText))
 
-type debugHTTP struct {
-	*Server
+// DebugSnapshot 服务器上所有服务与方法的统计快照
+// 字段与 JSON 名称保持稳定，供调试页面与外部程序使用
+type DebugSnapshot struct {
+	Services []ServiceSnapshot `json:"services"` // 按服务名与版本排序
 }
 
-type debugService struct {
-	Name   string
-	Method map[string]*methodType
+// ServiceSnapshot 单个服务的快照
+type ServiceSnapshot struct {
+	Name          string           `json:"name"`
+	Version       string           `json:"version,omitempty"` // 同名服务的不同版本分别列出
+	InFlight      int64            `json:"in_flight"`         // 正在处理的请求数
+	MaxConcurrent int              `json:"max_concurrent"`    // 并发上限，0表示不限制
+	Methods       []MethodSnapshot `json:"methods"`           // 按调用次数降序排列
 }
 
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var services []debugService
+// MethodSnapshot 单个方法的快照，耗时在 JSON 中以纳秒表示
+type MethodSnapshot struct {
+	Name          string        `json:"name"`
+	ArgType       string        `json:"arg_type"`
+	ReplyType     string        `json:"reply_type"`
+	Embedded      string        `json:"embedded,omitempty"` // 方法提升自的嵌入字段
+	Calls         uint64        `json:"calls"`
+	Errors        uint64        `json:"errors"`
+	Timeouts      uint64        `json:"timeouts"`
+	TotalDuration time.Duration `json:"total_ns"`
+	Avg           time.Duration `json:"avg_ns"`
+	Min           time.Duration `json:"min_ns"`
+	Max           time.Duration `json:"max_ns"`
+	P50           time.Duration `json:"p50_ns"`
+	P99           time.Duration `json:"p99_ns"`
+}
+
+// Snapshot 返回服务器当前所有服务与方法的统计快照，可与请求处理并发调用
+func (server *Server) Snapshot() DebugSnapshot {
+	var snap DebugSnapshot
 	server.serviceMap.Range(func(key, val interface{}) bool {
 		svc := val.(*service)
-		services = append(services, debugService{
-			Name:   key.(string),
-			Method: svc.method,
+		methods := make([]MethodSnapshot, 0, len(svc.method))
+		for name, mtype := range svc.method {
+			stats := mtype.stats.snapshot()
+			var replyType string
+			if mtype.ReplyType != nil {
+				replyType = mtype.ReplyType.String()
+			}
+			methods = append(methods, MethodSnapshot{
+				Name:          name,
+				ArgType:       mtype.ArgType.String(),
+				ReplyType:     replyType,
+				Embedded:      mtype.embedded,
+				Calls:         stats.Calls,
+				Errors:        stats.Errors,
+				Timeouts:      stats.Timeouts,
+				TotalDuration: stats.TotalDuration,
+				Avg:           stats.Avg,
+				Min:           stats.Min,
+				Max:           stats.Max,
+				P50:           stats.P50,
+				P99:           stats.P99,
+			})
+		}
+		sort.Slice(methods, func(i, j int) bool {
+			if methods[i].Calls != methods[j].Calls {
+				return methods[i].Calls > methods[j].Calls
+			}
+			return methods[i].Name < methods[j].Name
 		})
+		ss := ServiceSnapshot{Name: svc.name, Version: svc.version, InFlight: atomic.LoadInt64(&svc.inFlight), Methods: methods}
+		if l, _ := svc.limit.Load().(*serviceLimit); l != nil {
+			ss.MaxConcurrent, _ = l.limits()
+		}
+		snap.Services = append(snap.Services, ss)
 		return true
 	})
-	err := debug.Execute(w, services)
+	sort.Slice(snap.Services, func(i, j int) bool {
+		a, b := snap.Services[i], snap.Services[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Version < b.Version
+	})
+	return snap
+}
+
+// EnableDebugHTTP 开启或关闭调试页面，可以在服务期间随时调用；默认关闭，关闭时调试路径返回 404
+// 调试页面会暴露所有服务与方法的名称及调用统计，对外开放时应同时通过 SetDebugAuthorizer 限制访问
+func (server *Server) EnableDebugHTTP(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&server.debugEnabled, v)
+}
+
+// SetDebugAuthorizer 设置调试页面的访问控制，f 返回 false 时返回 403，f 为 nil 表示不限制
+func (server *Server) SetDebugAuthorizer(f func(r *http.Request) bool) {
+	server.debugAuth.Store(f)
+}
+
+// debugAllowed 检查调试页面是否开启以及请求是否有权访问，不允许时写入错误响应并返回 false
+func (server *Server) debugAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if atomic.LoadInt32(&server.debugEnabled) == 0 {
+		http.NotFound(w, r)
+		return false
+	}
+	if f, _ := server.debugAuth.Load().(func(r *http.Request) bool); f != nil && !f(r) {
+		server.log().Debug("rpc server: debug page access denied", "remote", r.RemoteAddr)
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type debugHTTP struct {
+	*Server
+}
+
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		debugJSON(server).ServeHTTP(w, r)
+		return
+	}
+	if !server.debugAllowed(w, r) {
+		return
+	}
+	// 先渲染到缓冲区，模板执行失败时不会输出不完整的页面
+	var buf bytes.Buffer
+	if err := debug.Execute(&buf, server.Snapshot()); err != nil {
+		http.Error(w, "rpc: error executing template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// debugJSON 以 JSON 格式输出 DebugSnapshot
+type debugJSON struct {
+	*Server
+}
+
+func (server debugJSON) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !server.debugAllowed(w, r) {
+		return
+	}
+	data, err := json.Marshal(server.Snapshot())
 	if err != nil {
-		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+		http.Error(w, "rpc: error encoding snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(data, '\n'))
 }
@@ -1,5 +1,18 @@
 package geerpc
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
 //func TestDebugHTTP_ServeHTTP(t *testing.T) {
 //	ch := make(chan struct{})
 //	addr := "127.0.0.1:9999"
@@ -15,3 +28,132 @@ package geerpc
 //	_, err := XDial("tcp@" + addr)
 //	assert.Nil(t, err, "failed to connect tcp")
 //}
+
+type Stat int
+
+func (s Stat) Fast(n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+func (s Stat) Slow(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func (s Stat) Fail(n int, reply *int) error {
+	return errors.New("stat: failed")
+}
+
+func TestDebugHTTP_Stats(t *testing.T) {
+	server := NewServer()
+	server.EnableDebugHTTP(true)
+	_ = server.Register(new(Stat))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 100 * time.Millisecond})
+	assert.Nil(t, err)
+	var reply int
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, client.Call(context.Background(), "Stat.Fast", i, &reply))
+	}
+	for i := 0; i < 2; i++ {
+		assert.NotNil(t, client.Call(context.Background(), "Stat.Fail", i, &reply))
+	}
+	assert.Nil(t, client.Call(context.Background(), "Stat.Slow", 20*time.Millisecond, &reply))
+	err = client.Call(context.Background(), "Stat.Slow", 300*time.Millisecond, &reply)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "handle timeout"))
+	time.Sleep(300 * time.Millisecond) // 等待超时的调用执行结束
+
+	stats := server.MethodStats()
+	fast := stats["Stat.Fast"]
+	assert.Equal(t, uint64(3), fast.Calls)
+	assert.Equal(t, uint64(0), fast.Errors)
+	fail := stats["Stat.Fail"]
+	assert.Equal(t, uint64(2), fail.Calls)
+	assert.Equal(t, uint64(2), fail.Errors)
+	slow := stats["Stat.Slow"]
+	assert.Equal(t, uint64(2), slow.Calls)
+	assert.Equal(t, uint64(1), slow.Timeouts)
+	assert.True(t, slow.Min >= 20*time.Millisecond && slow.Max >= 300*time.Millisecond)
+	assert.True(t, slow.P50 >= slow.Min && slow.P99 <= slow.Max)
+	assert.True(t, fast.Max < slow.Min)
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultDebugPath, nil))
+	body := w.Body.String()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, body, "Service Stat")
+	assert.Contains(t, body, "<th align=center>P99</th>")
+	fastAt, failAt := strings.Index(body, "Fast("), strings.Index(body, "Fail(")
+	assert.True(t, fastAt >= 0 && fastAt < failAt, "methods should be sorted by call count")
+}
+
+func TestDebugJSON_ServeHTTP(t *testing.T) {
+	server := NewServer()
+	server.EnableDebugHTTP(true)
+	_ = server.Register(new(Stat))
+	_ = server.Register(new(Foo))
+	svc, mtype, _ := server.findService("Stat.Fast")
+	for i := 0; i < 2; i++ {
+		_ = svc.call(context.Background(), mtype, mtype.newArgv(), mtype.newReplyv())
+	}
+
+	check := func(t *testing.T, w *httptest.ResponseRecorder) {
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var snap DebugSnapshot
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &snap))
+		assert.Equal(t, server.Snapshot(), snap)
+		assert.Equal(t, 2, len(snap.Services))
+		assert.Equal(t, "Foo", snap.Services[0].Name)
+		stat := snap.Services[1]
+		assert.Equal(t, "Stat", stat.Name)
+		assert.Equal(t, "Fast", stat.Methods[0].Name)
+		assert.Equal(t, "int", stat.Methods[0].ArgType)
+		assert.Equal(t, "*int", stat.Methods[0].ReplyType)
+		assert.Equal(t, uint64(2), stat.Methods[0].Calls)
+	}
+	t.Run("json path", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		debugJSON{server}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultDebugJSONPath, nil))
+		check(t, w)
+	})
+	t.Run("accept header", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, defaultDebugPath, nil)
+		r.Header.Set("Accept", "application/json")
+		debugHTTP{server}.ServeHTTP(w, r)
+		check(t, w)
+	})
+}
+
+func TestDebugHTTP_AccessControl(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Stat))
+	mux := http.NewServeMux()
+	assert.Nil(t, server.RegisterHTTP(mux, "/rpc", defaultDebugPath))
+	get := func(path, user string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-User", user)
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, path := range []string{defaultDebugPath, defaultDebugJSONPath} {
+		assert.Equal(t, http.StatusNotFound, get(path, "admin"), "disabled by default: %s", path)
+	}
+	server.EnableDebugHTTP(true)
+	server.SetDebugAuthorizer(func(r *http.Request) bool { return r.Header.Get("X-User") == "admin" })
+	for _, path := range []string{defaultDebugPath, defaultDebugJSONPath} {
+		assert.Equal(t, http.StatusForbidden, get(path, "guest"), path)
+		assert.Equal(t, http.StatusOK, get(path, "admin"), path)
+	}
+	server.SetDebugAuthorizer(nil)
+	assert.Equal(t, http.StatusOK, get(defaultDebugPath, "guest"))
+	server.EnableDebugHTTP(false)
+	assert.Equal(t, http.StatusNotFound, get(defaultDebugPath, "admin"))
+}
@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"errors"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// drainMethod 服务端开始关闭时推送的控制帧的 ServiceMethod，表示连接上不应再发送新的请求
+const drainMethod = "_geerpc.Drain"
+
+// ErrServerDraining 服务端正在关闭，客户端不再在该连接上发送新的请求
+// 连接池与 XClient 可以据此换用其他连接
+var ErrServerDraining = errors.New("rpc client: server is draining")
+
+// OnShutdown 添加在 Shutdown 开始时调用的回调，如切换健康状态、从注册中心注销
+// 回调按添加的顺序在 Shutdown 中同步调用，之后服务端才停止接收新连接并通知客户端；多次调用 Shutdown 时只调用一次
+func (server *Server) OnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.onShutdown = append(server.onShutdown, f)
+}
+
+// startShutdown 调用 OnShutdown 回调，回调 panic 时仅记录日志
+func (server *Server) startShutdown() {
+	server.mu.Lock()
+	hooks := server.onShutdown
+	server.onShutdown = nil
+	server.mu.Unlock()
+	for _, f := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					server.log().Error("rpc server: OnShutdown panic", "panic", r)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+// Draining 返回服务端通知连接即将关闭时被关闭的 channel，之后 Go 与 Call 直接返回 ErrServerDraining
+// 已经发送的请求仍会收到响应
+func (client *Client) Draining() <-chan struct{} {
+	return client.draining
+}
+
+// drain 处理服务端的关闭通知
+func (client *Client) drain(h *codec.Header) error {
+	client.mu.Lock()
+	if !client.drained {
+		client.drained = true
+		close(client.draining)
+	}
+	client.mu.Unlock()
+	return client.c.ReadBody(nil)
+}
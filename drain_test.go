@@ -0,0 +1,85 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+func TestServer_OnShutdownDrain(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Bar)))
+	assert.Nil(t, server.Register(new(Foo)))
+	var hooks []string
+	server.OnShutdown(func() { hooks = append(hooks, "health") })
+	server.OnShutdown(func() { panic("registry unavailable") })
+	server.OnShutdown(func() { hooks = append(hooks, "registry") })
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	slow := client.Go("Bar.Timeout", 1, new(int), nil)
+	time.Sleep(100 * time.Millisecond)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	select {
+	case <-client.Draining():
+	case <-time.After(time.Second):
+		t.Fatal("client did not observe draining")
+	}
+	assert.Equal(t, []string{"health", "registry"}, hooks)
+	assert.False(t, client.IsAvailable())
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+	assert.Equal(t, ErrServerDraining, err)
+	call := <-client.Go("Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int), nil).Done
+	assert.Equal(t, ErrServerDraining, call.Error)
+
+	assert.Nil(t, (<-slow.Done).Error, "in-flight call completes")
+	assert.Nil(t, <-shutdown)
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, []string{"health", "registry"}, hooks, "hooks run once")
+}
+
+func TestServer_ShutdownDrainsBusyConn(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Blob)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.Nil(t, json.NewEncoder(conn).Encode(DefaultOption))
+	time.Sleep(50 * time.Millisecond)
+
+	// 响应填满缓冲区后客户端暂停读取，时间超过默认的 BroadcastTimeout
+	size := 8 << 20
+	cc := codec.NewGobCodec(conn)
+	assert.Nil(t, cc.Write(&codec.Header{ServiceMethod: "Blob.Repeat", Seq: 1, HasBody: true}, size))
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+	time.Sleep(defaultBroadcastTimeout + 500*time.Millisecond)
+
+	// 响应完整送达，之后才收到关闭通知
+	var h codec.Header
+	var reply string
+	assert.Nil(t, cc.ReadHeader(&h))
+	assert.Equal(t, uint64(1), h.Seq)
+	assert.Nil(t, cc.ReadBody(&reply))
+	assert.Equal(t, size, len(reply))
+	assert.Nil(t, cc.ReadHeader(&h))
+	assert.Equal(t, drainMethod, h.ServiceMethod)
+	assert.Nil(t, cc.ReadBody(nil))
+	assert.Nil(t, <-shutdown)
+}
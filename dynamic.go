@@ -0,0 +1,93 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/yqchilde/gee-rpc/codec"
+	"github.com/yqchilde/gee-rpc/dynamic"
+)
+
+// DynamicValueKey CallDynamic 的应答不是对象（如 int）时，应答在 map 中使用的键
+const DynamicValueKey = "value"
+
+// dynamicMethod CallDynamic 还原出的方法参数与应答类型
+type dynamicMethod struct {
+	arg, reply reflect.Type
+}
+
+// CallDynamic 以 map 作为参数与应答调用方法，用于网关与工具等无法导入参数类型的场景
+//
+// 客户端先通过服务端的反射服务（Server.EnableReflection）获取方法的参数与应答类型，并在连接上缓存，
+// 再按类型构造参数：不存在的字段返回错误，JSON 中的 float64 在不损失精度时转换为整数，转换规则见 dynamic.Value。
+// 应答以 encoding/json 的规则转换为 map，不是对象的应答保存在键 DynamicValueKey 中。
+// 使用 JSON 编解码器且服务端没有开启反射服务时，参数直接编码为 JSON 对象发送，由服务端解码；
+// 使用 gob 编解码器时必须开启反射服务
+func (client *Client) CallDynamic(ctx context.Context, serviceMethod string, args map[string]interface{}, reply *map[string]interface{}, opts ...CallOption) error {
+	m, err := client.dynamicMethod(ctx, serviceMethod, opts)
+	if err != nil {
+		if !errors.Is(err, ErrReflectionUnavailable) || client.opt.CodecType != codec.JsonType {
+			return fmt.Errorf("rpc client: dynamic call %s: %w", serviceMethod, err)
+		}
+		var raw json.RawMessage
+		if err := client.Call(ctx, serviceMethod, args, &raw, opts...); err != nil {
+			return err
+		}
+		return dynamicReply(raw, reply)
+	}
+	argv, err := dynamic.Value(m.arg, args)
+	if err != nil {
+		return fmt.Errorf("rpc client: dynamic call %s: %w", serviceMethod, err)
+	}
+	replyv := reflect.New(m.reply)
+	if err := client.Call(ctx, serviceMethod, argv.Interface(), replyv.Interface(), opts...); err != nil {
+		return err
+	}
+	data, err := json.Marshal(replyv.Interface())
+	if err != nil {
+		return fmt.Errorf("rpc client: dynamic call %s: %w", serviceMethod, err)
+	}
+	return dynamicReply(data, reply)
+}
+
+// dynamicMethod 返回 serviceMethod 的参数与应答类型，优先使用缓存
+func (client *Client) dynamicMethod(ctx context.Context, serviceMethod string, opts []CallOption) (*dynamicMethod, error) {
+	if m, ok := client.dynamic.Load(serviceMethod); ok {
+		return m.(*dynamicMethod), nil
+	}
+	md, err := client.Describe(ctx, serviceMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if md.Stream != "" {
+		return nil, fmt.Errorf("%s is a %s streaming method", serviceMethod, md.Stream)
+	}
+	arg, err := md.Arg.GoType()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := md.Reply.GoType()
+	if err != nil {
+		return nil, err
+	}
+	m := &dynamicMethod{arg: arg, reply: reply}
+	client.dynamic.Store(serviceMethod, m)
+	return m, nil
+}
+
+// dynamicReply 将 JSON 编码的应答解码到 reply
+func dynamicReply(data []byte, reply *map[string]interface{}) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("rpc client: decode dynamic reply: %w", err)
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		*reply = m
+	} else {
+		*reply = map[string]interface{}{DynamicValueKey: v}
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+// Package dynamic 在不导入 Go 类型的情况下构造 RPC 的参数与应答
+//
+// 服务端用 TypeOf 描述方法的参数与应答类型，描述可以通过 gob 或 JSON 传输；
+// 客户端用 Type.GoType 还原出结构相同的类型，再用 Value 把 JSON 解码得到的
+// map[string]interface{} 等通用值转换为该类型的值。gob 按字段名而不是类型名匹配结构体，
+// 所以还原出的匿名结构体可以直接与服务端的类型互相编解码。
+package dynamic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type 类型的描述
+type Type struct {
+	Name      string  `json:"name,omitempty"`      // 类型名，如 "main.Args"，匿名类型为空
+	Kind      string  `json:"kind"`                // reflect.Kind 的名称，如 "struct"、"int"
+	Elem      *Type   `json:"elem,omitempty"`      // 指针、切片、数组与 map 的元素类型
+	Key       *Type   `json:"key,omitempty"`       // map 的键类型
+	Len       int     `json:"len,omitempty"`       // 数组长度
+	Fields    []Field `json:"fields,omitempty"`    // 结构体的导出字段
+	Recursive bool    `json:"recursive,omitempty"` // 结构体在描述中递归引用了自身，没有列出字段
+}
+
+// Field 结构体字段的描述
+type Field struct {
+	Name string `json:"name"`
+	Type *Type  `json:"type"`
+}
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+// known 描述中按名称还原的类型，它们没有导出字段，由自身实现编解码
+var known = map[string]reflect.Type{
+	typeOfTime.String(): typeOfTime,
+}
+
+// TypeOf 返回 t 的描述，只列出结构体的导出字段，与 gob 和 encoding/json 的规则一致
+func TypeOf(t reflect.Type) *Type {
+	return typeOf(t, make(map[reflect.Type]bool))
+}
+
+func typeOf(t reflect.Type, visiting map[reflect.Type]bool) *Type {
+	d := &Type{Kind: t.Kind().String()}
+	if t.Name() != "" {
+		d.Name = t.String()
+	}
+	if _, ok := known[d.Name]; ok {
+		return d
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		d.Elem = typeOf(t.Elem(), visiting)
+	case reflect.Array:
+		d.Elem, d.Len = typeOf(t.Elem(), visiting), t.Len()
+	case reflect.Map:
+		d.Key, d.Elem = typeOf(t.Key(), visiting), typeOf(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			d.Recursive = true
+			return d
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			d.Fields = append(d.Fields, Field{Name: f.Name, Type: typeOf(f.Type, visiting)})
+		}
+	}
+	return d
+}
+
+// String 返回类型名，匿名类型返回其结构，如 "[]int"、"struct { A int }"
+func (t *Type) String() string {
+	if t == nil {
+		return "<nil>"
+	}
+	if t.Name != "" {
+		return t.Name
+	}
+	switch t.Kind {
+	case "ptr":
+		return "*" + t.Elem.String()
+	case "slice":
+		return "[]" + t.Elem.String()
+	case "array":
+		return "[" + strconv.Itoa(t.Len) + "]" + t.Elem.String()
+	case "map":
+		return "map[" + t.Key.String() + "]" + t.Elem.String()
+	case "struct":
+		if len(t.Fields) == 0 {
+			return "struct {}"
+		}
+		fields := make([]string, len(t.Fields))
+		for i, f := range t.Fields {
+			fields[i] = f.Name + " " + f.Type.String()
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }"
+	}
+	return t.Kind
+}
+
+// kinds 可以还原的基础类型
+var kinds = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+	} {
+		t := reflect.TypeOf(v)
+		kinds[t.Kind().String()] = t
+	}
+	kinds[reflect.Interface.String()] = reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// GoType 还原出与描述结构相同的类型，命名类型还原为对应的匿名类型，
+// 递归的结构体以及函数、通道等无法编码的类型返回错误
+func (t *Type) GoType() (reflect.Type, error) {
+	if t == nil {
+		return nil, fmt.Errorf("dynamic: nil type")
+	}
+	if k, ok := known[t.Name]; ok {
+		return k, nil
+	}
+	if k, ok := kinds[t.Kind]; ok {
+		return k, nil
+	}
+	switch t.Kind {
+	case "ptr", "slice", "array", "map":
+		elem, err := t.Elem.GoType()
+		if err != nil {
+			return nil, err
+		}
+		switch t.Kind {
+		case "ptr":
+			return reflect.PtrTo(elem), nil
+		case "slice":
+			return reflect.SliceOf(elem), nil
+		case "array":
+			return reflect.ArrayOf(t.Len, elem), nil
+		}
+		key, err := t.Key.GoType()
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, fmt.Errorf("dynamic: invalid map key type %s", t.Key)
+		}
+		return reflect.MapOf(key, elem), nil
+	case "struct":
+		if t.Recursive {
+			return nil, fmt.Errorf("dynamic: recursive type %s is not supported", t)
+		}
+		fields := make([]reflect.StructField, len(t.Fields))
+		for i, f := range t.Fields {
+			ft, err := f.Type.GoType()
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = reflect.StructField{Name: f.Name, Type: ft}
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("dynamic: unsupported type %s", t)
+}
+
+// Unmarshal 将 JSON 解码为 t 类型的值，与 Value 的转换规则相同，数字不会经过 float64 损失精度
+func Unmarshal(t reflect.Type, data []byte) (reflect.Value, error) {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return reflect.Value{}, fmt.Errorf("dynamic: %v", err)
+	}
+	if dec.More() {
+		return reflect.Value{}, fmt.Errorf("dynamic: unexpected data after JSON value")
+	}
+	return Value(t, v)
+}
+
+// Value 将 JSON 解码得到的通用值（map[string]interface{}、[]interface{}、float64、json.Number、string、bool 与 nil）
+// 转换为 t 类型的值。结构体字段先按名称精确匹配，再忽略大小写匹配，不存在的字段返回错误；
+// 数字转换为整数时必须是整数且不溢出；time.Time 使用 RFC 3339 字符串；[]byte 使用 base64 字符串
+func Value(t reflect.Type, v interface{}) (reflect.Value, error) {
+	rv := reflect.New(t).Elem()
+	if err := set(rv, v, ""); err != nil {
+		return reflect.Value{}, err
+	}
+	return rv, nil
+}
+
+// convertError 转换失败的错误，path 为出错的位置，如 "Items[0].Price"
+func convertError(path string, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if path == "" {
+		return fmt.Errorf("dynamic: %s", msg)
+	}
+	return fmt.Errorf("dynamic: %s: %s", path, msg)
+}
+
+func set(rv reflect.Value, v interface{}, path string) error {
+	if v == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	t := rv.Type()
+	if t == typeOfTime {
+		s, ok := v.(string)
+		if !ok {
+			return convertError(path, "cannot convert %T to time.Time, want an RFC 3339 string", v)
+		}
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return convertError(path, "%v", err)
+		}
+		rv.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := set(p.Elem(), v, path); err != nil {
+			return err
+		}
+		rv.Set(p)
+	case reflect.Interface:
+		if n, ok := v.(json.Number); ok {
+			// 与 encoding/json 解码到 interface{} 的结果保持一致
+			f, err := n.Float64()
+			if err != nil {
+				return convertError(path, "%v", err)
+			}
+			v = f
+		}
+		val := reflect.ValueOf(v)
+		if !val.Type().AssignableTo(t) {
+			return convertError(path, "cannot assign %T to %s", v, t)
+		}
+		rv.Set(val)
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return convertError(path, "cannot convert %T to %s, want an object", v, t)
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := field(t, k)
+			if !ok {
+				return convertError(path, "unknown field %q in %s", k, t)
+			}
+			if err := set(rv.FieldByIndex(f.Index), m[k], join(path, f.Name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return convertError(path, "cannot convert %T to %s, want an object", v, t)
+		}
+		out := reflect.MakeMapWithSize(t, len(m))
+		for k, e := range m {
+			key := reflect.New(t.Key()).Elem()
+			if err := setKey(key, k, path); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := set(elem, e, path+"["+strconv.Quote(k)+"]"); err != nil {
+				return err
+			}
+			out.SetMapIndex(key, elem)
+		}
+		rv.Set(out)
+	case reflect.Slice:
+		if s, ok := v.(string); ok && t.Elem().Kind() == reflect.Uint8 {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return convertError(path, "%v", err)
+			}
+			rv.SetBytes(b)
+			return nil
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			return convertError(path, "cannot convert %T to %s, want an array", v, t)
+		}
+		out := reflect.MakeSlice(t, len(a), len(a))
+		for i, e := range a {
+			if err := set(out.Index(i), e, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+		rv.Set(out)
+	case reflect.Array:
+		a, ok := v.([]interface{})
+		if !ok {
+			return convertError(path, "cannot convert %T to %s, want an array", v, t)
+		}
+		if len(a) != t.Len() {
+			return convertError(path, "array of length %d does not fit %s", len(a), t)
+		}
+		for i, e := range a {
+			if err := set(rv.Index(i), e, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return convertError(path, "cannot convert %T to %s", v, t)
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return convertError(path, "cannot convert %T to %s", v, t)
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt(v)
+		if err != nil {
+			return convertError(path, "cannot convert %v to %s: %v", v, t, err)
+		}
+		if rv.OverflowInt(n) {
+			return convertError(path, "%d overflows %s", n, t)
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := toUint(v)
+		if err != nil {
+			return convertError(path, "cannot convert %v to %s: %v", v, t, err)
+		}
+		if rv.OverflowUint(n) {
+			return convertError(path, "%d overflows %s", n, t)
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := toFloat(v)
+		if err != nil {
+			return convertError(path, "cannot convert %v to %s: %v", v, t, err)
+		}
+		if rv.OverflowFloat(f) {
+			return convertError(path, "%v overflows %s", f, t)
+		}
+		rv.SetFloat(f)
+	default:
+		return convertError(path, "unsupported type %s", t)
+	}
+	return nil
+}
+
+// field 按名称查找结构体的导出字段，没有精确匹配时忽略大小写
+func field(t reflect.Type, name string) (reflect.StructField, bool) {
+	if f, ok := t.FieldByName(name); ok && f.PkgPath == "" {
+		return f, true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" && strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// setKey 将 JSON 对象的键转换为 map 的键，支持字符串与整数类型
+func setKey(key reflect.Value, k, path string) error {
+	switch key.Kind() {
+	case reflect.String:
+		key.SetString(k)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return set(key, json.Number(k), path+"["+strconv.Quote(k)+"]")
+	}
+	return convertError(path, "unsupported map key type %s", key.Type())
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func toInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return strconv.ParseInt(string(n), 10, 64)
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+			return 0, fmt.Errorf("not an integer")
+		}
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("not a number")
+}
+
+func toUint(v interface{}) (uint64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return strconv.ParseUint(string(n), 10, 64)
+	case float64:
+		if n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 {
+			return 0, fmt.Errorf("not an unsigned integer")
+		}
+		return uint64(n), nil
+	}
+	return 0, fmt.Errorf("not a number")
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.Float64()
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("not a number")
+}
@@ -0,0 +1,132 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Item struct {
+	Name  string
+	Price float64
+	Count uint8
+}
+
+type Order struct {
+	ID      int64
+	Items   []Item
+	Tags    map[string]int
+	Note    *string
+	Created time.Time
+	Extra   interface{}
+	secret  int
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func TestTypeOf(t *testing.T) {
+	d := TypeOf(reflect.TypeOf(Order{}))
+	assert.Equal(t, "dynamic.Order", d.Name)
+	assert.Equal(t, "struct", d.Kind)
+	names := make([]string, len(d.Fields))
+	for i, f := range d.Fields {
+		names[i] = f.Name
+	}
+	assert.Equal(t, []string{"ID", "Items", "Tags", "Note", "Created", "Extra"}, names, "unexported fields are skipped")
+	assert.Equal(t, "[]dynamic.Item", d.Fields[1].Type.String())
+	assert.Equal(t, "*string", d.Fields[3].Type.String())
+	assert.Nil(t, d.Fields[4].Type.Fields, "time.Time is described by name")
+
+	node := TypeOf(reflect.TypeOf(Node{}))
+	assert.True(t, node.Fields[1].Type.Elem.Recursive)
+	_, err := node.GoType()
+	assert.EqualError(t, err, "dynamic: recursive type dynamic.Node is not supported")
+
+	// 描述可以通过 JSON 与 gob 传输
+	data, err := json.Marshal(d)
+	assert.Nil(t, err)
+	var fromJSON Type
+	assert.Nil(t, json.Unmarshal(data, &fromJSON))
+	assert.Equal(t, d, &fromJSON)
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(d))
+	var fromGob Type
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(&fromGob))
+	assert.Equal(t, d.String(), fromGob.String())
+}
+
+func TestGoType_Gob(t *testing.T) {
+	typ, err := TypeOf(reflect.TypeOf(Order{})).GoType()
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.Struct, typ.Kind())
+	assert.Equal(t, "", typ.Name())
+
+	v, err := Unmarshal(typ, []byte(`{"ID": 9007199254740993, "items": [{"Name": "pen", "Price": 1.5, "Count": 2}],
+		"Tags": {"a": 1}, "Note": "gift", "Created": "2024-01-02T03:04:05Z"}`))
+	assert.Nil(t, err)
+
+	// 还原出的匿名类型与原始类型可以通过 gob 互相编解码
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(v.Interface()))
+	var order Order
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(&order))
+	note := "gift"
+	assert.Equal(t, Order{
+		ID:      9007199254740993,
+		Items:   []Item{{Name: "pen", Price: 1.5, Count: 2}},
+		Tags:    map[string]int{"a": 1},
+		Note:    &note,
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, order)
+
+	buf.Reset()
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(order))
+	reply := reflect.New(typ)
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(reply.Interface()))
+	data, err := json.Marshal(reply.Interface())
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"Items":[{"Name":"pen","Price":1.5,"Count":2}]`)
+}
+
+func TestValue(t *testing.T) {
+	typ := reflect.TypeOf(Order{})
+	v, err := Value(typ, map[string]interface{}{"ID": float64(7), "Extra": json.Number("2.5"), "Tags": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, Order{ID: 7, Extra: 2.5}, v.Interface())
+
+	v, err = Value(reflect.TypeOf(map[int]string{}), map[string]interface{}{"1": "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "a"}, v.Interface())
+
+	v, err = Value(reflect.TypeOf([]byte{}), "aGk=")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hi"), v.Interface())
+
+	for _, tc := range []struct {
+		v   interface{}
+		err string
+	}{
+		{map[string]interface{}{"Foo": 1}, `dynamic: unknown field "Foo" in dynamic.Order`},
+		{map[string]interface{}{"secret": 1}, `dynamic: unknown field "secret" in dynamic.Order`},
+		{map[string]interface{}{"ID": 1.5}, "dynamic: ID: cannot convert 1.5 to int64: not an integer"},
+		{map[string]interface{}{"ID": "1"}, "dynamic: ID: cannot convert 1 to int64: not a number"},
+		{map[string]interface{}{"Items": []interface{}{map[string]interface{}{"Count": float64(256)}}}, "dynamic: Items[0].Count: 256 overflows uint8"},
+		{map[string]interface{}{"Items": map[string]interface{}{}}, "dynamic: Items: cannot convert map[string]interface {} to []dynamic.Item, want an array"},
+		{map[string]interface{}{"Created": "yesterday"}, `dynamic: Created: parsing time "yesterday" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "yesterday" as "2006"`},
+		{[]interface{}{}, "dynamic: cannot convert []interface {} to dynamic.Order, want an object"},
+	} {
+		_, err := Value(typ, tc.v)
+		assert.EqualError(t, err, tc.err)
+	}
+
+	_, err = Unmarshal(typ, []byte(`{"ID": 1} {}`))
+	assert.EqualError(t, err, "dynamic: unexpected data after JSON value")
+}
@@ -0,0 +1,111 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+type DynItem struct {
+	Name  string
+	Count uint8
+}
+
+type DynOrder struct {
+	ID    int64
+	Items []DynItem
+	Note  *string
+}
+
+type DynReceipt struct {
+	ID    int64
+	Total int
+}
+
+// DynShop 记录收到的参数
+type DynShop struct {
+	got chan DynOrder
+}
+
+func (s *DynShop) Place(order DynOrder, reply *DynReceipt) error {
+	s.got <- order
+	*reply = DynReceipt{ID: order.ID}
+	for _, item := range order.Items {
+		reply.Total += int(item.Count)
+	}
+	return nil
+}
+
+func startDynamicServer(t *testing.T, reflection bool) (string, *DynShop) {
+	server := NewServer()
+	shop := &DynShop{got: make(chan DynOrder, 1)}
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(shop))
+	if reflection {
+		assert.Nil(t, server.EnableReflection())
+	}
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	t.Cleanup(func() { _ = server.Close() })
+	return addr, shop
+}
+
+func TestClient_CallDynamic(t *testing.T) {
+	addr, shop := startDynamicServer(t, true)
+	ctx := context.Background()
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		t.Run(string(ct), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodecType: ct})
+			assert.Nil(t, err)
+			defer func() { _ = client.Close() }()
+
+			// JSON 解码得到的数字为 float64
+			var reply map[string]interface{}
+			assert.Nil(t, client.CallDynamic(ctx, "Foo.Sum", map[string]interface{}{"Num1": float64(1), "Num2": float64(2)}, &reply))
+			assert.Equal(t, map[string]interface{}{DynamicValueKey: float64(3)}, reply)
+
+			args := map[string]interface{}{
+				"ID":    float64(42),
+				"Items": []interface{}{map[string]interface{}{"Name": "pen", "Count": float64(2)}, map[string]interface{}{"name": "ink", "count": float64(3)}},
+				"Note":  "gift",
+			}
+			assert.Nil(t, client.CallDynamic(ctx, "DynShop.Place", args, &reply))
+			assert.Equal(t, map[string]interface{}{"ID": float64(42), "Total": float64(5)}, reply)
+			note := "gift"
+			assert.Equal(t, DynOrder{ID: 42, Items: []DynItem{{"pen", 2}, {"ink", 3}}, Note: &note}, <-shop.got)
+
+			err = client.CallDynamic(ctx, "DynShop.Place", map[string]interface{}{"Id": float64(1), "Coupon": "x"}, &reply)
+			assert.EqualError(t, err, `rpc client: dynamic call DynShop.Place: dynamic: unknown field "Coupon" in struct { ID int64; Items []struct { Name string; Count uint8 }; Note *string }`)
+			err = client.CallDynamic(ctx, "DynShop.Place", map[string]interface{}{"ID": 1.5}, &reply)
+			assert.EqualError(t, err, "rpc client: dynamic call DynShop.Place: dynamic: ID: cannot convert 1.5 to int64: not an integer")
+			err = client.CallDynamic(ctx, "DynShop.Missing", args, &reply)
+			assert.True(t, err != nil && strings.Contains(err.Error(), "can't find method Missing"), err)
+			assert.Equal(t, 0, len(shop.got))
+		})
+	}
+}
+
+func TestClient_CallDynamic_NoReflection(t *testing.T) {
+	addr, _ := startDynamicServer(t, false)
+	ctx := context.Background()
+	args := map[string]interface{}{"Num1": float64(1), "Num2": float64(2)}
+	var reply map[string]interface{}
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodecType: codec.GobType})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	err = client.CallDynamic(ctx, "Foo.Sum", args, &reply)
+	assert.EqualError(t, err, "rpc client: dynamic call Foo.Sum: rpc client: server does not expose the reflection service")
+	assert.True(t, errors.Is(err, ErrReflectionUnavailable))
+
+	// JSON 编解码器直接发送参数
+	client, err = Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodecType: codec.JsonType})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	assert.Nil(t, client.CallDynamic(ctx, "Foo.Sum", args, &reply))
+	assert.Equal(t, map[string]interface{}{DynamicValueKey: float64(3)}, reply)
+}
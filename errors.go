@@ -0,0 +1,102 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// ErrorCode 错误响应携带的错误码，客户端据此将错误还原为对应的哨兵错误
+type ErrorCode int
+
+const (
+	CodeUnknown          ErrorCode = iota // 方法返回的普通错误
+	CodeInvalidArgument                   // 参数未通过 Validate 校验
+	CodeServiceBusy                       // 服务达到并发上限
+	CodeServerBusy                        // 服务器无法再接收请求
+	CodeSignatureInvalid                  // 请求签名校验失败
+	CodePermissionDenied                  // 请求未通过授权
+	CodeUnauthenticated                   // 请求没有携带有效的令牌
+	CodeTokenExpired                      // 令牌已过期
+	CodeHandleTimeout                     // 请求处理超时
+)
+
+var (
+	// ErrInvalidArgument 参数未通过校验时返回的错误，可以用 errors.Is 判断
+	ErrInvalidArgument = errors.New("rpc server: invalid argument")
+	// ErrHandleTimeout 服务端处理请求超时，可以用 errors.Is 判断
+	ErrHandleTimeout = errors.New("rpc server: request handle timeout")
+)
+
+// codeErrors 错误码与哨兵错误的对应关系
+var codeErrors = map[ErrorCode]error{
+	CodeInvalidArgument:  ErrInvalidArgument,
+	CodeServiceBusy:      ErrServiceBusy,
+	CodeServerBusy:       ErrServerBusy,
+	CodeSignatureInvalid: ErrSignatureInvalid,
+	CodePermissionDenied: ErrPermissionDenied,
+	CodeUnauthenticated:  ErrUnauthenticated,
+	CodeTokenExpired:     ErrTokenExpired,
+	CodeHandleTimeout:    ErrHandleTimeout,
+}
+
+// errorCode 返回 err 对应的错误码
+func errorCode(err error) ErrorCode {
+	for code, target := range codeErrors {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return CodeUnknown
+}
+
+// setError 将 err 写入响应头
+func setError(h *codec.Header, err error) {
+	h.Error = err.Error()
+	h.Code = int(errorCode(err))
+}
+
+// ServerError 服务端返回的错误，Error() 与服务端的错误信息一致
+// errors.Is(err, ErrInvalidArgument) 等可以判断错误码对应的哨兵错误
+type ServerError struct {
+	Code       ErrorCode
+	Message    string
+	RetryAfter time.Duration // 服务端建议的重试等待时间，0表示没有提示
+}
+
+func (e *ServerError) Error() string { return e.Message }
+
+// Is 判断错误码是否与 target 对应
+func (e *ServerError) Is(target error) bool {
+	return e.Code != CodeUnknown && codeErrors[e.Code] == target
+}
+
+// RetryAfter 返回服务端对 err 建议的重试等待时间，err 不是 *ServerError 或没有提示时返回0
+func RetryAfter(err error) time.Duration {
+	var se *ServerError
+	if errors.As(err, &se) {
+		return se.RetryAfter
+	}
+	return 0
+}
+
+// Validator 参数类型实现该接口时，服务端在调用方法前先校验参数
+// 校验失败时直接返回 ErrInvalidArgument 错误码的响应，不调用方法
+type Validator interface {
+	Validate() error
+}
+
+// validate 调用 Validate，panic 同样视为校验失败
+func validate(v Validator) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: validate panic: %v", ErrInvalidArgument, r)
+		}
+	}()
+	if err = v.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Range 值接收者实现 Validator
+type Range struct {
+	Lo, Hi int
+}
+
+func (r Range) Validate() error {
+	if r.Lo > r.Hi {
+		return errors.New("lo greater than hi")
+	}
+	if r.Lo < 0 {
+		panic("negative range")
+	}
+	return nil
+}
+
+// Page 指针接收者实现 Validator
+type Page struct {
+	Size int
+}
+
+func (p *Page) Validate() error {
+	if p.Size <= 0 {
+		return errors.New("size must be positive")
+	}
+	return nil
+}
+
+type Checked struct {
+	calls int
+}
+
+func (c *Checked) Span(r Range, reply *int) error {
+	c.calls++
+	*reply = r.Hi - r.Lo
+	return nil
+}
+
+func (c *Checked) Fetch(p Page, reply *int) error {
+	c.calls++
+	*reply = p.Size
+	return nil
+}
+
+func (c *Checked) FetchPtr(p *Page, reply *int) error {
+	c.calls++
+	*reply = p.Size
+	return nil
+}
+
+func TestServer_Validate(t *testing.T) {
+	server := NewServer()
+	checked := new(Checked)
+	_ = server.Register(checked)
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	tests := []struct {
+		method string
+		args   interface{}
+		reply  int
+		err    string
+	}{
+		{"Checked.Span", Range{Lo: 1, Hi: 3}, 2, ""},
+		{"Checked.Span", Range{Lo: 3, Hi: 1}, 0, "lo greater than hi"},
+		{"Checked.Span", Range{Lo: -1, Hi: 1}, 0, "validate panic: negative range"},
+		{"Checked.Fetch", Page{Size: 10}, 10, ""},
+		{"Checked.Fetch", Page{}, 0, "size must be positive"},
+		{"Checked.FetchPtr", &Page{Size: 5}, 5, ""},
+		{"Checked.FetchPtr", &Page{}, 0, "size must be positive"},
+	}
+	for _, tt := range tests {
+		var reply int
+		err := client.Call(context.Background(), tt.method, tt.args, &reply)
+		if tt.err == "" {
+			assert.Nil(t, err, tt.method)
+			assert.Equal(t, tt.reply, reply, tt.method)
+			continue
+		}
+		assert.True(t, errors.Is(err, ErrInvalidArgument), "%s: %v", tt.method, err)
+		assert.True(t, err != nil && strings.Contains(err.Error(), tt.err), "%s: %v", tt.method, err)
+	}
+	assert.Equal(t, 3, checked.calls, "handler must not run for invalid arguments")
+
+	// 校验失败后连接仍然可用
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Checked.Span", Range{Lo: 0, Hi: 4}, &reply))
+	assert.Equal(t, 4, reply)
+}
+
+func TestServerError_Is(t *testing.T) {
+	err := error(&ServerError{Code: CodeServiceBusy, Message: ErrServiceBusy.Error()})
+	assert.True(t, errors.Is(err, ErrServiceBusy))
+	assert.False(t, errors.Is(err, ErrInvalidArgument))
+	assert.False(t, errors.Is(&ServerError{Message: "boom"}, ErrInvalidArgument))
+	assert.Equal(t, CodeInvalidArgument, errorCode(validate(&Page{})))
+}
@@ -0,0 +1,47 @@
+// Package arith 是使用 cmd/geerpc-gen 生成客户端的示例服务，arith_client.go 由 go generate 生成
+package arith
+
+import (
+	"context"
+	"errors"
+)
+
+//go:generate go run ../../cmd/geerpc-gen -type Arith
+
+type Args struct{ A, B int }
+
+type Quotient struct{ Quo, Rem int }
+
+type Arith struct{}
+
+// Multiply 返回 A*B
+func (Arith) Multiply(args Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+// Divide 返回 A/B 的商与余数，B 为0时返回错误
+func (Arith) Divide(ctx context.Context, args Args, reply *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = Quotient{Quo: args.A / args.B, Rem: args.A % args.B}
+	return nil
+}
+
+// Primes 返回不大于 n 的质数
+func (Arith) Primes(n int, reply *[]int) error {
+	for i := 2; i <= n; i++ {
+		prime := true
+		for _, p := range *reply {
+			if i%p == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			*reply = append(*reply, i)
+		}
+	}
+	return nil
+}
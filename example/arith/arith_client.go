@@ -0,0 +1,40 @@
+// Code generated by geerpc-gen. DO NOT EDIT.
+
+package arith
+
+import (
+	"context"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// ArithClient Arith 服务的客户端
+type ArithClient struct {
+	c geerpc.Caller
+}
+
+// NewArithClient 返回通过 c 调用 Arith 服务的客户端，c 可以是 *xclient.XClient 或 geerpc.ClientCaller(client)
+func NewArithClient(c geerpc.Caller) *ArithClient {
+	return &ArithClient{c: c}
+}
+
+// Multiply 调用 Arith.Multiply
+func (a *ArithClient) Multiply(ctx context.Context, args Args) (int, error) {
+	var reply int
+	err := a.c.Call(ctx, "Arith.Multiply", args, &reply)
+	return reply, err
+}
+
+// Divide 调用 Arith.Divide
+func (a *ArithClient) Divide(ctx context.Context, args Args) (Quotient, error) {
+	var reply Quotient
+	err := a.c.Call(ctx, "Arith.Divide", args, &reply)
+	return reply, err
+}
+
+// Primes 调用 Arith.Primes
+func (a *ArithClient) Primes(ctx context.Context, args int) ([]int, error) {
+	var reply []int
+	err := a.c.Call(ctx, "Arith.Primes", args, &reply)
+	return reply, err
+}
@@ -0,0 +1,53 @@
+package arith
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+func startServer(t *testing.T) string {
+	server := geerpc.NewServer()
+	assert.Nil(t, server.Register(new(Arith)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Close() })
+	return l.Addr().String()
+}
+
+func testArithClient(t *testing.T, c *ArithClient) {
+	ctx := context.Background()
+	product, err := c.Multiply(ctx, Args{A: 6, B: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, 42, product)
+
+	q, err := c.Divide(ctx, Args{A: 7, B: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, Quotient{Quo: 3, Rem: 1}, q)
+	_, err = c.Divide(ctx, Args{A: 1})
+	assert.EqualError(t, err, "divide by zero")
+
+	primes, err := c.Primes(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3, 5, 7}, primes)
+}
+
+func TestArithClient(t *testing.T) {
+	addr := startServer(t)
+	client, err := geerpc.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	testArithClient(t, NewArithClient(geerpc.ClientCaller(client)))
+}
+
+func TestArithClient_XClient(t *testing.T) {
+	d := xclient.NewMultiServerDiscovery([]string{"tcp@" + startServer(t), "tcp@" + startServer(t)})
+	xc := xclient.NewXClient(d, xclient.RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	testArithClient(t, NewArithClient(xc))
+}
@@ -0,0 +1,155 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// maxGatewayBody 网关接受的请求体的最大字节数
+const maxGatewayBody = 1 << 20
+
+// gateway 将 HTTP 请求转换为 RPC 调用，通过内存中的连接发给服务器，与普通连接一样经过超时、认证与统计等处理
+type gateway struct {
+	server *Server
+
+	mu     sync.Mutex
+	client *Client // 连接到服务器的客户端，断开后在下一个请求时重新创建
+}
+
+// gatewayError 网关返回的 JSON 错误
+type gatewayError struct {
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code"`
+}
+
+// GatewayHandler 返回以 JSON 调用服务的 HTTP 处理程序，用于调试或无法使用 RPC 协议的调用方
+// 请求为 POST /<Service>/<Method>，Content-Type 为 application/json，请求体解码为方法的参数类型，应答以 JSON 返回；
+// 挂载在其他路径下时需要使用 http.StripPrefix。请求头 Authorization: Bearer <token> 作为令牌传给服务端
+// 出错时返回 {"error": ..., "code": ...}：找不到方法为 404，处理超时为 408，参数无效为 400，方法返回错误为 500
+func (server *Server) GatewayHandler() http.Handler {
+	return &gateway{server: server}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayError(w, http.StatusMethodNotAllowed, errors.New("rpc server: gateway: must POST"))
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeGatewayError(w, http.StatusUnsupportedMediaType, errors.New("rpc server: gateway: Content-Type must be application/json"))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeGatewayError(w, http.StatusNotFound, errors.New("rpc server: gateway: path must be /<Service>/<Method>"))
+		return
+	}
+	serviceMethod := parts[0] + "." + parts[1]
+	svc, mtype, err := g.server.findService(serviceMethod)
+	if err != nil {
+		writeGatewayError(w, http.StatusNotFound, err)
+		return
+	}
+	if mtype.stream != streamNone {
+		writeGatewayError(w, http.StatusBadRequest, errors.New("rpc server: gateway: streaming method "+serviceMethod+" is not supported"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGatewayBody))
+	if err != nil {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge, errors.New("rpc server: gateway: read body: "+err.Error()))
+		return
+	}
+	if svc.raw == nil {
+		// 先按参数类型解码一次，参数无效时直接返回 400，服务端会再次解码
+		argv := mtype.newArgv()
+		argvi := argv.Interface()
+		if argv.Kind() != reflect.Ptr {
+			argvi = argv.Addr().Interface()
+		}
+		if err = json.Unmarshal(body, argvi); err != nil {
+			writeGatewayError(w, http.StatusBadRequest, errors.New("rpc server: gateway: invalid argument: "+err.Error()))
+			return
+		}
+	}
+
+	client, err := g.dial()
+	if err != nil {
+		writeGatewayError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	var opts []CallOption
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		opts = append(opts, WithMetadata(tokenMetadata, strings.TrimPrefix(auth, "Bearer ")))
+	}
+	var reply json.RawMessage
+	if err = client.Call(r.Context(), serviceMethod, json.RawMessage(body), &reply, opts...); err != nil {
+		writeGatewayError(w, gatewayStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(reply, '\n'))
+}
+
+// dial 返回连接到服务器的客户端，没有可用的连接时通过 net.Pipe 新建一个
+func (g *gateway) dial() (*Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil && g.client.IsAvailable() {
+		return g.client, nil
+	}
+	if g.client != nil {
+		_ = g.client.Close()
+	}
+	conn, sconn := net.Pipe()
+	go g.server.ServeConn(sconn)
+	client, err := NewClient(conn, &Option{
+		MagicNumber: MagicNumber,
+		CodecType:   codec.JsonType,
+		SigningKeys: g.server.opts.SigningKeys,
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	g.client = client
+	return client, nil
+}
+
+// gatewayStatus 返回调用错误对应的 HTTP 状态码
+func gatewayStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrHandleTimeout):
+		return http.StatusRequestTimeout
+	case errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthenticated), errors.Is(err, ErrTokenExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrServiceBusy), errors.Is(err, ErrServerBusy), errors.Is(err, ErrServerDraining):
+		return http.StatusServiceUnavailable
+	}
+	var se *ServerError
+	if errors.As(err, &se) {
+		return http.StatusInternalServerError
+	}
+	// 连接断开或 HTTP 请求被取消
+	return http.StatusServiceUnavailable
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(gatewayError{Error: err.Error(), Code: errorCode(err)})
+}
@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Failing int
+
+func (f Failing) Fail(args Args, reply *int) error {
+	return ErrInvalidArgument
+}
+
+func (f Failing) Broken(args Args, reply *int) error {
+	return json.Unmarshal([]byte("{"), reply)
+}
+
+func TestServer_GatewayHandler(t *testing.T) {
+	server := NewServerWithOptions(ServerOptions{DefaultHandleTimeout: 100 * time.Millisecond})
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(new(Bar)))
+	assert.Nil(t, server.Register(new(Failing)))
+	httpServer := httptest.NewServer(server.GatewayHandler())
+	defer httpServer.Close()
+
+	post := func(path, contentType, body string) (int, map[string]interface{}) {
+		resp, err := http.Post(httpServer.URL+path, contentType, strings.NewReader(body))
+		assert.Nil(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var v map[string]interface{}
+		if resp.StatusCode != http.StatusOK {
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&v))
+		}
+		return resp.StatusCode, v
+	}
+
+	resp, err := http.Post(httpServer.URL+"/Foo/Sum", "application/json", strings.NewReader(`{"Num1": 1, "Num2": 2}`))
+	assert.Nil(t, err)
+	var reply int
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&reply))
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, reply)
+
+	for name, c := range map[string]struct {
+		path, contentType, body string
+		status                  int
+		error                   string
+	}{
+		"unknown service": {"/Baz/Sum", "application/json", `{}`, http.StatusNotFound, "rpc server: can't find service Baz"},
+		"unknown method":  {"/Foo/Mul", "application/json", `{}`, http.StatusNotFound, "rpc server: can't find method Mul"},
+		"bad path":        {"/Foo", "application/json", `{}`, http.StatusNotFound, "rpc server: gateway: path must be /<Service>/<Method>"},
+		"content type":    {"/Foo/Sum", "text/plain", `{}`, http.StatusUnsupportedMediaType, "rpc server: gateway: Content-Type must be application/json"},
+		"invalid json":    {"/Foo/Sum", "application/json", `{"Num1": "x"}`, http.StatusBadRequest, ""},
+		"too large":       {"/Foo/Sum", "application/json", `"` + strings.Repeat("x", maxGatewayBody) + `"`, http.StatusRequestEntityTooLarge, ""},
+		"timeout":         {"/Bar/Timeout", "application/json; charset=utf-8", `1`, http.StatusRequestTimeout, "rpc server: request handle timeout: except within 100ms"},
+		"invalid arg":     {"/Failing/Fail", "application/json", `{}`, http.StatusBadRequest, "rpc server: invalid argument"},
+		"handler error":   {"/Failing/Broken", "application/json", `{}`, http.StatusInternalServerError, "unexpected end of JSON input"},
+	} {
+		status, v := post(c.path, c.contentType, c.body)
+		assert.Equal(t, c.status, status, name)
+		if c.error != "" {
+			assert.Equal(t, c.error, v["error"], name)
+		}
+	}
+	_, v := post("/Bar/Timeout", "application/json", `1`)
+	assert.Equal(t, float64(CodeHandleTimeout), v["code"])
+
+	resp, err = http.Get(httpServer.URL + "/Foo/Sum")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// 调用经过正常的处理流程，共用一个内存中的连接
+	stats := server.Stats()
+	assert.Equal(t, uint64(5), stats.TotalRequests)
+	assert.Equal(t, uint64(2), stats.TimeoutsServed)
+	assert.Equal(t, uint64(1), stats.TotalConnections)
+}
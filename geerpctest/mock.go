@@ -0,0 +1,237 @@
+// Package geerpctest 提供测试 geerpc 客户端代码使用的工具
+package geerpctest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// MockServer 按预先设置的期望应答调用的服务器，不需要注册真实的服务
+// 通过 ExpectCall 设置每个方法的应答，调用没有设置期望的方法时返回错误并记录，AssertExpectations 检查调用是否符合期望。
+// 参数使用连接协商的编解码器解码，gob 与 JSON 客户端都可以使用
+type MockServer struct {
+	t      testing.TB
+	server *geerpc.Server
+	closed chan struct{}
+	once   sync.Once
+
+	mu           sync.Mutex // protect following
+	expectations []*Expectation
+	unexpected   []string       // 没有匹配的期望的调用
+	calls        map[string]int // 每个方法收到的调用数
+	addr         string         // Start 监听的地址
+}
+
+// NewMockServer 创建 MockServer，测试结束时自动关闭
+func NewMockServer(t testing.TB) *MockServer {
+	m := &MockServer{
+		t:      t,
+		server: geerpc.NewServer(),
+		closed: make(chan struct{}),
+		calls:  make(map[string]int),
+	}
+	m.server.SetUnknownHandler(m)
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// Server 返回底层的服务器，可以在 Dial 或 Start 之前修改其配置
+func (m *MockServer) Server() *geerpc.Server {
+	return m.server
+}
+
+// Dial 通过内存中的管道（net.Pipe）连接 MockServer，opt 为 nil 时使用 geerpc.DefaultOption
+func (m *MockServer) Dial(opt *geerpc.Option) (*geerpc.Client, error) {
+	if opt == nil {
+		opt = geerpc.DefaultOption
+	}
+	conn, sconn := net.Pipe()
+	go m.server.ServeConn(sconn)
+	client, err := geerpc.NewClient(conn, opt)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Start 在 127.0.0.1 的随机端口上监听，返回 geerpc.XDial 与 xclient 使用的地址，如 "tcp@127.0.0.1:1234"；
+// 多次调用返回同一地址
+func (m *MockServer) Start() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.addr != "" {
+		return m.addr
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		m.t.Fatalf("geerpctest: listen: %v", err)
+	}
+	go func() { _ = m.server.Serve(l) }()
+	m.addr = "tcp@" + l.Addr().String()
+	return m.addr
+}
+
+// Close 关闭服务器与所有连接，等待延迟应答的调用立即应答
+func (m *MockServer) Close() error {
+	m.once.Do(func() { close(m.closed) })
+	return m.server.Close()
+}
+
+// ExpectCall 期望收到对 serviceMethod（如 "Foo.Sum"）的调用，返回的 Expectation 用于设置应答，没有设置时应答错误。
+// 同一方法设置了多个期望时按设置的顺序使用，前一个期望的调用次数达到 Times 后使用下一个
+func (m *MockServer) ExpectCall(serviceMethod string) *Expectation {
+	e := &Expectation{serviceMethod: serviceMethod}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Calls 返回 serviceMethod 收到的调用数，包括没有设置期望的调用
+func (m *MockServer) Calls(serviceMethod string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[serviceMethod]
+}
+
+// AssertExpectations 检查每个期望都收到了足够的调用，且没有收到意外的调用，不符合时通过 t 报告失败并返回 false
+func (m *MockServer) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for _, e := range m.expectations {
+		if e.calls == 0 || (e.times > 0 && e.calls != e.times) {
+			want := "at least 1"
+			if e.times > 0 {
+				want = fmt.Sprint(e.times)
+			}
+			t.Errorf("geerpctest: expected %s call(s) to %s, got %d", want, e.serviceMethod, e.calls)
+			ok = false
+		}
+	}
+	if len(m.unexpected) > 0 {
+		unexpected := append([]string(nil), m.unexpected...)
+		sort.Strings(unexpected)
+		t.Errorf("geerpctest: unexpected call(s): %s", strings.Join(unexpected, ", "))
+		ok = false
+	}
+	return ok
+}
+
+// match 返回 serviceMethod 的第一个还没有达到调用次数的期望并计入一次调用，没有时返回 nil
+func (m *MockServer) match(serviceMethod string) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[serviceMethod]++
+	for _, e := range m.expectations {
+		if e.serviceMethod == serviceMethod && (e.times == 0 || e.calls < e.times) {
+			e.calls++
+			return e
+		}
+	}
+	m.unexpected = append(m.unexpected, serviceMethod)
+	return nil
+}
+
+// HandleGeeRPC 实现 geerpc.RawHandler，按期望应答所有调用
+func (m *MockServer) HandleGeeRPC(serviceMethod string, dec func(interface{}) error, send func(interface{}, error)) {
+	e := m.match(serviceMethod)
+	if e == nil {
+		send(nil, fmt.Errorf("geerpctest: unexpected call %s", serviceMethod))
+		return
+	}
+	reply, err := e.respond(Args{ServiceMethod: serviceMethod, dec: dec})
+	if reply == nil && err == nil {
+		// gob 客户端无法将空的应答解码到任意类型
+		err = fmt.Errorf("geerpctest: no reply set for %s", serviceMethod)
+	}
+	if e.delay <= 0 {
+		send(reply, err)
+		return
+	}
+	go func() {
+		timer := time.NewTimer(e.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-m.closed:
+		}
+		send(reply, err)
+	}()
+}
+
+// Args 一次调用的参数
+// gob 的编码依赖连接上之前传输的类型信息，无法单独取出一次调用的原始字节，因此只能通过 Decode 读取
+type Args struct {
+	ServiceMethod string
+	dec           func(interface{}) error
+}
+
+// Decode 使用连接协商的编解码器将参数解码到 v，v 应当为指针；只能在 Handle 的函数返回前调用一次
+func (a Args) Decode(v interface{}) error {
+	return a.dec(v)
+}
+
+// Expectation 对一个方法的调用的期望，设置方法返回自身以便链式调用；应当在发起调用之前设置完成
+type Expectation struct {
+	serviceMethod string
+	reply         interface{}
+	err           error
+	handle        func(args Args) (interface{}, error)
+	delay         time.Duration
+	times         int // 期望的调用次数，0 表示至少一次
+	calls         int // 受 MockServer.mu 保护
+}
+
+// Return 以 reply 应答调用，reply 的类型应当可以解码到客户端传入的应答
+func (e *Expectation) Return(reply interface{}) *Expectation {
+	e.reply, e.err, e.handle = reply, nil, nil
+	return e
+}
+
+// ReturnError 以错误 msg 应答调用，客户端收到的错误的 Error() 为 msg
+func (e *Expectation) ReturnError(msg string) *Expectation {
+	e.reply, e.err, e.handle = nil, errors.New(msg), nil
+	return e
+}
+
+// Handle 以 f 的返回值应答调用，f 可以通过 args.Decode 检查参数
+func (e *Expectation) Handle(f func(args Args) (interface{}, error)) *Expectation {
+	e.reply, e.err, e.handle = nil, nil, f
+	return e
+}
+
+// After 延迟 d 后再应答，用于测试客户端的超时
+func (e *Expectation) After(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+// Times 期望恰好收到 n 次调用，超过的调用匹配同一方法的下一个期望，没有时视为意外的调用
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// respond 返回期望的应答，Handle 的函数 panic 时返回错误
+func (e *Expectation) respond(args Args) (reply interface{}, err error) {
+	if e.handle == nil {
+		return e.reply, e.err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			reply, err = nil, fmt.Errorf("geerpctest: %s handler panic: %v", args.ServiceMethod, r)
+		}
+	}()
+	return e.handle(args)
+}
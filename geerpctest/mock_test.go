@@ -0,0 +1,135 @@
+package geerpctest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/codec"
+	"github.com/yqchilde/gee-rpc/example/arith"
+)
+
+type Args2 struct{ Num1, Num2 int }
+
+// recorder 记录 AssertExpectations 报告的失败
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestMockServer(t *testing.T) {
+	for _, codecType := range []codec.Type{codec.GobType, codec.JsonType} {
+		t.Run(string(codecType), func(t *testing.T) {
+			m := NewMockServer(t)
+			m.ExpectCall("Foo.Sum").Handle(func(args Args) (interface{}, error) {
+				var a Args2
+				if err := args.Decode(&a); err != nil {
+					return nil, err
+				}
+				return a.Num1 + a.Num2, nil
+			})
+			m.ExpectCall("Foo.Const").Return(42).Times(2)
+			m.ExpectCall("Foo.Fail").ReturnError("foo: failed")
+			m.ExpectCall("Foo.Nop")
+			client, err := m.Dial(&geerpc.Option{MagicNumber: geerpc.MagicNumber, CodecType: codecType})
+			assert.Nil(t, err)
+			defer func() { _ = client.Close() }()
+			ctx := context.Background()
+
+			var reply int
+			assert.Nil(t, client.Call(ctx, "Foo.Sum", Args2{Num1: 1, Num2: 2}, &reply))
+			assert.Equal(t, 3, reply)
+			for i := 0; i < 2; i++ {
+				assert.Nil(t, client.Call(ctx, "Foo.Const", Args2{}, &reply))
+				assert.Equal(t, 42, reply)
+			}
+			assert.EqualError(t, client.Call(ctx, "Foo.Fail", Args2{}, &reply), "foo: failed")
+			assert.EqualError(t, client.Call(ctx, "Foo.Nop", Args2{}, &reply), "geerpctest: no reply set for Foo.Nop")
+			assert.Equal(t, 1, m.Calls("Foo.Fail"))
+			assert.True(t, m.AssertExpectations(t))
+		})
+	}
+}
+
+func TestMockServer_Delay(t *testing.T) {
+	m := NewMockServer(t)
+	m.ExpectCall("Foo.Slow").Return(1).After(200 * time.Millisecond)
+	client, err := geerpc.XDial(m.Start())
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var reply int
+	err = client.Call(ctx, "Foo.Slow", Args2{}, &reply)
+	assert.True(t, err != nil && strings.Contains(err.Error(), context.DeadlineExceeded.Error()), err)
+	assert.Equal(t, m.Start(), m.Start())
+}
+
+func TestMockServer_AssertExpectations(t *testing.T) {
+	m := NewMockServer(t)
+	m.ExpectCall("Foo.Sum").Return(3)
+	m.ExpectCall("Foo.Once").Return(1).Times(1)
+	client, err := m.Dial(nil)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "Foo.Once", Args2{}, &reply))
+	// 超过 Times 与没有设置期望的调用都是意外的调用
+	assert.EqualError(t, client.Call(ctx, "Foo.Once", Args2{}, &reply), "geerpctest: unexpected call Foo.Once")
+	assert.NotNil(t, client.Call(ctx, "Bar.Missing", Args2{}, &reply))
+	assert.Equal(t, 2, m.Calls("Foo.Once"))
+
+	r := &recorder{TB: t}
+	assert.False(t, m.AssertExpectations(r))
+	assert.Equal(t, []string{
+		"geerpctest: expected at least 1 call(s) to Foo.Sum, got 0",
+		"geerpctest: unexpected call(s): Bar.Missing, Foo.Once",
+	}, r.errs)
+}
+
+func TestMockServer_HandlerPanic(t *testing.T) {
+	m := NewMockServer(t)
+	m.ExpectCall("Foo.Panic").Handle(func(args Args) (interface{}, error) { panic("boom") })
+	client, err := m.Dial(nil)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	assert.EqualError(t, client.Call(context.Background(), "Foo.Panic", Args2{}, &reply), "geerpctest: Foo.Panic handler panic: boom")
+}
+
+func TestMockServer_GeneratedClient(t *testing.T) {
+	m := NewMockServer(t)
+	m.ExpectCall("Arith.Multiply").Handle(func(args Args) (interface{}, error) {
+		var in arith.Args
+		if err := args.Decode(&in); err != nil {
+			return nil, err
+		}
+		return in.A * in.B, nil
+	}).Times(1)
+	m.ExpectCall("Arith.Divide").ReturnError("divide by zero")
+
+	client, err := m.Dial(nil)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	a := arith.NewArithClient(geerpc.ClientCaller(client))
+	product, err := a.Multiply(context.Background(), arith.Args{A: 3, B: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, 12, product)
+	_, err = a.Divide(context.Background(), arith.Args{A: 1})
+	assert.EqualError(t, err, "divide by zero")
+	m.AssertExpectations(t)
+}
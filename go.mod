@@ -2,4 +2,7 @@ module github.com/yqchilde/gee-rpc
 
 go 1.16
 
-require github.com/stretchr/testify v1.7.0
+require (
+	github.com/prometheus/client_golang v1.11.0
+	github.com/stretchr/testify v1.7.0
+)
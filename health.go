@@ -0,0 +1,70 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// SetServing 设置服务器整体是否可以提供服务，设置为 false 时健康检查返回 503，但不影响请求的处理
+// 可以在依赖（如数据库）不可用时调用，让负载均衡或探针将流量切走
+func (server *Server) SetServing(serving bool) {
+	var v int32
+	if !serving {
+		v = 1
+	}
+	atomic.StoreInt32(&server.notServing, v)
+}
+
+// healthStatus 返回健康检查的状态，只读取原子变量，不会与 Register 竞争
+func (server *Server) healthStatus() string {
+	switch {
+	case server.shuttingDown():
+		return "SHUTTING_DOWN"
+	case atomic.LoadInt32(&server.notServing) != 0:
+		return "NOT_SERVING"
+	default:
+		return "SERVING"
+	}
+}
+
+// HealthHandler 返回健康检查的 HTTP 处理程序，可以供 Kubernetes 等探针使用
+// 服务器正常时返回 200 与 {"status":"SERVING"}，Shutdown 期间或 SetServing(false) 后返回 503
+func (server *Server) HealthHandler() http.Handler {
+	return healthHTTP{server}
+}
+
+type healthHTTP struct {
+	*Server
+}
+
+func (server healthHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := server.healthStatus()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status != "SERVING" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// registerHealth 在 mux 上注册健康检查，路径已被占用（如同一个 mux 上的其他服务器）时跳过
+func (server *Server) registerHealth(mux *http.ServeMux) {
+	path := server.opts.HealthPath
+	if path == "-" {
+		return
+	}
+	if path == "" {
+		path = defaultHealthPath
+	}
+	if muxHas(mux, path) {
+		server.log().Debug("rpc server: health handler already registered", "path", path)
+		return
+	}
+	mux.Handle(path, server.HealthHandler())
+}
@@ -0,0 +1,53 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Health(t *testing.T) {
+	server := NewServer()
+	mux := http.NewServeMux()
+	assert.Nil(t, server.RegisterHTTP(mux, "/rpc", ""))
+	assert.Nil(t, NewServer().RegisterHTTP(mux, "/rpc2", ""), "another server on the same mux skips the health handler")
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	check := func(want int, status string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/healthz")
+		assert.Nil(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, want, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, status, body["status"])
+	}
+
+	check(http.StatusOK, "SERVING")
+	server.SetServing(false)
+	check(http.StatusServiceUnavailable, "NOT_SERVING")
+	server.SetServing(true)
+	check(http.StatusOK, "SERVING")
+	assert.Nil(t, server.Shutdown(context.Background()))
+	check(http.StatusServiceUnavailable, "SHUTTING_DOWN")
+}
+
+func TestServer_HealthPath(t *testing.T) {
+	for path, want := range map[string]int{"/livez": http.StatusOK, "-": http.StatusNotFound} {
+		mux := http.NewServeMux()
+		assert.Nil(t, NewServerWithOptions(ServerOptions{HealthPath: path}).RegisterHTTP(mux, "/rpc", ""))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		assert.Equal(t, want, w.Code, path)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
@@ -0,0 +1,40 @@
+package geerpc
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+)
+
+// SetHTTPAuth 设置校验 HTTP CONNECT 请求的回调，f 返回错误时以 401 拒绝该请求，错误信息作为响应体返回给客户端
+// 校验在接管连接之前进行，f 为 nil 表示不校验；客户端通过 Option.HTTPAuthorization 设置 Authorization 请求头
+func (server *Server) SetHTTPAuth(f func(r *http.Request) error) {
+	server.httpAuth.Store(f)
+}
+
+// authorizeHTTP 校验 CONNECT 请求，拒绝时写入 401 响应并返回 false
+func (server *Server) authorizeHTTP(w http.ResponseWriter, r *http.Request) bool {
+	f, _ := server.httpAuth.Load().(func(r *http.Request) error)
+	if f == nil {
+		return true
+	}
+	err := f(r)
+	if err == nil {
+		return true
+	}
+	server.log().Debug("rpc server: HTTP CONNECT unauthorized", "remote", r.RemoteAddr, "err", err)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = io.WriteString(w, err.Error()+"\n")
+	return false
+}
+
+// BasicAuthorization 返回 HTTP Basic 认证的 Authorization 请求头，用于 Option.HTTPAuthorization
+func BasicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// BearerAuthorization 返回携带 token 的 Authorization 请求头，用于 Option.HTTPAuthorization
+func BearerAuthorization(token string) string {
+	return "Bearer " + token
+}
@@ -0,0 +1,60 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_SetHTTPAuth(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	server.SetHTTPAuth(func(r *http.Request) error {
+		switch r.Header.Get("Authorization") {
+		case "":
+			return errors.New("missing credentials")
+		case BearerAuthorization("secret"), BasicAuthorization("user", "pass"):
+			return nil
+		default:
+			return errors.New("invalid credentials")
+		}
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	addr := httpServer.Listener.Addr().String()
+	dial := func(auth string) (*Client, error) {
+		return DialHTTP("tcp", addr, &Option{MagicNumber: MagicNumber, ConnectTimeout: time.Second, HTTPAuthorization: auth})
+	}
+
+	for _, auth := range []string{BearerAuthorization("secret"), BasicAuthorization("user", "pass")} {
+		client, err := dial(auth)
+		assert.Nil(t, err, auth)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: 1, Num2: 2}, &reply), auth)
+		assert.Equal(t, 103, reply, auth)
+		_ = client.Close()
+	}
+
+	for auth, reason := range map[string]string{
+		"":                                 "missing credentials",
+		BearerAuthorization("wrong"):       "invalid credentials",
+		BasicAuthorization("user", "nope"): "invalid credentials",
+	} {
+		_, err := dial(auth)
+		var statusErr *HTTPStatusError
+		assert.True(t, errors.As(err, &statusErr), "%q: %v", auth, err)
+		assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode, auth)
+		assert.Equal(t, reason, statusErr.Reason, auth)
+		assert.EqualError(t, err, "unexpected HTTP response: 401 Unauthorized: "+reason, auth)
+	}
+
+	server.SetHTTPAuth(nil)
+	client, err := dial("")
+	assert.Nil(t, err, "nil disables the check")
+	_ = client.Close()
+}
@@ -0,0 +1,197 @@
+package geerpc
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// idempotencyKeyMetadata 请求头 Metadata 中幂等键使用的键名
+const idempotencyKeyMetadata = "idempotency-key"
+
+const (
+	defaultIdempotencyCacheSize = 1024
+	defaultIdempotencyTTL       = 5 * time.Minute
+)
+
+// WithIdempotencyKey 为调用设置幂等键，服务端在缓存有效期内收到同一调用方以相同方法、相同幂等键发送的请求时，
+// 直接重放第一次调用的响应而不再调用方法；与第一次调用并发到达的重复请求会等待其完成。
+// 启用令牌认证时调用方以令牌对应身份的 Subject 区分，不同身份使用相同的幂等键互不影响
+func WithIdempotencyKey(key string) CallOption {
+	return WithMetadata(idempotencyKeyMetadata, key)
+}
+
+// errOriginalNotCompleted 重复请求等待的第一次调用没有发送响应（如连接已断开）
+var errOriginalNotCompleted = errors.New("rpc server: original request with the same idempotency key did not complete")
+
+// idempotencyCache 按 (方法, 身份, 幂等键) 缓存已成功发送的响应，容量与有效期有限，超出容量时淘汰最久未使用的条目
+type idempotencyCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	ll      *list.List               // 最近使用的条目在前
+	entries map[string]*list.Element // 值为 *idemEntry
+}
+
+// idemEntry 一次带幂等键的调用，done 关闭前表示第一次调用仍在处理
+type idemEntry struct {
+	key  string
+	done chan struct{}
+	once sync.Once
+
+	// 以下字段在 done 关闭后只读
+	sent      bool       // 第一次调用的响应已成功发送
+	errMsg    string     // 第一次调用的错误响应
+	code      int        // 错误码
+	codecType codec.Type // body 的编码格式
+	body      []byte     // 编码后的应答，sent 为 true 且没有错误时有效
+	expires   time.Time
+}
+
+// newIdempotencyCache 创建缓存，size 小于0时返回 nil，表示不处理幂等键
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultIdempotencyCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return &idempotencyCache{size: size, ttl: ttl, ll: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// begin 返回 key 对应的条目，leader 为 true 表示这是第一次调用，需要由调用方完成该条目
+func (c *idempotencyCache) begin(key string) (e *idemEntry, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e = el.Value.(*idemEntry)
+		if !e.completed() || time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			return e, false
+		}
+		c.ll.Remove(el)
+		delete(c.entries, key)
+	}
+	e = &idemEntry{key: key, done: make(chan struct{})}
+	c.entries[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		// 被淘汰的未完成条目仍由其第一次调用完成，只是之后的重复请求不再命中
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.entries, el.Value.(*idemEntry).key)
+	}
+	return e, true
+}
+
+// complete 记录第一次调用的结果并唤醒等待的重复请求，只有成功发送的正常响应会被保留以供重放
+func (c *idempotencyCache) complete(e *idemEntry, sent bool, h *codec.Header, t codec.Type, body []byte) {
+	e.once.Do(func() {
+		e.sent, e.codecType, e.body = sent, t, body
+		if h != nil {
+			e.errMsg, e.code = h.Error, h.Code
+		}
+		e.expires = time.Now().Add(c.ttl)
+		close(e.done)
+		if sent && e.errMsg == "" {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if el, ok := c.entries[e.key]; ok && el.Value == e {
+			c.ll.Remove(el)
+			delete(c.entries, e.key)
+		}
+	})
+}
+
+func (e *idemEntry) completed() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// idempotent 检查请求是否带有幂等键，返回对应的条目；dup 为 true 表示请求是重复请求，需要重放响应
+func (server *Server) idempotent(req *request) (e *idemEntry, dup bool) {
+	key := req.h.Metadata[idempotencyKeyMetadata]
+	if key == "" || server.idem == nil {
+		return nil, false
+	}
+	var subject string
+	if req.identity != nil {
+		subject = req.identity.Subject
+	}
+	e, leader := server.idem.begin(req.h.ServiceMethod + "\x00" + subject + "\x00" + key)
+	if leader {
+		req.idem = e
+	}
+	return e, !leader
+}
+
+// completeIdempotent 在第一次调用发送响应后记录结果，sent 表示响应是否成功发送
+func (server *Server) completeIdempotent(sc *serverConn, req *request, sent bool) {
+	if req.idem == nil {
+		return
+	}
+	var body []byte
+	if sent && req.h.Error == "" {
+		data, err := codec.Marshal(sc.opt.CodecType, req.replyv.Interface())
+		if err != nil {
+			sent = false
+		}
+		body = data
+	}
+	server.idem.complete(req.idem, sent, req.h, sc.opt.CodecType, body)
+	req.idem = nil
+}
+
+// abandonIdempotent 第一次调用没有发送响应就结束时释放等待的重复请求
+func (server *Server) abandonIdempotent(req *request) {
+	if req.idem != nil {
+		server.idem.complete(req.idem, false, nil, "", nil)
+		req.idem = nil
+	}
+}
+
+// serveReplay 在新的 goroutine 中等待第一次调用完成，然后向重复请求重放其响应
+func (server *Server) serveReplay(sc *serverConn, req *request, e *idemEntry) {
+	sc.wg.Add(1)
+	atomic.AddInt32(&sc.pending, 1)
+	go func() {
+		defer sc.wg.Done()
+		defer atomic.AddInt32(&sc.pending, -1)
+		defer server.freeRequest(req)
+		select {
+		case <-e.done:
+		case <-sc.ctx.Done():
+			return
+		}
+		sc.log.Debug("rpc server: replay idempotent response", "seq", req.h.Seq, "method", req.h.ServiceMethod)
+		switch {
+		case e.errMsg != "":
+			req.h.Error, req.h.Code = e.errMsg, e.code
+			server.sendResponse(sc, req.h, nil)
+		case !e.sent:
+			setError(req.h, errOriginalNotCompleted)
+			server.sendResponse(sc, req.h, nil)
+		default:
+			req.mtype.resetReplyv(req.replyv)
+			if err := codec.Unmarshal(e.codecType, e.body, req.replyv.Interface()); err != nil {
+				setError(req.h, err)
+				server.sendResponse(sc, req.h, nil)
+				return
+			}
+			server.sendResponse(sc, req.h, req.replyv.Interface())
+		}
+	}()
+}
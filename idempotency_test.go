@@ -0,0 +1,158 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ledger 的方法不是幂等的，每次调用都会增加余额
+type Ledger struct {
+	mu      sync.Mutex
+	calls   int
+	balance int
+	delay   time.Duration
+}
+
+func (l *Ledger) Deposit(n int, reply *int) error {
+	time.Sleep(l.delay)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if n < 0 {
+		return errors.New("negative deposit")
+	}
+	l.balance += n
+	*reply = l.balance
+	return nil
+}
+
+func (l *Ledger) Calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls
+}
+
+func startLedgerServer(t *testing.T, opts ServerOptions, ledger *Ledger) (*Server, *Client) {
+	server := NewServerWithOptions(opts)
+	assert.Nil(t, server.Register(ledger))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	return server, client
+}
+
+func TestServer_IdempotencyKey(t *testing.T) {
+	ledger := new(Ledger)
+	server, client := startLedgerServer(t, ServerOptions{}, ledger)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var first, second int
+	assert.Nil(t, client.Call(ctx, "Ledger.Deposit", 10, &first, WithIdempotencyKey("a")))
+	assert.Nil(t, client.Call(ctx, "Ledger.Deposit", 10, &second, WithIdempotencyKey("a")))
+	assert.Equal(t, 10, first)
+	assert.Equal(t, first, second, "duplicate replays the cached reply")
+	assert.Equal(t, 1, ledger.Calls())
+
+	// 其他连接上的重复请求同样命中缓存
+	other, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = other.Close() }()
+	var third int
+	assert.Nil(t, other.Call(ctx, "Ledger.Deposit", 10, &third, WithIdempotencyKey("a")))
+	assert.Equal(t, first, third)
+	assert.Equal(t, 1, ledger.Calls())
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "Ledger.Deposit", 10, &reply, WithIdempotencyKey("b")))
+	assert.Equal(t, 20, reply)
+	assert.Nil(t, client.Call(ctx, "Ledger.Deposit", 10, &reply))
+	assert.Equal(t, 30, reply, "calls without a key always execute")
+	assert.Equal(t, 3, ledger.Calls())
+
+	// 错误响应不会被缓存
+	assert.EqualError(t, client.Call(ctx, "Ledger.Deposit", -1, &reply, WithIdempotencyKey("c")), "negative deposit")
+	assert.EqualError(t, client.Call(ctx, "Ledger.Deposit", -1, &reply, WithIdempotencyKey("c")), "negative deposit")
+	assert.Equal(t, 5, ledger.Calls())
+}
+
+func TestServer_IdempotencyKeyConcurrent(t *testing.T) {
+	ledger := &Ledger{delay: 100 * time.Millisecond}
+	server, client := startLedgerServer(t, ServerOptions{}, ledger)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	replies := make([]int, 5)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, client.Call(context.Background(), "Ledger.Deposit", 7, &replies[i], WithIdempotencyKey("k")))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, []int{7, 7, 7, 7, 7}, replies)
+	assert.Equal(t, 1, ledger.Calls(), "concurrent duplicates wait for the first call")
+}
+
+func TestServer_IdempotencyKeyExpiry(t *testing.T) {
+	ledger := new(Ledger)
+	server, client := startLedgerServer(t, ServerOptions{IdempotencyTTL: 50 * time.Millisecond}, ledger)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Ledger.Deposit", 1, &reply, WithIdempotencyKey("x")))
+	assert.Nil(t, client.Call(context.Background(), "Ledger.Deposit", 1, &reply, WithIdempotencyKey("x")))
+	assert.Equal(t, 1, reply)
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, client.Call(context.Background(), "Ledger.Deposit", 1, &reply, WithIdempotencyKey("x")))
+	assert.Equal(t, 2, reply, "expired entries allow re-execution")
+	assert.Equal(t, 2, ledger.Calls())
+}
+
+func TestServer_IdempotencyKeyPerIdentity(t *testing.T) {
+	ledger := new(Ledger)
+	server, alice := startLedgerServer(t, ServerOptions{}, ledger)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = alice.Close() }()
+	tokens := NewMemoryTokens(time.Hour, func(cred Credentials) (Identity, error) {
+		return Identity{Subject: cred.Username}, nil
+	})
+	assert.Nil(t, server.EnableTokenAuth(tokens))
+	bob, err := Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = bob.Close() }()
+	alice.UseTokenAuth(NewTokenAuth(Credentials{Username: "alice"}))
+	bob.UseTokenAuth(NewTokenAuth(Credentials{Username: "bob"}))
+	ctx := context.Background()
+
+	var a1, a2, b1 int
+	assert.Nil(t, alice.Call(ctx, "Ledger.Deposit", 10, &a1, WithIdempotencyKey("k")))
+	assert.Nil(t, bob.Call(ctx, "Ledger.Deposit", 5, &b1, WithIdempotencyKey("k")))
+	assert.Equal(t, 15, b1, "the same key from another identity is not a duplicate")
+	assert.Nil(t, alice.Call(ctx, "Ledger.Deposit", 10, &a2, WithIdempotencyKey("k")))
+	assert.Equal(t, a1, a2, "duplicate from the same identity replays its own reply")
+	assert.Equal(t, 2, ledger.Calls())
+}
+
+func TestIdempotencyCache_Eviction(t *testing.T) {
+	c := newIdempotencyCache(2, time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		e, leader := c.begin(key)
+		assert.True(t, leader)
+		c.complete(e, true, nil, "", nil)
+	}
+	_, leader := c.begin("a")
+	assert.True(t, leader, "least recently used entry is evicted")
+	_, leader = c.begin("c")
+	assert.False(t, leader)
+	assert.Nil(t, newIdempotencyCache(-1, 0), "negative size disables the cache")
+}
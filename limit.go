@@ -0,0 +1,174 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceOptions 注册服务时的配置
+type ServiceOptions struct {
+	Name          string        // 服务名，为空时使用结构体名称
+	Version       string        // 服务版本，为空表示不带版本，见 RegisterVersion
+	MaxConcurrent int           // 服务在所有连接上同时处理的最大请求数，0表示不限制
+	MaxWait       time.Duration // 达到 MaxConcurrent 时请求最多排队等待的时间，0表示不等待直接返回 ErrServiceBusy；排队的请求按优先级获得名额
+
+	IncludeOnly    []string // 只注册列出的方法，为空时注册所有满足条件的方法
+	ExcludeMethods []string // 不注册的方法，常用于排除从嵌入字段提升的辅助方法
+
+	// DisableReuse 为 true 时每个请求都分配新的参数与应答
+	// 包含指针、切片、map 等引用的参数与应答总是新分配的，其余类型默认复用，方法在返回后仍持有应答的指针时需要设置
+	DisableReuse bool
+}
+
+// ErrServiceBusy 服务达到并发上限且等待超时时返回给客户端的错误
+var ErrServiceBusy = errors.New("rpc server: service busy")
+
+// serviceLimit 服务级别的并发限制，在所有连接间共享
+// 名额用尽时请求按优先级排队，释放的名额直接交给队列中的下一个请求
+type serviceLimit struct {
+	mu      sync.Mutex
+	max     int
+	active  int
+	waiters priorityQueue
+	maxWait time.Duration
+}
+
+func newServiceLimit(opts ServiceOptions) *serviceLimit {
+	if opts.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &serviceLimit{max: opts.MaxConcurrent, maxWait: opts.MaxWait}
+}
+
+// acquire 获取一个并发名额，返回获取名额的 serviceLimit，需要原样传给 release；服务未设置并发限制时直接返回 nil
+// 等待超过 MaxWait 时返回 ErrServiceBusy，ctx 结束时返回 ctx.Err()
+func (s *service) acquire(ctx context.Context, priority uint8) (*serviceLimit, error) {
+	l, _ := s.limit.Load().(*serviceLimit)
+	if l != nil {
+		if err := l.acquire(ctx, priority); err != nil {
+			return nil, err
+		}
+	}
+	atomic.AddInt64(&s.inFlight, 1)
+	return l, nil
+}
+
+func (l *serviceLimit) acquire(ctx context.Context, priority uint8) error {
+	l.mu.Lock()
+	if l.active < l.max {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	maxWait := l.maxWait
+	if maxWait <= 0 {
+		l.mu.Unlock()
+		return ErrServiceBusy
+	}
+	granted := make(chan struct{})
+	item := l.waiters.push(priority, func() { close(granted) })
+	l.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-granted:
+		return nil
+	case <-timer.C:
+		err = ErrServiceBusy
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-granted:
+		return nil // 超时的同时获得了名额
+	default:
+		item.cancelled = true
+		return err
+	}
+}
+
+// release 归还 acquire 从 l 获取的名额
+func (s *service) release(l *serviceLimit) {
+	atomic.AddInt64(&s.inFlight, -1)
+	if l != nil {
+		l.release()
+	}
+}
+
+func (l *serviceLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active > l.max {
+		l.active-- // 上限被调低，超出的名额不再转交
+		return
+	}
+	if item := l.waiters.pop(); item != nil {
+		item.task() // 名额直接转交给等待的请求
+		return
+	}
+	l.active--
+}
+
+// set 修改并发上限与等待时间，上限调高时立即将名额交给等待的请求
+func (l *serviceLimit) set(max int, maxWait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max, l.maxWait = max, maxWait
+	for l.active < l.max {
+		item := l.waiters.pop()
+		if item == nil {
+			return
+		}
+		l.active++
+		item.task()
+	}
+}
+
+// limits 返回当前的并发上限与等待时间
+func (l *serviceLimit) limits() (max int, maxWait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max, l.maxWait
+}
+
+// SetServiceLimit 在运行时修改服务的并发限制，name 为服务名，带版本的服务为 "Foo@v2"
+// maxConcurrent 与 maxWait 的含义与 ServiceOptions 的 MaxConcurrent、MaxWait 相同，maxConcurrent 为0时取消限制；
+// 调低上限时正在处理的请求不受影响，之后的请求在处理中的请求数降到新的上限以下后才能获得名额
+func (server *Server) SetServiceLimit(name string, maxConcurrent int, maxWait time.Duration) error {
+	val, ok := server.serviceMap.Load(name)
+	if !ok {
+		return errors.New("rpc server: can't find service " + name)
+	}
+	svc := val.(*service)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	old, _ := svc.limit.Load().(*serviceLimit)
+	switch {
+	case old != nil && maxConcurrent > 0:
+		old.set(maxConcurrent, maxWait)
+	case old != nil:
+		svc.limit.Store((*serviceLimit)(nil))
+		old.set(math.MaxInt32, 0) // 放行所有等待的请求
+	case maxConcurrent > 0:
+		svc.limit.Store(newServiceLimit(ServiceOptions{MaxConcurrent: maxConcurrent, MaxWait: maxWait}))
+	}
+	return nil
+}
+
+// RegisterWithOptions 与 Register 相同，但可以指定服务名与并发限制
+func (server *Server) RegisterWithOptions(rcvr interface{}, opts ServiceOptions) error {
+	return server.register(rcvr, opts)
+}
+
+// RegisterWithOptions 在 DefaultServer 上以指定配置注册服务
+func RegisterWithOptions(rcvr interface{}, opts ServiceOptions) error {
+	return DefaultServer.RegisterWithOptions(rcvr, opts)
+}
@@ -0,0 +1,161 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Report 用于测试并发限制的慢服务
+type Report struct {
+	running int32
+	peak    int32
+}
+
+func (r *Report) Generate(d time.Duration, reply *int) error {
+	n := atomic.AddInt32(&r.running, 1)
+	defer atomic.AddInt32(&r.running, -1)
+	for {
+		peak := atomic.LoadInt32(&r.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&r.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(d)
+	return nil
+}
+
+func TestServer_RegisterWithOptions(t *testing.T) {
+	server := NewServer()
+	report := new(Report)
+	assert.Nil(t, server.RegisterWithOptions(report, ServiceOptions{MaxConcurrent: 2, MaxWait: time.Second}))
+	assert.Nil(t, server.Register(new(Foo)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	// 使用多个连接，验证并发限制在所有连接间共享
+	clients := make([]*Client, 5)
+	for i := range clients {
+		client, err := Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer func() { _ = client.Close() }()
+		clients[i] = client
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			assert.Nil(t, client.Call(context.Background(), "Report.Generate", 50*time.Millisecond, new(int)))
+		}(clients[i%len(clients)])
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	for _, s := range server.Snapshot().Services {
+		if s.Name == "Report" {
+			assert.Equal(t, int64(2), s.InFlight)
+			assert.Equal(t, 2, s.MaxConcurrent)
+		}
+	}
+	start := time.Now()
+	var reply int
+	assert.Nil(t, clients[0].Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.True(t, time.Since(start) < 40*time.Millisecond, "unlimited service must not wait for the limited one")
+
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&report.peak))
+}
+
+func TestServer_ServiceBusy(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterWithOptions(new(Report), ServiceOptions{MaxConcurrent: 1}))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Report.Generate", 100*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	err = client.Call(context.Background(), "Report.Generate", time.Millisecond, new(int))
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrServiceBusy.Error(), err.Error())
+	assert.True(t, errors.Is(err, ErrServiceBusy))
+	assert.Nil(t, (<-call.Done).Error)
+}
+
+func TestServer_ServiceLimitPriority(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterWithOptions(new(Report), ServiceOptions{MaxConcurrent: 1, MaxWait: time.Second}))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	running := client.Go("Report.Generate", 100*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	low := client.Go("Report.Generate", 50*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	high := client.Go("Report.Generate", time.Millisecond, new(int), nil, WithPriority(1))
+
+	<-running.Done
+	select {
+	case <-high.Done:
+	case <-low.Done:
+		t.Fatal("low priority call should wait for the high priority one")
+	}
+	assert.Nil(t, (<-low.Done).Error)
+}
+
+func TestServer_SetServiceLimit(t *testing.T) {
+	server := NewServer()
+	report := new(Report)
+	assert.Nil(t, server.RegisterWithOptions(report, ServiceOptions{MaxConcurrent: 1, MaxWait: time.Second}))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	running := client.Go("Report.Generate", 200*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	waiting := client.Go("Report.Generate", time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	// 调高上限后等待的请求立即获得名额
+	assert.Nil(t, server.SetServiceLimit("Report", 2, time.Second))
+	select {
+	case call := <-waiting.Done:
+		assert.Nil(t, call.Error)
+	case <-running.Done:
+		t.Fatal("the waiting call should start before the running one finishes")
+	}
+	<-running.Done
+
+	// 取消限制后不再排队
+	assert.Nil(t, server.SetServiceLimit("Report", 0, 0))
+	atomic.StoreInt32(&report.peak, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, client.Call(context.Background(), "Report.Generate", 50*time.Millisecond, new(int)))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&report.peak))
+	assert.NotNil(t, server.SetServiceLimit("Missing", 1, 0))
+}
@@ -0,0 +1,195 @@
+package geerpc
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger 结构化日志接口，keyvals 为交替出现的键值对
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// LogLevel 日志级别，低于当前级别的日志不会输出
+type LogLevel int32
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// ParseLogLevel 解析 String 返回的级别名，不区分大小写，"WARNING" 与 "WARN" 相同
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return LogLevelDebug, nil
+	case "INFO":
+		return LogLevelInfo, nil
+	case "WARN", "WARNING":
+		return LogLevelWarn, nil
+	case "ERROR":
+		return LogLevelError, nil
+	}
+	return 0, fmt.Errorf("rpc: unknown log level %q", s)
+}
+
+// stdLogger 使用标准库 log.Logger 输出，格式为 "LEVEL msg key=value ..."
+type stdLogger struct {
+	l *log.Logger
+}
+
+// NewStdLogger 返回使用 l 输出的 Logger，l 为 nil 时使用标准库的默认 Logger
+func NewStdLogger(l *log.Logger) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return stdLogger{l: l}
+}
+
+func (s stdLogger) Debug(msg string, keyvals ...interface{}) { s.output(LogLevelDebug, msg, keyvals) }
+func (s stdLogger) Info(msg string, keyvals ...interface{})  { s.output(LogLevelInfo, msg, keyvals) }
+func (s stdLogger) Warn(msg string, keyvals ...interface{})  { s.output(LogLevelWarn, msg, keyvals) }
+func (s stdLogger) Error(msg string, keyvals ...interface{}) { s.output(LogLevelError, msg, keyvals) }
+
+func (s stdLogger) output(level LogLevel, msg string, keyvals []interface{}) {
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		if i+1 >= len(keyvals) {
+			b.WriteString("MISSING")
+			break
+		}
+		v := fmt.Sprint(keyvals[i+1])
+		if strings.ContainsAny(v, " =\"") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+	_ = s.l.Output(3, b.String())
+}
+
+// loggerHolder 用于在 atomic.Value 中保存不同具体类型的 Logger
+type loggerHolder struct {
+	Logger
+}
+
+// logCore 保存 Logger 与日志级别，两者都可以在运行时原子地替换
+type logCore struct {
+	level    int32        // LogLevel，原子访问
+	out      atomic.Value // loggerHolder，Logger 为 nil 时使用 fallback
+	fallback *logCore
+}
+
+func newLogCore(out Logger, fallback *logCore) *logCore {
+	c := &logCore{level: int32(LogLevelInfo), fallback: fallback}
+	c.out.Store(loggerHolder{out})
+	return c
+}
+
+func (c *logCore) setLogger(l Logger) { c.out.Store(loggerHolder{l}) }
+
+func (c *logCore) setLevel(l LogLevel) { atomic.StoreInt32(&c.level, int32(l)) }
+
+func (c *logCore) getLevel() LogLevel { return LogLevel(atomic.LoadInt32(&c.level)) }
+
+func (c *logCore) enabled(l LogLevel) bool { return l >= LogLevel(atomic.LoadInt32(&c.level)) }
+
+func (c *logCore) logger() Logger {
+	if l := c.out.Load().(loggerHolder).Logger; l != nil || c.fallback == nil {
+		return l
+	}
+	return c.fallback.logger()
+}
+
+// defaultLog 客户端以及没有单独设置 Logger 的服务器使用的日志配置
+var defaultLog = newLogCore(NewStdLogger(nil), nil)
+
+// SetLogger 设置客户端以及没有单独设置 Logger 的服务器使用的默认 Logger
+func SetLogger(l Logger) { defaultLog.setLogger(l) }
+
+// SetLogLevel 设置客户端日志级别，可以在运行时修改
+func SetLogLevel(l LogLevel) { defaultLog.setLevel(l) }
+
+// logHandle 按级别过滤日志，并为每条日志附加固定的上下文字段
+type logHandle struct {
+	core   *logCore
+	fields []interface{}
+}
+
+// with 返回附加了更多字段的 logHandle
+func (h logHandle) with(keyvals ...interface{}) logHandle {
+	fields := make([]interface{}, 0, len(h.fields)+len(keyvals))
+	fields = append(append(fields, h.fields...), keyvals...)
+	return logHandle{core: h.core, fields: fields}
+}
+
+func (h logHandle) log(level LogLevel, msg string, keyvals []interface{}) {
+	if !h.core.enabled(level) {
+		return
+	}
+	l := h.core.logger()
+	if l == nil {
+		return
+	}
+	if len(h.fields) > 0 {
+		keyvals = append(append(make([]interface{}, 0, len(keyvals)+len(h.fields)), keyvals...), h.fields...)
+	}
+	switch level {
+	case LogLevelDebug:
+		l.Debug(msg, keyvals...)
+	case LogLevelInfo:
+		l.Info(msg, keyvals...)
+	case LogLevelWarn:
+		l.Warn(msg, keyvals...)
+	default:
+		l.Error(msg, keyvals...)
+	}
+}
+
+func (h logHandle) Debug(msg string, keyvals ...interface{}) { h.log(LogLevelDebug, msg, keyvals) }
+func (h logHandle) Info(msg string, keyvals ...interface{})  { h.log(LogLevelInfo, msg, keyvals) }
+func (h logHandle) Warn(msg string, keyvals ...interface{})  { h.log(LogLevelWarn, msg, keyvals) }
+func (h logHandle) Error(msg string, keyvals ...interface{}) { h.log(LogLevelError, msg, keyvals) }
+
+// clientLog 客户端使用的日志
+var clientLog = logHandle{core: defaultLog}
+
+// SetLogger 设置服务器使用的 Logger，l 为 nil 时使用包级别的默认 Logger
+func (server *Server) SetLogger(l Logger) { server.logs.setLogger(l) }
+
+// SetLogLevel 设置服务器的日志级别，可以在运行时修改
+func (server *Server) SetLogLevel(l LogLevel) { server.logs.setLevel(l) }
+
+// LogLevel 返回服务器当前的日志级别
+func (server *Server) LogLevel() LogLevel { return server.logs.getLevel() }
+
+// log 返回服务器级别的日志
+func (server *Server) log() logHandle { return logHandle{core: server.logs} }
@@ -0,0 +1,17 @@
+//go:build go1.21
+
+package geerpc
+
+import "log/slog"
+
+// *slog.Logger 的方法签名与 Logger 一致，可以直接使用
+var _ Logger = (*slog.Logger)(nil)
+
+// NewSlogLogger 返回使用 l 输出的 Logger，l 为 nil 时使用 slog.Default()
+// 级别过滤仍由 SetLogLevel 控制，l 的 Handler 可以进一步过滤
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,111 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logEntry 一条被 recordLogger 记录的日志
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordLogger 记录所有日志的 Logger
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *recordLogger) record(level LogLevel, msg string, keyvals []interface{}) {
+	e := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		e.fields[keyvals[i].(string)] = keyvals[i+1]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+func (r *recordLogger) Debug(msg string, keyvals ...interface{}) {
+	r.record(LogLevelDebug, msg, keyvals)
+}
+func (r *recordLogger) Info(msg string, keyvals ...interface{}) { r.record(LogLevelInfo, msg, keyvals) }
+func (r *recordLogger) Warn(msg string, keyvals ...interface{}) { r.record(LogLevelWarn, msg, keyvals) }
+func (r *recordLogger) Error(msg string, keyvals ...interface{}) {
+	r.record(LogLevelError, msg, keyvals)
+}
+
+// find 返回第一条消息为 msg 的日志
+func (r *recordLogger) find(msg string) (logEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func (r *recordLogger) count(level LogLevel) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.entries {
+		if e.level == level {
+			n++
+		}
+	}
+	return n
+}
+
+func TestServer_SetLogger(t *testing.T) {
+	rec := new(recordLogger)
+	server := NewServer()
+	server.SetLogger(rec)
+	server.SetLogLevel(LogLevelDebug)
+	_ = server.Register(new(Conn))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	e, ok := rec.find("rpc server: register")
+	assert.True(t, ok)
+	assert.Equal(t, "Conn.Sleep", e.fields["method"])
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("request fields", func(t *testing.T) {
+		assert.Nil(t, client.Call(context.Background(), "Conn.Sleep", time.Millisecond, new(int)))
+		e, ok := rec.find("rpc server: handle request")
+		assert.True(t, ok)
+		assert.Equal(t, LogLevelDebug, e.level)
+		assert.Equal(t, "Conn.Sleep", e.fields["method"])
+		assert.Equal(t, uint64(1), e.fields["seq"])
+		assert.NotNil(t, e.fields["conn"])
+		assert.NotNil(t, e.fields["remote"])
+	})
+	t.Run("raise level", func(t *testing.T) {
+		server.SetLogLevel(LogLevelWarn)
+		before := rec.count(LogLevelDebug)
+		assert.Nil(t, client.Call(context.Background(), "Conn.Sleep", time.Millisecond, new(int)))
+		assert.Equal(t, before, rec.count(LogLevelDebug))
+	})
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0))
+	l.Warn("rpc server: slow request", "method", "Foo.Sum", "err", "a b", "odd")
+	assert.Equal(t, "WARN rpc server: slow request method=Foo.Sum err=\"a b\" odd=MISSING\n", buf.String())
+}
@@ -0,0 +1,23 @@
+package metrics_test
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/metrics"
+)
+
+func ExampleRegister() {
+	server := geerpc.NewServer()
+	reg := prometheus.NewRegistry()
+	if err := metrics.Register(server, reg); err != nil {
+		log.Fatal(err)
+	}
+	// 在 /metrics 上导出指标，如 geerpc_server_requests_total{method="Arith.Multiply"}
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go func() { _ = http.ListenAndServe(":2112", nil) }()
+	go func() { _ = server.ListenAndServe("tcp", ":9999") }()
+}
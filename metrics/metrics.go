@@ -0,0 +1,185 @@
+// Package metrics 将 geerpc 服务器与 XClient 的运行统计导出为 Prometheus 指标
+// 指标在每次抓取时从 Server.Stats、Server.MethodStats 与 XClient.Stats 读取，不在请求处理的路径上增加开销；
+// 需要显式调用 Register 注册，geerpc 本身不依赖 Prometheus
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+// DefaultBuckets 处理耗时直方图默认的桶上界，单位秒，从 256 微秒到约 4 秒，每个桶是上一个的 4 倍
+// 服务器内部以 2 的幂次微秒为界记录耗时，上界为 2 的幂次微秒的桶是精确的
+var DefaultBuckets = prometheus.ExponentialBuckets(256e-6, 4, 8)
+
+// Options 指标的选项
+type Options struct {
+	Namespace   string            // 指标名的前缀，为空时使用 "geerpc"
+	Buckets     []float64         // 处理耗时直方图的桶上界，单位秒，为空时使用 DefaultBuckets
+	ConstLabels prometheus.Labels // 附加到所有指标上的标签，如 {"instance": "a"}
+}
+
+func (opts Options) namespace() string {
+	if opts.Namespace == "" {
+		return "geerpc"
+	}
+	return opts.Namespace
+}
+
+// ServerCollector 导出服务器运行统计的 prometheus.Collector
+// 计数器在 Server.ResetStats 之后会重新从零开始，Prometheus 会将其视为计数器重置
+type ServerCollector struct {
+	server  *geerpc.Server
+	buckets []float64
+
+	requests    *prometheus.Desc
+	errors      *prometheus.Desc
+	timeouts    *prometheus.Desc
+	duration    *prometheus.Desc
+	activeConns *prometheus.Desc
+	totalConns  *prometheus.Desc
+	deniedConns *prometheus.Desc
+	inFlight    *prometheus.Desc
+}
+
+// NewServerCollector 创建导出 server 运行统计的 ServerCollector
+func NewServerCollector(server *geerpc.Server, opts Options) *ServerCollector {
+	ns := opts.namespace()
+	name := func(n string) string { return prometheus.BuildFQName(ns, "server", n) }
+	method := []string{"method"}
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &ServerCollector{
+		server:  server,
+		buckets: buckets,
+		requests: prometheus.NewDesc(name("requests_total"),
+			"Number of requests that started executing, by method.", method, opts.ConstLabels),
+		errors: prometheus.NewDesc(name("errors_total"),
+			"Number of requests whose method returned an error, by method.", method, opts.ConstLabels),
+		timeouts: prometheus.NewDesc(name("timeouts_total"),
+			"Number of requests that exceeded the handle timeout, by method.", method, opts.ConstLabels),
+		duration: prometheus.NewDesc(name("handler_duration_seconds"),
+			"Time spent in the method for requests that returned, by method.", method, opts.ConstLabels),
+		activeConns: prometheus.NewDesc(name("active_connections"),
+			"Number of connections currently being served.", nil, opts.ConstLabels),
+		totalConns: prometheus.NewDesc(name("connections_total"),
+			"Number of accepted connections.", nil, opts.ConstLabels),
+		deniedConns: prometheus.NewDesc(name("denied_connections_total"),
+			"Number of connections rejected by the access list.", nil, opts.ConstLabels),
+		inFlight: prometheus.NewDesc(name("in_flight_requests"),
+			"Number of requests currently being handled.", nil, opts.ConstLabels),
+	}
+}
+
+// Describe 实现 prometheus.Collector
+func (c *ServerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.requests, c.errors, c.timeouts, c.duration, c.activeConns, c.totalConns, c.deniedConns, c.inFlight} {
+		ch <- d
+	}
+}
+
+// Collect 实现 prometheus.Collector
+func (c *ServerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.server.Stats()
+	ch <- prometheus.MustNewConstMetric(c.activeConns, prometheus.GaugeValue, float64(stats.ActiveConnections))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.CounterValue, float64(stats.TotalConnections))
+	ch <- prometheus.MustNewConstMetric(c.deniedConns, prometheus.CounterValue, float64(stats.DeniedConnections))
+	ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(stats.InFlightRequests))
+	for method, s := range c.server.MethodStats() {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(s.Calls), method)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), method)
+		ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts), method)
+		count, buckets := histogram(s.Histogram, c.buckets)
+		ch <- prometheus.MustNewConstHistogram(c.duration, count, s.TotalDuration.Seconds(), buckets, method)
+	}
+}
+
+// histogram 将以 2 的幂次微秒为界的耗时直方图换算为以 bounds 为上界的累计计数
+// 每个上界的计数只包括上界不超过它的内部桶，上界不是 2 的幂次微秒时偏小
+func histogram(hist []uint64, bounds []float64) (count uint64, buckets map[float64]uint64) {
+	buckets = make(map[float64]uint64, len(bounds))
+	for _, b := range bounds {
+		buckets[b] = 0
+	}
+	for i, n := range hist {
+		count += n
+		if i == len(hist)-1 {
+			break // 最后一个内部桶包括更长的调用，只计入 +Inf
+		}
+		upper := (time.Duration(1) << uint(i) * time.Microsecond).Seconds()
+		for _, b := range bounds {
+			if upper <= b {
+				buckets[b] += n
+			}
+		}
+	}
+	return count, buckets
+}
+
+// XClientCollector 导出 XClient 对各个服务器的调用统计的 prometheus.Collector
+type XClientCollector struct {
+	xc *xclient.XClient
+
+	requests *prometheus.Desc
+	errors   *prometheus.Desc
+	inFlight *prometheus.Desc
+	dials    *prometheus.Desc
+	dialErrs *prometheus.Desc
+	recycled *prometheus.Desc
+}
+
+// NewXClientCollector 创建导出 xc 调用统计的 XClientCollector，Options.Buckets 不使用
+func NewXClientCollector(xc *xclient.XClient, opts Options) *XClientCollector {
+	ns := opts.namespace()
+	name := func(n string) string { return prometheus.BuildFQName(ns, "client", n) }
+	server := []string{"server"}
+	return &XClientCollector{
+		xc: xc,
+		requests: prometheus.NewDesc(name("requests_total"),
+			"Number of completed calls, by server address.", server, opts.ConstLabels),
+		errors: prometheus.NewDesc(name("errors_total"),
+			"Number of failed calls including dial failures, by server address.", server, opts.ConstLabels),
+		inFlight: prometheus.NewDesc(name("in_flight_requests"),
+			"Number of calls sent and not yet completed, by server address.", server, opts.ConstLabels),
+		dials: prometheus.NewDesc(name("dials_total"),
+			"Number of connection attempts, by server address.", server, opts.ConstLabels),
+		dialErrs: prometheus.NewDesc(name("dial_errors_total"),
+			"Number of failed connection attempts, by server address.", server, opts.ConstLabels),
+		recycled: prometheus.NewDesc(name("recycled_connections_total"),
+			"Number of connections replaced after MaxConnAge, by server address.", server, opts.ConstLabels),
+	}
+}
+
+// Describe 实现 prometheus.Collector
+func (c *XClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.requests, c.errors, c.inFlight, c.dials, c.dialErrs, c.recycled} {
+		ch <- d
+	}
+}
+
+// Collect 实现 prometheus.Collector
+func (c *XClientCollector) Collect(ch chan<- prometheus.Metric) {
+	for addr, s := range c.xc.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(s.Calls), addr)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), addr)
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(s.InFlight), addr)
+		ch <- prometheus.MustNewConstMetric(c.dials, prometheus.CounterValue, float64(s.Dials), addr)
+		ch <- prometheus.MustNewConstMetric(c.dialErrs, prometheus.CounterValue, float64(s.DialErrs), addr)
+		ch <- prometheus.MustNewConstMetric(c.recycled, prometheus.CounterValue, float64(s.Recycled), addr)
+	}
+}
+
+// Register 以默认选项将 server 的指标注册到 reg
+func Register(server *geerpc.Server, reg prometheus.Registerer) error {
+	return reg.Register(NewServerCollector(server, Options{}))
+}
+
+// RegisterXClient 以默认选项将 xc 的指标注册到 reg
+func RegisterXClient(xc *xclient.XClient, reg prometheus.Registerer) error {
+	return reg.Register(NewXClientCollector(xc, Options{}))
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+type Calc int
+
+func (c Calc) Double(n int, reply *int) error {
+	*reply = n * 2
+	return nil
+}
+
+func (c Calc) Fail(n int, reply *int) error {
+	return errors.New("calc failed")
+}
+
+func (c Calc) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func startServer(t *testing.T) (*geerpc.Server, string) {
+	server := geerpc.NewServer()
+	assert.Nil(t, server.Register(new(Calc)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, l.Addr().String()
+}
+
+func TestRegister(t *testing.T) {
+	server, addr := startServer(t)
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, Register(server, reg))
+	assert.NotNil(t, Register(server, reg), "registering the same collector twice fails")
+
+	client, err := geerpc.Dial("tcp", addr, &geerpc.Option{MagicNumber: geerpc.MagicNumber, HandleTimeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	var reply int
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, client.Call(ctx, "Calc.Double", i, &reply))
+	}
+	assert.NotNil(t, client.Call(ctx, "Calc.Fail", 0, &reply))
+	assert.NotNil(t, client.Call(ctx, "Calc.Sleep", 100*time.Millisecond, &reply))
+	time.Sleep(100 * time.Millisecond) // 等待超时的方法执行结束
+
+	expected := `
+# HELP geerpc_server_requests_total Number of requests that started executing, by method.
+# TYPE geerpc_server_requests_total counter
+geerpc_server_requests_total{method="Calc.Double"} 3
+geerpc_server_requests_total{method="Calc.Fail"} 1
+geerpc_server_requests_total{method="Calc.Sleep"} 1
+# HELP geerpc_server_errors_total Number of requests whose method returned an error, by method.
+# TYPE geerpc_server_errors_total counter
+geerpc_server_errors_total{method="Calc.Double"} 0
+geerpc_server_errors_total{method="Calc.Fail"} 1
+geerpc_server_errors_total{method="Calc.Sleep"} 0
+# HELP geerpc_server_timeouts_total Number of requests that exceeded the handle timeout, by method.
+# TYPE geerpc_server_timeouts_total counter
+geerpc_server_timeouts_total{method="Calc.Double"} 0
+geerpc_server_timeouts_total{method="Calc.Fail"} 0
+geerpc_server_timeouts_total{method="Calc.Sleep"} 1
+# HELP geerpc_server_active_connections Number of connections currently being served.
+# TYPE geerpc_server_active_connections gauge
+geerpc_server_active_connections 1
+# HELP geerpc_server_in_flight_requests Number of requests currently being handled.
+# TYPE geerpc_server_in_flight_requests gauge
+geerpc_server_in_flight_requests 0
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"geerpc_server_requests_total", "geerpc_server_errors_total", "geerpc_server_timeouts_total",
+		"geerpc_server_active_connections", "geerpc_server_in_flight_requests"))
+
+	families, err := reg.Gather()
+	assert.Nil(t, err)
+	for _, f := range families {
+		if f.GetName() != "geerpc_server_handler_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			h := m.GetHistogram()
+			switch m.GetLabel()[0].GetValue() {
+			case "Calc.Double":
+				assert.Equal(t, uint64(3), h.GetSampleCount())
+			case "Calc.Sleep":
+				assert.Equal(t, uint64(1), h.GetSampleCount())
+				assert.True(t, h.GetSampleSum() >= 0.1, h.GetSampleSum())
+				for _, b := range h.GetBucket() {
+					if b.GetUpperBound() < 0.1 {
+						assert.Equal(t, uint64(0), b.GetCumulativeCount(), b.GetUpperBound())
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestServerCollector_Options(t *testing.T) {
+	server, addr := startServer(t)
+	reg := prometheus.NewRegistry()
+	c := NewServerCollector(server, Options{
+		Namespace:   "app",
+		Buckets:     []float64{0.001024, 0.065536},
+		ConstLabels: prometheus.Labels{"instance": "a"},
+	})
+	assert.Nil(t, reg.Register(c))
+
+	client, err := geerpc.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Calc.Sleep", 5*time.Millisecond, &reply))
+
+	families, err := reg.Gather()
+	assert.Nil(t, err)
+	var found bool
+	for _, f := range families {
+		if f.GetName() != "app_server_handler_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["method"] != "Calc.Sleep" {
+				continue
+			}
+			found = true
+			assert.Equal(t, "a", labels["instance"])
+			buckets := m.GetHistogram().GetBucket()
+			assert.Equal(t, 2, len(buckets))
+			assert.Equal(t, uint64(0), buckets[0].GetCumulativeCount())
+			assert.Equal(t, uint64(1), buckets[1].GetCumulativeCount())
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestHistogram(t *testing.T) {
+	hist := make([]uint64, 40)
+	hist[1] = 2  // 小于 2 微秒
+	hist[10] = 3 // 小于 1024 微秒
+	hist[39] = 1 // 包括更长的调用
+	count, buckets := histogram(hist, []float64{0.000002, 0.001, 0.001024, 1e9})
+	assert.Equal(t, uint64(6), count)
+	assert.Equal(t, map[float64]uint64{0.000002: 2, 0.001: 2, 0.001024: 5, 1e9: 5}, buckets)
+
+	count, buckets = histogram(nil, DefaultBuckets)
+	assert.Equal(t, uint64(0), count)
+	assert.Equal(t, len(DefaultBuckets), len(buckets))
+}
+
+func TestRegisterXClient(t *testing.T) {
+	_, addr := startServer(t)
+	xc := xclient.NewXClient(xclient.NewMultiServerDiscovery([]string{"tcp@" + addr}), xclient.RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, RegisterXClient(xc, reg))
+
+	ctx := context.Background()
+	var reply int
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, xc.Call(ctx, "Calc.Double", i, &reply))
+	}
+	assert.NotNil(t, xc.Call(ctx, "Calc.Fail", 0, &reply))
+
+	server := "tcp@" + addr
+	expected := `
+# HELP geerpc_client_requests_total Number of completed calls, by server address.
+# TYPE geerpc_client_requests_total counter
+geerpc_client_requests_total{server="` + server + `"} 3
+# HELP geerpc_client_errors_total Number of failed calls including dial failures, by server address.
+# TYPE geerpc_client_errors_total counter
+geerpc_client_errors_total{server="` + server + `"} 1
+# HELP geerpc_client_in_flight_requests Number of calls sent and not yet completed, by server address.
+# TYPE geerpc_client_in_flight_requests gauge
+geerpc_client_in_flight_requests{server="` + server + `"} 0
+# HELP geerpc_client_dials_total Number of connection attempts, by server address.
+# TYPE geerpc_client_dials_total counter
+geerpc_client_dials_total{server="` + server + `"} 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"geerpc_client_requests_total", "geerpc_client_errors_total",
+		"geerpc_client_in_flight_requests", "geerpc_client_dials_total"))
+}
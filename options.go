@@ -0,0 +1,76 @@
+package geerpc
+
+import (
+	"time"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// ServerOptions 服务器级别的默认配置
+// 客户端在握手时发送的 Option 会与这些配置合并，客户端不能突破服务器设置的上限
+type ServerOptions struct {
+	DefaultHandleTimeout time.Duration // 客户端未指定 HandleTimeout 时使用，0表示不受限制
+	MaxHandleTimeout     time.Duration // 客户端可以请求的最大 HandleTimeout，0表示不限制
+	DefaultCodec         codec.Type    // 客户端未指定 CodecType 时使用，为空时使用 gob
+	HandshakeTimeout     time.Duration // 等待客户端发送 Option 的最长时间，0表示不受限制
+
+	WorkerPool           WorkerPool    // worker 池配置，Size 为0时每个请求使用一个 goroutine
+	SlowRequestThreshold time.Duration // 慢请求阈值，超过该耗时的请求会输出到日志
+	BroadcastTimeout     time.Duration // Broadcast 写入单个连接的超时时间，0表示使用 defaultBroadcastTimeout
+
+	IdempotencyCacheSize int           // 幂等键响应缓存的条目数，0表示使用默认值 1024，小于0表示不处理幂等键
+	IdempotencyTTL       time.Duration // 幂等键响应缓存的有效期，0表示使用默认值5分钟
+
+	AllowCIDRs []string // 允许连接的地址，为空表示允许所有地址，见 Server.SetAccessLists
+	DenyCIDRs  []string // 拒绝连接的地址，优先于 AllowCIDRs
+
+	// SigningKeys 不为空时所有连接都必须对请求签名，任意一个密钥都可以通过校验，响应使用最近一次校验通过的密钥签名
+	// 轮换密钥时先在服务端加入新密钥，客户端全部更换后再移除旧密钥
+	SigningKeys          [][]byte
+	MaxSignatureFailures int // 单个连接签名校验失败达到该次数后断开连接，0表示不断开
+
+	// HealthPath RegisterHTTP 注册健康检查的路径，为空时使用 "/healthz"，为 "-" 时不注册
+	HealthPath string
+
+	// EnablePprofLabels 为 true 时调用方法的 goroutine 带有 pprof 标签 PprofLabelMethod，值为 ServiceMethod，
+	// 可以在 CPU 与 goroutine profile 中按方法区分，每次调用有少量额外开销
+	EnablePprofLabels bool
+
+	// WriteCoalesceWindow 大于0时合并每个连接上该时间内发送的响应，减少小响应较多时写连接的系统调用，
+	// 响应最多延迟该时间发送；0表示每个响应立即写入连接
+	WriteCoalesceWindow time.Duration
+	// WriteCoalesceMaxBytes 合并的数据超过该大小时立即写入连接，0表示使用默认值 32KiB
+	WriteCoalesceMaxBytes int
+}
+
+// NewServerWithOptions 使用指定的服务器配置创建服务器
+// 配置无效时（如 AllowCIDRs 或 DenyCIDRs 中有无法解析的项），Serve、ListenAndServe 等返回该错误，ServeConn 直接关闭连接
+func NewServerWithOptions(opts ServerOptions) *Server {
+	server := NewServer()
+	server.opts = opts
+	server.idem = newIdempotencyCache(opts.IdempotencyCacheSize, opts.IdempotencyTTL)
+	if opts.WorkerPool.Size > 0 {
+		server.SetWorkerPool(opts.WorkerPool)
+	}
+	if opts.SlowRequestThreshold > 0 {
+		server.SetSlowRequestThreshold(opts.SlowRequestThreshold, nil)
+	}
+	server.optsErr = server.SetAccessLists(opts.AllowCIDRs, opts.DenyCIDRs)
+	return server
+}
+
+// merge 将客户端发送的 Option 与服务器配置合并
+func (o *ServerOptions) merge(opt *Option) {
+	if opt.CodecType == "" {
+		opt.CodecType = o.DefaultCodec
+		if opt.CodecType == "" {
+			opt.CodecType = DefaultOption.CodecType
+		}
+	}
+	if opt.HandleTimeout <= 0 {
+		opt.HandleTimeout = o.DefaultHandleTimeout
+	}
+	if o.MaxHandleTimeout > 0 && (opt.HandleTimeout <= 0 || opt.HandleTimeout > o.MaxHandleTimeout) {
+		opt.HandleTimeout = o.MaxHandleTimeout
+	}
+}
@@ -0,0 +1,71 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+func TestServerOptions_merge(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  ServerOptions
+		client  Option
+		timeout time.Duration
+		codec   codec.Type
+	}{
+		{"no server defaults", ServerOptions{}, Option{HandleTimeout: time.Second}, time.Second, codec.GobType},
+		{"unlimited client", ServerOptions{}, Option{}, 0, codec.GobType},
+		{"default timeout", ServerOptions{DefaultHandleTimeout: time.Second}, Option{}, time.Second, codec.GobType},
+		{"client overrides default", ServerOptions{DefaultHandleTimeout: time.Second}, Option{HandleTimeout: 2 * time.Second}, 2 * time.Second, codec.GobType},
+		{"clamped to max", ServerOptions{MaxHandleTimeout: time.Second}, Option{HandleTimeout: time.Minute}, time.Second, codec.GobType},
+		{"within max", ServerOptions{MaxHandleTimeout: time.Second}, Option{HandleTimeout: time.Millisecond}, time.Millisecond, codec.GobType},
+		{"unlimited clamped to max", ServerOptions{MaxHandleTimeout: time.Second}, Option{}, time.Second, codec.GobType},
+		{"default above max", ServerOptions{DefaultHandleTimeout: time.Minute, MaxHandleTimeout: time.Second}, Option{}, time.Second, codec.GobType},
+		{"default codec", ServerOptions{DefaultCodec: codec.JsonType}, Option{}, 0, codec.JsonType},
+		{"client codec", ServerOptions{DefaultCodec: codec.JsonType}, Option{CodecType: codec.GobType}, 0, codec.GobType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := tt.client
+			tt.server.merge(&opt)
+			assert.Equal(t, tt.timeout, opt.HandleTimeout)
+			assert.Equal(t, tt.codec, opt.CodecType)
+		})
+	}
+}
+
+func TestNewServerWithOptions(t *testing.T) {
+	server := NewServerWithOptions(ServerOptions{
+		MaxHandleTimeout: 100 * time.Millisecond,
+		HandshakeTimeout: 100 * time.Millisecond,
+	})
+	_ = server.Register(new(Conn))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	t.Run("max handle timeout", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: time.Minute})
+		assert.Nil(t, err)
+		defer func() { _ = client.Close() }()
+		err = client.Call(context.Background(), "Conn.Sleep", 300*time.Millisecond, new(int))
+		assert.True(t, err != nil && strings.Contains(err.Error(), "handle timeout"), err)
+	})
+	t.Run("handshake timeout", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.NotNil(t, err)
+		if ne, ok := err.(net.Error); ok {
+			assert.False(t, ne.Timeout(), "server should close the idle connection")
+		}
+	})
+}
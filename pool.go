@@ -0,0 +1,187 @@
+package geerpc
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WorkerPool 配置服务器以固定数量的 worker 处理请求，而不是每个请求启动一个 goroutine
+// 适用于方法本身非常轻量、goroutine 开销占比较高的服务
+type WorkerPool struct {
+	Size     int // worker 数量，为0时不启用 worker 池
+	QueueLen int // 等待处理的请求队列长度，队列满时拒绝新的请求；排队的请求按 Priority 从高到低执行
+
+	RetryAfter time.Duration // 队列满时建议客户端等待多久后重试，0表示不提示
+}
+
+// ErrServerBusy 服务器无法再接收请求时返回给客户端的错误，可以用 errors.Is 判断
+// 客户端应退避后重试，或者立即换一台服务器
+var ErrServerBusy = errors.New("rpc server: server busy")
+
+// starvationInterval 每出队这么多次，直接出队一个最早入队的任务，避免低优先级任务被饿死
+const starvationInterval = 8
+
+// queueItem 等待执行的任务
+type queueItem struct {
+	priority  uint8
+	seq       uint64 // 入队顺序
+	task      func()
+	cancelled bool // 已被取消的任务出队时跳过
+}
+
+// priorityQueue 按优先级出队的任务队列，同一优先级先进先出，非并发安全
+type priorityQueue struct {
+	items []*queueItem // 按 priority 降序、seq 升序排列的二叉堆
+	seq   uint64
+	pops  uint64
+}
+
+func (q *priorityQueue) Len() int { return len(q.items) }
+
+func (q *priorityQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+func (q *priorityQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *priorityQueue) Push(x interface{}) { q.items = append(q.items, x.(*queueItem)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
+
+// push 将任务放入队列
+func (q *priorityQueue) push(priority uint8, task func()) *queueItem {
+	q.seq++
+	item := &queueItem{priority: priority, seq: q.seq, task: task}
+	heap.Push(q, item)
+	return item
+}
+
+// pop 取出下一个未被取消的任务，队列为空时返回 nil
+func (q *priorityQueue) pop() *queueItem {
+	for len(q.items) > 0 {
+		i := 0
+		q.pops++
+		if q.pops%starvationInterval == 0 {
+			for j := range q.items {
+				if q.items[j].seq < q.items[i].seq {
+					i = j
+				}
+			}
+		}
+		item := heap.Remove(q, i).(*queueItem)
+		if !item.cancelled {
+			return item
+		}
+	}
+	return nil
+}
+
+// workerPool 在所有连接间共享的 worker 池，排队的请求按优先级执行
+type workerPool struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    priorityQueue
+	queueLen int           // 队列中最多等待的任务数，不含可以立即被空闲 worker 执行的任务
+	retry    time.Duration // 队列满时建议客户端重试的等待时间
+	idle     int           // 空闲的 worker 数
+	stopped  bool          // 停止后 worker 执行完队列中的任务后退出
+}
+
+func newWorkerPool(p WorkerPool) *workerPool {
+	wp := &workerPool{queueLen: p.QueueLen, retry: p.RetryAfter}
+	wp.cond = sync.NewCond(&wp.mu)
+	for i := 0; i < p.Size; i++ {
+		go wp.work()
+	}
+	return wp
+}
+
+func (wp *workerPool) work() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for {
+		for wp.queue.Len() == 0 && !wp.stopped {
+			wp.idle++
+			wp.cond.Wait()
+			wp.idle--
+		}
+		// 执行完已经进入队列的请求，保证连接上等待的请求都能结束
+		item := wp.queue.pop()
+		if item == nil {
+			return
+		}
+		wp.mu.Unlock()
+		item.task()
+		wp.mu.Lock()
+	}
+}
+
+// submit 将任务放入队列，队列已满时返回 false
+// worker 池停止后任务直接在新的 goroutine 中执行
+func (wp *workerPool) submit(task func(), priority uint8) bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.stopped {
+		go task()
+		return true
+	}
+	if wp.queue.Len() >= wp.queueLen+wp.idle {
+		return false
+	}
+	wp.queue.push(priority, task)
+	wp.cond.Signal()
+	return true
+}
+
+func (wp *workerPool) stop() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.stopped = true
+	wp.cond.Broadcast()
+}
+
+// SetWorkerPool 设置服务器的 worker 池，需要在开始服务前调用
+// p.Size 为0时恢复为每个请求一个 goroutine
+func (server *Server) SetWorkerPool(p WorkerPool) {
+	var pool *workerPool
+	if p.Size > 0 {
+		pool = newWorkerPool(p)
+	}
+	server.mu.Lock()
+	old, _ := server.pool.Load().(*workerPool)
+	server.pool.Store(pool)
+	server.mu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+}
+
+// dispatch 调度执行 task，使用 worker 池且队列已满时返回 false 以及建议客户端重试的等待时间
+// priority 只在使用 worker 池时生效
+func (server *Server) dispatch(task func(), priority uint8) (bool, time.Duration) {
+	pool, _ := server.pool.Load().(*workerPool)
+	if pool == nil {
+		go task()
+		return true, 0
+	}
+	return pool.submit(task, priority), pool.retry
+}
+
+// stopWorkers 停止 worker 池
+func (server *Server) stopWorkers() {
+	if pool, _ := server.pool.Load().(*workerPool); pool != nil {
+		pool.stop()
+	}
+}
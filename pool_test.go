@@ -0,0 +1,154 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startPoolServer(t testing.TB, p WorkerPool, rcvrs ...interface{}) (*Server, string) {
+	server := NewServer()
+	server.SetWorkerPool(p)
+	for _, rcvr := range rcvrs {
+		_ = server.Register(rcvr)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func TestWorkerPool_Responses(t *testing.T) {
+	server, addr := startPoolServer(t, WorkerPool{Size: 2, QueueLen: 64}, new(Foo))
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			var err error
+			// 队列满时客户端会收到 server busy，重试直到成功
+			for {
+				err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i * i}, &reply)
+				if err == nil || err.Error() != ErrServerBusy.Error() {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, i+i*i, reply, "reply should match the request seq")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWorkerPool_Busy(t *testing.T) {
+	server, addr := startPoolServer(t, WorkerPool{Size: 1, QueueLen: 1, RetryAfter: 20 * time.Millisecond}, new(Conn))
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	running := client.Go("Conn.Sleep", 200*time.Millisecond, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	queued := client.Go("Conn.Sleep", time.Millisecond, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	err = client.Call(context.Background(), "Conn.Sleep", time.Millisecond, new(int))
+	assert.EqualError(t, err, ErrServerBusy.Error())
+	assert.True(t, errors.Is(err, ErrServerBusy))
+	assert.Equal(t, 20*time.Millisecond, RetryAfter(err))
+
+	assert.Nil(t, (<-running.Done).Error)
+	assert.Nil(t, (<-queued.Done).Error)
+}
+
+func benchmarkFooSum(b *testing.B, p WorkerPool, rcvr interface{}, serviceMethod string) {
+	server, addr := startPoolServer(b, p, rcvr)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			_ = client.Call(context.Background(), serviceMethod, &Args{Num1: 1, Num2: 2}, &reply)
+		}
+	})
+}
+
+func BenchmarkServer_FooSum(b *testing.B) {
+	b.Run("goroutine per request", func(b *testing.B) {
+		benchmarkFooSum(b, WorkerPool{}, new(Foo), "Foo.Sum")
+	})
+	b.Run("worker pool", func(b *testing.B) {
+		n := runtime.GOMAXPROCS(0)
+		benchmarkFooSum(b, WorkerPool{Size: n, QueueLen: 1024}, new(Foo), "Foo.Sum")
+	})
+	b.Run("raw handler", func(b *testing.B) {
+		benchmarkFooSum(b, WorkerPool{}, new(RawFoo), "RawFoo.Sum")
+	})
+}
+
+func TestWorkerPool_Priority(t *testing.T) {
+	server, addr := startPoolServer(t, WorkerPool{Size: 1, QueueLen: 16}, new(Conn))
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	running := client.Go("Conn.Sleep", 100*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	var queued []*Call
+	for i := 0; i < 3; i++ {
+		queued = append(queued, client.Go("Conn.Sleep", 50*time.Millisecond, new(int), nil))
+	}
+	time.Sleep(20 * time.Millisecond)
+	high := client.Go("Conn.Sleep", time.Millisecond, new(int), nil, WithPriority(10))
+
+	var order []*Call
+	for i := 0; i < 5; i++ {
+		select {
+		case call := <-running.Done:
+			order = append(order, call)
+		case call := <-queued[0].Done:
+			order = append(order, call)
+		case call := <-queued[1].Done:
+			order = append(order, call)
+		case call := <-queued[2].Done:
+			order = append(order, call)
+		case call := <-high.Done:
+			order = append(order, call)
+		}
+	}
+	assert.Equal(t, running, order[0])
+	assert.Equal(t, high, order[1], "high priority call should jump the queue")
+}
+
+func TestPriorityQueue_Starvation(t *testing.T) {
+	var q priorityQueue
+	var got []int
+	q.push(0, func() { got = append(got, -1) })
+	for i := 0; i < 20; i++ {
+		i := i
+		q.push(10, func() { got = append(got, i) })
+	}
+	for item := q.pop(); item != nil; item = q.pop() {
+		item.task()
+	}
+	assert.Equal(t, 21, len(got))
+	assert.Equal(t, -1, got[starvationInterval-1], "oldest low priority task is promoted")
+	assert.Equal(t, 0, got[0])
+}
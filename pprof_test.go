@@ -0,0 +1,64 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Blocker 的方法阻塞到 release 关闭
+type Blocker struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *Blocker) Wait(n int, reply *int) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return nil
+}
+
+// labeledGoroutines 返回 goroutine profile 中带有 method 标签的部分
+func labeledGoroutines(t *testing.T, method string) int {
+	var buf bytes.Buffer
+	assert.Nil(t, pprof.Lookup("goroutine").WriteTo(&buf, 1))
+	return bytes.Count(buf.Bytes(), []byte(`"`+PprofLabelMethod+`":"`+method+`"`))
+}
+
+func TestPprofLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		server, client bool
+		want           int
+	}{
+		{name: "disabled"},
+		{name: "server", server: true, want: 1},
+		{name: "client", client: true, want: 1},
+		{name: "both", server: true, client: true, want: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServerWithOptions(ServerOptions{EnablePprofLabels: tc.server})
+			b := &Blocker{entered: make(chan struct{}), release: make(chan struct{})}
+			assert.Nil(t, server.Register(b))
+			go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+			addr := waitForAddr(t, server)
+			defer func() { _ = server.Close() }()
+			client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, EnablePprofLabels: tc.client})
+			assert.Nil(t, err)
+			defer func() { _ = client.Close() }()
+
+			done := make(chan error, 1)
+			go func() { done <- client.Call(context.Background(), "Blocker.Wait", 1, new(int)) }()
+			<-b.entered
+			time.Sleep(10 * time.Millisecond) // 等待客户端进入等待响应的状态
+			assert.Equal(t, tc.want, labeledGoroutines(t, "Blocker.Wait"))
+			close(b.release)
+			assert.Nil(t, <-done)
+			assert.Equal(t, 0, labeledGoroutines(t, "Blocker.Wait"), "labels are removed after the call")
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package geerpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Propagator 在调用之间传递 context 中的值，如请求ID、租户ID
+// 客户端发送请求前调用 Inject 将 ctx 中的值写入请求的元数据，服务端调用方法前调用 Extract 将元数据中的值放回 ctx，
+// 带 context 参数的方法即可在服务端读取到这些值。同一个 Propagator 会被并发调用
+type Propagator interface {
+	// Inject 将 ctx 中的值写入 md，调用选项（如 WithMetadata）中已经设置的键不会被覆盖
+	Inject(ctx context.Context, md map[string]string)
+	// Extract 返回附加了 md 中的值的 ctx，md 中没有对应的值时直接返回 ctx
+	Extract(ctx context.Context, md map[string]string) context.Context
+}
+
+var (
+	propagatorsMu     sync.RWMutex
+	globalPropagators []Propagator
+)
+
+// RegisterPropagator 注册对所有 Client 与 Server 生效的 Propagator，在 SetPropagators 设置的 Propagator 之前调用
+// 通常在 init 或程序启动时调用
+func RegisterPropagator(p Propagator) {
+	propagatorsMu.Lock()
+	defer propagatorsMu.Unlock()
+	globalPropagators = append(globalPropagators, p)
+}
+
+// propagators 返回依次生效的 Propagator，local 为 SetPropagators 设置的值
+func propagators(local interface{}) []Propagator {
+	propagatorsMu.RLock()
+	ps := globalPropagators
+	propagatorsMu.RUnlock()
+	if l, _ := local.([]Propagator); len(l) > 0 {
+		ps = append(ps[:len(ps):len(ps)], l...)
+	}
+	return ps
+}
+
+// SetPropagators 设置该客户端使用的 Propagator，替换之前设置的值，RegisterPropagator 注册的 Propagator 仍然生效
+func (client *Client) SetPropagators(ps ...Propagator) {
+	client.propagators.Store(append([]Propagator(nil), ps...))
+}
+
+// inject 将 ctx 中的值写入调用的元数据
+func (client *Client) inject(ctx context.Context, call *Call) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		if _, set := call.Metadata[TraceparentMetadata]; !set {
+			WithMetadata(TraceparentMetadata, sc.String())(call)
+		}
+	}
+	ps := propagators(client.propagators.Load())
+	if len(ps) == 0 {
+		return
+	}
+	md := make(map[string]string)
+	for _, p := range ps {
+		p.Inject(ctx, md)
+	}
+	for k, v := range md {
+		if _, ok := call.Metadata[k]; !ok {
+			WithMetadata(k, v)(call)
+		}
+	}
+}
+
+// SetPropagators 设置该服务器使用的 Propagator，替换之前设置的值，RegisterPropagator 注册的 Propagator 仍然生效
+func (server *Server) SetPropagators(ps ...Propagator) {
+	server.propagators.Store(append([]Propagator(nil), ps...))
+}
+
+// extract 返回附加了请求元数据中传递的值的 ctx
+func (server *Server) extract(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	for _, p := range propagators(server.propagators.Load()) {
+		ctx = p.Extract(ctx, md)
+	}
+	return ctx
+}
+
+// RequestIDMetadata RequestIDPropagator 在元数据中使用的键名
+const RequestIDMetadata = "x-request-id"
+
+// RequestIDPropagator 传递 WithRequestID 设置的请求ID
+var RequestIDPropagator Propagator = requestIDPropagator{}
+
+type requestIDKey struct{}
+
+type requestIDPropagator struct{}
+
+func (requestIDPropagator) Inject(ctx context.Context, md map[string]string) {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		md[RequestIDMetadata] = id
+	}
+}
+
+func (requestIDPropagator) Extract(ctx context.Context, md map[string]string) context.Context {
+	if id := md[RequestIDMetadata]; id != "" {
+		return WithRequestID(ctx, id)
+	}
+	return ctx
+}
+
+// WithRequestID 返回携带请求ID的 ctx，启用 RequestIDPropagator 时请求ID随调用传递给服务端
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 返回 ctx 中的请求ID，没有设置时 ok 为 false
+func RequestIDFromContext(ctx context.Context) (id string, ok bool) {
+	id, ok = ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,89 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantIDKey struct{}
+
+// tenantPropagator 通过 "x-tenant" 传递租户ID
+type tenantPropagator struct{}
+
+func (tenantPropagator) Inject(ctx context.Context, md map[string]string) {
+	if t, ok := ctx.Value(tenantIDKey{}).(string); ok {
+		md["x-tenant"] = t
+	}
+}
+
+func (tenantPropagator) Extract(ctx context.Context, md map[string]string) context.Context {
+	if t := md["x-tenant"]; t != "" {
+		return context.WithValue(ctx, tenantIDKey{}, t)
+	}
+	return ctx
+}
+
+type Propagated struct{}
+
+// Values 返回服务端 ctx 中的请求ID与租户ID
+func (Propagated) Values(ctx context.Context, args int, reply *[]string) error {
+	id, _ := RequestIDFromContext(ctx)
+	tenant, _ := ctx.Value(tenantIDKey{}).(string)
+	*reply = []string{id, tenant}
+	return nil
+}
+
+func TestPropagator(t *testing.T) {
+	RegisterPropagator(RequestIDPropagator)
+	server := NewServer()
+	assert.Nil(t, server.Register(Propagated{}))
+	logged := make(chan string, 10)
+	server.SetAccessLog(func(ctx context.Context, e AccessEntry) {
+		id, _ := RequestIDFromContext(ctx)
+		logged <- e.ServiceMethod + " " + id
+	})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx := context.WithValue(WithRequestID(context.Background(), "req-1"), tenantIDKey{}, "acme")
+	var reply []string
+	assert.Nil(t, client.Call(ctx, "Propagated.Values", 0, &reply))
+	assert.Equal(t, []string{"req-1", ""}, reply, "only the global propagator is registered")
+	assert.Equal(t, "Propagated.Values req-1", <-logged)
+
+	// 客户端与服务端都需要设置 Propagator
+	client.SetPropagators(tenantPropagator{})
+	assert.Nil(t, client.Call(ctx, "Propagated.Values", 0, &reply))
+	assert.Equal(t, []string{"req-1", ""}, reply)
+	<-logged
+	server.SetPropagators(tenantPropagator{})
+	assert.Nil(t, client.Call(ctx, "Propagated.Values", 0, &reply))
+	assert.Equal(t, []string{"req-1", "acme"}, reply)
+	<-logged
+
+	// 调用选项中设置的元数据优先
+	assert.Nil(t, client.Call(ctx, "Propagated.Values", 0, &reply, WithMetadata(RequestIDMetadata, "explicit")))
+	assert.Equal(t, []string{"explicit", "acme"}, reply)
+	assert.Equal(t, "Propagated.Values explicit", <-logged)
+
+	assert.Nil(t, client.Call(context.Background(), "Propagated.Values", 0, &reply))
+	assert.Equal(t, []string{"", ""}, reply)
+	assert.Equal(t, "Propagated.Values ", <-logged)
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	_, ok := RequestIDFromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = RequestIDFromContext(WithRequestID(context.Background(), ""))
+	assert.False(t, ok)
+	id, ok := RequestIDFromContext(WithRequestID(context.Background(), "abc"))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+}
@@ -0,0 +1,58 @@
+package geerpc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// parseProxyURL 解析 Option.ProxyURL，只支持 http 代理，没有端口时使用80
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: invalid proxy URL: %v", err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("rpc client: unsupported proxy URL %q, expect http://host:port", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	return u, nil
+}
+
+// proxyConnect 通过已连接到代理的 conn 建立到 address 的 CONNECT 隧道
+// 代理拒绝时返回包装了 *HTTPStatusError 的错误，如 407 需要认证、403 禁止访问
+// timeout 大于0时限制 CONNECT 请求与响应的时间
+func proxyConnect(conn net.Conn, proxy *url.URL, address string, timeout time.Duration) error {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u := proxy.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", BasicAuthorization(u.Username(), password))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	// 隧道建立前代理不会发送响应之外的数据，丢弃 bufio.Reader 不会丢失数据
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("rpc client: proxy CONNECT %s: %v", address, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc client: proxy CONNECT %s: %w", address, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	return nil
+}
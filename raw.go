@@ -0,0 +1,147 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// RawHandler 不使用反射的请求处理接口，适用于反射开销占比较高的轻量方法
+// 注册的接收者实现该接口时，服务端不再通过反射调用方法，而是调用 HandleGeeRPC：
+// 接收者上满足条件的方法仍用于确定服务包含哪些方法，并在调试页面中展示
+//
+// HandleGeeRPC 在连接的读取 goroutine 中同步调用，dec 只能在 HandleGeeRPC 返回前调用一次，
+// 用于解码请求参数；send 发送响应，可以在返回后从其他 goroutine 调用，只有第一次调用有效。
+// 耗时的处理应在新的 goroutine 中完成，否则会阻塞同一连接上后续请求的读取。
+// 该路径不经过 worker 池，也不受服务并发限制的约束；HandleGeeRPC 返回时还没有调用 send 的请求，
+// 在超过 HandleTimeout 或客户端的截止时间、或者连接的读取结束后以错误响应结束，之后的 send 不再生效
+type RawHandler interface {
+	HandleGeeRPC(method string, dec func(interface{}) error, send func(interface{}, error))
+}
+
+// errBodyDecoded 重复调用 dec 时返回的错误
+var errBodyDecoded = errors.New("rpc server: request body already decoded")
+
+// errRawNotSent 连接的读取结束时 RawHandler 仍没有发送响应
+var errRawNotSent = errors.New("rpc server: connection closed before the handler sent a response")
+
+// handleRaw 通过 RawHandler 处理请求，timeout 为连接的 HandleTimeout
+func (server *Server) handleRaw(sc *serverConn, req *request, timeout time.Duration) {
+	sc.wg.Add(1)
+	atomic.AddInt32(&sc.pending, 1)
+	atomic.AddInt64(&server.stats.inFlight, 1)
+	start := time.Now()
+	req.mtype.stats.begin(start)
+
+	method, serviceMethod := req.mtype.method.Name, req.h.ServiceMethod
+	ctx := server.requestContext(sc.ctx, sc, req)
+	limit := timeout // 超时响应中报告的时间限制
+	if !req.deadline.IsZero() {
+		if remaining := time.Until(req.deadline); timeout == 0 || remaining < timeout {
+			timeout, limit = remaining, time.Duration(req.h.Timeout)
+		}
+	}
+	var sent int32
+	done := make(chan struct{})
+	// respond 发送响应并释放请求，只有第一次调用有效
+	respond := func(reply interface{}, err error, timedOut bool) {
+		if !atomic.CompareAndSwapInt32(&sent, 0, 1) {
+			return
+		}
+		close(done)
+		d := time.Since(start)
+		if timedOut {
+			req.mtype.stats.timeout()
+			atomic.AddUint64(&server.stats.timeouts, 1)
+		} else {
+			req.mtype.stats.record(d, err != nil)
+		}
+		server.reportSlow(sc, serviceMethod, d, timedOut)
+		if err != nil {
+			setError(req.h, err)
+			server.sendResponse(sc, req.h, nil)
+		} else {
+			server.sendResponse(sc, req.h, reply)
+		}
+		server.finishRequest(ctx, sc, serviceMethod, d, err)
+		server.freeRequest(req)
+		atomic.AddInt64(&server.stats.inFlight, -1)
+		atomic.AddInt32(&sc.pending, -1)
+		sc.wg.Done()
+	}
+	send := func(reply interface{}, err error) { respond(reply, err, false) }
+	var decoded bool
+	dec := func(argv interface{}) error {
+		if decoded {
+			return errBodyDecoded
+		}
+		decoded = true
+		if err := sc.codec.ReadBody(argv); err != nil {
+			return err
+		}
+		if v, ok := argv.(Validator); ok {
+			return validate(v)
+		}
+		return nil
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				send(nil, fmt.Errorf("rpc server: %s panic: %v", serviceMethod, r))
+			}
+		}()
+		req.svc.raw.HandleGeeRPC(method, dec, send)
+	}()
+	if !decoded {
+		// 丢弃未读取的body，保证后续请求可以正常读取
+		decoded = true
+		_ = sc.codec.ReadBody(nil)
+	}
+	if atomic.LoadInt32(&sent) != 0 {
+		return
+	}
+	// 响应由其他 goroutine 稍后发送，超时或连接的读取结束时不再等待
+	go func() {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			t := time.NewTimer(timeout - time.Since(start))
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case <-done:
+		case <-expired:
+			respond(nil, fmt.Errorf("%w: except within %s", ErrHandleTimeout, limit), true)
+		case <-sc.readDone:
+			respond(nil, errRawNotSent, false)
+		}
+	}()
+}
+
+// unknownBox 保存在 atomic.Value 中的 RawHandler，h 可以为 nil
+type unknownBox struct {
+	h RawHandler
+}
+
+// SetUnknownHandler 设置处理没有注册的服务或方法的请求的 RawHandler，HandleGeeRPC 的 method 为完整的
+// "Service.Method"，其他与注册了 RawHandler 的服务相同；用于转发请求或测试替身（见 geerpctest），h 为 nil 时取消
+func (server *Server) SetUnknownHandler(h RawHandler) {
+	server.unknown.Store(unknownBox{h: h})
+}
+
+// unknownService 设置了 SetUnknownHandler 时返回交给它处理的服务与方法
+// serviceMethod 由客户端决定，每次都构造新的 methodType，不为它们保存任何状态
+func (server *Server) unknownService(serviceMethod string) (*service, *methodType, bool) {
+	box, _ := server.unknown.Load().(unknownBox)
+	if box.h == nil {
+		return nil, nil, false
+	}
+	serviceName, _, _, err := parseServiceMethod(serviceMethod)
+	if err != nil {
+		return nil, nil, false
+	}
+	return &service{name: serviceName, raw: box.h}, &methodType{method: reflect.Method{Name: serviceMethod}}, true
+}
@@ -0,0 +1,155 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RawFoo 通过 RawHandler 处理请求，方法声明仅用于描述服务包含的方法
+type RawFoo int
+
+func (f RawFoo) Sum(args Args, reply *int) error { return nil }
+
+func (f RawFoo) Fail(args Args, reply *int) error { return nil }
+
+func (f RawFoo) Panic(args Args, reply *int) error { return nil }
+
+func (f RawFoo) Later(args Args, reply *int) error { return nil }
+
+func (f RawFoo) HandleGeeRPC(method string, dec func(interface{}) error, send func(interface{}, error)) {
+	var args Args
+	if err := dec(&args); err != nil {
+		send(nil, err)
+		return
+	}
+	switch method {
+	case "Sum":
+		send(args.Num1+args.Num2, nil)
+	case "Fail":
+		send(nil, errors.New("raw: failed"))
+	case "Panic":
+		panic("raw: boom")
+	case "Later":
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			send(args.Num1*args.Num2, nil)
+		}()
+	}
+}
+
+func TestServer_RawHandler(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(RawFoo)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	assert.Nil(t, client.Call(ctx, "RawFoo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 3, reply)
+	assert.EqualError(t, client.Call(ctx, "RawFoo.Fail", Args{}, &reply), "raw: failed")
+	err = client.Call(ctx, "RawFoo.Panic", Args{}, &reply)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "raw: boom"), err)
+	assert.NotNil(t, client.Call(ctx, "RawFoo.Missing", Args{}, &reply))
+	assert.Nil(t, client.Call(ctx, "RawFoo.Later", Args{Num1: 3, Num2: 4}, &reply))
+	assert.Equal(t, 12, reply)
+
+	for _, s := range server.Snapshot().Services {
+		assert.Equal(t, "RawFoo", s.Name)
+		assert.Equal(t, 4, len(s.Methods))
+		for _, m := range s.Methods {
+			if m.Name == "Fail" {
+				assert.Equal(t, uint64(1), m.Calls)
+				assert.Equal(t, uint64(1), m.Errors)
+			}
+		}
+	}
+}
+
+// RawSilent 解码参数后从不发送响应
+type RawSilent int
+
+func (r RawSilent) Drop(args Args, reply *int) error { return nil }
+
+func (r RawSilent) HandleGeeRPC(method string, dec func(interface{}) error, send func(interface{}, error)) {
+	var args Args
+	_ = dec(&args)
+}
+
+func TestServer_RawHandlerWithoutSend(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(RawSilent)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	var reply int
+	err = client.Call(context.Background(), "RawSilent.Drop", Args{}, &reply)
+	assert.True(t, errors.Is(err, ErrHandleTimeout), err)
+	assert.Equal(t, uint64(1), server.Stats().TimeoutsServed)
+	_ = client.Close()
+
+	// 没有超时限制时，连接断开后请求被释放，Shutdown 不会一直等待
+	client, err = Dial("tcp", addr)
+	assert.Nil(t, err)
+	call := client.Go("RawSilent.Drop", Args{}, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+	_ = client.Close()
+	<-call.Done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, server.Shutdown(ctx))
+}
+
+// unknownEcho 以方法名与参数之和应答所有没有注册的方法
+type unknownEcho struct{}
+
+func (unknownEcho) HandleGeeRPC(method string, dec func(interface{}) error, send func(interface{}, error)) {
+	var args Args
+	if err := dec(&args); err != nil {
+		send(nil, err)
+		return
+	}
+	send(fmt.Sprintf("%s=%d", method, args.Num1+args.Num2), nil)
+}
+
+func TestServer_UnknownHandler(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	server.SetUnknownHandler(unknownEcho{})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	// 注册的方法不受影响
+	var sum int
+	assert.Nil(t, client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum))
+	assert.Equal(t, 3, sum)
+	var reply string
+	assert.Nil(t, client.Call(ctx, "Foo.Missing", &Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, "Foo.Missing=3", reply)
+	assert.Nil(t, client.Call(ctx, "Bar.Sum", &Args{Num1: 2, Num2: 2}, &reply))
+	assert.Equal(t, "Bar.Sum=4", reply)
+	assert.NotNil(t, client.Call(ctx, "malformed", &Args{}, &reply))
+
+	server.SetUnknownHandler(nil)
+	err = client.Call(ctx, "Bar.Sum", &Args{}, &reply)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "can't find service"), err)
+}
@@ -0,0 +1,122 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/yqchilde/gee-rpc/dynamic"
+)
+
+// ReflectionService EnableReflection 注册的反射服务的名称
+const ReflectionService = "Reflection"
+
+// ErrReflectionUnavailable 服务端没有开启反射服务，可以用 errors.Is 判断
+var ErrReflectionUnavailable = errors.New("rpc client: server does not expose the reflection service")
+
+// ServiceDescription 反射服务返回的服务描述
+type ServiceDescription struct {
+	Name    string              `json:"name"`
+	Version string              `json:"version,omitempty"`
+	Methods []MethodDescription `json:"methods"` // 按方法名排序
+}
+
+// MethodDescription 反射服务返回的方法描述
+// 流式方法的 Stream 为 "server"、"client" 或 "bidi"，流中传输的类型无法从签名得知，对应的 Arg 或 Reply 为 nil
+type MethodDescription struct {
+	Name   string        `json:"name"`
+	Arg    *dynamic.Type `json:"arg,omitempty"`
+	Reply  *dynamic.Type `json:"reply,omitempty"`
+	Stream string        `json:"stream,omitempty"`
+}
+
+// reflectionService 反射服务，描述服务器上注册的服务与方法
+type reflectionService struct {
+	server *Server
+}
+
+// EnableReflection 注册名为 ReflectionService 的反射服务，客户端可以通过它列出服务并获取方法的参数与应答类型，
+// 命令行工具与动态调用依赖该服务。反射服务会暴露所有服务的方法与类型结构，需要时通过授权限制访问
+func (server *Server) EnableReflection() error {
+	return server.RegisterName(ReflectionService, &reflectionService{server: server})
+}
+
+// Services 返回名为 name 的服务的所有版本，name 为空时返回所有服务，按服务名与版本排序
+func (r *reflectionService) Services(name string, reply *[]ServiceDescription) error {
+	var services []ServiceDescription
+	r.server.serviceMap.Range(func(_, val interface{}) bool {
+		svc := val.(*service)
+		if name != "" && svc.name != name {
+			return true
+		}
+		sd := ServiceDescription{Name: svc.name, Version: svc.version, Methods: make([]MethodDescription, 0, len(svc.method))}
+		for methodName, mtype := range svc.method {
+			sd.Methods = append(sd.Methods, describeMethod(methodName, mtype))
+		}
+		sort.Slice(sd.Methods, func(i, j int) bool { return sd.Methods[i].Name < sd.Methods[j].Name })
+		services = append(services, sd)
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool {
+		a, b := services[i], services[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Version < b.Version
+	})
+	*reply = services
+	return nil
+}
+
+// Describe 返回 serviceMethod（如 "Foo.Sum"、"Foo.Sum@v2"）的描述，版本的解析与调用时相同
+func (r *reflectionService) Describe(serviceMethod string, reply *MethodDescription) error {
+	_, mtype, err := r.server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	*reply = describeMethod(mtype.method.Name, mtype)
+	return nil
+}
+
+// describeMethod 返回方法的描述
+func describeMethod(name string, mtype *methodType) MethodDescription {
+	md := MethodDescription{Name: name}
+	switch mtype.stream {
+	case streamServer:
+		md.Stream = "server"
+		md.Arg = dynamic.TypeOf(mtype.ArgType)
+	case streamClient:
+		md.Stream = "client"
+		md.Reply = dynamic.TypeOf(mtype.ReplyType.Elem())
+	case streamBidi:
+		md.Stream = "bidi"
+	default:
+		md.Arg = dynamic.TypeOf(mtype.ArgType)
+		md.Reply = dynamic.TypeOf(mtype.ReplyType.Elem())
+	}
+	return md
+}
+
+// Services 通过服务端的反射服务列出所有服务，按服务名与版本排序，服务端没有开启反射服务时返回 ErrReflectionUnavailable
+func (client *Client) Services(ctx context.Context, opts ...CallOption) ([]ServiceDescription, error) {
+	var services []ServiceDescription
+	if err := client.Call(ctx, ReflectionService+".Services", "", &services, opts...); err != nil {
+		return nil, reflectionError(err)
+	}
+	return services, nil
+}
+
+// Describe 通过服务端的反射服务获取 serviceMethod（如 "Foo.Sum"）的描述，服务端没有开启反射服务时返回 ErrReflectionUnavailable
+func (client *Client) Describe(ctx context.Context, serviceMethod string, opts ...CallOption) (MethodDescription, error) {
+	var md MethodDescription
+	if err := client.Call(ctx, ReflectionService+".Describe", serviceMethod, &md, opts...); err != nil {
+		return MethodDescription{}, reflectionError(err)
+	}
+	return md, nil
+}
+
+// reflectionError 将找不到反射服务的错误转换为 ErrReflectionUnavailable
+func reflectionError(err error) error {
+	var se *ServerError
+	if errors.As(err, &se) && se.Message == "rpc server: can't find service "+ReflectionService {
+		return ErrReflectionUnavailable
+	}
+	return err
+}
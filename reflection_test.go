@@ -0,0 +1,92 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_EnableReflection(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.RegisterVersion("Foo", "v2", new(Foo)))
+	assert.Nil(t, server.Register(new(Upload)))
+	assert.Nil(t, server.EnableReflection())
+	assert.NotNil(t, server.EnableReflection(), "already registered")
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var services []ServiceDescription
+	assert.Nil(t, client.Call(ctx, "Reflection.Services", "", &services))
+	var names []string
+	for _, s := range services {
+		names = append(names, serviceKey(s.Name, s.Version))
+	}
+	assert.Equal(t, []string{"Foo", "Foo@v2", "Reflection", "Upload"}, names)
+
+	assert.Nil(t, client.Call(ctx, "Reflection.Services", "Upload", &services))
+	assert.Equal(t, 1, len(services))
+	methods := services[0].Methods
+	assert.Equal(t, "Drain", methods[0].Name)
+	assert.Equal(t, "client", methods[0].Stream)
+	assert.Nil(t, methods[0].Arg)
+	assert.Equal(t, "int", methods[0].Reply.String())
+
+	var method MethodDescription
+	assert.Nil(t, client.Call(ctx, "Reflection.Describe", "Foo.Sum@v2", &method))
+	assert.Equal(t, "Sum", method.Name)
+	assert.Equal(t, "geerpc.Args", method.Arg.Name)
+	assert.Equal(t, 2, len(method.Arg.Fields))
+	assert.Equal(t, "Num1", method.Arg.Fields[0].Name)
+	assert.Equal(t, "int", method.Arg.Fields[0].Type.Kind)
+	assert.Equal(t, "int", method.Reply.String())
+
+	err = client.Call(ctx, "Reflection.Describe", "Foo.Missing", &method)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "can't find method Missing"), err)
+}
+
+func TestClient_ServicesAndDescribe(t *testing.T) {
+	ctx := context.Background()
+	for _, enabled := range []bool{true, false} {
+		server := NewServer()
+		assert.Nil(t, server.Register(new(Foo)))
+		if enabled {
+			assert.Nil(t, server.EnableReflection())
+		}
+		go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+		addr := waitForAddr(t, server)
+		client, err := Dial("tcp", addr)
+		assert.Nil(t, err)
+
+		services, err := client.Services(ctx)
+		method, derr := client.Describe(ctx, "Foo.Sum")
+		if enabled {
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(services))
+			assert.Equal(t, "Foo", services[0].Name)
+			assert.Equal(t, "Sum", services[0].Methods[0].Name)
+			assert.Nil(t, derr)
+			assert.Equal(t, "geerpc.Args", method.Arg.String())
+			assert.Equal(t, "int", method.Reply.String())
+
+			_, derr = client.Describe(ctx, "Foo.Missing")
+			assert.False(t, errors.Is(derr, ErrReflectionUnavailable))
+			assert.True(t, derr != nil && strings.Contains(derr.Error(), "can't find method Missing"), derr)
+		} else {
+			assert.Equal(t, ErrReflectionUnavailable, err)
+			assert.Nil(t, services)
+			assert.Equal(t, ErrReflectionUnavailable, derr)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
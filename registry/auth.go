@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrUnauthorized 注册中心以 401 拒绝了请求
+var ErrUnauthorized = errors.New("rpc registry: unauthorized")
+
+// AuthOptions 注册中心的令牌认证，客户端在请求头中携带 Authorization: Bearer <token>。
+// 轮换令牌时先在注册中心同时配置新旧令牌，所有客户端换成新令牌后再移除旧令牌
+type AuthOptions struct {
+	WriteTokens []string // 心跳等写操作接受的令牌，为空表示不校验写操作
+	ReadTokens  []string // 获取服务器列表接受的令牌，为空表示不校验读操作；写令牌也可以读取
+}
+
+// AuthStats 认证的统计
+type AuthStats struct {
+	UnauthorizedWrites uint64 // 被拒绝的写操作数
+	UnauthorizedReads  uint64 // 被拒绝的读操作数
+}
+
+// SetAuth 设置令牌认证，可以在运行中调用以轮换令牌
+func (r *Registry) SetAuth(opts AuthOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth = opts
+}
+
+// AuthStats 返回认证的统计
+func (r *Registry) AuthStats() AuthStats {
+	return AuthStats{
+		UnauthorizedWrites: atomic.LoadUint64(&r.unauthorizedWrites),
+		UnauthorizedReads:  atomic.LoadUint64(&r.unauthorizedReads),
+	}
+}
+
+// authorize 校验 req 的令牌，拒绝时写入 401 响应并返回 false
+func (r *Registry) authorize(w http.ResponseWriter, req *http.Request, write bool) bool {
+	r.mu.Lock()
+	auth := r.auth
+	r.mu.Unlock()
+	tokens := auth.WriteTokens
+	if !write {
+		if len(auth.ReadTokens) == 0 {
+			return true
+		}
+		tokens = append(tokens[:len(tokens):len(tokens)], auth.ReadTokens...)
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	for _, valid := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+			return true
+		}
+	}
+	if write {
+		atomic.AddUint64(&r.unauthorizedWrites, 1)
+	} else {
+		atomic.AddUint64(&r.unauthorizedReads, 1)
+	}
+	log.Printf("rpc registry: unauthorized %s from %s", req.Method, req.RemoteAddr)
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+	return false
+}
+
+// setToken 设置请求的令牌，token 为空时不设置
+func setToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
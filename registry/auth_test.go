@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, url, token string) int {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	setToken(req, token)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRegistry_Auth(t *testing.T) {
+	r := New(time.Minute)
+	r.SetAuth(AuthOptions{WriteTokens: []string{"w1"}, ReadTokens: []string{"r1"}})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// 写操作
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{Interval: time.Minute, Token: "w1"}))
+	assert.Equal(t, ErrUnauthorized, HeartbeatWithOptions(ts.URL, "tcp@b", HeartbeatOptions{Interval: time.Minute, Token: "r1"}))
+	assert.Equal(t, ErrUnauthorized, Heartbeat(ts.URL, "tcp@c", nil, time.Minute, nil))
+	assert.Equal(t, []string{"tcp@a"}, addrs(r.aliveServers()))
+
+	// 读操作，写令牌也可以读取
+	assert.Equal(t, http.StatusOK, get(t, ts.URL, "r1"))
+	assert.Equal(t, http.StatusOK, get(t, ts.URL, "w1"))
+	assert.Equal(t, http.StatusUnauthorized, get(t, ts.URL, ""))
+	assert.Equal(t, http.StatusUnauthorized, get(t, ts.URL, "wrong"))
+	assert.Equal(t, AuthStats{UnauthorizedWrites: 2, UnauthorizedReads: 2}, r.AuthStats())
+
+	// 只校验写操作
+	r.SetAuth(AuthOptions{WriteTokens: []string{"w1"}})
+	assert.Equal(t, http.StatusOK, get(t, ts.URL, ""))
+}
+
+func TestRegistry_AuthRotation(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	r.SetAuth(AuthOptions{WriteTokens: []string{"old"}})
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{Interval: time.Minute, Token: "old"}))
+	// 轮换期间新旧令牌都有效
+	r.SetAuth(AuthOptions{WriteTokens: []string{"old", "new"}})
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{Interval: time.Minute, Token: "old"}))
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@b", HeartbeatOptions{Interval: time.Minute, Token: "new"}))
+	// 移除旧令牌
+	r.SetAuth(AuthOptions{WriteTokens: []string{"new"}})
+	assert.Equal(t, ErrUnauthorized, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{Interval: time.Minute, Token: "old"}))
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@b", HeartbeatOptions{Interval: time.Minute, Token: "new"}))
+}
+
+func TestHeartbeat_BacksOffWhenUnauthorized(t *testing.T) {
+	r := New(time.Minute)
+	r.SetAuth(AuthOptions{WriteTokens: []string{"secret"}})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	errs := make(chan error, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	err := HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{
+		Interval: 10 * time.Millisecond,
+		Token:    "wrong",
+		Stop:     stop,
+		OnError:  func(err error) { errs <- err },
+	})
+	assert.Equal(t, ErrUnauthorized, err)
+	assert.Equal(t, ErrUnauthorized, <-errs)
+	backingOff := func() {
+		select {
+		case err := <-errs:
+			assert.True(t, errors.Is(err, ErrUnauthorized))
+			assert.True(t, strings.Contains(err.Error(), "backing off"), err.Error())
+		case <-time.After(time.Second):
+			t.Fatal("expect the heartbeat to back off")
+		}
+	}
+	backingOff()
+
+	// 退避期间继续发送心跳，但间隔变长，也不再回调
+	time.Sleep(300 * time.Millisecond)
+	assert.Len(t, errs, 0)
+	n := r.AuthStats().UnauthorizedWrites
+	assert.True(t, n > 3 && n < 15, n)
+
+	// 注册中心接受令牌后恢复
+	r.SetAuth(AuthOptions{WriteTokens: []string{"secret", "wrong"}})
+	assert.Eventually(t, func() bool { return len(r.aliveServers()) == 1 }, time.Second, 5*time.Millisecond)
+
+	// 再次持续被拒绝时重新回调
+	r.SetAuth(AuthOptions{WriteTokens: []string{"secret"}})
+	assert.Equal(t, ErrUnauthorized, <-errs)
+	assert.Equal(t, ErrUnauthorized, <-errs)
+	backingOff()
+}
+
+func TestRegistry_AuthPeers(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	r1.SetAuth(AuthOptions{WriteTokens: []string{"peer"}})
+	r2.SetAuth(AuthOptions{WriteTokens: []string{"peer"}, ReadTokens: []string{"reader"}})
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r1.SetPeers(PeerOptions{Peers: []string{ts2.URL}, SyncInterval: time.Hour, Token: "peer"})
+	defer func() { _ = r1.Close() }()
+
+	assert.Nil(t, HeartbeatWithOptions(ts1.URL, "tcp@a", HeartbeatOptions{Interval: time.Minute, Token: "peer"}))
+	assert.Eventually(t, func() bool { return len(r2.aliveServers()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Zero(t, r2.AuthStats().UnauthorizedWrites)
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// PeerHeader 注册中心之间转发心跳与注销时使用的请求头，值为原始心跳或注销的时间（RFC 3339），收到的请求不再转发
+	PeerHeader = "X-Geerpc-Peer-Heartbeat"
+
+	defaultSyncInterval  = 30 * time.Second
+	defaultPeerRetries   = 3
+	defaultRetryInterval = 100 * time.Millisecond
+	// peerQueueSize 每个对端等待转发的心跳数，队列满时丢弃，由定期同步补齐
+	peerQueueSize = 1024
+)
+
+// PeerOptions 注册中心之间复制的配置
+type PeerOptions struct {
+	Peers         []string      // 其他注册中心的 URL
+	SyncInterval  time.Duration // 拉取对端完整列表的间隔，默认30秒
+	Retries       int           // 转发心跳失败后的重试次数，默认3，小于0表示不重试
+	RetryInterval time.Duration // 第一次重试前等待的时间，之后每次翻倍，默认100毫秒
+	Token         string        // 对端的写令牌，同时用于转发心跳与拉取列表，见 SetAuth
+}
+
+// peerEvent 一次需要转发的心跳或注销
+type peerEvent struct {
+	addr    string
+	start   time.Time // 心跳或注销的时间
+	meta    Metadata
+	deleted bool // 是否为注销
+}
+
+// peering 向其他注册中心转发心跳，并定期拉取它们的列表，按心跳时间合并
+type peering struct {
+	r      *Registry
+	opts   PeerOptions
+	queues map[string]chan peerEvent
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// SetPeers 与 opts.Peers 中的注册中心互相复制：本地收到的心跳与注销异步转发给每个对端，失败时重试；
+// 每隔 SyncInterval 拉取对端的完整列表，同一地址以心跳时间较新的一方为准。
+// 对端也应当把本注册中心配置为对端，再次调用时替换之前的配置，Close 时停止
+func (r *Registry) SetPeers(opts PeerOptions) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultPeerRetries
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultRetryInterval
+	}
+	var p *peering
+	if len(opts.Peers) > 0 {
+		p = &peering{r: r, opts: opts, queues: make(map[string]chan peerEvent), stop: make(chan struct{})}
+		for _, peer := range opts.Peers {
+			q := make(chan peerEvent, peerQueueSize)
+			p.queues[peer] = q
+			p.wg.Add(1)
+			go p.sendLoop(peer, q)
+		}
+		p.wg.Add(1)
+		go p.syncLoop()
+	}
+	r.mu.Lock()
+	old := r.peers
+	r.peers = p
+	r.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// forward 将 ev 放入每个对端的转发队列，不会阻塞，需要持有 r.mu
+func (p *peering) forward(ev peerEvent) {
+	for peer, q := range p.queues {
+		select {
+		case q <- ev:
+		default:
+			log.Printf("rpc registry: peer %s queue is full, dropping event of %s", peer, ev.addr)
+		}
+	}
+}
+
+// sendLoop 依次向 peer 转发队列中的心跳
+func (p *peering) sendLoop(peer string, q <-chan peerEvent) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case ev := <-q:
+			p.send(peer, ev)
+		}
+	}
+}
+
+// send 转发一次心跳或注销，失败时按指数退避重试，全部失败后只记录日志
+func (p *peering) send(peer string, ev peerEvent) {
+	var body []byte
+	if !ev.deleted {
+		var err error
+		if body, err = json.Marshal(ev.meta); err != nil {
+			return
+		}
+	}
+	wait := p.opts.RetryInterval
+	for attempt := 0; ; attempt++ {
+		err := sendPeerEvent(peer, ev, body, p.opts.Token)
+		if err == nil {
+			return
+		}
+		if attempt >= p.opts.Retries {
+			log.Printf("rpc registry: forward event of %s to %s err: %v", ev.addr, peer, err)
+			return
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func sendPeerEvent(peer string, ev peerEvent, body []byte, token string) error {
+	method := http.MethodPost
+	if ev.deleted {
+		method = http.MethodDelete
+	}
+	req, err := http.NewRequest(method, peer, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ServerHeader, ev.addr)
+	setToken(req, token)
+	req.Header.Set(PeerHeader, ev.start.Format(time.RFC3339Nano))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// syncLoop 立即拉取一次对端的列表，之后每隔 SyncInterval 拉取一次
+func (p *peering) syncLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range p.opts.Peers {
+			if err := p.pull(peer); err != nil {
+				log.Printf("rpc registry: sync from %s err: %v", peer, err)
+			}
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pull 拉取 peer 的完整列表并合并
+func (p *peering) pull(peer string) error {
+	req, err := http.NewRequest(http.MethodGet, peer, nil)
+	if err != nil {
+		return err
+	}
+	setToken(req, p.opts.Token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	var servers []Server
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return err
+	}
+	for _, s := range servers {
+		p.r.mergeServer(s.Addr, s.Metadata, s.Heartbeat)
+	}
+	return nil
+}
+
+// close 停止转发与同步，等待中的心跳被丢弃
+func (p *peering) close() {
+	close(p.stop)
+	p.wg.Wait()
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_PeerForward(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r1.SetPeers(PeerOptions{Peers: []string{ts2.URL}, SyncInterval: time.Hour})
+	r2.SetPeers(PeerOptions{Peers: []string{ts1.URL}, SyncInterval: time.Hour})
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+
+	assert.Nil(t, Heartbeat(ts1.URL, "tcp@a", &Metadata{Zone: "z1"}, time.Minute, nil))
+	assert.Eventually(t, func() bool { return len(r2.aliveServers()) == 1 }, time.Second, 5*time.Millisecond)
+	got, want := r2.aliveServers()[0], r1.aliveServers()[0]
+	assert.True(t, want.Heartbeat.Equal(got.Heartbeat), "forwarded with the original heartbeat time")
+	assert.Equal(t, Metadata{Weight: 1, Zone: "z1"}, got.Metadata)
+}
+
+func TestRegistry_PeerRetry(t *testing.T) {
+	var rejected int32
+	r2 := New(time.Minute)
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost && atomic.AddInt32(&rejected, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r2.ServeHTTP(w, req)
+	}))
+	defer ts2.Close()
+	r1 := New(time.Minute)
+	r1.SetPeers(PeerOptions{Peers: []string{ts2.URL}, SyncInterval: time.Hour, RetryInterval: 5 * time.Millisecond})
+	defer func() { _ = r1.Close() }()
+
+	r1.putServer("tcp@a", Metadata{})
+	assert.Eventually(t, func() bool { return len(r2.aliveServers()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&rejected))
+}
+
+func TestRegistry_PeerAntiEntropy(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+
+	// 复制开始之前的状态，同一地址以较新的心跳为准
+	now := time.Now()
+	assert.True(t, r1.mergeServer("tcp@a", Metadata{Weight: 2}, now.Add(-time.Second)))
+	assert.True(t, r2.mergeServer("tcp@a", Metadata{Weight: 5}, now))
+	assert.True(t, r2.mergeServer("tcp@b", Metadata{}, now))
+	assert.False(t, r2.mergeServer("tcp@c", Metadata{}, now.Add(-2*time.Minute)), "expired")
+
+	r1.SetPeers(PeerOptions{Peers: []string{ts2.URL}, SyncInterval: 20 * time.Millisecond})
+	r2.SetPeers(PeerOptions{Peers: []string{ts1.URL}, SyncInterval: 20 * time.Millisecond})
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+	assert.Eventually(t, func() bool { return len(r1.aliveServers()) == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	servers1, servers2 := r1.aliveServers(), r2.aliveServers()
+	for i := range servers1 {
+		assert.True(t, servers1[i].Heartbeat.Equal(servers2[i].Heartbeat))
+	}
+	assert.Equal(t, withoutHeartbeat(servers2), withoutHeartbeat(servers1))
+	assert.Equal(t, 5, servers1[0].Weight)
+}
+
+func TestRegistry_PeerDeregister(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r1.SetPeers(PeerOptions{Peers: []string{ts2.URL}, SyncInterval: 20 * time.Millisecond})
+	r2.SetPeers(PeerOptions{Peers: []string{ts1.URL}, SyncInterval: 20 * time.Millisecond})
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+
+	assert.Nil(t, Heartbeat(ts1.URL, "tcp@a", nil, time.Minute, nil))
+	assert.Eventually(t, func() bool { return len(r2.aliveServers()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Nil(t, Deregister(ts1.URL, "tcp@a"))
+	assert.Eventually(t, func() bool { return len(r2.aliveServers()) == 0 }, time.Second, 5*time.Millisecond)
+
+	// 同步不会恢复已经注销的服务器
+	time.Sleep(60 * time.Millisecond)
+	assert.Empty(t, r1.aliveServers())
+	assert.Empty(t, r2.aliveServers())
+}
@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultPersistDebounce 快照模式下两次写入之间的默认间隔
+const defaultPersistDebounce = time.Second
+
+// PersistOptions 注册中心持久化的配置
+type PersistOptions struct {
+	Path string // 保存状态的文件
+	// Debounce 快照模式下，状态变化后等待该时间再写入，期间的变化合并为一次写入，默认1秒
+	Debounce time.Duration
+	// AppendOnly 为 true 时每次变化立即追加到文件（仅追加日志），不丢失变化但写入更多；
+	// 默认以快照的方式覆盖写入整个文件
+	AppendOnly bool
+}
+
+// PersistStats 持久化的统计
+type PersistStats struct {
+	LastSnapshot time.Time // 最近一次成功写入文件的时间
+	Restored     int       // 启动时从文件恢复的服务器数
+	LastError    error     // 最近一次读写文件的错误，成功写入后清除
+}
+
+// record 文件中的一个服务器，仅追加日志中 Op 为 "put" 或 "del"
+type record struct {
+	Op    string    `json:"op,omitempty"`
+	Addr  string    `json:"addr"`
+	Start time.Time `json:"start"`
+	Meta  Metadata  `json:"meta"`
+}
+
+// snapshot 快照文件的内容
+type snapshot struct {
+	Servers []record `json:"servers"`
+}
+
+// persister 将注册中心的状态保存到文件
+type persister struct {
+	r    *Registry
+	opts PersistOptions
+
+	writeMu sync.Mutex // 保证快照按顺序写入，先于 r.mu 获取
+
+	mu     sync.Mutex // protect following
+	timer  *time.Timer
+	file   *os.File // 仅追加日志
+	stats  PersistStats
+	closed bool
+}
+
+// Persist 从 opts.Path 恢复没有过期的服务器，之后将状态的变化保存到该文件，应当在开始处理请求之前调用。
+// 文件不存在时从空的状态开始；文件损坏时记录日志并从空的状态开始（仅追加日志保留损坏之前的记录）。
+// 关闭注册中心时调用 Close 写入还没有保存的变化
+func (r *Registry) Persist(opts PersistOptions) {
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultPersistDebounce
+	}
+	p := &persister{r: r, opts: opts}
+	records, err := p.load()
+	now := time.Now()
+	r.mu.Lock()
+	for _, rec := range records {
+		if _, ok := r.servers[rec.Addr]; ok || (r.timeout != 0 && !rec.Start.Add(r.timeout).After(now)) {
+			continue
+		}
+		r.servers[rec.Addr] = &entry{start: rec.Start, meta: rec.Meta}
+		r.armExpiryLocked(rec.Start)
+		p.stats.Restored++
+	}
+	if p.stats.Restored > 0 {
+		r.bumpLocked()
+	}
+	r.persist = p
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("rpc registry: restore from %s: %v (restored %d servers)", opts.Path, err, p.stats.Restored)
+		p.mu.Lock()
+		p.stats.LastError = err
+		p.mu.Unlock()
+	}
+	if opts.AppendOnly {
+		p.compact()
+	}
+}
+
+// PersistStats 返回持久化的统计，没有调用 Persist 时为零值
+func (r *Registry) PersistStats() PersistStats {
+	r.mu.Lock()
+	p := r.persist
+	r.mu.Unlock()
+	if p == nil {
+		return PersistStats{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// load 读取文件中的记录，返回的错误不为 nil 时记录可能不完整
+func (p *persister) load() ([]record, error) {
+	data, err := ioutil.ReadFile(p.opts.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.opts.AppendOnly {
+		var s snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		return s.Servers, nil
+	}
+	// 依次重放日志，崩溃时最后一行可能不完整
+	servers := make(map[string]record)
+	var order []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxMetadataSize+4<<10)
+	for line := 1; scanner.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return replayed(servers, order), fmt.Errorf("line %d: %v", line, err)
+		}
+		switch rec.Op {
+		case "put":
+			if _, ok := servers[rec.Addr]; !ok {
+				order = append(order, rec.Addr)
+			}
+			servers[rec.Addr] = rec
+		case "del":
+			delete(servers, rec.Addr)
+		}
+	}
+	return replayed(servers, order), scanner.Err()
+}
+
+// replayed 按第一次出现的顺序返回重放后的记录
+func replayed(servers map[string]record, order []string) []record {
+	records := make([]record, 0, len(servers))
+	for _, addr := range order {
+		if rec, ok := servers[addr]; ok {
+			records = append(records, rec)
+			delete(servers, addr)
+		}
+	}
+	return records
+}
+
+// records 返回注册中心当前的服务器
+func (p *persister) records(op string) []record {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+	records := make([]record, 0, len(p.r.servers))
+	for addr, e := range p.r.servers {
+		records = append(records, record{Op: op, Addr: addr, Start: e.start, Meta: e.meta})
+	}
+	return records
+}
+
+// changedLocked 记录 addr 的变化，e 为 nil 表示被移除，需要持有 r.mu
+// 仅追加日志立即写入，快照模式在 Debounce 之后写入
+func (p *persister) changedLocked(addr string, e *entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if !p.opts.AppendOnly {
+		if p.timer == nil {
+			p.timer = time.AfterFunc(p.opts.Debounce, p.flush)
+		}
+		return
+	}
+	if p.file == nil {
+		return
+	}
+	rec := record{Op: "del", Addr: addr}
+	if e != nil {
+		rec = record{Op: "put", Addr: addr, Start: e.start, Meta: e.meta}
+	}
+	data, _ := json.Marshal(rec)
+	if _, err := p.file.Write(append(data, '\n')); err != nil {
+		log.Println("rpc registry: persist err:", err)
+		p.stats.LastError = err
+		return
+	}
+	p.stats.LastSnapshot, p.stats.LastError = time.Now(), nil
+}
+
+// flush 写入快照
+func (p *persister) flush() {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.mu.Lock()
+	p.timer = nil
+	p.mu.Unlock()
+	data, _ := json.Marshal(snapshot{Servers: p.records("")})
+	p.written(writeFile(p.opts.Path, data))
+}
+
+// compact 以当前的服务器重写仅追加日志，并打开文件用于之后的追加
+func (p *persister) compact() {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	var buf bytes.Buffer
+	for _, rec := range p.records("put") {
+		data, _ := json.Marshal(rec)
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	err := writeFile(p.opts.Path, buf.Bytes())
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(p.opts.Path, os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			p.mu.Lock()
+			p.file = f
+			p.mu.Unlock()
+		}
+	}
+	p.written(err)
+}
+
+// written 记录一次写入的结果
+func (p *persister) written(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		log.Println("rpc registry: persist err:", err)
+		p.stats.LastError = err
+		return
+	}
+	p.stats.LastSnapshot, p.stats.LastError = time.Now(), nil
+}
+
+// close 停止持久化，快照模式下写入等待中的变化
+func (p *persister) close() error {
+	p.mu.Lock()
+	p.closed = true
+	pending := p.timer != nil && p.timer.Stop()
+	f := p.file
+	p.file = nil
+	p.mu.Unlock()
+	if pending {
+		p.flush()
+	}
+	if f != nil {
+		return f.Close()
+	}
+	return nil
+}
+
+// writeFile 先写入临时文件再重命名，避免崩溃时留下不完整的文件
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
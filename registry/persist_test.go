@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func addrs(servers []Server) []string {
+	var s []string
+	for _, server := range servers {
+		s = append(s, server.Addr)
+	}
+	return s
+}
+
+func TestRegistry_PersistSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(time.Minute)
+	r.Persist(PersistOptions{Path: path, Debounce: 10 * time.Millisecond})
+	ts := httptest.NewServer(r)
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", &Metadata{Weight: 2}, time.Minute, nil))
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@b", nil, time.Minute, nil))
+	assert.Eventually(t, func() bool { return !r.PersistStats().LastSnapshot.IsZero() }, time.Second, 5*time.Millisecond)
+	ts.Close()
+	assert.Nil(t, r.Close())
+
+	// 重启后恢复服务器与元数据
+	restarted := New(time.Minute)
+	restarted.Persist(PersistOptions{Path: path})
+	defer func() { _ = restarted.Close() }()
+	assert.Equal(t, []Server{
+		{Addr: "tcp@a", Metadata: Metadata{Weight: 2}},
+		{Addr: "tcp@b", Metadata: Metadata{Weight: 1}},
+	}, withoutHeartbeat(restarted.aliveServers()))
+	stats := restarted.PersistStats()
+	assert.Equal(t, 2, stats.Restored)
+	assert.Nil(t, stats.LastError)
+}
+
+func TestRegistry_PersistDropsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	now := time.Now()
+	data, _ := json.Marshal(snapshot{Servers: []record{
+		{Addr: "tcp@fresh", Start: now.Add(-30 * time.Second), Meta: Metadata{Weight: 1}},
+		{Addr: "tcp@expired", Start: now.Add(-2 * time.Minute), Meta: Metadata{Weight: 1}},
+	}})
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+
+	r := New(time.Minute)
+	r.Persist(PersistOptions{Path: path})
+	defer func() { _ = r.Close() }()
+	assert.Equal(t, []string{"tcp@fresh"}, addrs(r.aliveServers()))
+	assert.Equal(t, 1, r.PersistStats().Restored)
+}
+
+func TestRegistry_PersistCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"servers":[{"addr":"tcp@a"`), 0644))
+
+	r := New(time.Minute)
+	r.Persist(PersistOptions{Path: path, Debounce: 10 * time.Millisecond})
+	assert.Empty(t, r.aliveServers())
+	assert.NotNil(t, r.PersistStats().LastError)
+
+	// 之后的变化覆盖损坏的文件
+	r.putServer("tcp@b", Metadata{})
+	assert.Nil(t, r.Close())
+	restarted := New(time.Minute)
+	restarted.Persist(PersistOptions{Path: path})
+	assert.Equal(t, []string{"tcp@b"}, addrs(restarted.aliveServers()))
+}
+
+func TestRegistry_PersistAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.log")
+	r := New(100 * time.Millisecond)
+	r.Persist(PersistOptions{Path: path, AppendOnly: true})
+	r.putServer("tcp@a", Metadata{Zone: "z1"})
+	r.putServer("tcp@b", Metadata{})
+	time.Sleep(150 * time.Millisecond)
+	r.putServer("tcp@b", Metadata{})
+	assert.Equal(t, []string{"tcp@b"}, addrs(r.aliveServers()), "tcp@a expired")
+	r.putServer("tcp@c", Metadata{})
+	assert.Nil(t, r.Close())
+
+	// 模拟崩溃时写了一半的记录
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, _ = f.WriteString(`{"op":"put","addr":"tcp@d"`)
+	_ = f.Close()
+
+	restarted := New(time.Minute)
+	restarted.Persist(PersistOptions{Path: path, AppendOnly: true})
+	defer func() { _ = restarted.Close() }()
+	assert.Equal(t, []string{"tcp@b", "tcp@c"}, addrs(restarted.aliveServers()))
+	stats := restarted.PersistStats()
+	assert.Equal(t, 2, stats.Restored)
+	assert.Nil(t, stats.LastError, "compaction rewrites the log")
+
+	// 重写后的日志只包含当前的服务器
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "tcp@a")
+	assert.NotContains(t, string(data), "tcp@d")
+}
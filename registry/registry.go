@@ -0,0 +1,504 @@
+// Package registry 提供一个简单的基于 HTTP 的注册中心
+// 服务器定期 POST 自己的地址作为心跳，请求体中可以携带 JSON 格式的 Metadata，超过 TTL 没有心跳的服务器被移除；
+// 客户端 GET 获取所有可用的服务器，响应头中只有地址，响应体中是包括元数据的 JSON 列表
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPath    = "/_geerpc_/registry"
+	defaultTimeout = time.Minute * 5
+
+	// ServersHeader GET 响应中可用服务器列表使用的请求头，地址之间以逗号分隔
+	ServersHeader = "X-Geerpc-Servers"
+	// ServerHeader POST 心跳与 DELETE 注销时服务器地址使用的请求头
+	ServerHeader = "X-Geerpc-Server"
+
+	// maxMetadataSize 心跳请求体的大小上限
+	maxMetadataSize = 64 << 10
+)
+
+// Metadata 服务器随心跳上报的元数据，没有上报的字段使用默认值
+type Metadata struct {
+	Weight int               `json:"weight,omitempty"` // 加权选择使用的权重，不大于0时为1
+	Zone   string            `json:"zone,omitempty"`   // 服务器所在的区域
+	Tags   map[string]string `json:"tags,omitempty"`   // 其他标签
+	Codec  string            `json:"codec,omitempty"`  // 服务器支持的编解码方式，如 "application/gob"
+	Load   float64           `json:"load,omitempty"`   // 服务器上报的负载，如正在处理的请求数，见 HeartbeatOptions.Load
+}
+
+// Server GET 响应体中的一个可用的服务器
+type Server struct {
+	Addr string `json:"addr"`
+	Metadata
+	Heartbeat time.Time `json:"heartbeat"` // 最近一次心跳的时间，注册中心之间按该时间合并
+}
+
+// entry 注册中心中的一个服务器
+type entry struct {
+	start time.Time // 最近一次心跳的时间
+	meta  Metadata
+}
+
+// Registry 注册中心，记录服务器最近一次心跳的时间与元数据
+type Registry struct {
+	timeout time.Duration
+
+	unauthorizedWrites uint64 // 被拒绝的写操作数，原子操作
+	unauthorizedReads  uint64 // 被拒绝的读操作数，原子操作
+
+	mu      sync.Mutex // protect following
+	servers map[string]*entry
+	// removed 被注销的服务器及注销的时间，避免从其他注册中心合并更早的心跳，超过 TTL 后清除
+	removed map[string]time.Time
+	persist *persister // 没有调用 Persist 时为 nil
+	peers   *peering   // 没有调用 SetPeers 时为 nil
+	auth    AuthOptions
+	watch   watchState
+}
+
+// New 创建注册中心，timeout 为服务器的 TTL，0表示不过期
+func New(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout: timeout,
+		servers: make(map[string]*entry),
+		removed: make(map[string]time.Time),
+		watch:   watchState{changed: make(chan struct{})},
+	}
+}
+
+var DefaultRegistry = New(defaultTimeout)
+
+// putServer 记录服务器的心跳，每次心跳都以 meta 替换之前的元数据
+func (r *Registry) putServer(addr string, meta Metadata) {
+	if meta.Weight <= 0 {
+		meta.Weight = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := &entry{start: time.Now(), meta: meta}
+	old := r.servers[addr]
+	r.servers[addr] = e
+	delete(r.removed, addr)
+	r.changedLocked(addr, old, e)
+	if r.peers != nil {
+		r.peers.forward(peerEvent{addr: addr, start: e.start, meta: meta})
+	}
+}
+
+// removeServer 注销 addr，at 之后又收到心跳时不注销，返回是否移除了服务器；
+// forward 为 true 时转发给其他注册中心
+func (r *Registry) removeServer(addr string, at time.Time, forward bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if forward && r.peers != nil {
+		r.peers.forward(peerEvent{addr: addr, start: at, deleted: true})
+	}
+	if old, ok := r.removed[addr]; !ok || at.After(old) {
+		r.removed[addr] = at
+	}
+	e, ok := r.servers[addr]
+	if !ok || e.start.After(at) {
+		return false
+	}
+	delete(r.servers, addr)
+	r.changedLocked(addr, e, nil)
+	return true
+}
+
+// mergeServer 合并来自其他注册中心的心跳，start 比已有的心跳新且没有过期时才更新，返回是否更新
+func (r *Registry) mergeServer(addr string, meta Metadata, start time.Time) bool {
+	if meta.Weight <= 0 {
+		meta.Weight = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timeout != 0 && !start.Add(r.timeout).After(time.Now()) {
+		return false
+	}
+	old := r.servers[addr]
+	if old != nil && !start.After(old.start) {
+		return false
+	}
+	if removed, ok := r.removed[addr]; ok && !start.After(removed) {
+		return false
+	}
+	e := &entry{start: start, meta: meta}
+	r.changedLocked(addr, old, e)
+	r.servers[addr] = e
+	return true
+}
+
+// changedLocked 在 addr 从 old 更新为 e 之后调用，old 为 nil 表示新注册，e 为 nil 表示被移除，需要持有 r.mu
+func (r *Registry) changedLocked(addr string, old, e *entry) {
+	if r.persist != nil {
+		r.persist.changedLocked(addr, e)
+	}
+	if old == nil || e == nil || !reflect.DeepEqual(old.meta, e.meta) {
+		r.bumpLocked()
+	}
+	if e != nil {
+		r.armExpiryLocked(e.start)
+	}
+}
+
+// aliveServers 返回没有过期的服务器，按地址排序，同时移除已过期的服务器
+func (r *Registry) aliveServers() []Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(time.Now())
+	return r.listLocked()
+}
+
+// expireLocked 移除在 now 之前过期的服务器，需要持有 r.mu
+func (r *Registry) expireLocked(now time.Time) {
+	if r.timeout == 0 {
+		return
+	}
+	for addr, e := range r.servers {
+		if !e.start.Add(r.timeout).After(now) {
+			delete(r.servers, addr)
+			r.changedLocked(addr, e, nil)
+		}
+	}
+	for addr, at := range r.removed {
+		if !at.Add(r.timeout).After(now) {
+			delete(r.removed, addr)
+		}
+	}
+}
+
+// listLocked 返回所有服务器，按地址排序，需要持有 r.mu
+func (r *Registry) listLocked() []Server {
+	var alive []Server
+	for addr, e := range r.servers {
+		alive = append(alive, Server{Addr: addr, Metadata: e.meta, Heartbeat: e.start})
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
+	return alive
+}
+
+// ServeHTTP GET 在 ServersHeader 中返回所有可用的服务器，在响应体中返回包括元数据的 []Server；
+// POST 以 ServerHeader 中的地址作为心跳，请求体为空时使用默认的元数据；DELETE 立即注销 ServerHeader 中的地址，
+// 注销不存在的地址也会成功，注销之后再收到心跳会重新注册；带有 PeerHeader 的请求由其他注册中心转发。
+// 路径以 WatchPath 结尾的请求见 serveWatch；设置了 SetAuth 时先校验令牌
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if isWatch(req) {
+		r.serveWatch(w, req)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if !r.authorize(w, req, false) {
+			return
+		}
+		alive := r.aliveServers()
+		addrs := make([]string, len(alive))
+		for i, s := range alive {
+			addrs[i] = s.Addr
+		}
+		w.Header().Set(ServersHeader, strings.Join(addrs, ","))
+		w.Header().Set("Content-Type", "application/json")
+		if alive == nil {
+			alive = []Server{}
+		}
+		_ = json.NewEncoder(w).Encode(alive)
+	case http.MethodPost:
+		if !r.authorize(w, req, true) {
+			return
+		}
+		addr := req.Header.Get(ServerHeader)
+		if addr == "" {
+			http.Error(w, "rpc registry: missing "+ServerHeader, http.StatusBadRequest)
+			return
+		}
+		meta, err := readMetadata(req.Body)
+		if err != nil {
+			http.Error(w, "rpc registry: invalid metadata: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if peer := req.Header.Get(PeerHeader); peer != "" {
+			start, err := time.Parse(time.RFC3339Nano, peer)
+			if err != nil {
+				http.Error(w, "rpc registry: invalid "+PeerHeader, http.StatusBadRequest)
+				return
+			}
+			r.mergeServer(addr, meta, start)
+			return
+		}
+		r.putServer(addr, meta)
+	case http.MethodDelete:
+		if !r.authorize(w, req, true) {
+			return
+		}
+		addr := req.Header.Get(ServerHeader)
+		if addr == "" {
+			http.Error(w, "rpc registry: missing "+ServerHeader, http.StatusBadRequest)
+			return
+		}
+		if peer := req.Header.Get(PeerHeader); peer != "" {
+			at, err := time.Parse(time.RFC3339Nano, peer)
+			if err != nil {
+				http.Error(w, "rpc registry: invalid "+PeerHeader, http.StatusBadRequest)
+				return
+			}
+			r.removeServer(addr, at, false)
+			return
+		}
+		r.removeServer(addr, time.Now(), true)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Close 停止与其他注册中心的复制，写入还没有保存的变化并停止持久化
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	persist, peers := r.persist, r.peers
+	r.persist, r.peers = nil, nil
+	r.stopExpiryLocked()
+	r.mu.Unlock()
+	if peers != nil {
+		peers.close()
+	}
+	if persist == nil {
+		return nil
+	}
+	return persist.close()
+}
+
+// HandleHTTP 在 http.DefaultServeMux 的 registryPath 与 registryPath+WatchPath 上注册注册中心
+func (r *Registry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	http.Handle(strings.TrimSuffix(registryPath, "/")+WatchPath, r)
+	log.Println("rpc registry path:", registryPath)
+}
+
+// HandleHTTP 在默认路径上注册 DefaultRegistry
+func HandleHTTP() {
+	DefaultRegistry.HandleHTTP(defaultPath)
+}
+
+// readMetadata 读取心跳请求体中的元数据，请求体为空时返回零值
+func readMetadata(body io.Reader) (Metadata, error) {
+	var meta Metadata
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxMetadataSize+1))
+	if err != nil {
+		return meta, err
+	}
+	if len(data) > maxMetadataSize {
+		return meta, fmt.Errorf("larger than %d bytes", maxMetadataSize)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return meta, nil
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+const (
+	// maxUnauthorized 连续多少次心跳被拒绝后开始退避
+	maxUnauthorized = 3
+	// maxUnauthorizedBackoff 退避后心跳间隔最多为正常间隔的多少倍
+	maxUnauthorizedBackoff = 8
+)
+
+// HeartbeatOptions HeartbeatWithOptions 的配置
+type HeartbeatOptions struct {
+	// Metadata 为 nil 时不上报元数据，否则每次心跳都会携带，注册中心重启后也能恢复完整的信息
+	Metadata *Metadata
+	// Interval 心跳的间隔，为0时使用比默认 TTL 少1分钟的间隔
+	Interval time.Duration
+	// Stop 被关闭时停止发送心跳
+	Stop <-chan struct{}
+	// Token 注册中心的写令牌，见 Registry.SetAuth
+	Token string
+	// OnError 后续心跳失败时的回调，为 nil 时只记录日志；连续被拒绝（ErrUnauthorized）多次后逐渐延长心跳间隔，
+	// 每次持续被拒绝只回调一次说明开始退避的错误，心跳再次成功后恢复正常间隔
+	OnError func(err error)
+	// Server 不为 nil 时，在 Server 开始关闭时停止心跳并从注册中心注销，通常为发送心跳的 *geerpc.Server
+	Server ShutdownNotifier
+	// Load 不为 nil 时每次心跳都会调用，结果作为 Metadata.Load 上报，
+	// 如 func() float64 { return float64(server.Stats().InFlightRequests) }
+	Load func() float64
+}
+
+// ShutdownNotifier 能够在开始关闭时调用回调的服务端，*geerpc.Server 实现了该接口
+type ShutdownNotifier interface {
+	OnShutdown(f func())
+}
+
+// Heartbeat 立即向注册中心发送一次心跳并返回其错误，之后每隔 interval 发送一次，直到 stop 被关闭
+// interval 为0时使用比默认 TTL 少1分钟的间隔；后续心跳失败只记录日志，不会停止
+// addr 为客户端连接使用的地址，如 "tcp@127.0.0.1:9999"；meta 为 nil 时不上报元数据，
+// 否则每次心跳都会携带，注册中心重启后也能恢复完整的信息
+func Heartbeat(registryURL, addr string, meta *Metadata, interval time.Duration, stop <-chan struct{}) error {
+	return HeartbeatWithOptions(registryURL, addr, HeartbeatOptions{Metadata: meta, Interval: interval, Stop: stop})
+}
+
+// unauthorizedBackoff 连续第 n 次被拒绝后到下一次心跳的间隔，从第 maxUnauthorized 次开始每次加倍，
+// 最多为 interval 的 maxUnauthorizedBackoff 倍
+func unauthorizedBackoff(interval time.Duration, n int) time.Duration {
+	backoff := interval
+	for i := maxUnauthorized; i <= n && backoff < maxUnauthorizedBackoff*interval; i++ {
+		backoff *= 2
+	}
+	if backoff > maxUnauthorizedBackoff*interval {
+		backoff = maxUnauthorizedBackoff * interval
+	}
+	return backoff
+}
+
+// HeartbeatWithOptions 与 Heartbeat 相同，可以设置令牌与错误回调
+func HeartbeatWithOptions(registryURL, addr string, opts HeartbeatOptions) error {
+	interval := opts.Interval
+	if interval == 0 {
+		// 保证在服务器被移除之前有足够的时间发送下一次心跳
+		interval = defaultTimeout - time.Minute
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(err error) { log.Println("rpc registry: heart beat err:", err) }
+	}
+	body, err := heartbeatBody(opts)
+	if err != nil {
+		return err
+	}
+	err = sendHeartbeat(registryURL, addr, body, opts.Token)
+	unauthorized := 0
+	if errors.Is(err, ErrUnauthorized) {
+		unauthorized++
+	}
+	shutdown, exited := make(chan struct{}), make(chan struct{})
+	if opts.Server != nil {
+		opts.Server.OnShutdown(func() {
+			// 先等待心跳停止，避免注销之后又被正在发送的心跳重新注册
+			close(shutdown)
+			<-exited
+			if err := DeregisterWithToken(registryURL, addr, opts.Token); err != nil {
+				onError(err)
+			}
+		})
+	}
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-opts.Stop:
+				return
+			case <-shutdown:
+				return
+			case <-timer.C:
+			}
+			next := interval
+			if opts.Load != nil {
+				if body, err = heartbeatBody(opts); err != nil {
+					onError(err)
+					timer.Reset(next)
+					continue
+				}
+			}
+			err := sendHeartbeat(registryURL, addr, body, opts.Token)
+			if !errors.Is(err, ErrUnauthorized) {
+				unauthorized = 0
+			} else if unauthorized++; unauthorized >= maxUnauthorized {
+				// 持续被拒绝时退避而不是停止，注册中心更新令牌后心跳恢复
+				if unauthorized == maxUnauthorized {
+					onError(fmt.Errorf("rpc registry: heartbeat of %s rejected %d times in a row, backing off: %w", addr, unauthorized, err))
+				}
+				next, err = unauthorizedBackoff(interval, unauthorized), nil
+			}
+			if err != nil {
+				onError(err)
+			}
+			timer.Reset(next)
+		}
+	}()
+	return err
+}
+
+// heartbeatBody 返回心跳携带的元数据，设置了 Load 时以当前的负载填充 Metadata.Load
+func heartbeatBody(opts HeartbeatOptions) ([]byte, error) {
+	if opts.Load == nil {
+		if opts.Metadata == nil {
+			return nil, nil
+		}
+		return json.Marshal(opts.Metadata)
+	}
+	var meta Metadata
+	if opts.Metadata != nil {
+		meta = *opts.Metadata
+	}
+	meta.Load = opts.Load()
+	return json.Marshal(meta)
+}
+
+// Deregister 立即从注册中心注销 addr，地址不存在时也返回 nil；之后再发送心跳会重新注册
+func Deregister(registryURL, addr string) error {
+	return DeregisterWithToken(registryURL, addr, "")
+}
+
+// DeregisterWithToken 与 Deregister 相同，使用 token 作为写令牌
+func DeregisterWithToken(registryURL, addr, token string) error {
+	req, err := http.NewRequest(http.MethodDelete, registryURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ServerHeader, addr)
+	setToken(req, token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// httpClient 发送心跳与获取服务器列表使用的客户端，避免注册中心无响应时一直阻塞
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func sendHeartbeat(registryURL, addr string, body []byte, token string) error {
+	req, err := http.NewRequest(http.MethodPost, registryURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ServerHeader, addr)
+	setToken(req, token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// checkResponse 将注册中心的非 200 响应转换为错误，401 时返回 ErrUnauthorized
+func checkResponse(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	}
+	return fmt.Errorf("rpc registry: unexpected response %s", resp.Status)
+}
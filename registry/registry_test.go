@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	r := New(100 * time.Millisecond)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	stop := make(chan struct{})
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", nil, 20*time.Millisecond, stop))
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@b", nil, 20*time.Millisecond, nil))
+	get := func() string {
+		resp, err := http.Get(ts.URL)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		return resp.Header.Get(ServersHeader)
+	}
+	assert.Equal(t, "tcp@a,tcp@b", get())
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, "tcp@a,tcp@b", get(), "heartbeats keep servers alive")
+
+	close(stop)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, "tcp@b", get(), "expired after the TTL")
+
+	resp, err := http.Post(ts.URL, "", nil)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NotNil(t, Heartbeat("http://127.0.0.1:1/registry", "tcp@c", nil, time.Second, stop))
+}
+
+// withoutHeartbeat 清除 servers 的心跳时间，便于比较
+func withoutHeartbeat(servers []Server) []Server {
+	for i := range servers {
+		servers[i].Heartbeat = time.Time{}
+	}
+	return servers
+}
+
+func TestRegistry_Metadata(t *testing.T) {
+	ts := httptest.NewServer(New(time.Minute))
+	defer ts.Close()
+
+	meta := &Metadata{Weight: 3, Zone: "us-east-1a", Tags: map[string]string{"version": "v2"}, Codec: "application/gob"}
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", meta, time.Minute, nil))
+	// 没有元数据的旧版本服务器使用默认值
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@b", nil, time.Minute, nil))
+
+	resp, err := http.Get(ts.URL)
+	assert.Nil(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, "tcp@a,tcp@b", resp.Header.Get(ServersHeader))
+	var servers []Server
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&servers))
+	for _, s := range servers {
+		assert.WithinDuration(t, time.Now(), s.Heartbeat, time.Second)
+	}
+	assert.Equal(t, []Server{
+		{Addr: "tcp@a", Metadata: *meta},
+		{Addr: "tcp@b", Metadata: Metadata{Weight: 1}},
+	}, withoutHeartbeat(servers))
+
+	// 每次心跳替换之前的元数据
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", &Metadata{Weight: 5}, time.Minute, nil))
+	resp2, err := http.Get(ts.URL)
+	assert.Nil(t, err)
+	defer func() { _ = resp2.Body.Close() }()
+	var updated []Server
+	assert.Nil(t, json.NewDecoder(resp2.Body).Decode(&updated))
+	assert.Equal(t, Metadata{Weight: 5}, updated[0].Metadata)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("{not json"))
+	req.Header.Set(ServerHeader, "tcp@c")
+	resp3, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	_ = resp3.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp3.StatusCode)
+}
+
+func TestHeartbeat_Load(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	var load float64 = 3
+	var mu sync.Mutex
+	stop := make(chan struct{})
+	defer close(stop)
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{
+		Metadata: &Metadata{Zone: "z1"},
+		Interval: 20 * time.Millisecond,
+		Stop:     stop,
+		Load: func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return load
+		},
+	}))
+	assert.Equal(t, []Server{{Addr: "tcp@a", Metadata: Metadata{Weight: 1, Zone: "z1", Load: 3}}}, withoutHeartbeat(r.aliveServers()))
+
+	// 每次心跳上报当前的负载
+	mu.Lock()
+	load = 7
+	mu.Unlock()
+	assert.Eventually(t, func() bool {
+		servers := r.aliveServers()
+		return len(servers) == 1 && servers[0].Load == 7
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRegistry_Deregister(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", nil, time.Minute, nil))
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@b", nil, time.Minute, nil))
+
+	assert.Nil(t, Deregister(ts.URL, "tcp@a"))
+	assert.Equal(t, []string{"tcp@b"}, addrs(r.aliveServers()))
+	assert.Nil(t, Deregister(ts.URL, "tcp@unknown"), "deregistering an unknown address is a no-op")
+
+	// 注销之后的心跳重新注册
+	assert.Nil(t, Heartbeat(ts.URL, "tcp@a", nil, time.Minute, nil))
+	assert.Equal(t, []string{"tcp@a", "tcp@b"}, addrs(r.aliveServers()))
+
+	r.SetAuth(AuthOptions{WriteTokens: []string{"w"}})
+	assert.Equal(t, ErrUnauthorized, Deregister(ts.URL, "tcp@a"))
+	assert.Nil(t, DeregisterWithToken(ts.URL, "tcp@a", "w"))
+	assert.Equal(t, []string{"tcp@b"}, addrs(r.aliveServers()))
+}
+
+func TestHeartbeat_DeregisterOnShutdown(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	server := geerpc.NewServer()
+	var _ ShutdownNotifier = server
+
+	assert.Nil(t, HeartbeatWithOptions(ts.URL, "tcp@a", HeartbeatOptions{Interval: 5 * time.Millisecond, Server: server}))
+	assert.Equal(t, []string{"tcp@a"}, addrs(r.aliveServers()))
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Empty(t, r.aliveServers())
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, r.aliveServers(), "heartbeats stop after shutdown")
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// IndexHeader 服务器列表的版本，列表每次变化时递增
+	IndexHeader = "X-Geerpc-Registry-Index"
+
+	// WatchPath 挂载注册中心的路径后加上该后缀即为 watch 的路径
+	WatchPath = "/watch"
+
+	defaultWatchTimeout = 30 * time.Second
+	// maxWatchTimeout 每个 watch 请求最长的等待时间
+	maxWatchTimeout = 5 * time.Minute
+)
+
+// WatchResponse watch 请求的响应，Servers 中的 Heartbeat 为列表最近一次变化时的心跳时间
+type WatchResponse struct {
+	Index   uint64   `json:"index"`
+	Servers []Server `json:"servers"`
+}
+
+// watchState watch 的状态，受 Registry.mu 保护
+type watchState struct {
+	index   uint64        // 服务器列表的版本
+	changed chan struct{} // 列表变化时关闭并替换，所有等待的 watch 请求同时返回
+
+	cached      []Server // 版本为 cachedIndex 的列表，所有 watch 请求共用
+	cachedIndex uint64
+	computed    uint64 // 计算列表的次数
+
+	expiry   *time.Timer // 在最早的服务器过期时移除它，使 watch 请求及时返回
+	expiryAt time.Time
+}
+
+// bumpLocked 递增列表的版本并唤醒等待的 watch 请求，需要持有 r.mu
+func (r *Registry) bumpLocked() {
+	r.watch.index++
+	close(r.watch.changed)
+	r.watch.changed = make(chan struct{})
+}
+
+// armExpiryLocked 确保在心跳时间为 start 的服务器过期时移除它，需要持有 r.mu
+func (r *Registry) armExpiryLocked(start time.Time) {
+	if r.timeout == 0 {
+		return
+	}
+	at := start.Add(r.timeout)
+	w := &r.watch
+	if w.expiry != nil && !at.Before(w.expiryAt) {
+		return
+	}
+	if w.expiry != nil {
+		w.expiry.Stop()
+	}
+	w.expiryAt = at
+	w.expiry = time.AfterFunc(time.Until(at), r.expire)
+}
+
+// expire 移除过期的服务器，再为剩余的服务器中最早过期的一个设置定时器
+func (r *Registry) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watch.expiry = nil
+	r.expireLocked(time.Now())
+	for _, e := range r.servers {
+		r.armExpiryLocked(e.start)
+	}
+}
+
+// stopExpiryLocked 停止过期的定时器，需要持有 r.mu
+func (r *Registry) stopExpiryLocked() {
+	if r.watch.expiry != nil {
+		r.watch.expiry.Stop()
+		r.watch.expiry = nil
+	}
+}
+
+// watchListLocked 返回当前版本的列表，同一版本只计算一次，需要持有 r.mu
+func (r *Registry) watchListLocked() WatchResponse {
+	w := &r.watch
+	if w.cached == nil || w.cachedIndex != w.index {
+		w.cached = r.listLocked()
+		if w.cached == nil {
+			w.cached = []Server{}
+		}
+		w.cachedIndex = w.index
+		w.computed++
+	}
+	return WatchResponse{Index: w.index, Servers: w.cached}
+}
+
+// serveWatch 处理 GET <path>/watch?since=<index>&timeout=<duration>：
+// 列表的版本与 since 不同时立即返回，否则等待列表变化或超时（默认30秒，最长5分钟）后返回当前的列表
+func (r *Registry) serveWatch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !r.authorize(w, req, false) {
+		return
+	}
+	query := req.URL.Query()
+	timeout := defaultWatchTimeout
+	if s := query.Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "rpc registry: invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	if timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+	r.mu.Lock()
+	if s := query.Get("since"); s != "" {
+		since, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			r.mu.Unlock()
+			http.Error(w, "rpc registry: invalid since", http.StatusBadRequest)
+			return
+		}
+		if since == r.watch.index {
+			changed := r.watch.changed
+			r.mu.Unlock()
+			timer := time.NewTimer(timeout)
+			select {
+			case <-changed:
+			case <-timer.C:
+			case <-req.Context().Done():
+			}
+			timer.Stop()
+			r.mu.Lock()
+		}
+	}
+	resp := r.watchListLocked()
+	r.mu.Unlock()
+	w.Header().Set(IndexHeader, strconv.FormatUint(resp.Index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// isWatch 返回 req 是否为 watch 请求
+func isWatch(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, WatchPath)
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// watch 发送 watch 请求，since 为负数时不携带 since
+func watch(t *testing.T, url string, since int64) WatchResponse {
+	if since >= 0 {
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		url = fmt.Sprintf("%s%ssince=%d", url, sep, since)
+	}
+	resp, err := http.Get(url)
+	if !assert.Nil(t, err) {
+		return WatchResponse{}
+	}
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var w WatchResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&w))
+	assert.Equal(t, fmt.Sprint(w.Index), resp.Header.Get(IndexHeader))
+	return w
+}
+
+func TestRegistry_Watch(t *testing.T) {
+	r := New(200 * time.Millisecond)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	defer func() { _ = r.Close() }()
+	url := ts.URL + WatchPath
+
+	initial := watch(t, url, -1)
+	assert.Empty(t, initial.Servers)
+
+	// 注册后立即返回
+	start := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.putServer("tcp@a", Metadata{})
+	}()
+	w := watch(t, url, int64(initial.Index))
+	assert.Greater(t, w.Index, initial.Index)
+	assert.Equal(t, []string{"tcp@a"}, addrs(w.Servers))
+	assert.Less(t, int64(time.Since(start)), int64(150*time.Millisecond))
+
+	// 心跳只更新时间时不算变化，过期后立即返回
+	r.putServer("tcp@a", Metadata{})
+	start = time.Now()
+	expired := watch(t, url, int64(w.Index))
+	assert.Greater(t, expired.Index, w.Index)
+	assert.Empty(t, expired.Servers)
+	assert.InDelta(t, int64(200*time.Millisecond), int64(time.Since(start)), float64(100*time.Millisecond))
+
+	// 没有变化时等待到超时
+	start = time.Now()
+	timeout := watch(t, url+"?timeout=50ms", int64(expired.Index))
+	assert.Equal(t, expired.Index, timeout.Index)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
+
+func TestRegistry_WatchBroadcast(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	url := ts.URL + WatchPath
+	index := watch(t, url, -1).Index
+
+	r.mu.Lock()
+	computed := r.watch.computed
+	r.mu.Unlock()
+	const watchers = 100
+	var wg sync.WaitGroup
+	results := make([]WatchResponse, watchers)
+	for i := 0; i < watchers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = watch(t, url, int64(index))
+		}(i)
+	}
+	// 等待所有请求开始等待
+	time.Sleep(100 * time.Millisecond)
+	r.putServer("tcp@a", Metadata{})
+	wg.Wait()
+	for _, w := range results {
+		assert.Equal(t, index+1, w.Index)
+		assert.Equal(t, []string{"tcp@a"}, addrs(w.Servers))
+	}
+	r.mu.Lock()
+	assert.Equal(t, computed+1, r.watch.computed, "one list computation per change")
+	r.mu.Unlock()
+}
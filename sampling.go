@@ -0,0 +1,77 @@
+package geerpc
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// AccessLogSampling 访问日志的采样配置，返回错误（包括处理超时）的请求总是记录
+type AccessLogSampling struct {
+	Rate          float64            // 基础采样率，取值 [0, 1]，如 0.01 表示记录约 1% 的请求
+	MethodRates   map[string]float64 // 按 "Service.Method" 覆盖基础采样率
+	SlowThreshold time.Duration      // 耗时不小于该值的请求总是记录，0 表示不按耗时
+}
+
+// sampler 编译后的采样配置，设置之后只读，采样判断不加锁
+type sampler struct {
+	seq     uint64 // 已经判断过的请求数，原子访问，放在首位保证 32 位平台上的对齐
+	base    uint64
+	methods map[string]uint64
+	slow    time.Duration
+}
+
+// threshold 将采样率换算为 mix 结果的阈值，math.MaxUint64 表示全部记录
+func threshold(rate float64) uint64 {
+	switch {
+	case rate >= 1:
+		return math.MaxUint64
+	case rate <= 0:
+		return 0
+	}
+	return uint64(rate * (1 << 64))
+}
+
+// SetAccessLogSampling 设置 SetAccessLog 回调的采样，s 为 nil 时记录所有请求
+// 采样掉的请求仍然计入 ServerStats 的 AccessLogTotal，可以据此换算实际的请求量
+func (server *Server) SetAccessLogSampling(s *AccessLogSampling) {
+	if s == nil {
+		server.sampling.Store((*sampler)(nil))
+		return
+	}
+	sp := &sampler{base: threshold(s.Rate), slow: s.SlowThreshold}
+	if len(s.MethodRates) > 0 {
+		sp.methods = make(map[string]uint64, len(s.MethodRates))
+		for method, rate := range s.MethodRates {
+			sp.methods[method] = threshold(rate)
+		}
+	}
+	server.sampling.Store(sp)
+}
+
+// sample 判断是否记录一次请求，s 为 nil 时总是记录，管理服务的请求总是记录
+func (s *sampler) sample(serviceMethod string, d time.Duration, err error) bool {
+	if s == nil || err != nil || (s.slow > 0 && d >= s.slow) || strings.HasPrefix(serviceMethod, AdminService+".") {
+		return true
+	}
+	t, ok := s.methods[serviceMethod]
+	if !ok {
+		t = s.base
+	}
+	switch t {
+	case 0:
+		return false
+	case math.MaxUint64:
+		return true
+	}
+	return mix(atomic.AddUint64(&s.seq, 1)) < t
+}
+
+// mix 将递增的序号打散为均匀分布的 64 位值（splitmix64）
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
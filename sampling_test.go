@@ -0,0 +1,79 @@
+package geerpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_SetAccessLogSampling(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Stat)))
+	var mu sync.Mutex
+	logged := make(map[string]int)
+	server.SetAccessLog(func(ctx context.Context, e AccessEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged[e.ServiceMethod]++
+	})
+	server.SetAccessLogSampling(&AccessLogSampling{
+		Rate:          0.1,
+		MethodRates:   map[string]float64{"Stat.Fail": 0, "Stat.Slow": 0},
+		SlowThreshold: 20 * time.Millisecond,
+	})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	const n = 2000
+	var reply int
+	for i := 0; i < n; i++ {
+		assert.Nil(t, client.Call(ctx, "Stat.Fast", i, &reply))
+	}
+	// 采样率为 0 的方法，错误与慢请求仍然全部记录
+	for i := 0; i < 20; i++ {
+		assert.NotNil(t, client.Call(ctx, "Stat.Fail", i, &reply))
+	}
+	assert.Nil(t, client.Call(ctx, "Stat.Slow", 30*time.Millisecond, &reply))
+	assert.Nil(t, client.Call(ctx, "Stat.Slow", time.Millisecond, &reply))
+
+	// 访问日志在发送响应之后调用
+	total := uint64(n + 22)
+	assert.Eventually(t, func() bool { return server.Stats().AccessLogTotal == total }, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	fast, fail, slow := logged["Stat.Fast"], logged["Stat.Fail"], logged["Stat.Slow"]
+	mu.Unlock()
+	assert.True(t, fast >= n*5/100 && fast <= n*15/100, fast)
+	assert.Equal(t, 20, fail)
+	assert.Equal(t, 1, slow)
+	assert.Equal(t, uint64(fast+fail+slow), server.Stats().AccessLogged)
+
+	server.SetAccessLogSampling(nil)
+	assert.Nil(t, client.Call(ctx, "Stat.Fast", 0, &reply))
+	assert.Eventually(t, func() bool { return server.Stats().AccessLogged == uint64(fast+fail+slow+1) }, time.Second, 5*time.Millisecond)
+}
+
+func TestSampler_Proportions(t *testing.T) {
+	for _, rate := range []float64{0.01, 0.25, 0.5, 1} {
+		server := NewServer()
+		server.SetAccessLogSampling(&AccessLogSampling{Rate: rate})
+		s := server.sampling.Load().(*sampler)
+		const n = 100000
+		var kept int
+		for i := 0; i < n; i++ {
+			if s.sample("Foo.Sum", time.Millisecond, nil) {
+				kept++
+			}
+		}
+		got := float64(kept) / n
+		assert.InDelta(t, rate, got, rate*0.1+0.001, "rate %v", rate)
+		assert.True(t, s.sample("Foo.Sum", 0, ErrHandleTimeout))
+	}
+}
@@ -1,8 +1,29 @@
 package geerpc
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -28,3 +49,678 @@ func TestServer_ServeConn(t *testing.T) {
 		assert.NotEqual(t, err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
 }
+
+// testCA 测试中使用的自签名 CA，可以签发服务端与客户端证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geerpc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发一张以 cn 为 CommonName、对 127.0.0.1 与 localhost 有效的证书
+func (ca *testCA) issue(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// waitForAddr 等待 server 开始监听并返回绑定的地址
+func waitForAddr(t *testing.T, server *Server) string {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if addr := server.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start listening")
+	return ""
+}
+
+func TestServer_ListenAndServe(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, reply)
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errCh)
+	assert.Nil(t, server.Addr())
+	assert.Equal(t, ErrServerClosed, server.ListenAndServe("tcp", "127.0.0.1:0"))
+}
+
+func TestServer_ListenAndServeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "localhost")
+
+	t.Run("cert files with InsecureSkipVerify", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+		assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+		server := NewServer()
+		_ = server.Register(new(Foo))
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.ListenAndServeTLS("tcp", "127.0.0.1:0", certFile, keyFile) }()
+		addr := waitForAddr(t, server)
+
+		client, err := DialTLS("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		assert.Nil(t, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 2, Num2: 3}, &reply)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, reply)
+
+		assert.Nil(t, server.Close())
+		assert.Equal(t, ErrServerClosed, <-errCh)
+	})
+	t.Run("tls config with CA verification", func(t *testing.T) {
+		server := NewServer()
+		_ = server.Register(new(Foo))
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{cert}}) }()
+		addr := waitForAddr(t, server)
+
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		assert.Nil(t, err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 3, Num2: 4}, &reply)
+		assert.Nil(t, err)
+		assert.Equal(t, 7, reply)
+
+		_, err = DialTLS("tcp", addr, &tls.Config{})
+		assert.NotNil(t, err, "expect an unknown authority error")
+
+		assert.Nil(t, server.Shutdown(context.Background()))
+		assert.Equal(t, ErrServerClosed, <-errCh)
+	})
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Bar))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	call := client.Go("Bar.Timeout", 1, new(int), nil)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, server.Shutdown(ctx), "in-flight call keeps the connection open")
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	call = <-call.Done
+	assert.Nil(t, call.Error, "in-flight call should complete during shutdown")
+}
+
+func TestServer_ShutdownWaitsForRequestBody(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, json.NewEncoder(conn).Encode(DefaultOption))
+	time.Sleep(50 * time.Millisecond)
+
+	// 请求头与请求体分开发送，读取请求体期间连接不能被当作空闲关闭
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	assert.Nil(t, enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}))
+	_, err = conn.Write(buf.Bytes())
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+	time.Sleep(3 * shutdownPollInterval)
+	buf.Reset()
+	assert.Nil(t, enc.Encode(&Args{Num1: 1, Num2: 2}))
+	_, err = conn.Write(buf.Bytes())
+	assert.Nil(t, err)
+
+	cc := codec.NewGobCodec(conn)
+	var h codec.Header
+	var reply int
+	assert.Nil(t, cc.ReadHeader(&h))
+	if h.ServiceMethod == drainMethod {
+		assert.Nil(t, cc.ReadBody(nil))
+		assert.Nil(t, cc.ReadHeader(&h))
+	}
+	assert.Equal(t, "", h.Error)
+	assert.Nil(t, cc.ReadBody(&reply))
+	assert.Equal(t, 3, reply)
+	assert.Nil(t, <-done)
+}
+
+func TestServer_ServeMultipleListeners(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	tcpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	unixLis, err := net.Listen("unix", filepath.Join(t.TempDir(), "geerpc.sock"))
+	assert.Nil(t, err)
+
+	errCh := make(chan error, 2)
+	for _, lis := range []net.Listener{tcpLis, unixLis} {
+		lis := lis
+		go func() { errCh <- server.Serve(lis) }()
+	}
+	for _, lis := range []net.Listener{tcpLis, unixLis} {
+		client, err := Dial(lis.Addr().Network(), lis.Addr().String())
+		assert.Nil(t, err)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 3, reply)
+		_ = client.Close()
+	}
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			assert.Equal(t, ErrServerClosed, err)
+		case <-time.After(time.Second):
+			t.Fatal("Serve did not return after Shutdown")
+		}
+	}
+	_, err = tcpLis.Accept()
+	assert.NotNil(t, err, "listener should be closed")
+}
+
+func TestServer_ConcurrentReuse(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	_ = server.Register(new(Conn))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 20 * time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%20 == 0 {
+				// 超时的请求在方法返回前不能复用其参数与应答
+				_ = client.Call(context.Background(), "Conn.Sleep", 30*time.Millisecond, new(int))
+				return
+			}
+			var reply int
+			assert.Nil(t, client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i * i}, &reply))
+			assert.Equal(t, i+i*i, reply)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// bufferConn 将写入的数据收集到内存中，用于预先编码请求
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+func TestServer_ServeConnBatchedWrite(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	clientConn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	defer func() { _ = clientConn.Close() }()
+
+	// Option 与三个请求在同一次 Write 中发送
+	var payload bufferConn
+	assert.Nil(t, json.NewEncoder(&payload).Encode(DefaultOption))
+	enc := codec.NewGobCodec(&payload)
+	for i := 1; i <= 3; i++ {
+		h := &codec.Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}
+		assert.Nil(t, enc.Write(h, &Args{Num1: i, Num2: i}))
+	}
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := clientConn.Write(payload.Bytes())
+	assert.Nil(t, err)
+
+	dec := codec.NewGobCodec(clientConn)
+	replies := make(map[uint64]int)
+	for i := 0; i < 3; i++ {
+		var h codec.Header
+		var reply int
+		assert.Nil(t, dec.ReadHeader(&h))
+		assert.Equal(t, "", h.Error)
+		assert.Nil(t, dec.ReadBody(&reply))
+		replies[h.Seq] = reply
+	}
+	assert.Equal(t, map[uint64]int{1: 2, 2: 4, 3: 6}, replies)
+}
+
+type tenantKey struct{}
+
+type Conn int
+
+func (c Conn) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func (c Conn) Tenant(ctx context.Context, _ int, reply *string) error {
+	*reply, _ = ctx.Value(tenantKey{}).(string)
+	return nil
+}
+
+func TestServer_ConnHooks(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Conn))
+	var mu sync.Mutex
+	denied := map[string]bool{"127.0.0.1": true}
+	disconnected := make(chan error, 1)
+	server.OnConnect(func(conn ConnInfo) (context.Context, error) {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr.String())
+		mu.Lock()
+		defer mu.Unlock()
+		if denied[host] {
+			return nil, fmt.Errorf("%s is denied", host)
+		}
+		return context.WithValue(context.Background(), tenantKey{}, fmt.Sprintf("conn-%d", conn.ID)), nil
+	})
+	server.OnDisconnect(func(conn ConnInfo, err error) {
+		disconnected <- err
+	})
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	t.Run("reject by ip", func(t *testing.T) {
+		client, err := Dial("tcp", addr)
+		if err == nil {
+			var reply string
+			err = client.Call(context.Background(), "Conn.Tenant", 0, &reply)
+		}
+		assert.NotNil(t, err, "connection from a denied ip should fail")
+		select {
+		case <-disconnected:
+			t.Fatal("OnDisconnect should not fire for rejected connections")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	mu.Lock()
+	denied = nil
+	mu.Unlock()
+
+	t.Run("connection context", func(t *testing.T) {
+		client, err := Dial("tcp", addr)
+		assert.Nil(t, err)
+		var reply string
+		assert.Nil(t, client.Call(context.Background(), "Conn.Tenant", 0, &reply))
+		assert.True(t, strings.HasPrefix(reply, "conn-"), "handler should see the OnConnect context")
+		_ = client.Close()
+		assert.Equal(t, io.EOF, <-disconnected)
+	})
+	t.Run("disconnect mid-call", func(t *testing.T) {
+		client, err := Dial("tcp", addr)
+		assert.Nil(t, err)
+		client.Go("Conn.Sleep", 200*time.Millisecond, new(int), nil)
+		time.Sleep(50 * time.Millisecond)
+		_ = client.Close()
+		select {
+		case err := <-disconnected:
+			assert.NotNil(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("OnDisconnect did not fire")
+		}
+	})
+	t.Run("panic in hook", func(t *testing.T) {
+		server.OnConnect(func(conn ConnInfo) (context.Context, error) { panic("boom") })
+		client, err := Dial("tcp", addr)
+		if err == nil {
+			var reply string
+			err = client.Call(context.Background(), "Conn.Tenant", 0, &reply)
+		}
+		assert.NotNil(t, err, "a panicking OnConnect rejects the connection")
+	})
+}
+
+// Cooperative 的方法监听 ctx，在超时后尽快返回
+type Cooperative struct {
+	exited chan error
+}
+
+func (c *Cooperative) Wait(ctx context.Context, d time.Duration, reply *int) error {
+	select {
+	case <-ctx.Done():
+		c.exited <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(d):
+		c.exited <- nil
+		return nil
+	}
+}
+
+func TestServer_HandleTimeoutContext(t *testing.T) {
+	server := NewServer()
+	coop := &Cooperative{exited: make(chan error, 1)}
+	_ = server.Register(coop)
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 100 * time.Millisecond})
+	assert.Nil(t, err)
+
+	t.Run("handler observes cancellation", func(t *testing.T) {
+		start := time.Now()
+		err := client.Call(context.Background(), "Cooperative.Wait", 10*time.Second, new(int))
+		assert.True(t, err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+		select {
+		case err := <-coop.exited:
+			assert.Equal(t, context.DeadlineExceeded, err)
+		case <-time.After(time.Second):
+			t.Fatal("handler did not exit after the timeout")
+		}
+		assert.True(t, time.Since(start) < time.Second)
+	})
+	t.Run("handler finishes in time", func(t *testing.T) {
+		assert.Nil(t, client.Call(context.Background(), "Cooperative.Wait", 10*time.Millisecond, new(int)))
+		assert.Nil(t, <-coop.exited)
+	})
+}
+
+// Partial 的方法在返回错误前会修改 reply，客户端不应看到这些修改
+type Partial int
+
+func (p Partial) Struct(n int, reply *Args) error {
+	reply.Num1 = n
+	if n < 0 {
+		return errors.New("partial: struct failed")
+	}
+	return nil
+}
+
+func (p Partial) Map(n int, reply *map[string]int) error {
+	(*reply)["n"] = n
+	if n < 0 {
+		return errors.New("partial: map failed")
+	}
+	return nil
+}
+
+func (p Partial) Slice(n int, reply *[]int) error {
+	*reply = append(*reply, n)
+	if n < 0 {
+		return errors.New("partial: slice failed")
+	}
+	return nil
+}
+
+func TestServer_ErrorResponseBody(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Partial))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodecType: typ})
+			assert.Nil(t, err)
+			defer func() { _ = client.Close() }()
+			ctx := context.Background()
+
+			s := Args{Num1: 7}
+			assert.EqualError(t, client.Call(ctx, "Partial.Struct", -1, &s), "partial: struct failed")
+			assert.Equal(t, Args{Num1: 7}, s, "error response must not touch the reply")
+			m := map[string]int{"keep": 1}
+			assert.EqualError(t, client.Call(ctx, "Partial.Map", -1, &m), "partial: map failed")
+			assert.Equal(t, map[string]int{"keep": 1}, m)
+			sl := []int{1}
+			assert.EqualError(t, client.Call(ctx, "Partial.Slice", -1, &sl), "partial: slice failed")
+			assert.Equal(t, []int{1}, sl)
+
+			// 未知方法的请求body会被丢弃，之后的请求仍然正常
+			assert.NotNil(t, client.Call(ctx, "Partial.Unknown", 1, new(int)))
+			assert.Nil(t, client.Call(ctx, "Partial.Struct", 3, &s))
+			assert.Equal(t, 3, s.Num1)
+			assert.Nil(t, client.Call(ctx, "Partial.Map", 4, &m))
+			assert.Equal(t, 4, m["n"])
+			sl = nil
+			assert.Nil(t, client.Call(ctx, "Partial.Slice", 5, &sl))
+			assert.Equal(t, []int{5}, sl)
+		})
+	}
+}
+
+func TestServer_PropagatedDeadline(t *testing.T) {
+	server := NewServerWithOptions(ServerOptions{DefaultHandleTimeout: 10 * time.Second})
+	coop := &Cooperative{exited: make(chan error, 1)}
+	_ = server.Register(coop)
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	t.Run("handler context", func(t *testing.T) {
+		client, err := Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer func() { _ = client.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err = client.Call(ctx, "Cooperative.Wait", 10*time.Second, new(int))
+		assert.NotNil(t, err)
+		select {
+		case err := <-coop.exited:
+			assert.Equal(t, context.DeadlineExceeded, err)
+		case <-time.After(time.Second):
+			t.Fatal("handler did not observe the client deadline")
+		}
+		assert.True(t, time.Since(start) < time.Second)
+		for i := 0; i < 100 && server.Stats().TimeoutsServed == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, uint64(1), server.Stats().TimeoutsServed)
+	})
+	t.Run("server timeout response", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.Nil(t, json.NewEncoder(conn).Encode(DefaultOption))
+		cc := codec.NewGobCodec(conn)
+		start := time.Now()
+		h := &codec.Header{ServiceMethod: "Cooperative.Wait", Seq: 1, Timeout: int64(100 * time.Millisecond)}
+		assert.Nil(t, cc.Write(h, 10*time.Second))
+		assert.Nil(t, cc.ReadHeader(h))
+		assert.Nil(t, cc.ReadBody(nil))
+		elapsed := time.Since(start)
+		assert.Equal(t, "rpc server: request handle timeout: except within 100ms", h.Error)
+		assert.Equal(t, int(CodeHandleTimeout), h.Code)
+		assert.True(t, elapsed >= 100*time.Millisecond && elapsed < time.Second, elapsed)
+		assert.Equal(t, context.DeadlineExceeded, <-coop.exited)
+	})
+}
+
+func TestServer_HandleHTTPWithPaths(t *testing.T) {
+	a, b := NewServer(), NewServer()
+	assert.Nil(t, a.RegisterName("Adder", Adder(100)))
+	assert.Nil(t, b.RegisterName("Adder", Adder(200)))
+	a.EnableDebugHTTP(true)
+	a.HandleHTTPWithPaths("/rpc/a", "/debug/a")
+	b.HandleHTTPWithPaths("/rpc/b", "")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = http.Serve(l, nil) }()
+	defer func() { _ = l.Close() }()
+	addr := l.Addr().String()
+
+	for path, want := range map[string]int{"/rpc/a": 103, "/rpc/b": 203} {
+		client, err := DialHTTPPath("tcp", addr, path)
+		assert.Nil(t, err, path)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, want, reply, path)
+		_ = client.Close()
+	}
+	_, err = DialHTTPPath("tcp", addr, "/rpc/c")
+	assert.EqualError(t, err, "unexpected HTTP response: 404 Not Found: 404 page not found")
+
+	resp, err := http.Get("http://" + addr + "/debug/a.json")
+	assert.Nil(t, err)
+	var snap DebugSnapshot
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&snap))
+	_ = resp.Body.Close()
+	assert.Equal(t, uint64(1), snap.Services[0].Methods[0].Calls)
+	resp, err = http.Get("http://" + addr + "/debug/b")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "debug handler disabled")
+}
+
+func TestServer_RegisterHTTP(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	var urls []string
+	for i := 0; i < 2; i++ {
+		mux := http.NewServeMux()
+		assert.Nil(t, server.RegisterHTTP(mux, "/rpc", "/debug"))
+		assert.EqualError(t, server.RegisterHTTP(mux, "/rpc2", ""), "rpc server: HTTP handlers already registered on this mux")
+		err := NewServer().RegisterHTTP(mux, "/rpc", "")
+		assert.True(t, strings.HasPrefix(err.Error(), "rpc server: register HTTP handlers: "), "conflicting pattern: %v", err)
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+		urls = append(urls, ts.Listener.Addr().String())
+	}
+	for _, addr := range urls {
+		client, err := DialHTTPPath("tcp", addr, "/rpc")
+		assert.Nil(t, err)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 103, reply)
+		_ = client.Close()
+	}
+	assert.Equal(t, uint64(2), server.MethodStats()["Adder.Sum"].Calls, "both muxes serve the same server")
+
+	// 调试路径冲突时不注册任何处理程序，之后可以换一个路径重新注册
+	mux := http.NewServeMux()
+	mux.Handle("/debug", http.NotFoundHandler())
+	err := server.RegisterHTTP(mux, "/rpc", "/debug")
+	assert.EqualError(t, err, "rpc server: register HTTP handlers: pattern /debug already registered")
+	assert.False(t, muxHas(mux, "/rpc"), "rpc path must not be left registered")
+	assert.NotNil(t, server.RegisterHTTP(mux, "/rpc", "/rpc"), "duplicate paths within one call")
+	assert.False(t, muxHas(mux, "/rpc"))
+	assert.Nil(t, server.RegisterHTTP(mux, "/rpc", "/debug2"))
+	assert.True(t, muxHas(mux, "/rpc"))
+	assert.True(t, muxHas(mux, "/debug2.json"))
+}
+
+// hijackWriter 返回指定连接的 Hijacker，用于模拟写入 CONNECT 响应失败
+type hijackWriter struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, nil, nil
+}
+
+func TestServer_ServeHTTPErrors(t *testing.T) {
+	server := NewServer()
+
+	t.Run("method not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "CONNECT", w.Header().Get("Allow"))
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "405 must CONNECT\n", w.Body.String())
+	})
+	t.Run("hijack not supported", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodConnect, "/rpc", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "does not support hijacking")
+	})
+	t.Run("write connected failed", func(t *testing.T) {
+		a, b := net.Pipe()
+		_ = b.Close()
+		server.ServeHTTP(hijackWriter{httptest.NewRecorder(), a}, httptest.NewRequest(http.MethodConnect, "/rpc", nil))
+		_, err := a.Write([]byte("x"))
+		assert.Equal(t, io.ErrClosedPipe, err, "hijacked connection is closed")
+	})
+	t.Run("rejected handshake", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "maintenance in progress", http.StatusServiceUnavailable)
+		}))
+		defer ts.Close()
+		_, err := DialHTTP("tcp", ts.Listener.Addr().String())
+		var statusErr *HTTPStatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Equal(t, "maintenance in progress", statusErr.Reason)
+		assert.EqualError(t, err, "unexpected HTTP response: 503 Service Unavailable: maintenance in progress")
+	})
+}
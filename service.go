@@ -1,22 +1,33 @@
 package geerpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type methodType struct {
 	method    reflect.Method // 方法本身
+	hasCtx    bool           // 第一个参数是否为 context.Context
 	ArgType   reflect.Type   // 第一个参数的类型
-	ReplyType reflect.Type   // 第二个参数的类型
-	numCalls  uint64         // 后续统计方法调用次数时会调用
+	ReplyType reflect.Type   // 第二个参数的类型，双向流式方法没有第二个参数，为 nil
+	embedded  string         // 方法从哪个嵌入字段提升而来，为空表示直接在服务类型上声明
+	stream    streamKind     // 流式调用类型
+	reuse     bool           // 是否复用参数与应答的值，只复用不含引用的类型，流式方法不复用
+	argPool   sync.Pool      // 复用的参数，保存指向参数的指针
+	replyPool sync.Pool      // 复用的应答
+	stats     methodStats    // 耗时与错误统计
 }
 
 // NumCalls 调用次数计数
 func (m *methodType) NumCalls() uint64 {
-	return atomic.LoadUint64(&m.numCalls)
+	return atomic.LoadUint64(&m.stats.calls)
 }
 
 // newArgv 构造新的参数
@@ -32,40 +43,123 @@ func (m *methodType) newArgv() reflect.Value {
 	return argv
 }
 
+// getValues 返回一组参数与应答，开启复用时优先从池中取出并重置为零值
+func (m *methodType) getValues() (argv, replyv reflect.Value) {
+	if !m.reuse {
+		return m.newArgv(), m.newReplyv()
+	}
+	if p := m.argPool.Get(); p != nil {
+		argv = reflect.ValueOf(p)
+		// 复用的参数一定不是指针，池中保存的是它的地址
+		argv = argv.Elem()
+		argv.Set(reflect.Zero(argv.Type()))
+	} else {
+		argv = m.newArgv()
+	}
+	if p := m.replyPool.Get(); p != nil {
+		replyv = reflect.ValueOf(p)
+		m.resetReplyv(replyv)
+	} else {
+		replyv = m.newReplyv()
+	}
+	return argv, replyv
+}
+
+// putValues 将参数与应答放回池中，调用方保证之后不再使用它们
+func (m *methodType) putValues(argv, replyv reflect.Value) {
+	if !m.reuse {
+		return
+	}
+	m.argPool.Put(argv.Addr().Interface())
+	m.replyPool.Put(replyv.Interface())
+}
+
 // newReplyv 构造reply
 func (m *methodType) newReplyv() reflect.Value {
 	// reply一定是一个指针类型，用于反射
 	replyv := reflect.New(m.ReplyType.Elem())
+	m.resetReplyv(replyv)
+	return replyv
+}
 
+// resetReplyv 将 reply 重置为方法看到的初始值
+func (m *methodType) resetReplyv(replyv reflect.Value) {
+	elem := replyv.Elem()
 	// map或slice的不同处理
-	switch m.ReplyType.Elem().Kind() {
+	switch elem.Kind() {
 	case reflect.Map:
-		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
+		elem.Set(reflect.MakeMap(elem.Type()))
 	case reflect.Slice:
-		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+		elem.Set(reflect.MakeSlice(elem.Type(), 0, 0))
+	default:
+		elem.Set(reflect.Zero(elem.Type()))
 	}
-	return replyv
+}
+
+// hasRefs 判断 t 的值是否包含指针、切片、map、chan、interface 或 func
+// 方法可能在返回后保留这些引用，包含它们的参数与应答被复用时会被之后的请求覆盖
+func hasRefs(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Slice, reflect.Map, reflect.Chan, reflect.Interface, reflect.Func:
+		return true
+	case reflect.Array:
+		return hasRefs(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasRefs(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // service
 type service struct {
-	name   string                 // 映射的结构体名称
-	typ    reflect.Type           // 映射的结构体类型
-	rcvr   reflect.Value          // 映射的结构体实例本身
-	method map[string]*methodType // 用户存储映射的结构体的所有符合条件的方法
+	name     string                 // 映射的结构体名称
+	version  string                 // 服务版本，为空表示不带版本
+	typ      reflect.Type           // 映射的结构体类型
+	rcvr     reflect.Value          // 映射的结构体实例本身
+	method   map[string]*methodType // 用户存储映射的结构体的所有符合条件的方法
+	raw      RawHandler             // 接收者实现了 RawHandler 时不通过反射调用方法
+	limit    atomic.Value           // *serviceLimit，并发限制，为 nil 时不限制
+	inFlight int64                  // 正在处理的请求数，原子访问
 }
 
-// newService 从receive中构造service
-func newService(rcvr interface{}) *service {
+// newService 从receive中构造service，name 为空时使用结构体名称作为服务名
+// rcvr 不是指针时，服务持有它的一个可寻址副本，因此指针接收者的方法（包括从嵌入字段提升的方法）同样会被注册，
+// 这些方法对接收者的修改只作用于该副本
+func newService(rcvr interface{}, name string) (*service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: register nil receiver")
+	}
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if s.rcvr.Kind() != reflect.Ptr {
+		v := reflect.New(s.rcvr.Type())
+		v.Elem().Set(s.rcvr)
+		s.rcvr = v
+	}
+	s.typ = s.rcvr.Type()
+	s.name = name
+	if s.name == "" {
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+		if s.name == "" {
+			return nil, fmt.Errorf("rpc server: no service name for type %s", s.typ)
+		}
+		if !ast.IsExported(s.name) {
+			return nil, fmt.Errorf("rpc server: type %s is not exported", s.name)
+		}
+	} else if !ast.IsExported(s.name) {
+		return nil, fmt.Errorf("rpc server: %s is not a valid service name", s.name)
 	}
 	s.registerMethods()
-	return s
+	s.raw, _ = rcvr.(RawHandler)
+	if len(s.method) == 0 {
+		return nil, fmt.Errorf("rpc server: type %s has no exported methods of suitable type "+
+			"(want func([ctx context.Context,] args T, reply *R) error)", s.name)
+	}
+	return s, nil
 }
 
 // registerMethods 注册请求方法
@@ -74,36 +168,142 @@ func (s *service) registerMethods() {
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
 		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		if isBidiMethod(mType) {
+			s.method[method.Name] = &methodType{
+				method:   method,
+				hasCtx:   mType.NumIn() == 3,
+				ArgType:  typeOfBidiStream,
+				embedded: embeddedFrom(s.typ.Elem(), method.Name),
+				stream:   streamBidi,
+			}
+			continue
+		}
+		// 支持 func(args, reply) error 与 func(ctx, args, reply) error 两种形式
+		hasCtx := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+		if (mType.NumIn() != 3 && !hasCtx) || mType.NumOut() != 1 {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		if mType.Out(0) != typeOfError {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
-		s.method[method.Name] = &methodType{
+		m := &methodType{
 			method:    method,
+			hasCtx:    hasCtx,
 			ArgType:   argType,
 			ReplyType: replyType,
+			embedded:  embeddedFrom(s.typ.Elem(), method.Name),
+			reuse:     !hasRefs(argType) && replyType.Kind() == reflect.Ptr && !hasRefs(replyType.Elem()),
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+		switch {
+		case replyType == typeOfServerStream:
+			// 流由服务端在调用时构造
+			m.stream, m.reuse = streamServer, false
+		case argType == typeOfServerRecvStream:
+			m.stream, m.reuse = streamClient, false
+		}
+		s.method[method.Name] = m
 	}
 }
 
+// filterMethods 按照 include 与 exclude 过滤已注册的方法，include 为空时保留所有方法
+// 列表中出现不存在的方法时返回错误，避免拼写错误导致方法被意外暴露
+func (s *service) filterMethods(include, exclude []string) error {
+	for _, name := range append(append([]string(nil), include...), exclude...) {
+		if s.method[name] == nil {
+			return fmt.Errorf("rpc server: type %s has no suitable method %s", s.name, name)
+		}
+	}
+	if len(include) > 0 {
+		keep := make(map[string]*methodType, len(include))
+		for _, name := range include {
+			keep[name] = s.method[name]
+		}
+		s.method = keep
+	}
+	for _, name := range exclude {
+		delete(s.method, name)
+	}
+	if len(s.method) == 0 {
+		return fmt.Errorf("rpc server: type %s has no methods left after filtering", s.name)
+	}
+	return nil
+}
+
+// embeddedFrom 返回方法 name 所属的嵌入字段名，方法直接在 typ 或 *typ 上声明时返回空字符串
+// 编译器为提升的方法生成的包装函数没有源文件位置，据此区分声明与提升的方法
+func embeddedFrom(typ reflect.Type, name string) string {
+	for _, t := range []reflect.Type{typ, reflect.PtrTo(typ)} {
+		m, ok := t.MethodByName(name)
+		if !ok {
+			continue
+		}
+		pc := m.Func.Pointer()
+		if f := runtime.FuncForPC(pc); f != nil {
+			if file, _ := f.FileLine(pc); file != "<autogenerated>" {
+				return ""
+			}
+		}
+	}
+	if typ.Kind() != reflect.Struct {
+		return ""
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		ft := field.Type
+		if ft.Kind() != reflect.Ptr {
+			ft = reflect.PtrTo(ft)
+		}
+		if _, ok := ft.MethodByName(name); ok {
+			return field.Name
+		}
+	}
+	return ""
+}
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 // 检查是否是可导出的
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
-	atomic.AddUint64(&m.numCalls, 1)
+// call 调用方法，ctx 仅传递给第一个参数为 context.Context 的方法
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	start := time.Now()
+	m.stats.begin(start)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
-	if errInter := returnValues[0].Interface(); errInter != nil {
+	in := make([]reflect.Value, 0, 4)
+	in = append(in, s.rcvr)
+	if m.hasCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, argv)
+	if m.stream != streamBidi {
+		in = append(in, replyv)
+	}
+	returnValues := f.Call(in)
+	errInter := returnValues[0].Interface()
+	m.stats.record(time.Since(start), errInter != nil)
+	if errInter != nil {
 		return errInter.(error)
 	}
 	return nil
 }
+
+// methodName 返回方法的完整名称，带版本的服务为 "Service.Method@version"
+func (s *service) methodName(method string) string {
+	if s.version == "" {
+		return s.name + "." + method
+	}
+	return s.name + "." + method + "@" + s.version
+}
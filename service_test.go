@@ -1,7 +1,9 @@
 package geerpc
 
 import (
+	"context"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,39 +64,234 @@ func (f Foo4) Sums(args args, reply *int) error {
 	return nil
 }
 
+type foo5 int
+
+func (f foo5) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, err := newService(&foo, "")
+	assert.Nil(t, err)
 	assert.Equal(t, len(s.method), 1, "wrong service Method, expect 1, bug got %d", len(s.method))
 	mType := s.method["Sum"]
 	assert.NotNil(t, mType, "wrong Method, Sum shouldn't nil")
 	mType2 := s.method["sum"]
 	assert.Nil(t, mType2, "wrong Method, sum should nil")
 
+	// Foo2.Sum 参数个数不符合要求被跳过，其余方法正常注册
 	var foo2 Foo2
-	_ = newService(&foo2)
+	s2, err := newService(&foo2, "")
+	assert.Nil(t, err)
+	assert.Nil(t, s2.method["Sum"])
+	assert.Equal(t, 3, len(s2.method))
 
+	// Foo3.Sum 的返回值不是 error，没有可用的方法
 	var foo3 Foo3
-	_ = newService(&foo3)
+	_, err = newService(&foo3, "")
+	assert.EqualError(t, err, "rpc server: type Foo3 has no exported methods of suitable type "+
+		"(want func([ctx context.Context,] args T, reply *R) error)")
 
+	// Foo4.Sums 的参数类型未导出，没有可用的方法
 	var foo4 Foo4
-	_ = newService(&foo4)
+	_, err = newService(&foo4, "")
+	assert.EqualError(t, err, "rpc server: type Foo4 has no exported methods of suitable type "+
+		"(want func([ctx context.Context,] args T, reply *R) error)")
+
+	var f5 foo5
+	_, err = newService(&f5, "")
+	assert.EqualError(t, err, "rpc server: type foo5 is not exported")
+	s5, err := newService(&f5, "Foo5")
+	assert.Nil(t, err)
+	assert.Equal(t, "Foo5", s5.name)
+	_, err = newService(&f5, "foo5")
+	assert.EqualError(t, err, "rpc server: foo5 is not a valid service name")
+
+	_, err = newService(nil, "")
+	assert.NotNil(t, err)
+}
+
+func TestServer_Register(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.EqualError(t, server.Register(new(Foo)), "rpc: service already defined: Foo")
+	assert.NotNil(t, server.Register(new(Foo3)))
+	assert.Nil(t, server.RegisterName("Calc", new(Foo)))
+	_, _, err := server.findService("Calc.Sum")
+	assert.Nil(t, err)
 }
 
 func TestMethodCall(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo, "")
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
-	assert.NotEqual(t, err == nil && *replyv.Interface().(*int) == 4 && mType.numCalls == 1, "failed to call Foo.Sum")
+	err := s.call(context.Background(), mType, argv, replyv)
+	assert.NotEqual(t, err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 
 	var foo2 Foo2
-	s2 := newService(&foo2)
+	s2, _ := newService(&foo2, "")
 	s2.method["SumArgPointer"].newArgv()
 	s2.method["SumRetMap"].newReplyv()
 	s2.method["SumRetSlice"].newReplyv()
 }
+
+// Base 被嵌入的辅助类型，Ping 为指针接收者
+type Base struct {
+	pings int
+}
+
+func (b *Base) Ping(n int, reply *int) error {
+	b.pings++
+	*reply = b.pings
+	return nil
+}
+
+func (b Base) Health(n int, reply *string) error {
+	*reply = "ok"
+	return nil
+}
+
+// Outer 嵌入 Base 的服务
+type Outer struct {
+	Base
+}
+
+func (o Outer) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestNewService_Embedded(t *testing.T) {
+	for name, rcvr := range map[string]interface{}{"pointer": &Outer{}, "value": Outer{}} {
+		t.Run(name, func(t *testing.T) {
+			s, err := newService(rcvr, "")
+			assert.Nil(t, err)
+			assert.Equal(t, "Outer", s.name)
+			assert.Equal(t, 3, len(s.method))
+			assert.Equal(t, "", s.method["Sum"].embedded)
+			assert.Equal(t, "Base", s.method["Ping"].embedded)
+			assert.Equal(t, "Base", s.method["Health"].embedded)
+
+			m := s.method["Ping"]
+			replyv := m.newReplyv()
+			assert.Nil(t, s.call(context.Background(), m, reflect.ValueOf(1), replyv))
+			assert.Nil(t, s.call(context.Background(), m, reflect.ValueOf(1), replyv))
+			assert.Equal(t, 2, *replyv.Interface().(*int), "pointer receiver state is kept between calls")
+		})
+	}
+}
+
+func TestServer_RegisterFilterMethods(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterWithOptions(&Outer{}, ServiceOptions{ExcludeMethods: []string{"Ping", "Health"}}))
+	_, _, err := server.findService("Outer.Sum")
+	assert.Nil(t, err)
+	_, _, err = server.findService("Outer.Ping")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, server.RegisterWithOptions(&Outer{}, ServiceOptions{Name: "Pinger", IncludeOnly: []string{"Ping"}}))
+	_, _, err = server.findService("Pinger.Ping")
+	assert.Nil(t, err)
+	_, _, err = server.findService("Pinger.Sum")
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, server.RegisterWithOptions(&Outer{}, ServiceOptions{Name: "Typo", ExcludeMethods: []string{"Pong"}}))
+	assert.NotNil(t, server.RegisterWithOptions(&Outer{}, ServiceOptions{Name: "Empty", ExcludeMethods: []string{"Ping", "Health", "Sum"}}))
+}
+
+func TestMethodType_ReuseValues(t *testing.T) {
+	var foo2 Foo2
+	s, _ := newService(&foo2, "")
+
+	assert.False(t, s.method["SumArgPointer"].reuse, "pointer args must not be reused")
+
+	var foo Foo
+	s, _ = newService(&foo, "")
+	m := s.method["Sum"]
+	assert.True(t, m.reuse)
+	argv, replyv := m.getValues()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 2}))
+	*replyv.Interface().(*int) = 9
+	m.putValues(argv, replyv)
+	argv, replyv = m.getValues()
+	assert.Equal(t, Args{}, argv.Interface(), "reused args must be reset")
+	assert.Equal(t, 0, *replyv.Interface().(*int), "reused reply must be reset")
+	assert.True(t, argv.CanAddr())
+
+	m.reuse = false
+	argv, _ = m.getValues()
+	assert.Equal(t, Args{}, argv.Interface())
+}
+
+func BenchmarkMethodType_Values(b *testing.B) {
+	var foo Foo
+	s, _ := newService(&foo, "")
+	m := s.method["Sum"]
+	b.Run("reuse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			argv, replyv := m.getValues()
+			m.putValues(argv, replyv)
+		}
+	})
+	b.Run("allocate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = m.newArgv(), m.newReplyv()
+		}
+	})
+}
+
+// Keeper 保留每次调用的参数
+type Keeper struct {
+	mu   sync.Mutex
+	kept []*Items
+}
+
+type Items struct {
+	Items []int
+}
+
+func (k *Keeper) Keep(args *Items, reply *int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.kept = append(k.kept, args)
+	*reply = len(args.Items)
+	return nil
+}
+
+func (k *Keeper) Count(n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+func TestServer_RetainedArgsAreNotReused(t *testing.T) {
+	keeper := new(Keeper)
+	server := NewServer()
+	assert.Nil(t, server.Register(keeper))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Keeper.Keep", &Items{Items: []int{1, 2, 3}}, &reply))
+	assert.Nil(t, client.Call(context.Background(), "Keeper.Keep", &Items{Items: []int{4}}, &reply))
+	keeper.mu.Lock()
+	defer keeper.mu.Unlock()
+	assert.Equal(t, []int{1, 2, 3}, keeper.kept[0].Items, "a later request must not overwrite retained args")
+	assert.Equal(t, []int{4}, keeper.kept[1].Items)
+
+	svc, _, err := server.findService("Keeper.Keep")
+	assert.Nil(t, err)
+	assert.False(t, svc.method["Keep"].reuse)
+	assert.True(t, svc.method["Count"].reuse)
+}
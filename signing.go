@@ -0,0 +1,153 @@
+package geerpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sort"
+	"sync"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// ErrSignatureInvalid 帧的签名校验失败，可能被篡改或双方使用的密钥不同，可以用 errors.Is 判断
+var ErrSignatureInvalid = errors.New("rpc: signature invalid")
+
+// signedCodec 为每一帧签名并校验对端的签名，用于无法使用 TLS 的链路
+// body 先编码为 []byte 再交给底层编解码器发送，签名覆盖请求头与这些字节；
+// ReadHeader 读取完整的一帧并校验签名，校验失败时返回 ErrSignatureInvalid，此时请求头已读取，之后的 ReadBody 不读取任何数据
+type signedCodec struct {
+	codec.Codec
+	t    codec.Type
+	keys [][]byte
+	body []byte // 最近读取的一帧的body
+
+	mu  sync.Mutex
+	key []byte // 签名使用的密钥，为最近一次校验通过的密钥，初始为 keys[0]
+}
+
+// newSignedCodec 包装 c，keys 中的任意一个都可以通过校验，以便轮换密钥
+func newSignedCodec(c codec.Codec, t codec.Type, keys [][]byte) codec.Codec {
+	return &signedCodec{Codec: c, t: t, keys: keys, key: keys[0]}
+}
+
+func (s *signedCodec) ReadHeader(h *codec.Header) error {
+	s.body = nil
+	if err := s.Codec.ReadHeader(h); err != nil {
+		return err
+	}
+	if err := s.Codec.ReadBody(&s.body); err != nil {
+		return err
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(h.Signature, signature(key, h, s.body)) {
+			s.mu.Lock()
+			s.key = key
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	s.body = nil
+	return ErrSignatureInvalid
+}
+
+func (s *signedCodec) ReadBody(body interface{}) error {
+	data := s.body
+	s.body = nil
+	if body == nil || len(data) == 0 {
+		return nil
+	}
+	return codec.Unmarshal(s.t, data, body)
+}
+
+func (s *signedCodec) Write(h *codec.Header, body interface{}) error {
+	var data []byte
+	if h.HasBody && body != nil {
+		var err error
+		if data, err = codec.Marshal(s.t, body); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	key := s.key
+	s.mu.Unlock()
+	h.Signature = signature(key, h, data)
+	return s.Codec.Write(h, data)
+}
+
+// signature 计算帧的签名，请求头按固定的顺序与长度前缀写入，与编解码器的编码方式无关
+// codec.Header 增加字段时需要同时加入签名
+func signature(key []byte, h *codec.Header, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	writeUint(mac, uint64(len(h.ServiceMethod)))
+	mac.Write([]byte(h.ServiceMethod))
+	writeUint(mac, h.Seq)
+	writeUint(mac, uint64(len(h.Error)))
+	mac.Write([]byte(h.Error))
+	writeUint(mac, uint64(h.Code))
+	var flags uint64
+	for i, f := range []bool{h.HasBody, h.Compressed, h.Stream, h.More} {
+		if f {
+			flags |= 1 << i
+		}
+	}
+	writeUint(mac, flags)
+	writeUint(mac, uint64(h.Priority))
+	writeUint(mac, uint64(h.RetryAfter))
+	writeUint(mac, uint64(h.Timeout))
+	keys := make([]string, 0, len(h.Metadata))
+	for k := range h.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	writeUint(mac, uint64(len(keys)))
+	for _, k := range keys {
+		writeUint(mac, uint64(len(k)))
+		mac.Write([]byte(k))
+		writeUint(mac, uint64(len(h.Metadata[k])))
+		mac.Write([]byte(h.Metadata[k]))
+	}
+	writeUint(mac, uint64(len(body)))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func writeUint(h hash.Hash, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	h.Write(buf[:])
+}
+
+// invalidSignature 处理签名校验失败的帧，返回用于发送错误响应的请求
+// 被篡改的请求头不可信，只保留定位调用所需的字段；流的后续帧校验失败时终止整个流，错误响应作为流的结束帧
+func (server *Server) invalidSignature(sc *serverConn, req *request) *request {
+	h := req.h
+	sc.log.Warn("rpc server: invalid signature", "seq", h.Seq, "method", h.ServiceMethod)
+	if h.Stream && h.ServiceMethod == "" {
+		sc.streamsMu.Lock()
+		s := sc.streams[h.Seq]
+		sc.streamsMu.Unlock()
+		if s != nil {
+			s.cancel()
+		}
+	}
+	*h = codec.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Stream: h.Stream}
+	return req
+}
+
+// signatureFailed 记录一次签名校验失败，返回是否需要断开连接
+func (server *Server) signatureFailed(sc *serverConn) bool {
+	sc.sigFailures++
+	return server.opts.MaxSignatureFailures > 0 && sc.sigFailures >= server.opts.MaxSignatureFailures
+}
+
+// invalidSignature 签名校验失败的响应以 ErrSignatureInvalid 结束对应的调用，返回读取body时的错误
+func (client *Client) invalidSignature(h *codec.Header) error {
+	if call := client.removeCall(h.Seq); call != nil {
+		call.Error = ErrSignatureInvalid
+		call.done()
+	}
+	return client.c.ReadBody(nil)
+}
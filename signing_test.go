@@ -0,0 +1,160 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+func startSigningServer(t *testing.T, opts ServerOptions) *Server {
+	server := NewServerWithOptions(opts)
+	assert.Nil(t, server.Register(new(Foo)))
+	assert.Nil(t, server.Register(&Echo{errs: make(chan error, 1)}))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	waitForAddr(t, server)
+	return server
+}
+
+func dialSigned(t *testing.T, server *Server, keys ...[]byte) *Client {
+	client, err := Dial("tcp", server.Addr().String(), &Option{MagicNumber: MagicNumber, SigningKeys: keys})
+	assert.Nil(t, err)
+	return client
+}
+
+func TestSigning_KeyRotation(t *testing.T) {
+	oldKey, newKey := []byte("old key"), []byte("new key")
+	server := startSigningServer(t, ServerOptions{SigningKeys: [][]byte{newKey, oldKey}})
+	defer func() { _ = server.Close() }()
+
+	for name, keys := range map[string][][]byte{
+		"old key":   {oldKey},
+		"new key":   {newKey},
+		"both keys": {newKey, oldKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := dialSigned(t, server, keys...)
+			defer func() { _ = client.Close() }()
+			var reply int
+			assert.Nil(t, client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+			assert.Equal(t, 3, reply)
+		})
+	}
+	t.Run("stream", func(t *testing.T) {
+		client := dialSigned(t, server, oldKey)
+		defer func() { _ = client.Close() }()
+		stream, err := client.OpenStream(context.Background(), "Echo.Echo")
+		assert.Nil(t, err)
+		var msg string
+		assert.Nil(t, stream.Send("hi"))
+		assert.Nil(t, stream.Recv(&msg))
+		assert.Equal(t, "hi", msg)
+		assert.Nil(t, stream.CloseSend())
+		assert.Nil(t, stream.Recv(&msg))
+		assert.Equal(t, "bye", msg)
+		assert.Equal(t, io.EOF, stream.Recv(&msg))
+	})
+}
+
+func TestSigning_WrongKey(t *testing.T) {
+	server := startSigningServer(t, ServerOptions{SigningKeys: [][]byte{[]byte("key")}, MaxSignatureFailures: 2})
+	defer func() { _ = server.Close() }()
+
+	t.Run("unsigned client", func(t *testing.T) {
+		client, err := Dial("tcp", server.Addr().String())
+		assert.Nil(t, err)
+		defer func() { _ = client.Close() }()
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+		assert.NotNil(t, err)
+	})
+	t.Run("dropped after repeated failures", func(t *testing.T) {
+		client := dialSigned(t, server, []byte("wrong key"))
+		defer func() { _ = client.Close() }()
+		for i := 0; i < 2; i++ {
+			err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+			assert.True(t, errors.Is(err, ErrSignatureInvalid), "%v", err)
+		}
+		for i := 0; i < 100 && client.IsAvailable(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.False(t, client.IsAvailable())
+	})
+}
+
+func TestSigning_Tampered(t *testing.T) {
+	key := []byte("key")
+	server := startSigningServer(t, ServerOptions{SigningKeys: [][]byte{key}})
+	defer func() { _ = server.Close() }()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, json.NewEncoder(conn).Encode(DefaultOption))
+	raw := codec.NewGobCodec(conn)
+	signed := newSignedCodec(raw, codec.GobType, [][]byte{key})
+
+	send := func(h *codec.Header, signedArgs, sentArgs Args) {
+		data, err := codec.Marshal(codec.GobType, signedArgs)
+		assert.Nil(t, err)
+		h.HasBody = true
+		h.Signature = signature(key, h, data)
+		sent, err := codec.Marshal(codec.GobType, sentArgs)
+		assert.Nil(t, err)
+		assert.Nil(t, raw.Write(h, sent))
+	}
+	recv := func(seq uint64) (codec.Header, int) {
+		var h codec.Header
+		var reply int
+		assert.Nil(t, signed.ReadHeader(&h))
+		assert.Nil(t, signed.ReadBody(&reply))
+		assert.Equal(t, seq, h.Seq)
+		return h, reply
+	}
+
+	send(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}, Args{Num1: 1, Num2: 3})
+	h, _ := recv(1)
+	assert.Equal(t, int(CodeSignatureInvalid), h.Code, "tampered body")
+
+	h = codec.Header{ServiceMethod: "Foo.Sum", Seq: 2}
+	data, _ := codec.Marshal(codec.GobType, Args{Num1: 1, Num2: 2})
+	h.HasBody, h.Signature = true, signature(key, &h, data)
+	h.Metadata = map[string]string{"idempotency-key": "forged"}
+	assert.Nil(t, raw.Write(&h, data))
+	h, _ = recv(2)
+	assert.Equal(t, int(CodeSignatureInvalid), h.Code, "tampered header")
+
+	send(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 3}, Args{Num1: 1, Num2: 2}, Args{Num1: 1, Num2: 2})
+	h, reply := recv(3)
+	assert.Equal(t, "", h.Error, "connection is still usable")
+	assert.Equal(t, 3, reply)
+}
+
+func TestSignedCodec_VerifyResponse(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+	w := newSignedCodec(codec.NewJsonCodec(a), codec.JsonType, [][]byte{[]byte("server key")})
+	r := newSignedCodec(codec.NewJsonCodec(b), codec.JsonType, [][]byte{[]byte("client key"), []byte("server key")})
+	go func() {
+		_ = w.Write(&codec.Header{Seq: 1, HasBody: true}, "hello")
+		w.(*signedCodec).key = []byte("other key")
+		_ = w.Write(&codec.Header{Seq: 2, HasBody: true}, "hello")
+	}()
+	var h codec.Header
+	var body string
+	assert.Nil(t, r.ReadHeader(&h))
+	assert.Nil(t, r.ReadBody(&body))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, []byte("server key"), r.(*signedCodec).key, "replies are signed with the key the peer uses")
+
+	assert.Equal(t, ErrSignatureInvalid, r.ReadHeader(&h))
+	assert.Equal(t, uint64(2), h.Seq)
+	body = ""
+	assert.Nil(t, r.ReadBody(&body))
+	assert.Equal(t, "", body, "body of an unverified frame is discarded")
+}
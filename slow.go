@@ -0,0 +1,49 @@
+package geerpc
+
+import (
+	"net"
+	"time"
+)
+
+// SlowRequest 描述一次耗时超过阈值或处理超时的请求
+type SlowRequest struct {
+	ServiceMethod string
+	Duration      time.Duration // 从开始调用方法到方法返回（或超时）的时间
+	RemoteAddr    net.Addr      // 对端地址，连接不是 net.Conn 时为 nil
+	TimedOut      bool          // 是否因超过 HandleTimeout 而返回了超时错误
+}
+
+// slowConfig 慢请求上报配置
+type slowConfig struct {
+	threshold time.Duration
+	report    func(SlowRequest)
+}
+
+// SetSlowRequestThreshold 设置慢请求阈值，耗时超过 threshold 的请求会被上报
+// report 为 nil 时输出到日志；处理超时的请求无论阈值如何都会上报；threshold 为0时只上报超时的请求
+func (server *Server) SetSlowRequestThreshold(threshold time.Duration, report func(SlowRequest)) {
+	server.slow.Store(&slowConfig{threshold: threshold, report: report})
+}
+
+// slowThreshold 返回当前的慢请求阈值，没有设置时为0
+func (server *Server) slowThreshold() time.Duration {
+	cfg, _ := server.slow.Load().(*slowConfig)
+	if cfg == nil {
+		return 0
+	}
+	return cfg.threshold
+}
+
+// reportSlow 在请求耗时超过阈值或超时时上报，每个请求最多调用一次
+func (server *Server) reportSlow(sc *serverConn, serviceMethod string, d time.Duration, timedOut bool) {
+	cfg, _ := server.slow.Load().(*slowConfig)
+	if !timedOut && (cfg == nil || cfg.threshold <= 0 || d < cfg.threshold) {
+		return
+	}
+	r := SlowRequest{ServiceMethod: serviceMethod, Duration: d, RemoteAddr: sc.info.RemoteAddr, TimedOut: timedOut}
+	if cfg != nil && cfg.report != nil {
+		cfg.report(r)
+		return
+	}
+	sc.log.Warn("rpc server: slow request", "method", r.ServiceMethod, "duration", r.Duration, "timeout", r.TimedOut)
+}
@@ -0,0 +1,90 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer 可以被并发写入的 bytes.Buffer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServer_SlowRequest(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Conn))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	var mu sync.Mutex
+	var reports []SlowRequest
+	server.SetSlowRequestThreshold(50*time.Millisecond, func(r SlowRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, r)
+	})
+	taken := func() []SlowRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		r := reports
+		reports = nil
+		return r
+	}
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 200 * time.Millisecond})
+	assert.Nil(t, err)
+
+	t.Run("fast request", func(t *testing.T) {
+		assert.Nil(t, client.Call(context.Background(), "Conn.Sleep", time.Millisecond, new(int)))
+		assert.Empty(t, taken())
+	})
+	t.Run("slow request", func(t *testing.T) {
+		assert.Nil(t, client.Call(context.Background(), "Conn.Sleep", 80*time.Millisecond, new(int)))
+		r := taken()
+		assert.Equal(t, 1, len(r))
+		assert.Equal(t, "Conn.Sleep", r[0].ServiceMethod)
+		assert.False(t, r[0].TimedOut)
+		assert.True(t, r[0].Duration >= 80*time.Millisecond)
+		assert.NotNil(t, r[0].RemoteAddr)
+	})
+	t.Run("timeout reported once", func(t *testing.T) {
+		server.SetSlowRequestThreshold(time.Hour, server.slow.Load().(*slowConfig).report)
+		err := client.Call(context.Background(), "Conn.Sleep", 400*time.Millisecond, new(int))
+		assert.True(t, err != nil && strings.Contains(err.Error(), "handle timeout"))
+		time.Sleep(300 * time.Millisecond) // 等待方法执行结束
+		r := taken()
+		assert.Equal(t, 1, len(r), "timeout and late completion must be reported once")
+		assert.True(t, r[0].TimedOut)
+	})
+	t.Run("log output", func(t *testing.T) {
+		var buf syncBuffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+		server.SetSlowRequestThreshold(50*time.Millisecond, nil)
+		assert.Nil(t, client.Call(context.Background(), "Conn.Sleep", 80*time.Millisecond, new(int)))
+		assert.Contains(t, buf.String(), "rpc server: slow request method=Conn.Sleep duration=")
+		assert.Contains(t, buf.String(), "timeout=false")
+	})
+}
@@ -0,0 +1,110 @@
+package geerpc
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServeAuto 与 Serve 相同，但同一个 listener 同时服务 RPC 客户端与 HTTP 客户端
+// 根据连接的第一个字节区分协议：RPC 客户端首先发送 JSON 格式的 Option，以 '{' 开头；
+// HTTP 请求以大写的方法名开头（如 CONNECT、GET），交给内部的 http.Server 处理，
+// 其上在默认路径注册了 RPC、调试页面与健康检查的处理程序，DialHTTP 与 Dial 可以连接同一个端口
+func (server *Server) ServeAuto(lis net.Listener) error {
+	if server.optsErr != nil {
+		_ = lis.Close()
+		return server.optsErr
+	}
+	mux := http.NewServeMux()
+	if err := server.RegisterHTTP(mux, defaultRPCPath, defaultDebugPath); err != nil {
+		return err
+	}
+	hl := &connListener{addr: lis.Addr(), conns: make(chan net.Conn), done: make(chan struct{})}
+	hs := &http.Server{Handler: mux}
+	go func() { _ = hs.Serve(hl) }()
+	// 被接管的 CONNECT 连接由服务器管理，http.Server 只关闭仍在处理 HTTP 请求的连接
+	defer func() { _ = hs.Close() }()
+
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return ErrServerClosed
+	}
+	defer server.trackListener(lis, false)
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if server.shuttingDown() {
+				return ErrServerClosed
+			}
+			server.log().Error("rpc server: accept error", "addr", lis.Addr(), "err", err)
+			return err
+		}
+		if server.accept(conn) {
+			go server.sniff(conn, hl)
+		}
+	}
+}
+
+// sniff 读取连接的第一个字节判断协议，读取的数据保留在 bufio.Reader 中，之后的读取先返回这些数据
+func (server *Server) sniff(conn net.Conn, hl *connListener) {
+	if server.opts.HandshakeTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(server.opts.HandshakeTimeout))
+	}
+	br := bufio.NewReader(conn)
+	b, err := br.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		server.log().Debug("rpc server: sniff protocol error", "remote", conn.RemoteAddr(), "err", err)
+		_ = conn.Close()
+		return
+	}
+	pc := &peekedConn{Conn: conn, r: br}
+	if b[0] < 'A' || b[0] > 'Z' {
+		server.ServeConn(pc)
+		return
+	}
+	select {
+	case hl.conns <- pc:
+	case <-hl.done:
+		_ = conn.Close()
+	}
+}
+
+// peekedConn 先从 r 读取已经预读的数据，写入与关闭仍作用于原连接
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener 将 ServeAuto 识别为 HTTP 的连接交给 http.Server
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
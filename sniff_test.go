@@ -0,0 +1,51 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_ServeAuto(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error, 1)
+	go func() { done <- server.ServeAuto(l) }()
+	addr := l.Addr().String()
+
+	var wg sync.WaitGroup
+	for name, dial := range map[string]func() (*Client, error){
+		"Dial":     func() (*Client, error) { return Dial("tcp", addr) },
+		"DialHTTP": func() (*Client, error) { return DialHTTP("tcp", addr) },
+	} {
+		wg.Add(1)
+		go func(name string, dial func() (*Client, error)) {
+			defer wg.Done()
+			client, err := dial()
+			if !assert.Nil(t, err, name) {
+				return
+			}
+			defer func() { _ = client.Close() }()
+			for i := 0; i < 20; i++ {
+				var reply int
+				assert.Nil(t, client.Call(context.Background(), "Adder.Sum", &Args{Num1: i, Num2: 1}, &reply), name)
+				assert.Equal(t, i+101, reply, name)
+			}
+		}(name, dial)
+	}
+	wg.Wait()
+
+	resp, err := http.Get("http://" + addr + defaultHealthPath)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-done)
+}
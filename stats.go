@@ -0,0 +1,215 @@
+package geerpc
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets 耗时直方图的桶数，第 i 个桶记录耗时小于 2^i 微秒（且不小于 2^(i-1) 微秒）的调用
+const latencyBuckets = 40
+
+// methodStats 记录方法调用的耗时与错误统计
+// 位于请求处理的热路径上，所有字段均原子访问，不加锁
+type methodStats struct {
+	calls    uint64                 // 已开始的调用次数
+	last     int64                  // 最近一次调用开始的时间，Unix 纳秒
+	count    uint64                 // 已完成的调用次数
+	errors   uint64                 // 方法返回错误的次数
+	timeouts uint64                 // 处理超时的次数
+	total    int64                  // 累计耗时，单位纳秒
+	min      int64                  // 最小耗时，0 表示尚无数据
+	max      int64                  // 最大耗时
+	buckets  [latencyBuckets]uint64 // 耗时直方图
+}
+
+// begin 记录一次调用开始
+func (s *methodStats) begin(t time.Time) {
+	atomic.AddUint64(&s.calls, 1)
+	atomic.StoreInt64(&s.last, t.UnixNano())
+}
+
+// record 记录一次已完成的调用
+func (s *methodStats) record(d time.Duration, failed bool) {
+	ns := int64(d)
+	if ns <= 0 {
+		ns = 1
+	}
+	atomic.AddUint64(&s.count, 1)
+	atomic.AddInt64(&s.total, ns)
+	if failed {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	for {
+		old := atomic.LoadInt64(&s.min)
+		if (old != 0 && old <= ns) || atomic.CompareAndSwapInt64(&s.min, old, ns) {
+			break
+		}
+	}
+	for {
+		old := atomic.LoadInt64(&s.max)
+		if old >= ns || atomic.CompareAndSwapInt64(&s.max, old, ns) {
+			break
+		}
+	}
+	i := bits.Len64(uint64(ns / int64(time.Microsecond)))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	atomic.AddUint64(&s.buckets[i], 1)
+}
+
+// timeout 记录一次处理超时
+func (s *methodStats) timeout() {
+	atomic.AddUint64(&s.timeouts, 1)
+}
+
+// MethodStats 单个方法的运行统计
+// 耗时相关的字段只统计已经返回的调用
+type MethodStats struct {
+	Calls         uint64        // 调用次数，包括正在处理的调用
+	Errors        uint64        // 方法返回错误的次数
+	Timeouts      uint64        // 处理超时的次数
+	TotalDuration time.Duration // 累计耗时
+	LastCalled    time.Time     // 最近一次调用开始的时间，零值表示尚未调用
+	Avg           time.Duration
+	Min           time.Duration
+	Max           time.Duration
+	P50           time.Duration // 根据耗时直方图估算
+	P99           time.Duration
+	// Histogram 耗时直方图，第 i 个元素为耗时小于 2^i 微秒（且不小于 2^(i-1) 微秒）的调用数，最后一个元素还包括更长的调用；
+	// 尚无已返回的调用时为 nil
+	Histogram []uint64
+}
+
+func (s *methodStats) snapshot() MethodStats {
+	snap := MethodStats{
+		Calls:         atomic.LoadUint64(&s.calls),
+		Errors:        atomic.LoadUint64(&s.errors),
+		Timeouts:      atomic.LoadUint64(&s.timeouts),
+		TotalDuration: time.Duration(atomic.LoadInt64(&s.total)),
+		Min:           time.Duration(atomic.LoadInt64(&s.min)),
+		Max:           time.Duration(atomic.LoadInt64(&s.max)),
+	}
+	if last := atomic.LoadInt64(&s.last); last != 0 {
+		snap.LastCalled = time.Unix(0, last)
+	}
+	var buckets [latencyBuckets]uint64
+	var n uint64
+	for i := range buckets {
+		buckets[i] = atomic.LoadUint64(&s.buckets[i])
+		n += buckets[i]
+	}
+	if count := atomic.LoadUint64(&s.count); count > 0 {
+		snap.Avg = snap.TotalDuration / time.Duration(count)
+	}
+	if n > 0 {
+		snap.Histogram = append([]uint64(nil), buckets[:]...)
+	}
+	snap.P50 = percentile(buckets[:], n, 0.50, snap.Max)
+	snap.P99 = percentile(buckets[:], n, 0.99, snap.Max)
+	return snap
+}
+
+// reset 清空统计，与正在进行的调用并发时个别调用可能只被部分计入
+func (s *methodStats) reset() {
+	atomic.StoreUint64(&s.calls, 0)
+	atomic.StoreInt64(&s.last, 0)
+	atomic.StoreUint64(&s.count, 0)
+	atomic.StoreUint64(&s.errors, 0)
+	atomic.StoreUint64(&s.timeouts, 0)
+	atomic.StoreInt64(&s.total, 0)
+	atomic.StoreInt64(&s.min, 0)
+	atomic.StoreInt64(&s.max, 0)
+	for i := range s.buckets {
+		atomic.StoreUint64(&s.buckets[i], 0)
+	}
+}
+
+// MethodStats 返回所有方法的运行统计，键为 "Service.Method"
+func (server *Server) MethodStats() map[string]MethodStats {
+	stats := make(map[string]MethodStats)
+	server.serviceMap.Range(func(key, val interface{}) bool {
+		svc := val.(*service)
+		for name, mtype := range svc.method {
+			stats[svc.methodName(name)] = mtype.stats.snapshot()
+		}
+		return true
+	})
+	return stats
+}
+
+// ResetStats 清空所有方法的运行统计
+func (server *Server) ResetStats() {
+	server.serviceMap.Range(func(key, val interface{}) bool {
+		for _, mtype := range val.(*service).method {
+			mtype.stats.reset()
+		}
+		return true
+	})
+}
+
+// percentile 根据直方图估算分位数，返回所在桶的上界，不超过 max
+func percentile(buckets []uint64, n uint64, q float64, max time.Duration) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	target := uint64(q*float64(n) + 0.5)
+	if target == 0 {
+		target = 1
+	}
+	var acc uint64
+	for i, c := range buckets {
+		acc += c
+		if acc >= target {
+			upper := time.Duration(uint64(1)<<uint(i)) * time.Microsecond
+			if upper > max {
+				upper = max
+			}
+			return upper
+		}
+	}
+	return max
+}
+
+// ServerStats 服务器运行状态的快照
+type ServerStats struct {
+	ActiveConnections int64  // 当前正在服务的连接数
+	TotalConnections  uint64 // 累计接受的连接数（不含被 OnConnect 拒绝的连接）
+	InFlightRequests  int64  // 正在处理的请求数
+	TotalRequests     uint64 // 累计读取到的请求数
+	TotalErrors       uint64 // 累计返回错误的响应数，包括超时、服务器繁忙与找不到方法
+	TimeoutsServed    uint64 // 累计因超过 HandleTimeout 返回的超时响应数
+	DeniedConnections uint64 // 累计被访问控制列表拒绝的连接数
+	AccessLogTotal    uint64 // 设置了 SetAccessLog 回调时，累计处理完成的请求数，包括采样掉的请求
+	AccessLogged      uint64 // 累计经过采样后实际调用了 SetAccessLog 回调的请求数
+}
+
+// serverStats 服务器级别的计数器，所有字段均原子访问
+type serverStats struct {
+	activeConns int64
+	totalConns  uint64
+	inFlight    int64
+	requests    uint64
+	errors      uint64
+	timeouts    uint64
+	denied      uint64
+	logTotal    uint64
+	logged      uint64
+}
+
+// Stats 返回服务器当前的运行状态，可以在服务请求的同时并发调用
+func (server *Server) Stats() ServerStats {
+	s := &server.stats
+	return ServerStats{
+		ActiveConnections: atomic.LoadInt64(&s.activeConns),
+		TotalConnections:  atomic.LoadUint64(&s.totalConns),
+		InFlightRequests:  atomic.LoadInt64(&s.inFlight),
+		TotalRequests:     atomic.LoadUint64(&s.requests),
+		TotalErrors:       atomic.LoadUint64(&s.errors),
+		TimeoutsServed:    atomic.LoadUint64(&s.timeouts),
+		DeniedConnections: atomic.LoadUint64(&s.denied),
+		AccessLogTotal:    atomic.LoadUint64(&s.logTotal),
+		AccessLogged:      atomic.LoadUint64(&s.logged),
+	}
+}
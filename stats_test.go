@@ -0,0 +1,95 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Stats(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Stat))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+	assert.Equal(t, ServerStats{}, server.Stats())
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 100 * time.Millisecond})
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, client.Call(context.Background(), "Stat.Fast", i, new(int)))
+	}
+	assert.NotNil(t, client.Call(context.Background(), "Stat.Fail", 0, new(int)))
+	assert.NotNil(t, client.Call(context.Background(), "Stat.Missing", 0, new(int)))
+	assert.NotNil(t, client.Call(context.Background(), "Stat.Slow", 300*time.Millisecond, new(int)))
+
+	call := client.Go("Stat.Slow", 50*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	during := server.Stats()
+	assert.Equal(t, int64(1), during.ActiveConnections)
+	assert.Equal(t, uint64(1), during.TotalConnections)
+	assert.Equal(t, int64(1), during.InFlightRequests)
+	<-call.Done
+
+	time.Sleep(300 * time.Millisecond) // 等待超时的方法执行结束
+	assert.Equal(t, ServerStats{
+		ActiveConnections: 1,
+		TotalConnections:  1,
+		TotalRequests:     7,
+		TotalErrors:       3,
+		TimeoutsServed:    1,
+	}, server.Stats())
+
+	_ = client.Close()
+	for i := 0; i < 100 && server.Stats().ActiveConnections != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), server.Stats().ActiveConnections)
+}
+
+func TestServer_MethodStats(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Stat))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+
+	stats := server.MethodStats()
+	assert.Equal(t, 3, len(stats))
+	assert.True(t, stats["Stat.Fast"].LastCalled.IsZero())
+
+	client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, HandleTimeout: 100 * time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	before := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, client.Call(context.Background(), "Stat.Fast", i, new(int)))
+	}
+	assert.NotNil(t, client.Call(context.Background(), "Stat.Fail", 0, new(int)))
+	assert.NotNil(t, client.Call(context.Background(), "Stat.Slow", 200*time.Millisecond, new(int)))
+	time.Sleep(150 * time.Millisecond) // 等待超时的调用执行结束
+
+	stats = server.MethodStats()
+	assert.Equal(t, uint64(4), stats["Stat.Fast"].Calls)
+	assert.Equal(t, uint64(0), stats["Stat.Fast"].Errors)
+	assert.False(t, stats["Stat.Fast"].LastCalled.Before(before))
+	assert.Equal(t, uint64(1), stats["Stat.Fail"].Calls)
+	assert.Equal(t, uint64(1), stats["Stat.Fail"].Errors)
+	assert.Equal(t, uint64(1), stats["Stat.Slow"].Timeouts)
+	assert.True(t, stats["Stat.Slow"].TotalDuration >= 200*time.Millisecond)
+	var n uint64
+	for _, c := range stats["Stat.Fast"].Histogram {
+		n += c
+	}
+	assert.Equal(t, uint64(4), n)
+
+	server.ResetStats()
+	for name, s := range server.MethodStats() {
+		assert.Equal(t, MethodStats{}, s, name)
+	}
+	assert.Nil(t, client.Call(context.Background(), "Stat.Fast", 1, new(int)))
+	assert.Equal(t, uint64(1), server.MethodStats()["Stat.Fast"].Calls)
+}
@@ -0,0 +1,536 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+// 流式调用的帧协议：
+// 调用方发送 Stream 为 true、带 ServiceMethod 的帧开启一个流，之后双方都使用该调用的 Seq 发送帧；
+// More 为 true 的帧携带一个 chunk，body 为 codec.Marshal 编码的 []byte，接收方收到后再解码为具体类型；
+// More 为 true 但没有 body 的帧用于保活，接收方直接忽略；
+// More 为 false 表示发送方结束发送，服务端的结束帧同时结束整个调用，Error 不为空时表示调用失败；
+// 客户端放弃调用时发送带 Error 的结束帧，服务端据此取消处理流的 context
+
+// streamKind 方法的流式调用类型
+type streamKind uint8
+
+const (
+	streamNone   streamKind = iota
+	streamServer            // func([ctx,] args T, stream *ServerStream) error
+	streamClient            // func([ctx,] stream *ServerRecvStream, reply *R) error
+	streamBidi              // func([ctx,] stream *BidiStream) error
+)
+
+// streamWindow 每个流缓冲的 chunk 数量，接收方处理不过来时阻塞连接的读取
+const streamWindow = 64
+
+var (
+	typeOfServerStream     = reflect.TypeOf((*ServerStream)(nil))
+	typeOfServerRecvStream = reflect.TypeOf((*ServerRecvStream)(nil))
+	typeOfBidiStream       = reflect.TypeOf((*BidiStream)(nil))
+)
+
+// isBidiMethod 判断方法是否为 func([ctx,] stream *BidiStream) error 形式的双向流式方法
+func isBidiMethod(mType reflect.Type) bool {
+	n := mType.NumIn()
+	if mType.NumOut() != 1 || mType.Out(0) != typeOfError || mType.In(n-1) != typeOfBidiStream {
+		return false
+	}
+	return n == 2 || (n == 3 && mType.In(1) == typeOfContext)
+}
+
+// errStreamClosed 流已经结束后继续发送时返回该错误
+var errStreamClosed = errors.New("rpc: stream closed")
+
+// serverStream 服务端一个流式调用的状态
+type serverStream struct {
+	server *Server
+	sc     *serverConn
+	req    *request
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex // 保证结束帧之后不再发送 chunk
+	closed bool
+
+	in  chan []byte   // 客户端发送的 chunk，由读取请求的 goroutine 写入
+	eof chan struct{} // 客户端结束发送时关闭
+}
+
+// ServerStream 服务端流式方法用于向客户端发送多个 chunk
+type ServerStream struct {
+	s *serverStream
+}
+
+// Context 返回流的 context，客户端取消调用或连接断开时取消
+func (s *ServerStream) Context() context.Context {
+	return s.s.ctx
+}
+
+// Send 向客户端发送一个 chunk，可以在多个 goroutine 中并发调用，chunk 按照调用顺序到达
+// 方法返回后、客户端取消调用后或连接出错时返回错误
+func (s *ServerStream) Send(chunk interface{}) error {
+	return s.s.send(chunk)
+}
+
+func (s *serverStream) send(chunk interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	data, err := codec.Marshal(s.sc.opt.CodecType, chunk)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	h := codec.Header{ServiceMethod: s.req.h.ServiceMethod, Seq: s.req.h.Seq, Stream: true, More: true, HasBody: true}
+	s.sc.sending.Lock()
+	err = s.sc.codec.Write(&h, data)
+	s.sc.sending.Unlock()
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
+
+// ServerRecvStream 服务端方法用于接收客户端发送的多个 chunk
+type ServerRecvStream struct {
+	s *serverStream
+}
+
+// Context 返回流的 context，客户端取消调用或连接断开时取消
+func (s *ServerRecvStream) Context() context.Context {
+	return s.s.ctx
+}
+
+// Recv 将客户端发送的下一个 chunk 解码到 chunk 中，客户端结束发送后返回 io.EOF
+// 客户端取消调用或连接断开时返回 context 的错误；方法不必读完所有 chunk 即可返回，剩余的 chunk 会被丢弃
+func (s *ServerRecvStream) Recv(chunk interface{}) error {
+	return s.s.recv(chunk)
+}
+
+// BidiStream 服务端双向流式方法用于与客户端互相发送 chunk
+// 客户端调用 CloseSend 后 Recv 返回 io.EOF，方法返回即结束服务端的发送，客户端的 Recv 随之返回 io.EOF
+type BidiStream struct {
+	s *serverStream
+}
+
+// Context 返回流的 context，客户端取消调用或连接断开时取消
+func (s *BidiStream) Context() context.Context {
+	return s.s.ctx
+}
+
+// Send 与 ServerStream.Send 相同，可以与 Recv 并发调用
+func (s *BidiStream) Send(chunk interface{}) error {
+	return s.s.send(chunk)
+}
+
+// Recv 与 ServerRecvStream.Recv 相同
+func (s *BidiStream) Recv(chunk interface{}) error {
+	return s.s.recv(chunk)
+}
+
+func (s *serverStream) recv(chunk interface{}) error {
+	select {
+	case data := <-s.in:
+		return codec.Unmarshal(s.sc.opt.CodecType, data, chunk)
+	case <-s.eof:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	// 结束发送之前的 chunk 已经全部进入缓冲，先读完
+	select {
+	case data := <-s.in:
+		return codec.Unmarshal(s.sc.opt.CodecType, data, chunk)
+	default:
+		return io.EOF
+	}
+}
+
+// deliver 将客户端发送的 chunk 交给方法，缓冲已满时阻塞，方法已经返回时丢弃
+func (s *serverStream) deliver(data []byte) {
+	select {
+	case s.in <- data:
+	case <-s.ctx.Done():
+	}
+}
+
+// finish 发送结束帧，reply 不为 nil 时编码后作为结束帧的 body，之后 Send 返回错误
+func (s *serverStream) finish(err error, reply interface{}) {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.sc.removeStream(s.req.h.Seq)
+	s.cancel()
+	h := s.req.h
+	h.More = false
+	var body interface{}
+	if err == nil && reply != nil {
+		var data []byte
+		if data, err = codec.Marshal(s.sc.opt.CodecType, reply); err == nil {
+			body = data
+		}
+	}
+	if err != nil {
+		setError(h, err)
+	}
+	s.server.sendResponse(s.sc, h, body)
+}
+
+// addStream 记录连接上正在进行的流，只在读取请求的 goroutine 中调用
+func (sc *serverConn) addStream(s *serverStream) {
+	sc.streamsMu.Lock()
+	defer sc.streamsMu.Unlock()
+	if sc.streams == nil {
+		sc.streams = make(map[uint64]*serverStream)
+	}
+	sc.streams[s.req.h.Seq] = s
+}
+
+func (sc *serverConn) removeStream(seq uint64) {
+	sc.streamsMu.Lock()
+	defer sc.streamsMu.Unlock()
+	delete(sc.streams, seq)
+}
+
+// abortStreams 连接的读取结束后取消所有正在进行的流，避免方法一直等待客户端的 chunk
+func (sc *serverConn) abortStreams() {
+	sc.streamsMu.Lock()
+	defer sc.streamsMu.Unlock()
+	for _, s := range sc.streams {
+		s.cancel()
+	}
+}
+
+// streamFrame 处理已开启的流的后续帧，流已经结束时丢弃
+func (server *Server) streamFrame(sc *serverConn, h *codec.Header) error {
+	sc.streamsMu.Lock()
+	s := sc.streams[h.Seq]
+	sc.streamsMu.Unlock()
+	if s == nil || s.in == nil || !h.More || !h.HasBody {
+		if err := sc.codec.ReadBody(nil); err != nil {
+			return err
+		}
+	}
+	switch {
+	case s == nil:
+	case h.Error != "":
+		sc.log.Debug("rpc server: stream canceled by client", "seq", h.Seq, "method", s.req.h.ServiceMethod)
+		s.cancel()
+	case s.in == nil:
+	case !h.More:
+		select {
+		case <-s.eof: // 重复的结束帧
+		default:
+			close(s.eof)
+		}
+	case h.HasBody:
+		var data []byte
+		if err := sc.codec.ReadBody(&data); err != nil {
+			return err
+		}
+		s.deliver(data)
+	}
+	return nil
+}
+
+// serveStream 在新的 goroutine 中调用流式方法，流可能长时间存在，不占用 worker 池，也不受 HandleTimeout 限制
+// 客户端 ctx 的截止时间仍然作用于流的 context
+func (server *Server) serveStream(sc *serverConn, req *request) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	reqCtx := server.requestContext(sc.ctx, sc, req)
+	if req.deadline.IsZero() {
+		ctx, cancel = context.WithCancel(reqCtx)
+	} else {
+		ctx, cancel = context.WithDeadline(reqCtx, req.deadline)
+	}
+	s := &serverStream{server: server, sc: sc, req: req, ctx: ctx, cancel: cancel}
+	switch req.mtype.stream {
+	case streamServer:
+		req.replyv = reflect.ValueOf(&ServerStream{s: s})
+	case streamClient:
+		s.in, s.eof = make(chan []byte, streamWindow), make(chan struct{})
+		req.argv = reflect.ValueOf(&ServerRecvStream{s: s})
+		req.replyv = req.mtype.newReplyv()
+	case streamBidi:
+		s.in, s.eof = make(chan []byte, streamWindow), make(chan struct{})
+		req.argv = reflect.ValueOf(&BidiStream{s: s})
+	}
+	if s.in != nil && !req.h.More {
+		close(s.eof) // 客户端没有发送任何 chunk
+	}
+	sc.addStream(s)
+
+	sc.wg.Add(1)
+	atomic.AddInt32(&sc.pending, 1)
+	atomic.AddInt64(&server.stats.inFlight, 1)
+	go func() {
+		defer sc.wg.Done()
+		defer atomic.AddInt32(&sc.pending, -1)
+		defer atomic.AddInt64(&server.stats.inFlight, -1)
+		defer server.freeRequest(req)
+		start := time.Now()
+		sc.log.Debug("rpc server: stream opened", "seq", req.h.Seq, "method", req.h.ServiceMethod)
+		err := server.invoke(ctx, req)
+		d := time.Since(start)
+		server.reportSlow(sc, req.h.ServiceMethod, d, false)
+		var reply interface{}
+		if req.mtype.stream == streamClient {
+			reply = req.replyv.Interface()
+		}
+		s.finish(err, reply)
+		server.finishRequest(reqCtx, sc, req.h.ServiceMethod, d, err)
+		sc.log.Debug("rpc server: stream closed", "seq", req.h.Seq, "method", req.h.ServiceMethod, "err", err)
+	}()
+}
+
+// checkStream 检查请求与方法的流式类型是否匹配
+func checkStream(h *codec.Header, svc *service, mtype *methodType) error {
+	stream := mtype.stream != streamNone && svc.raw == nil
+	if h.Stream == stream {
+		return nil
+	}
+	if stream {
+		return fmt.Errorf("rpc server: method %s is a streaming method", h.ServiceMethod)
+	}
+	return fmt.Errorf("rpc server: method %s is not a streaming method", h.ServiceMethod)
+}
+
+// ClientStream 客户端的流式调用，由 CallStream、OpenClientStream 或 OpenStream 返回
+type ClientStream struct {
+	client *Client
+	call   *Call
+	ctx    context.Context
+	chunks chan []byte   // 已收到但尚未被 Recv 读取的 chunk
+	done   chan struct{} // 调用结束时关闭
+	once   sync.Once
+	err    error  // 调用结束的原因，done 关闭后只读
+	reply  []byte // 结束帧中编码后的应答，done 关闭后只读
+
+	sendMu    sync.Mutex // 保证 chunk 与结束发送的帧有序发送
+	sending   bool       // 客户端是否会发送 chunk
+	closeSent bool       // 已经结束发送
+}
+
+func newClientStream(ctx context.Context, client *Client, call *Call, sending bool) *ClientStream {
+	s := &ClientStream{
+		client:  client,
+		call:    call,
+		ctx:     ctx,
+		chunks:  make(chan []byte, streamWindow),
+		done:    make(chan struct{}),
+		sending: sending,
+	}
+	call.stream = s
+	return s
+}
+
+// CallStream 调用服务端流式方法，通过返回的 ClientStream 依次接收服务端发送的 chunk
+// 调用方需要持续调用 Recv 直到返回错误，或者取消 ctx；未读取的 chunk 超过缓冲后会阻塞该连接上所有响应的接收
+func (client *Client) CallStream(ctx context.Context, serviceMethod string, args interface{}, opts ...CallOption) (*ClientStream, error) {
+	return client.openStream(ctx, newStreamCall(serviceMethod, args, opts), false)
+}
+
+// OpenClientStream 调用客户端流式方法，通过返回的 ClientStream 依次发送 chunk，最后调用 CloseAndRecv 获取应答
+// 取消 ctx 会放弃调用，服务端方法的 Recv 随之返回错误
+func (client *Client) OpenClientStream(ctx context.Context, serviceMethod string, opts ...CallOption) (*ClientStream, error) {
+	return client.openStream(ctx, newStreamCall(serviceMethod, nil, opts), true)
+}
+
+// OpenStream 调用双向流式方法，通过返回的 ClientStream 发送与接收 chunk，两个方向互不阻塞
+// 调用 CloseSend 结束发送后仍可继续 Recv，直到服务端方法返回时 Recv 返回 io.EOF
+func (client *Client) OpenStream(ctx context.Context, serviceMethod string, opts ...CallOption) (*ClientStream, error) {
+	return client.openStream(ctx, newStreamCall(serviceMethod, nil, opts), true)
+}
+
+func newStreamCall(serviceMethod string, args interface{}, opts []CallOption) *Call {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	return call
+}
+
+// openStream 发送开启流的帧，sending 表示客户端之后还会发送 chunk
+func (client *Client) openStream(ctx context.Context, call *Call, sending bool) (*ClientStream, error) {
+	client.inject(ctx, call)
+	if _, err := client.attachToken(ctx, call, ""); err != nil {
+		return nil, err
+	}
+	s := newClientStream(ctx, client, call, sending)
+	call.deadline, _ = ctx.Deadline()
+	if err := client.write(call); err != nil {
+		return nil, err
+	}
+	go s.watch()
+	return s, nil
+}
+
+// Send 向服务端发送一个 chunk，可以在多个 goroutine 中并发调用，chunk 按照调用顺序到达
+// 调用已经结束（如服务端方法提前返回）或 ctx 已取消时返回 io.EOF，调用的结果通过 CloseAndRecv 获取
+func (s *ClientStream) Send(chunk interface{}) error {
+	if s.ended() {
+		return io.EOF
+	}
+	data, err := codec.Marshal(s.client.opt.CodecType, chunk)
+	if err != nil {
+		return err
+	}
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if !s.sending || s.closeSent {
+		return errStreamClosed
+	}
+	return s.client.writeFrame(&codec.Header{Seq: s.call.Seq, Stream: true, More: true, HasBody: true}, data)
+}
+
+// CloseSend 结束发送，服务端的 Recv 在读完之前的 chunk 后返回 io.EOF，重复调用不会出错
+func (s *ClientStream) CloseSend() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if !s.sending || s.closeSent {
+		return nil
+	}
+	s.closeSent = true
+	if s.ended() {
+		return nil // 服务端不再需要结束帧
+	}
+	return s.client.writeFrame(&codec.Header{Seq: s.call.Seq, Stream: true}, nil)
+}
+
+// CloseAndRecv 结束发送并等待调用结束，将服务端方法的应答解码到 reply 中
+func (s *ClientStream) CloseAndRecv(reply interface{}) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	<-s.done
+	if s.err != nil {
+		return s.err
+	}
+	if s.reply == nil {
+		return nil
+	}
+	return codec.Unmarshal(s.client.opt.CodecType, s.reply, reply)
+}
+
+// Recv 将下一个 chunk 解码到 chunk 中
+// 服务端方法正常返回后返回 io.EOF，方法返回错误、调用被取消或连接出错时返回对应的错误
+func (s *ClientStream) Recv(chunk interface{}) error {
+	select {
+	case data := <-s.chunks:
+		return codec.Unmarshal(s.client.opt.CodecType, data, chunk)
+	case <-s.done:
+	}
+	// 结束之前收到的 chunk 已经全部进入缓冲，先读完
+	select {
+	case data := <-s.chunks:
+		return codec.Unmarshal(s.client.opt.CodecType, data, chunk)
+	default:
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return io.EOF
+}
+
+// watch 在 ctx 结束时取消调用并通知服务端
+func (s *ClientStream) watch() {
+	ctx := s.ctx
+	select {
+	case <-s.done:
+		return
+	case <-ctx.Done():
+	}
+	if s.client.removeCall(s.call.Seq) == nil {
+		return // 调用已经结束
+	}
+	_ = s.client.writeFrame(&codec.Header{Seq: s.call.Seq, Stream: true, Error: ctx.Err().Error()}, nil)
+	s.finish(errors.New("rpc client: call failed: " + ctx.Err().Error()))
+}
+
+// ended 调用是否已经结束或即将被取消
+// ctx 取消后由 watch 通知服务端，此时不再发送其他帧，避免服务端先收到正常的结束发送
+func (s *ClientStream) ended() bool {
+	select {
+	case <-s.done:
+		return true
+	case <-s.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver 将收到的 chunk 放入缓冲，缓冲已满时阻塞，调用已经结束时丢弃
+func (s *ClientStream) deliver(data []byte) {
+	select {
+	case s.chunks <- data:
+	case <-s.done:
+	}
+}
+
+// finish 结束调用，err 为 nil 表示正常结束
+func (s *ClientStream) finish(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// receiveStream 处理流式调用的帧，返回读取body时的错误
+func (client *Client) receiveStream(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	if !h.More {
+		delete(client.pending, h.Seq)
+	}
+	client.mu.Unlock()
+	if call == nil || call.stream == nil {
+		return client.c.ReadBody(nil)
+	}
+	if h.More && !h.HasBody {
+		return client.c.ReadBody(nil) // 保活帧
+	}
+	if h.More {
+		var data []byte
+		if err := client.c.ReadBody(&data); err != nil {
+			return err
+		}
+		call.stream.deliver(data)
+		return nil
+	}
+	var err error
+	switch {
+	case h.Error != "":
+		call.Error = serverError(h)
+		err = client.c.ReadBody(nil)
+	case h.Compressed:
+		var data []byte
+		if err = client.c.ReadBody(&data); err == nil {
+			err = codec.DecompressBody(client.opt.CodecType, data, &call.stream.reply)
+		}
+	case h.HasBody:
+		err = client.c.ReadBody(&call.stream.reply)
+	default:
+		err = client.c.ReadBody(nil)
+	}
+	call.done()
+	return err
+}
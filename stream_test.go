@@ -0,0 +1,337 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+type Numbers int
+
+type CountArgs struct {
+	N      int
+	FailAt int // 发送到第 FailAt 个 chunk 时返回错误，0 表示不失败
+}
+
+func (n Numbers) Count(args CountArgs, stream *ServerStream) error {
+	for i := 0; i < args.N; i++ {
+		if args.FailAt > 0 && i == args.FailAt {
+			return errors.New("count failed")
+		}
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n Numbers) Forever(ctx context.Context, interval time.Duration, stream *ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+type Upload struct {
+	errs chan error // Drain 中 Recv 返回的错误
+}
+
+func (u *Upload) Sum(stream *ServerRecvStream, reply *int) error {
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		*reply += n
+	}
+}
+
+// Limit 只接收前3个 chunk
+func (u *Upload) Limit(stream *ServerRecvStream, reply *int) error {
+	for i := 0; i < 3; i++ {
+		var n int
+		if err := stream.Recv(&n); err != nil {
+			return err
+		}
+		*reply += n
+	}
+	return errors.New("too many chunks")
+}
+
+func (u *Upload) Drain(ctx context.Context, stream *ServerRecvStream, reply *int) error {
+	for {
+		var n int
+		if err := stream.Recv(&n); err != nil {
+			u.errs <- err
+			return err
+		}
+	}
+}
+
+type Echo struct {
+	errs chan error // Hold 中 Recv 返回的错误
+}
+
+func (e *Echo) Echo(stream *BidiStream) error {
+	for {
+		var msg string
+		err := stream.Recv(&msg)
+		if err == io.EOF {
+			return stream.Send("bye")
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Echo) Hold(ctx context.Context, stream *BidiStream) error {
+	for {
+		var msg string
+		if err := stream.Recv(&msg); err != nil {
+			e.errs <- err
+			return err
+		}
+		_ = stream.Send(msg)
+	}
+}
+
+func startStreamServer(t *testing.T) (*Server, *Client) {
+	server := NewServer()
+	assert.Nil(t, server.Register(&Echo{errs: make(chan error, 1)}))
+	assert.Nil(t, server.Register(new(Numbers)))
+	assert.Nil(t, server.Register(&Upload{errs: make(chan error, 1)}))
+	assert.Nil(t, server.Register(new(Foo)))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	return server, client
+}
+
+func TestClient_CallStream(t *testing.T) {
+	server, client := startStreamServer(t)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	t.Run("all chunks", func(t *testing.T) {
+		stream, err := client.CallStream(context.Background(), "Numbers.Count", CountArgs{N: 10000})
+		assert.Nil(t, err)
+		var got int
+		for {
+			var n int
+			if err = stream.Recv(&n); err != nil {
+				break
+			}
+			assert.Equal(t, got, n)
+			got++
+		}
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 10000, got)
+	})
+	t.Run("handler error mid-stream", func(t *testing.T) {
+		stream, err := client.CallStream(context.Background(), "Numbers.Count", CountArgs{N: 10000, FailAt: 5000})
+		assert.Nil(t, err)
+		var got int
+		for {
+			var n int
+			if err = stream.Recv(&n); err != nil {
+				break
+			}
+			got++
+		}
+		assert.Equal(t, 5000, got, "chunks sent before the error are delivered")
+		assert.EqualError(t, err, "count failed")
+	})
+	t.Run("interleaved with unary calls", func(t *testing.T) {
+		// 未读取的 chunk 不超过缓冲，不会阻塞其他响应的接收
+		stream, err := client.CallStream(context.Background(), "Numbers.Count", CountArgs{N: streamWindow})
+		assert.Nil(t, err)
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 3, reply)
+		var got int
+		for n := 0; stream.Recv(&n) == nil; got++ {
+		}
+		assert.Equal(t, streamWindow, got)
+	})
+	t.Run("not a streaming method", func(t *testing.T) {
+		stream, err := client.CallStream(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2})
+		assert.Nil(t, err)
+		assert.EqualError(t, stream.Recv(new(int)), "rpc server: method Foo.Sum is not a streaming method")
+		err = client.Call(context.Background(), "Numbers.Count", CountArgs{N: 1}, new(int))
+		assert.EqualError(t, err, "rpc server: method Numbers.Count is a streaming method")
+	})
+}
+
+func TestClientStream_Cancel(t *testing.T) {
+	server, client := startStreamServer(t)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.CallStream(ctx, "Numbers.Forever", time.Millisecond)
+	assert.Nil(t, err)
+	var n int
+	assert.Nil(t, stream.Recv(&n))
+	cancel()
+	for err == nil {
+		err = stream.Recv(&n)
+	}
+	assert.EqualError(t, err, "rpc client: call failed: context canceled")
+
+	// 服务端的方法随之返回
+	for i := 0; i < 100 && server.Stats().InFlightRequests != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), server.Stats().InFlightRequests)
+}
+
+func TestClient_OpenClientStream(t *testing.T) {
+	server, client := startStreamServer(t)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	t.Run("upload", func(t *testing.T) {
+		stream, err := client.OpenClientStream(context.Background(), "Upload.Sum")
+		assert.Nil(t, err)
+		want := 0
+		for i := 0; i < 10000; i++ {
+			assert.Nil(t, stream.Send(i))
+			want += i
+		}
+		var reply int
+		assert.Nil(t, stream.CloseAndRecv(&reply))
+		assert.Equal(t, want, reply)
+	})
+	t.Run("no chunks", func(t *testing.T) {
+		stream, err := client.OpenClientStream(context.Background(), "Upload.Sum")
+		assert.Nil(t, err)
+		reply := -1
+		assert.Nil(t, stream.CloseAndRecv(&reply))
+		assert.Equal(t, 0, reply)
+	})
+	t.Run("server aborts early", func(t *testing.T) {
+		stream, err := client.OpenClientStream(context.Background(), "Upload.Limit")
+		assert.Nil(t, err)
+		var sent int
+		for ; sent < 100000; sent++ {
+			if err = stream.Send(sent); err != nil {
+				break
+			}
+		}
+		assert.Equal(t, io.EOF, err, "Send reports the call has ended")
+		assert.Less(t, sent, 100000)
+		assert.EqualError(t, stream.CloseAndRecv(new(int)), "too many chunks")
+
+		// 连接仍然可用
+		var reply int
+		assert.Nil(t, client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 3, reply)
+	})
+}
+
+func TestClientStream_CancelUpload(t *testing.T) {
+	server := NewServer()
+	upload := &Upload{errs: make(chan error, 1)}
+	assert.Nil(t, server.Register(upload))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.OpenClientStream(ctx, "Upload.Drain")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, stream.Send(i))
+	}
+	cancel()
+	assert.EqualError(t, stream.CloseAndRecv(new(int)), "rpc client: call failed: context canceled")
+	select {
+	case err := <-upload.errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("server handler did not observe the cancellation")
+	}
+}
+
+func TestClient_OpenStream(t *testing.T) {
+	server, client := startStreamServer(t)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.OpenStream(context.Background(), "Echo.Echo")
+	assert.Nil(t, err)
+	const n = 1000
+	sendErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := stream.Send(fmt.Sprint(i)); err != nil {
+				sendErr <- err
+				return
+			}
+			if i == n/2 {
+				// 保活帧可以穿插在 chunk 之间
+				_ = client.writeFrame(&codec.Header{Seq: stream.call.Seq, Stream: true, More: true}, nil)
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+	for i := 0; i < n; i++ {
+		var msg string
+		assert.Nil(t, stream.Recv(&msg))
+		assert.Equal(t, fmt.Sprint(i), msg)
+	}
+	assert.Nil(t, <-sendErr)
+	var msg string
+	assert.Nil(t, stream.Recv(&msg))
+	assert.Equal(t, "bye", msg, "server observes CloseSend as io.EOF")
+	assert.Equal(t, io.EOF, stream.Recv(&msg), "client observes the handler returning as io.EOF")
+}
+
+func TestClientStream_ConnectionLost(t *testing.T) {
+	server := NewServer()
+	echo := &Echo{errs: make(chan error, 1)}
+	assert.Nil(t, server.Register(echo))
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.OpenStream(context.Background(), "Echo.Hold")
+	assert.Nil(t, err)
+	var msg string
+	assert.Nil(t, stream.Send("ping"))
+	assert.Nil(t, stream.Recv(&msg))
+
+	_ = server.Close()
+	err = stream.Recv(&msg)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err, "connection loss is not a normal end of stream")
+	select {
+	case err := <-echo.errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("server handler did not observe the connection loss")
+	}
+}
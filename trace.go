@@ -0,0 +1,213 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+)
+
+// TraceparentMetadata 请求元数据中传递调用链上下文使用的键名，值的格式与 W3C Trace Context 的 traceparent 相同
+const TraceparentMetadata = "traceparent"
+
+// SpanContext 调用链中一个 span 的标识
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid 判断 TraceID 与 SpanID 是否都不为零
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// String 返回 traceparent 格式的字符串，如 "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func (sc SpanContext) String() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+var errInvalidTraceparent = errors.New("rpc: invalid traceparent")
+
+// ParseTraceparent 解析 traceparent 格式的字符串，只支持版本 00
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) != 55 || s[:3] != "00-" || s[35] != '-' || s[52] != '-' {
+		return sc, errInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:])); err != nil {
+		return sc, errInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 返回携带 sc 的 ctx，之后的调用以 sc 为父 span
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前 span 的标识，没有时 ok 为 false
+// 服务端方法的 ctx 中为服务端 span；服务端没有设置 SpanHook 时为客户端传递过来的 span
+func SpanContextFromContext(ctx context.Context) (sc SpanContext, ok bool) {
+	sc, ok = ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// SpanKind span 的类型
+type SpanKind int
+
+const (
+	SpanClient SpanKind = iota // 客户端发起的调用
+	SpanServer                 // 服务端处理的请求
+)
+
+func (k SpanKind) String() string {
+	if k == SpanServer {
+		return "server"
+	}
+	return "client"
+}
+
+// Span 一次调用在客户端或服务端的 span
+// 客户端的 span 从发送请求前开始，到收到响应（或 ctx 取消）为止；服务端的 span 从读取到请求开始，包括排队与方法的处理时间，到发送响应为止
+type Span struct {
+	Kind          SpanKind
+	ServiceMethod string
+	Peer          string      // 对端地址，无法获取时为空
+	Context       SpanContext // 该 span 的标识，没有父 span 时生成新的 TraceID
+	Parent        SpanContext // 父 span 的标识，IsValid 为 false 表示该 span 是调用链的根
+	Start         time.Time
+	End           time.Time // 在 EndSpan 之前为零值
+	Err           error     // 调用或方法返回的错误，nil 表示成功
+}
+
+// Duration span 的持续时间
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// SpanHook span 的生命周期回调，可以用于对接 OpenTelemetry 等调用链系统
+// StartSpan 返回的 ctx 用于之后的调用（客户端）或传给方法（服务端）；同一个 SpanHook 会被并发调用
+type SpanHook interface {
+	StartSpan(ctx context.Context, span *Span) context.Context
+	EndSpan(ctx context.Context, span *Span)
+}
+
+// newSpan 创建以 parent 为父 span 的 span
+func newSpan(kind SpanKind, serviceMethod, peer string, parent SpanContext, start time.Time) *Span {
+	s := &Span{Kind: kind, ServiceMethod: serviceMethod, Peer: peer, Parent: parent, Start: start}
+	if parent.IsValid() {
+		s.Context.TraceID, s.Context.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		randomID(s.Context.TraceID[:])
+		s.Context.Sampled = true
+	}
+	randomID(s.Context.SpanID[:])
+	return s
+}
+
+// randomID 用随机字节填充 id，保证不全为零
+func randomID(id []byte) {
+	for {
+		_, _ = rand.Read(id)
+		for _, b := range id {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+type spanHookBox struct {
+	h SpanHook
+}
+
+// activeSpan 已经开始的 span 及开始时使用的 SpanHook，之后更换 SpanHook 不影响它的结束
+type activeSpan struct {
+	span *Span
+	h    SpanHook
+}
+
+// end 结束 span，s 为 nil 时什么也不做
+func (s *activeSpan) end(ctx context.Context, err error) {
+	if s == nil {
+		return
+	}
+	s.span.End, s.span.Err = time.Now(), err
+	s.h.EndSpan(ctx, s.span)
+}
+
+// SetSpanHook 设置客户端 span 的回调，h 为 nil 表示不再创建 span
+// 无论是否设置，ctx 中的 SpanContext（如服务端方法的 ctx）都会随请求传递给服务端
+func (client *Client) SetSpanHook(h SpanHook) {
+	client.spanHook.Store(spanHookBox{h: h})
+}
+
+// startSpan 为 Call 创建客户端 span，没有设置 SpanHook 时返回 nil
+func (client *Client) startSpan(ctx context.Context, serviceMethod string) (context.Context, *activeSpan) {
+	box, _ := client.spanHook.Load().(spanHookBox)
+	if box.h == nil {
+		return ctx, nil
+	}
+	parent, _ := SpanContextFromContext(ctx)
+	span := newSpan(SpanClient, serviceMethod, client.peer, parent, time.Now())
+	ctx = ContextWithSpanContext(ctx, span.Context)
+	return box.h.StartSpan(ctx, span), &activeSpan{span: span, h: box.h}
+}
+
+// SetSpanHook 设置服务端 span 的回调，h 为 nil 表示不再创建 span
+// 请求携带 traceparent 时服务端 span 以它为父 span，否则生成新的 TraceID
+func (server *Server) SetSpanHook(h SpanHook) {
+	server.spanHook.Store(spanHookBox{h: h})
+}
+
+type serverSpanKey struct{}
+
+// startSpan 提取请求传递的 SpanContext，设置了 SpanHook 时创建服务端 span
+func (server *Server) startSpan(ctx context.Context, sc *serverConn, req *request) context.Context {
+	parent, err := ParseTraceparent(req.h.Metadata[TraceparentMetadata])
+	box, _ := server.spanHook.Load().(spanHookBox)
+	if box.h == nil {
+		if err == nil {
+			ctx = ContextWithSpanContext(ctx, parent)
+		}
+		return ctx
+	}
+	span := newSpan(SpanServer, req.h.ServiceMethod, remoteAddrString(sc.info.RemoteAddr), parent, req.received)
+	ctx = ContextWithSpanContext(ctx, span.Context)
+	ctx = context.WithValue(ctx, serverSpanKey{}, &activeSpan{span: span, h: box.h})
+	return box.h.StartSpan(ctx, span)
+}
+
+// endSpan 结束 ctx 中的服务端 span
+func (server *Server) endSpan(ctx context.Context, err error) {
+	s, _ := ctx.Value(serverSpanKey{}).(*activeSpan)
+	s.end(ctx, err)
+}
+
+// remoteAddrString 返回 addr 的字符串形式，addr 为 nil 时返回空字符串
+func remoteAddrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
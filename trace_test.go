@@ -0,0 +1,181 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHook 记录结束的 span
+type recordingHook struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (h *recordingHook) StartSpan(ctx context.Context, span *Span) context.Context {
+	return ctx
+}
+
+func (h *recordingHook) EndSpan(ctx context.Context, span *Span) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.spans = append(h.spans, *span)
+}
+
+func (h *recordingHook) get() []Span {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Span(nil), h.spans...)
+}
+
+// Hop 将调用转发给下一个服务器
+type Hop struct {
+	next *Client
+}
+
+func (h *Hop) Forward(ctx context.Context, args Args, reply *int) error {
+	return h.next.Call(ctx, "Foo.Sum", args, reply)
+}
+
+func (h *Hop) Fail(args Args, reply *int) error {
+	return errors.New("hop failed")
+}
+
+func startTracedServer(t *testing.T, rcvr interface{}, hook SpanHook) string {
+	server := NewServer()
+	assert.Nil(t, server.Register(rcvr))
+	server.SetSpanHook(hook)
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	t.Cleanup(func() { _ = server.Close() })
+	return addr
+}
+
+func TestSpanHook_TwoHops(t *testing.T) {
+	var clientA, serverA, clientB, serverB recordingHook
+	addrB := startTracedServer(t, new(Foo), &serverB)
+	next, err := Dial("tcp", addrB)
+	assert.Nil(t, err)
+	defer func() { _ = next.Close() }()
+	next.SetSpanHook(&clientB)
+	addrA := startTracedServer(t, &Hop{next: next}, &serverA)
+
+	client, err := Dial("tcp", addrA)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	client.SetSpanHook(&clientA)
+
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Hop.Forward", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 3, reply)
+
+	// 服务端 span 在发送响应之后结束
+	assert.Eventually(t, func() bool { return len(serverA.get()) == 1 && len(serverB.get()) == 1 }, time.Second, 5*time.Millisecond)
+	c1, s1, c2, s2 := clientA.get(), serverA.get(), clientB.get(), serverB.get()
+	assert.Equal(t, 1, len(c1))
+	assert.Equal(t, 1, len(c2))
+
+	root, hopServer, hopClient, leaf := c1[0], s1[0], c2[0], s2[0]
+	assert.False(t, root.Parent.IsValid(), "the first client span starts a new trace")
+	assert.Equal(t, SpanClient, root.Kind)
+	assert.Equal(t, addrA, root.Peer)
+	assert.Equal(t, root.Context, hopServer.Parent)
+	assert.Equal(t, SpanServer, hopServer.Kind)
+	assert.Equal(t, "Hop.Forward", hopServer.ServiceMethod)
+	assert.Equal(t, hopServer.Context, hopClient.Parent)
+	assert.Equal(t, "Foo.Sum", hopClient.ServiceMethod)
+	assert.Equal(t, hopClient.Context, leaf.Parent)
+	for _, s := range []Span{hopServer, hopClient, leaf} {
+		assert.Equal(t, root.Context.TraceID, s.Context.TraceID)
+		assert.NotEqual(t, root.Context.SpanID, s.Context.SpanID)
+	}
+	assert.True(t, root.Duration() >= hopServer.Duration())
+	assert.True(t, hopServer.Duration() >= hopClient.Duration())
+	assert.True(t, hopClient.Duration() >= leaf.Duration())
+
+	// 方法返回的错误记录在两端的 span 中
+	assert.NotNil(t, client.Call(context.Background(), "Hop.Fail", Args{}, &reply))
+	assert.Equal(t, "hop failed", clientA.get()[1].Err.Error())
+	assert.Eventually(t, func() bool { return len(serverA.get()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "hop failed", serverA.get()[1].Err.Error())
+}
+
+func TestSpanHook_ServerGeneratesTrace(t *testing.T) {
+	var hook recordingHook
+	addr := startTracedServer(t, new(Foo), &hook)
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	assert.Nil(t, client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Eventually(t, func() bool { return len(hook.get()) == 1 }, time.Second, 5*time.Millisecond)
+	span := hook.get()[0]
+	assert.False(t, span.Parent.IsValid())
+	assert.True(t, span.Context.IsValid())
+
+	// 客户端没有设置 SpanHook 时仍然传递 ctx 中的 SpanContext
+	parent := SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}, Sampled: true}
+	assert.Nil(t, client.Call(ContextWithSpanContext(context.Background(), parent), "Foo.Sum", Args{}, &reply))
+	assert.Eventually(t, func() bool { return len(hook.get()) == 2 }, time.Second, 5*time.Millisecond)
+	span = hook.get()[1]
+	assert.Equal(t, parent, span.Parent)
+	assert.Equal(t, parent.TraceID, span.Context.TraceID)
+}
+
+func TestSpanHook_ServerQueueTime(t *testing.T) {
+	var hook recordingHook
+	server := NewServerWithOptions(ServerOptions{WorkerPool: WorkerPool{Size: 1, QueueLen: 4}})
+	assert.Nil(t, server.Register(&Cooperative{exited: make(chan error, 2)}))
+	server.SetSpanHook(&hook)
+	handled := make(chan struct{}, 2)
+	server.SetAccessLog(func(ctx context.Context, e AccessEntry) { handled <- struct{}{} })
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	addr := waitForAddr(t, server)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			assert.Nil(t, client.Call(context.Background(), "Cooperative.Wait", 50*time.Millisecond, &reply))
+		}()
+	}
+	wg.Wait()
+	<-handled
+	<-handled
+	spans := hook.get()
+	assert.Equal(t, 2, len(spans))
+	// 第二个请求在 worker 池中排队，服务端 span 包括排队时间
+	longest := spans[0].Duration()
+	if d := spans[1].Duration(); d > longest {
+		longest = d
+	}
+	assert.True(t, longest >= 100*time.Millisecond, longest)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Nil(t, err)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.String())
+	for _, s := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,371 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultChunkSize 分块传输默认的分块大小
+const DefaultChunkSize = 1 << 20
+
+const (
+	defaultTransferIdleTimeout = 10 * time.Minute
+	defaultTransferDoneTTL     = time.Minute
+)
+
+// ErrChecksumMismatch 分块传输完成后服务端计算的校验和与客户端不一致
+var ErrChecksumMismatch = errors.New("rpc server: transfer checksum mismatch")
+
+// TransferChunk SendReader 发送的一个分块，即 TransferReceiver.Write 的参数
+type TransferChunk struct {
+	ID       string // 传输ID，同一次传输的所有分块相同
+	Offset   int64  // 分块在数据中的偏移
+	Data     []byte
+	Last     bool   // 是否为最后一个分块
+	Size     int64  // 数据的总大小，Last 为 true 时有效
+	Checksum []byte // 数据整体的 SHA-256，Last 为 true 时有效
+}
+
+// TransferStatus 服务端接收一个传输的进度
+type TransferStatus struct {
+	Offset int64 // 已经写入的字节数
+	Done   bool  // 传输已完成并通过校验
+}
+
+// TransferReceiverOptions TransferReceiver 的选项
+type TransferReceiverOptions struct {
+	// IdleTimeout 传输在这段时间内没有收到分块或查询时被放弃并关闭写入目标，0 表示10分钟，小于0表示不放弃
+	IdleTimeout time.Duration
+	// DoneTTL 已完成的传输的记录保留的时间，期间重发的最后一个分块与 Status 仍报告完成，0 表示1分钟，小于0表示不保留
+	DoneTTL time.Duration
+}
+
+// TransferReceiver 接收 SendReader 发送的分块，按顺序写入 open 返回的 io.Writer
+// 以任意服务名注册后使用，如 server.RegisterName("Upload", NewTransferReceiver(open))；
+// 写入目标实现了 io.Closer 时，传输结束（无论成功、失败、调用 Abort 还是空闲超时）后关闭它
+type TransferReceiver struct {
+	open func(id string) (io.Writer, error)
+	opts TransferReceiverOptions
+
+	mu        sync.Mutex
+	transfers map[string]*transfer     // 尚未完成的传输，中断后可以从已写入的偏移处继续
+	done      map[string]*doneTransfer // 最近完成的传输
+}
+
+// transfer 服务端一个未完成的传输
+type transfer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	offset int64
+	hash   hash.Hash
+	closed bool      // 传输已经结束或被放弃，写入目标已关闭
+	active time.Time // 最近一次收到分块或查询的时间，持有 TransferReceiver.mu 访问
+}
+
+// doneTransfer 已完成的传输的记录
+type doneTransfer struct {
+	size     int64
+	checksum []byte
+	expires  time.Time
+}
+
+// NewTransferReceiver 返回一个使用默认选项的 TransferReceiver，open 在收到新传输的第一个分块时调用
+func NewTransferReceiver(open func(id string) (io.Writer, error)) *TransferReceiver {
+	return NewTransferReceiverWithOptions(open, TransferReceiverOptions{})
+}
+
+// NewTransferReceiverWithOptions 与 NewTransferReceiver 相同，但使用 opts 中的选项
+func NewTransferReceiverWithOptions(open func(id string) (io.Writer, error), opts TransferReceiverOptions) *TransferReceiver {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = defaultTransferIdleTimeout
+	}
+	if opts.DoneTTL == 0 {
+		opts.DoneTTL = defaultTransferDoneTTL
+	}
+	return &TransferReceiver{
+		open:      open,
+		opts:      opts,
+		transfers: make(map[string]*transfer),
+		done:      make(map[string]*doneTransfer),
+	}
+}
+
+// Write 写入一个分块，已经写入过的部分会被跳过，因此客户端可以安全地重发分块
+// 已完成的传输在 DoneTTL 内重发最后一个分块时直接报告完成
+func (r *TransferReceiver) Write(chunk TransferChunk, status *TransferStatus) error {
+	t, d, err := r.get(chunk.ID, chunk.Offset)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		if !chunk.Last || chunk.Offset+int64(len(chunk.Data)) != d.size || !bytes.Equal(chunk.Checksum, d.checksum) {
+			return fmt.Errorf("rpc server: transfer %s: already done", chunk.ID)
+		}
+		status.Offset, status.Done = d.size, true
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return fmt.Errorf("rpc server: transfer %s: closed", chunk.ID)
+	}
+	skip := t.offset - chunk.Offset
+	if skip < 0 {
+		return fmt.Errorf("rpc server: transfer %s: chunk at offset %d, expect %d", chunk.ID, chunk.Offset, t.offset)
+	}
+	if skip < int64(len(chunk.Data)) {
+		data := chunk.Data[skip:]
+		n, err := t.w.Write(data)
+		t.hash.Write(data[:n])
+		t.offset += int64(n)
+		if err != nil {
+			r.finish(chunk.ID, t, nil)
+			return err
+		}
+	}
+	status.Offset = t.offset
+	if !chunk.Last {
+		return nil
+	}
+	if t.offset != chunk.Size {
+		r.finish(chunk.ID, t, nil)
+		return fmt.Errorf("rpc server: transfer %s: received %d bytes, expect %d", chunk.ID, t.offset, chunk.Size)
+	}
+	if !bytes.Equal(t.hash.Sum(nil), chunk.Checksum) {
+		r.finish(chunk.ID, t, nil)
+		return ErrChecksumMismatch
+	}
+	r.finish(chunk.ID, t, &doneTransfer{size: t.offset, checksum: chunk.Checksum})
+	status.Done = true
+	return nil
+}
+
+// Status 返回传输已经写入的字节数，未知的传输返回0，DoneTTL 内完成的传输 Done 为 true
+func (r *TransferReceiver) Status(id string, status *TransferStatus) error {
+	r.mu.Lock()
+	expired := r.expireLocked(time.Now())
+	t, d := r.transfers[id], r.done[id]
+	if t != nil {
+		t.active = time.Now()
+	}
+	r.mu.Unlock()
+	abortAll(expired)
+	if t == nil {
+		if d != nil {
+			status.Offset, status.Done = d.size, true
+		}
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status.Offset = t.offset
+	return nil
+}
+
+// Abort 放弃一个未完成的传输并关闭写入目标，之后需要从头重新发送，未知的传输忽略
+func (r *TransferReceiver) Abort(id string, status *TransferStatus) error {
+	r.mu.Lock()
+	t := r.transfers[id]
+	if t != nil {
+		delete(r.transfers, id)
+	}
+	r.mu.Unlock()
+	if t != nil {
+		t.abort()
+	}
+	return nil
+}
+
+// get 返回 id 对应的传输，offset 为0时创建新的传输；传输已完成时返回它的记录
+func (r *TransferReceiver) get(id string, offset int64) (*transfer, *doneTransfer, error) {
+	now := time.Now()
+	r.mu.Lock()
+	expired := r.expireLocked(now)
+	defer abortAll(expired)
+	defer r.mu.Unlock()
+	if t := r.transfers[id]; t != nil {
+		t.active = now
+		return t, nil, nil
+	}
+	if d := r.done[id]; d != nil {
+		if offset != 0 {
+			return nil, d, nil
+		}
+		delete(r.done, id) // 重新使用已完成的传输ID
+	}
+	if offset != 0 {
+		return nil, nil, fmt.Errorf("rpc server: unknown transfer %s", id)
+	}
+	w, err := r.open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &transfer{w: w, hash: sha256.New(), active: now}
+	r.transfers[id] = t
+	return t, nil, nil
+}
+
+// finish 移除传输并关闭写入目标，需要持有 t.mu；d 不为 nil 时记录传输已完成
+func (r *TransferReceiver) finish(id string, t *transfer, d *doneTransfer) {
+	r.mu.Lock()
+	if r.transfers[id] == t {
+		delete(r.transfers, id)
+		if d != nil && r.opts.DoneTTL >= 0 {
+			d.expires = time.Now().Add(r.opts.DoneTTL)
+			r.done[id] = d
+		}
+	}
+	r.mu.Unlock()
+	t.close()
+}
+
+// expireLocked 移除过期的完成记录与空闲超时的传输，返回需要放弃的传输，需要持有 r.mu
+func (r *TransferReceiver) expireLocked(now time.Time) []*transfer {
+	for id, d := range r.done {
+		if now.After(d.expires) {
+			delete(r.done, id)
+		}
+	}
+	if r.opts.IdleTimeout < 0 {
+		return nil
+	}
+	var expired []*transfer
+	for id, t := range r.transfers {
+		if now.Sub(t.active) >= r.opts.IdleTimeout {
+			delete(r.transfers, id)
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+// abort 放弃传输并关闭写入目标，等待正在进行的写入结束
+func (t *transfer) abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.close()
+}
+
+// close 关闭写入目标，只有第一次调用有效，需要持有 t.mu
+func (t *transfer) close() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if c, ok := t.w.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+func abortAll(ts []*transfer) {
+	for _, t := range ts {
+		t.abort()
+	}
+}
+
+// TransferOptions SendReader 与 SendFile 的选项
+type TransferOptions struct {
+	ID        string                  // 传输ID，为空时随机生成；续传时需要与中断的传输相同
+	ChunkSize int                     // 分块大小，0 表示 DefaultChunkSize
+	Resume    bool                    // 发送前向服务端查询已写入的偏移，从该处继续发送
+	Retries   int                     // 单个分块调用失败后的重试次数，服务端方法返回的错误（服务器繁忙除外）不重试
+	Progress  func(sent, total int64) // 每个分块被服务端确认后调用，total 未知时为 -1
+}
+
+// SendReader 将 r 中的数据按分块依次调用 service 的 Write 方法发送，服务端使用 TransferReceiver 接收
+// 同一时刻只在内存中保留一个分块；分块调用失败时按 Retries 重发，
+// 仍然失败时可以使用相同的 ID 与 Resume 再次调用，从服务端已写入的偏移处继续
+func SendReader(ctx context.Context, client *Client, service string, r io.Reader, opts TransferOptions) error {
+	return sendReader(ctx, client, service, r, -1, opts)
+}
+
+// SendFile 与 SendReader 相同，发送 path 文件的内容，Progress 的 total 为文件大小
+func SendFile(ctx context.Context, client *Client, service, path string, opts TransferOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return sendReader(ctx, client, service, f, info.Size(), opts)
+}
+
+func sendReader(ctx context.Context, client *Client, service string, r io.Reader, total int64, opts TransferOptions) error {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.ID == "" {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		opts.ID = hex.EncodeToString(b[:])
+	}
+	buf := make([]byte, opts.ChunkSize)
+	h := sha256.New()
+	var offset int64
+	if opts.Resume {
+		var status TransferStatus
+		if err := client.Call(ctx, service+".Status", opts.ID, &status); err != nil {
+			return err
+		}
+		// 已发送的部分仍然需要计入校验和
+		n, err := io.CopyBuffer(h, io.LimitReader(r, status.Offset), buf)
+		if err != nil {
+			return err
+		}
+		if n != status.Offset {
+			return fmt.Errorf("rpc client: transfer %s: data is shorter than the received %d bytes", opts.ID, status.Offset)
+		}
+		offset = n
+	}
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		h.Write(buf[:n])
+		chunk := TransferChunk{ID: opts.ID, Offset: offset, Data: buf[:n], Last: last}
+		if last {
+			chunk.Size, chunk.Checksum = offset+int64(n), h.Sum(nil)
+		}
+		var status TransferStatus
+		for retry := 0; ; retry++ {
+			status = TransferStatus{}
+			err = client.Call(ctx, service+".Write", chunk, &status)
+			if err == nil || retry >= opts.Retries || ctx.Err() != nil || !retryable(err) {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+		if opts.Progress != nil {
+			opts.Progress(status.Offset, total)
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// retryable 判断分块调用的错误是否值得重试，方法本身返回的错误重发也不会成功
+func retryable(err error) bool {
+	var se *ServerError
+	return !errors.As(err, &se) || se.RetryAfter > 0 || se.Code == CodeServerBusy || se.Code == CodeServiceBusy
+}
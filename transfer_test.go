@@ -0,0 +1,244 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sinks 按传输ID收集接收到的数据
+type sinks struct {
+	mu   sync.Mutex
+	bufs map[string]*bytes.Buffer
+}
+
+func (s *sinks) open(id string) (io.Writer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bufs == nil {
+		s.bufs = make(map[string]*bytes.Buffer)
+	}
+	s.bufs[id] = new(bytes.Buffer)
+	return s.bufs[id], nil
+}
+
+func (s *sinks) get(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bufs[id].Bytes()
+}
+
+// failingReader 读取 n 字节后返回错误，模拟传输中断
+type failingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("reader broken")
+	}
+	if int64(len(p)) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= int64(n)
+	return n, err
+}
+
+func newTransferServer(t *testing.T) (*Server, *sinks) {
+	server := NewServer()
+	s := new(sinks)
+	assert.Nil(t, server.RegisterName("Upload", NewTransferReceiver(s.open)))
+	return server, s
+}
+
+func TestSendReader(t *testing.T) {
+	data := make([]byte, 50<<20)
+	_, _ = rand.Read(data)
+
+	transports := map[string]func(t *testing.T, server *Server) *Client{
+		"tcp": func(t *testing.T, server *Server) *Client {
+			go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+			client, err := Dial("tcp", waitForAddr(t, server))
+			assert.Nil(t, err)
+			return client
+		},
+		"pipe": func(t *testing.T, server *Server) *Client {
+			clientConn, serverConn := net.Pipe()
+			go server.ServeConn(serverConn)
+			client, err := NewClient(clientConn, DefaultOption)
+			assert.Nil(t, err)
+			return client
+		},
+	}
+	for name, dial := range transports {
+		t.Run(name, func(t *testing.T) {
+			server, sinks := newTransferServer(t)
+			defer func() { _ = server.Close() }()
+			client := dial(t, server)
+			defer func() { _ = client.Close() }()
+
+			var calls int
+			var last int64
+			err := SendReader(context.Background(), client, "Upload", bytes.NewReader(data), TransferOptions{
+				ID: "blob",
+				Progress: func(sent, total int64) {
+					calls++
+					assert.Equal(t, int64(-1), total)
+					assert.True(t, sent > last || sent == int64(len(data)))
+					last = sent
+				},
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, int64(len(data)), last)
+			assert.Equal(t, 51, calls, "50 full chunks and an empty last chunk")
+			assert.True(t, bytes.Equal(data, sinks.get("blob")))
+		})
+	}
+}
+
+func TestSendReader_Resume(t *testing.T) {
+	server, sinks := newTransferServer(t)
+	defer func() { _ = server.Close() }()
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	data := make([]byte, 10<<10+123)
+	_, _ = rand.Read(data)
+	opts := TransferOptions{ID: "resume", ChunkSize: 1 << 10}
+	err = SendReader(context.Background(), client, "Upload", &failingReader{r: bytes.NewReader(data), n: 3 << 10}, opts)
+	assert.EqualError(t, err, "reader broken")
+
+	var first int64 = -1
+	opts.Resume = true
+	opts.Progress = func(sent, total int64) {
+		if first < 0 {
+			first = sent
+		}
+	}
+	assert.Nil(t, SendReader(context.Background(), client, "Upload", bytes.NewReader(data), opts))
+	assert.Equal(t, int64(4<<10), first, "resumes after the 3 chunks already received")
+	assert.True(t, bytes.Equal(data, sinks.get("resume")))
+}
+
+func TestSendFile(t *testing.T) {
+	server, sinks := newTransferServer(t)
+	defer func() { _ = server.Close() }()
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	data := make([]byte, 100<<10)
+	_, _ = rand.Read(data)
+	path := filepath.Join(t.TempDir(), "blob")
+	assert.Nil(t, os.WriteFile(path, data, 0o600))
+	var total int64
+	err = SendFile(context.Background(), client, "Upload", path, TransferOptions{
+		ID:        "file",
+		ChunkSize: 64 << 10,
+		Progress:  func(sent, n int64) { total = n },
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), total)
+	assert.True(t, bytes.Equal(data, sinks.get("file")))
+}
+
+func TestTransferReceiver_Validation(t *testing.T) {
+	r := NewTransferReceiver(new(sinks).open)
+	var status TransferStatus
+	assert.Nil(t, r.Write(TransferChunk{ID: "a", Data: []byte("hello")}, &status))
+	assert.Equal(t, int64(5), status.Offset)
+
+	// 重发的分块被跳过
+	assert.Nil(t, r.Write(TransferChunk{ID: "a", Data: []byte("hello")}, &status))
+	assert.Equal(t, int64(5), status.Offset)
+
+	err := r.Write(TransferChunk{ID: "a", Offset: 9, Data: []byte("x")}, &status)
+	assert.EqualError(t, err, "rpc server: transfer a: chunk at offset 9, expect 5")
+
+	err = r.Write(TransferChunk{ID: "a", Offset: 5, Last: true, Size: 5, Checksum: []byte("bad")}, &status)
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	err = r.Write(TransferChunk{ID: "a", Offset: 5, Last: true, Size: 5}, &status)
+	assert.EqualError(t, err, "rpc server: unknown transfer a", "failed transfers are discarded")
+
+	assert.Nil(t, r.Write(TransferChunk{ID: "b", Data: []byte("hi")}, &status))
+	err = r.Write(TransferChunk{ID: "b", Offset: 2, Last: true, Size: 3}, &status)
+	assert.EqualError(t, err, "rpc server: transfer b: received 2 bytes, expect 3")
+}
+
+// closingSink 记录写入目标是否被关闭
+type closingSink struct {
+	bytes.Buffer
+	closed int32
+}
+
+func (c *closingSink) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func TestTransferReceiver_AbortAndIdle(t *testing.T) {
+	var mu sync.Mutex
+	opened := make(map[string]*closingSink)
+	open := func(id string) (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opened[id] = new(closingSink)
+		return opened[id], nil
+	}
+	r := NewTransferReceiverWithOptions(open, TransferReceiverOptions{IdleTimeout: 100 * time.Millisecond})
+	var status TransferStatus
+	assert.Nil(t, r.Write(TransferChunk{ID: "a", Data: []byte("hello")}, &status))
+	assert.Nil(t, r.Abort("a", &status))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opened["a"].closed))
+	err := r.Write(TransferChunk{ID: "a", Offset: 5, Data: []byte("x")}, &status)
+	assert.EqualError(t, err, "rpc server: unknown transfer a", "aborted transfers start over")
+
+	// 空闲超时的传输在之后的调用中被放弃
+	assert.Nil(t, r.Write(TransferChunk{ID: "b", Data: []byte("hello")}, &status))
+	time.Sleep(150 * time.Millisecond)
+	status = TransferStatus{}
+	assert.Nil(t, r.Status("c", &status))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opened["b"].closed))
+	assert.Nil(t, r.Status("b", &status))
+	assert.Equal(t, int64(0), status.Offset)
+}
+
+func TestTransferReceiver_DoneRecord(t *testing.T) {
+	r := NewTransferReceiverWithOptions(new(sinks).open, TransferReceiverOptions{DoneTTL: 100 * time.Millisecond})
+	sum := sha256.Sum256([]byte("hello"))
+	last := TransferChunk{ID: "a", Data: []byte("hello"), Last: true, Size: 5, Checksum: sum[:]}
+	var status TransferStatus
+	assert.Nil(t, r.Write(last, &status))
+	assert.Equal(t, TransferStatus{Offset: 5, Done: true}, status)
+
+	// 确认丢失后重发的最后一个分块与查询都报告完成，数据不会重复写入
+	status = TransferStatus{}
+	assert.Nil(t, r.Write(TransferChunk{ID: "a", Offset: 5, Last: true, Size: 5, Checksum: sum[:]}, &status))
+	assert.Equal(t, TransferStatus{Offset: 5, Done: true}, status)
+	status = TransferStatus{}
+	assert.Nil(t, r.Status("a", &status))
+	assert.Equal(t, TransferStatus{Offset: 5, Done: true}, status)
+
+	time.Sleep(150 * time.Millisecond)
+	status = TransferStatus{}
+	assert.Nil(t, r.Status("a", &status))
+	assert.Equal(t, TransferStatus{}, status, "done records expire")
+}
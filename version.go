@@ -0,0 +1,75 @@
+package geerpc
+
+import (
+	"errors"
+	"strings"
+)
+
+// RegisterVersion 以 version 版本注册名为 name 的服务，同一服务的多个版本可以同时存在
+// 客户端通过 "Foo/v2.Sum" 或 "Foo.Sum@v2" 调用指定版本；不带版本的 "Foo.Sum" 调用 SetDefaultVersion 设置的默认版本，
+// 没有设置默认版本时调用不带版本注册的服务
+func (server *Server) RegisterVersion(name, version string, rcvr interface{}) error {
+	return server.register(rcvr, ServiceOptions{Name: name, Version: version})
+}
+
+// SetDefaultVersion 设置不带版本的调用使用的版本，version 为空表示使用不带版本注册的服务
+// 设置时不要求该版本已经注册，便于先切换默认版本再注册新版本
+func (server *Server) SetDefaultVersion(name, version string) {
+	if version == "" {
+		server.defaultVersions.Delete(name)
+		return
+	}
+	server.defaultVersions.Store(name, version)
+}
+
+// Unregister 注销指定版本的服务，version 为空表示不带版本注册的服务
+// 注销的版本为默认版本时同时清除默认版本；正在处理的请求不受影响
+func (server *Server) Unregister(name, version string) error {
+	if _, ok := server.serviceMap.LoadAndDelete(serviceKey(name, version)); !ok {
+		return errors.New("rpc server: can't find service " + serviceKey(name, version))
+	}
+	if version != "" {
+		if v, ok := server.defaultVersions.Load(name); ok && v.(string) == version {
+			server.defaultVersions.Delete(name)
+		}
+	}
+	server.log().Info("rpc server: unregister", "service", serviceKey(name, version))
+	return nil
+}
+
+// serviceKey 服务在 serviceMap 中的键，带版本时为 "name@version"
+func serviceKey(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "@" + version
+}
+
+// validVersion 版本不能为空，也不能包含用于解析版本的 '@' 与 '/'
+func validVersion(version string) bool {
+	return version != "" && !strings.ContainsAny(version, "@/ \t\r\n")
+}
+
+// parseServiceMethod 解析 "Service.Method"、"Service/version.Method" 与 "Service.Method@version"
+// 服务名可以包含 '.'，以最后一个 '.' 分隔方法名
+func parseServiceMethod(serviceMethod string) (service, version, method string, err error) {
+	s := serviceMethod
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		s, version = s[:at], s[at+1:]
+		if !validVersion(version) {
+			return "", "", "", errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		}
+	}
+	dot := strings.LastIndex(s, ".")
+	if dot < 0 {
+		return "", "", "", errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+	}
+	service, method = s[:dot], s[dot+1:]
+	if slash := strings.LastIndex(service, "/"); slash >= 0 {
+		if version != "" || !validVersion(service[slash+1:]) {
+			return "", "", "", errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		}
+		service, version = service[:slash], service[slash+1:]
+	}
+	return service, version, method, nil
+}
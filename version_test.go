@@ -0,0 +1,99 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServiceMethod(t *testing.T) {
+	tests := []struct {
+		in                       string
+		service, version, method string
+		ok                       bool
+	}{
+		{"Foo.Sum", "Foo", "", "Sum", true},
+		{"pkg.Foo.Sum", "pkg.Foo", "", "Sum", true},
+		{"Foo/v2.Sum", "Foo", "v2", "Sum", true},
+		{"Foo.Sum@v2", "Foo", "v2", "Sum", true},
+		{"pkg.Foo/v2.Sum", "pkg.Foo", "v2", "Sum", true},
+		{"pkg.Foo.Sum@v2", "pkg.Foo", "v2", "Sum", true},
+		{"Foo/v2.1.Sum", "Foo", "v2.1", "Sum", true},
+		{"Foo.Sum@v2.1", "Foo", "v2.1", "Sum", true},
+		{"Foo", "", "", "", false},
+		{"Foo@v2", "", "", "", false},
+		{"Foo.Sum@", "", "", "", false},
+		{"Foo/.Sum", "", "", "", false},
+		{"Foo/v1.Sum@v2", "", "", "", false},
+	}
+	for _, tt := range tests {
+		service, version, method, err := parseServiceMethod(tt.in)
+		if !tt.ok {
+			assert.EqualError(t, err, "rpc server: service/method request ill-formed: "+tt.in)
+			continue
+		}
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, []string{tt.service, tt.version, tt.method}, []string{service, version, method}, tt.in)
+	}
+}
+
+type Adder int
+
+func (a Adder) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2 + int(a)
+	return nil
+}
+
+func TestServer_RegisterVersion(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.RegisterName("Adder", Adder(100)))
+	assert.Nil(t, server.RegisterVersion("Adder", "v1", Adder(1000)))
+	assert.Nil(t, server.RegisterVersion("Adder", "v2", Adder(2000)))
+	assert.EqualError(t, server.RegisterVersion("Adder", "v2", Adder(0)), "rpc: service already defined: Adder@v2")
+	assert.EqualError(t, server.RegisterVersion("Adder", "v/3", Adder(0)), `rpc server: "v/3" is not a valid service version`)
+	go func() { _ = server.ListenAndServe("tcp", "127.0.0.1:0") }()
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", waitForAddr(t, server))
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	sum := func(method string) (int, error) {
+		var reply int
+		err := client.Call(context.Background(), method, &Args{Num1: 1, Num2: 2}, &reply)
+		return reply, err
+	}
+	for method, want := range map[string]int{
+		"Adder.Sum":    103,
+		"Adder/v1.Sum": 1003,
+		"Adder.Sum@v1": 1003,
+		"Adder/v2.Sum": 2003,
+		"Adder.Sum@v2": 2003,
+	} {
+		got, err := sum(method)
+		assert.Nil(t, err, method)
+		assert.Equal(t, want, got, method)
+	}
+	_, err = sum("Adder.Sum@v3")
+	assert.EqualError(t, err, "rpc server: can't find service Adder@v3")
+
+	server.SetDefaultVersion("Adder", "v2")
+	got, _ := sum("Adder.Sum")
+	assert.Equal(t, 2003, got)
+
+	snap := server.Snapshot()
+	assert.Len(t, snap.Services, 3)
+	assert.Equal(t, []string{"", "v1", "v2"}, []string{snap.Services[0].Version, snap.Services[1].Version, snap.Services[2].Version})
+	assert.Contains(t, server.MethodStats(), "Adder.Sum@v2")
+
+	assert.Nil(t, server.Unregister("Adder", "v2"))
+	assert.EqualError(t, server.Unregister("Adder", "v2"), "rpc server: can't find service Adder@v2")
+	got, err = sum("Adder.Sum")
+	assert.Nil(t, err)
+	assert.Equal(t, 103, got, "unregistering the default version restores the unversioned service")
+	assert.Nil(t, server.Unregister("Adder", ""))
+	_, err = sum("Adder.Sum")
+	assert.EqualError(t, err, "rpc server: can't find service Adder")
+	got, _ = sum("Adder/v1.Sum")
+	assert.Equal(t, 1003, got)
+}
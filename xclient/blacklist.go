@@ -0,0 +1,185 @@
+package xclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBlacklistCooldown    = time.Second
+	defaultBlacklistMaxCooldown = time.Minute
+)
+
+// BlacklistOptions BlacklistDiscovery 的配置
+type BlacklistOptions struct {
+	Cooldown    time.Duration    // 第一次连接失败后排除的时间，0表示使用默认值1秒
+	MaxCooldown time.Duration    // 排除时间的上限，0表示使用默认值1分钟
+	Now         func() time.Time // 返回当前时间，为 nil 时使用 time.Now，用于测试
+}
+
+// blacklistEntry 一个被排除的服务器
+type blacklistEntry struct {
+	until    time.Time
+	cooldown time.Duration
+}
+
+// BlacklistDiscovery 为任意 Discovery 增加黑名单：XClient 报告连接失败的服务器在冷却时间内不会被 Get 与 GetAll 返回，
+// 冷却结束后再次连接失败时冷却时间翻倍（不超过 MaxCooldown），调用成功后清除记录。
+// 所有服务器都在黑名单中时忽略黑名单。与熔断器不同，只有连接失败会加入黑名单
+type BlacklistDiscovery struct {
+	inner   Discovery
+	opts    BlacklistOptions
+	allowed *MultiServersDiscovery // 不在黑名单中的服务器，负责按负载均衡策略选择
+
+	mu      sync.Mutex // protect following
+	entries map[string]*blacklistEntry
+}
+
+var (
+	_ KeyedDiscovery    = (*BlacklistDiscovery)(nil)
+	_ InstanceDiscovery = (*BlacklistDiscovery)(nil)
+	_ FailureReporter   = (*BlacklistDiscovery)(nil)
+	_ SuccessReporter   = (*BlacklistDiscovery)(nil)
+)
+
+// NewBlacklistDiscovery 包装 inner，每次 Get 与 GetAll 时从 inner 读取服务器列表
+func NewBlacklistDiscovery(inner Discovery, opts BlacklistOptions) *BlacklistDiscovery {
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = defaultBlacklistCooldown
+	}
+	if opts.MaxCooldown <= 0 {
+		opts.MaxCooldown = defaultBlacklistMaxCooldown
+	}
+	if opts.MaxCooldown < opts.Cooldown {
+		opts.MaxCooldown = opts.Cooldown
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &BlacklistDiscovery{
+		inner:   inner,
+		opts:    opts,
+		allowed: NewMultiServerDiscovery(nil),
+		entries: make(map[string]*blacklistEntry),
+	}
+}
+
+// ReportFailure 连接失败时将服务器加入黑名单；冷却期间的失败不会延长冷却时间，其他错误被忽略
+func (d *BlacklistDiscovery) ReportFailure(addr string, err error) {
+	var de *DialError
+	if !errors.As(err, &de) {
+		return
+	}
+	now := d.opts.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[addr]
+	if !ok {
+		d.entries[addr] = &blacklistEntry{until: now.Add(d.opts.Cooldown), cooldown: d.opts.Cooldown}
+		return
+	}
+	if now.Before(e.until) {
+		return
+	}
+	e.cooldown *= 2
+	if e.cooldown > d.opts.MaxCooldown {
+		e.cooldown = d.opts.MaxCooldown
+	}
+	e.until = now.Add(e.cooldown)
+}
+
+// ReportSuccess 调用成功时清除服务器的记录，下次连接失败时冷却时间重新从 Cooldown 开始
+func (d *BlacklistDiscovery) ReportSuccess(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, addr)
+}
+
+// Blacklisted 返回正在冷却的服务器及其冷却结束的时间
+func (d *BlacklistDiscovery) Blacklisted() map[string]time.Time {
+	now := d.opts.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := make(map[string]time.Time)
+	for addr, e := range d.entries {
+		if now.Before(e.until) {
+			m[addr] = e.until
+		}
+	}
+	return m
+}
+
+// Clear 清除 addrs 的记录，没有指定服务器时清空黑名单
+func (d *BlacklistDiscovery) Clear(addrs ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(addrs) == 0 {
+		d.entries = make(map[string]*blacklistEntry)
+		return
+	}
+	for _, addr := range addrs {
+		delete(d.entries, addr)
+	}
+}
+
+// sync 用 inner 中不在黑名单中的服务器更新 allowed，全部在黑名单中时使用所有服务器
+func (d *BlacklistDiscovery) sync() error {
+	instances, err := GetAllInstances(d.inner)
+	if err != nil {
+		return err
+	}
+	now := d.opts.Now()
+	d.mu.Lock()
+	allowed := make([]ServerInstance, 0, len(instances))
+	for _, in := range instances {
+		if e, ok := d.entries[in.Addr]; !ok || !now.Before(e.until) {
+			allowed = append(allowed, in)
+		}
+	}
+	d.mu.Unlock()
+	if len(allowed) == 0 {
+		allowed = instances
+	}
+	syncInstances(d.allowed, allowed)
+	return nil
+}
+
+// Refresh 刷新 inner 的服务器列表
+func (d *BlacklistDiscovery) Refresh() error {
+	return d.inner.Refresh()
+}
+
+// Update 更新 inner 的服务器列表
+func (d *BlacklistDiscovery) Update(servers []string) error {
+	return d.inner.Update(servers)
+}
+
+// Get 从不在黑名单中的服务器中选择一个
+func (d *BlacklistDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetWithKey(mode, "")
+}
+
+// GetWithKey 从不在黑名单中的服务器中按 key 选择一个
+func (d *BlacklistDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
+	if err := d.sync(); err != nil {
+		return "", err
+	}
+	return d.allowed.GetWithKey(mode, key)
+}
+
+// GetAll 返回所有不在黑名单中的服务器
+func (d *BlacklistDiscovery) GetAll() ([]string, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.allowed.GetAll()
+}
+
+// GetAllInstances 返回所有不在黑名单中的服务器及其元数据
+func (d *BlacklistDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.allowed.GetAllInstances()
+}
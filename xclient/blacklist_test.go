@@ -0,0 +1,80 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+func TestBlacklistDiscovery_Cooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := NewBlacklistDiscovery(NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"}), BlacklistOptions{
+		Cooldown: time.Second, MaxCooldown: 5 * time.Second, Now: clock.Now,
+	})
+	refused := &DialError{Addr: "tcp@a", Err: errors.New("connection refused")}
+
+	// 只有连接失败会加入黑名单
+	d.ReportFailure("tcp@a", errors.New("method failed"))
+	assert.Empty(t, d.Blacklisted())
+
+	// 冷却时间按 1s 2s 4s 5s 增长，冷却期间的失败不会延长冷却时间
+	for _, cooldown := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		d.ReportFailure("tcp@a", refused)
+		d.ReportFailure("tcp@a", refused)
+		assert.Equal(t, map[string]time.Time{"tcp@a": clock.Now().Add(cooldown)}, d.Blacklisted())
+		servers, err := d.GetAll()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"tcp@b"}, servers)
+		for i := 0; i < 5; i++ {
+			addr, err := d.Get(RandomSelect)
+			assert.Nil(t, err)
+			assert.Equal(t, "tcp@b", addr)
+		}
+		clock.Advance(cooldown)
+		servers, _ = d.GetAll()
+		assert.Equal(t, []string{"tcp@a", "tcp@b"}, servers)
+	}
+
+	// 调用成功后重新从 Cooldown 开始
+	d.ReportSuccess("tcp@a")
+	d.ReportFailure("tcp@a", refused)
+	assert.Equal(t, clock.Now().Add(time.Second), d.Blacklisted()["tcp@a"])
+
+	// 所有服务器都在黑名单中时忽略黑名单
+	d.ReportFailure("tcp@b", refused)
+	servers, _ := d.GetAll()
+	assert.Equal(t, []string{"tcp@a", "tcp@b"}, servers)
+
+	d.Clear("tcp@a")
+	assert.Len(t, d.Blacklisted(), 1)
+	d.Clear()
+	assert.Empty(t, d.Blacklisted())
+}
+
+func TestXClient_Blacklist(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	_, okAddr := startServer(t, geerpc.WorkerPool{})
+	dead := deadAddr(t)
+	d := NewBlacklistDiscovery(NewMultiServerDiscovery([]string{okAddr, dead}), BlacklistOptions{Now: clock.Now})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 广播时连接失败的服务器被加入黑名单，之后的广播跳过它
+	var reply int
+	assert.NotNil(t, xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Contains(t, d.Blacklisted(), dead)
+	assert.Nil(t, xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	}
+	assert.Equal(t, uint64(1), xc.Stats()[dead].Calls)
+
+	// 冷却结束后再次尝试
+	clock.Advance(time.Second)
+	assert.NotNil(t, xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, clock.Now().Add(2*time.Second), d.Blacklisted()[dead])
+}
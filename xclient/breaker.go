@@ -0,0 +1,251 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 服务器的熔断器处于打开状态，调用没有发出
+var ErrBreakerOpen = errors.New("rpc client: circuit breaker is open")
+
+// BreakerState 熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常调用
+	BreakerOpen                         // 不再向服务器发出调用，直到冷却时间结束
+	BreakerHalfOpen                     // 冷却时间结束，只允许一个探测调用，成功后关闭，失败后重新打开
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions 熔断器的配置，每个服务器地址有独立的熔断器
+type BreakerOptions struct {
+	Window      time.Duration // 统计失败的时间窗口，默认10秒
+	MaxFailures int           // 窗口内失败次数达到该值时打开，默认5，小于0表示不按次数判断
+	// FailureRate 窗口内调用数不少于 MinRequests 且失败率达到该值时打开，0表示不按失败率判断
+	FailureRate float64
+	MinRequests int           // 按失败率判断的最少调用数，默认10
+	Cooldown    time.Duration // 打开后经过该时间进入半开状态，默认5秒
+	// BroadcastOpen 为 true 时广播仍然调用熔断器打开的服务器，默认跳过
+	BroadcastOpen bool
+
+	// IsFailure 判断调用的错误是否计为服务器故障，为 nil 时只计入连接失败与连接断开，方法返回的错误不计入
+	IsFailure func(err error) bool
+	// OnStateChange 熔断器状态变化时的回调
+	OnStateChange func(addr string, from, to BreakerState)
+	// Now 返回当前时间，为 nil 时使用 time.Now，用于测试
+	Now func() time.Time
+}
+
+// breaker 单个服务器的熔断器
+type breaker struct {
+	state       BreakerState
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	probing     bool // 半开状态下探测调用是否已经发出
+}
+
+// breakerSet 各个服务器的熔断器
+type breakerSet struct {
+	opts    BreakerOptions
+	observe func(addr string, to BreakerState) // XClient 的状态变化回调，可以为 nil
+
+	mu       sync.Mutex // protect following
+	breakers map[string]*breaker
+}
+
+type breakerTransition struct {
+	addr     string
+	from, to BreakerState
+}
+
+func newBreakerSet(opts BreakerOptions) *breakerSet {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MaxFailures == 0 {
+		opts.MaxFailures = 5
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 5 * time.Second
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isConnFailure
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &breakerSet{opts: opts, breakers: make(map[string]*breaker)}
+}
+
+// get 返回 addr 的熔断器，不存在时创建，需要持有 s.mu
+func (s *breakerSet) get(addr string, now time.Time) *breaker {
+	b := s.breakers[addr]
+	if b == nil {
+		b = &breaker{windowStart: now}
+		s.breakers[addr] = b
+	}
+	return b
+}
+
+// notify 在不持有 s.mu 时调用状态变化的回调
+func (s *breakerSet) notify(t *breakerTransition) {
+	if t == nil {
+		return
+	}
+	if s.observe != nil {
+		s.observe(t.addr, t.to)
+	}
+	if s.opts.OnStateChange != nil {
+		s.opts.OnStateChange(t.addr, t.from, t.to)
+	}
+}
+
+// allow 返回是否可以向 addr 发出调用，冷却时间结束后的第一个调用作为探测调用
+func (s *breakerSet) allow(addr string) bool {
+	if s == nil {
+		return true
+	}
+	now := s.opts.Now()
+	s.mu.Lock()
+	b := s.get(addr, now)
+	var t *breakerTransition
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < s.opts.Cooldown {
+			allowed = false
+			break
+		}
+		t = &breakerTransition{addr, BreakerOpen, BreakerHalfOpen}
+		b.state, b.probing = BreakerHalfOpen, true
+	case BreakerHalfOpen:
+		allowed = !b.probing
+		b.probing = true
+	}
+	s.mu.Unlock()
+	s.notify(t)
+	return allowed
+}
+
+// record 记录发往 addr 的调用的结果
+func (s *breakerSet) record(addr string, err error) {
+	if s == nil || errors.Is(err, ErrBreakerOpen) {
+		return
+	}
+	failed := err != nil && s.opts.IsFailure(err)
+	// 调用方取消的探测调用既不算成功也不算失败，允许下一个调用继续探测
+	canceled := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	now := s.opts.Now()
+	s.mu.Lock()
+	b := s.get(addr, now)
+	var t *breakerTransition
+	switch b.state {
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= s.opts.Window {
+			b.windowStart, b.total, b.failures = now, 0, 0
+		}
+		b.total++
+		if failed {
+			b.failures++
+		}
+		if (s.opts.MaxFailures > 0 && b.failures >= s.opts.MaxFailures) ||
+			(s.opts.FailureRate > 0 && b.total >= s.opts.MinRequests &&
+				float64(b.failures) >= s.opts.FailureRate*float64(b.total)) {
+			t = &breakerTransition{addr, BreakerClosed, BreakerOpen}
+			b.state, b.openedAt = BreakerOpen, now
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+		if canceled && !failed {
+			break
+		}
+		if failed {
+			t = &breakerTransition{addr, BreakerHalfOpen, BreakerOpen}
+			b.state, b.openedAt = BreakerOpen, now
+		} else {
+			t = &breakerTransition{addr, BreakerHalfOpen, BreakerClosed}
+			b.state, b.windowStart, b.total, b.failures = BreakerClosed, now, 0, 0
+		}
+	}
+	s.mu.Unlock()
+	s.notify(t)
+}
+
+// isOpen 返回 addr 的熔断器是否打开且还在冷却时间内
+func (s *breakerSet) isOpen(addr string) bool {
+	if s == nil {
+		return false
+	}
+	now := s.opts.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.breakers[addr]
+	return b != nil && b.state == BreakerOpen && now.Sub(b.openedAt) < s.opts.Cooldown
+}
+
+// states 返回各个服务器熔断器的状态
+func (s *breakerSet) states() map[string]BreakerState {
+	m := make(map[string]BreakerState)
+	if s == nil {
+		return m
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for addr, b := range s.breakers {
+		m[addr] = b.state
+	}
+	return m
+}
+
+// SetBreaker 为每个服务器启用熔断器，熔断器打开的服务器在选择与重试时被跳过；默认不启用
+func (xc *XClient) SetBreaker(opts BreakerOptions) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.breakers = newBreakerSet(opts)
+	xc.breakers.observe = xc.breakerChanged
+}
+
+// Breakers 返回各个服务器熔断器的状态，键为服务器地址，没有启用熔断器时为空
+func (xc *XClient) Breakers() map[string]BreakerState {
+	return xc.breakerSet().states()
+}
+
+func (xc *XClient) breakerSet() *breakerSet {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.breakers
+}
+
+// broadcastServers 返回广播的服务器，没有设置 BroadcastOpen 时跳过熔断器打开的服务器
+func (xc *XClient) broadcastServers(servers []string) []string {
+	bs := xc.breakerSet()
+	if bs == nil || bs.opts.BroadcastOpen {
+		return servers
+	}
+	filtered := make([]string, 0, len(servers))
+	for _, addr := range servers {
+		if !bs.isOpen(addr) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
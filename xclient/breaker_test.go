@@ -0,0 +1,205 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// Flaky fail 不为0时返回错误的服务
+type Flaky struct{ fail int32 }
+
+func (f *Flaky) Do(args int, reply *int) error {
+	if atomic.LoadInt32(&f.fail) != 0 {
+		return errors.New("flaky failed")
+	}
+	*reply = args
+	return nil
+}
+
+// Sleep 等待 ms 毫秒后返回
+func (f *Flaky) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func startFlakyServer(t *testing.T, f *Flaky) (*geerpc.Server, string) {
+	server, addr := startServer(t, geerpc.WorkerPool{})
+	assert.Nil(t, server.Register(f))
+	return server, addr
+}
+
+func anyError(err error) bool { return err != nil }
+
+func TestXClient_BreakerTransitions(t *testing.T) {
+	flaky := &Flaky{fail: 1}
+	server, addr := startFlakyServer(t, flaky)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var transitions []string
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(BreakerOptions{
+		MaxFailures: 2,
+		Cooldown:    time.Minute,
+		IsFailure:   anyError,
+		Now:         clock.Now,
+		OnStateChange: func(a string, from, to BreakerState) {
+			assert.Equal(t, addr, a)
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	call := func() error {
+		var reply int
+		return xc.Call(context.Background(), "Flaky.Do", 1, &reply)
+	}
+
+	assert.EqualError(t, call(), "flaky failed")
+	assert.Equal(t, BreakerClosed, xc.Breakers()[addr])
+	assert.EqualError(t, call(), "flaky failed")
+	assert.Equal(t, BreakerOpen, xc.Breakers()[addr])
+
+	// 打开后不再发出调用
+	assert.Equal(t, ErrBreakerOpen, call())
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, ErrBreakerOpen, call())
+	assert.Equal(t, uint64(2), server.Stats().TotalRequests)
+
+	// 冷却结束后的探测失败，重新打开
+	clock.Advance(30 * time.Second)
+	assert.EqualError(t, call(), "flaky failed")
+	assert.Equal(t, BreakerOpen, xc.Breakers()[addr])
+	assert.Equal(t, ErrBreakerOpen, call())
+
+	// 探测成功后关闭
+	atomic.StoreInt32(&flaky.fail, 0)
+	clock.Advance(time.Minute)
+	assert.Nil(t, call())
+	assert.Equal(t, BreakerClosed, xc.Breakers()[addr])
+	assert.Nil(t, call())
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed",
+	}, transitions)
+}
+
+func TestXClient_BreakerHalfOpenSingleProbe(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	bs := newBreakerSet(BreakerOptions{MaxFailures: 1, Cooldown: time.Second, Now: clock.Now})
+	bs.record("a", &DialError{Addr: "a", Err: errors.New("refused")})
+	assert.False(t, bs.allow("a"))
+	clock.Advance(time.Second)
+	assert.True(t, bs.allow("a"))
+	assert.False(t, bs.allow("a"), "only one probe in half-open")
+	bs.record("a", context.Canceled)
+	assert.Equal(t, BreakerHalfOpen, bs.states()["a"])
+	assert.True(t, bs.allow("a"), "canceled probe lets the next call probe")
+	bs.record("a", errors.New("handler error"))
+	assert.Equal(t, BreakerClosed, bs.states()["a"], "handler errors do not count by default")
+
+	// 按失败率打开
+	bs = newBreakerSet(BreakerOptions{MaxFailures: -1, FailureRate: 0.5, MinRequests: 4, Window: time.Second, Now: clock.Now})
+	refused := &DialError{Addr: "b", Err: errors.New("refused")}
+	for _, err := range []error{refused, nil, refused} {
+		bs.record("b", err)
+	}
+	assert.Equal(t, BreakerClosed, bs.states()["b"])
+	clock.Advance(time.Second) // 新的窗口
+	for _, err := range []error{refused, nil, refused, nil} {
+		bs.record("b", err)
+	}
+	assert.Equal(t, BreakerOpen, bs.states()["b"])
+}
+
+func TestXClient_BreakerCanceledProbe(t *testing.T) {
+	flaky := &Flaky{fail: 1}
+	_, addr := startFlakyServer(t, flaky)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(BreakerOptions{
+		MaxFailures: 1,
+		Cooldown:    time.Minute,
+		IsFailure:   func(err error) bool { return err != nil && err.Error() == "flaky failed" },
+		Now:         clock.Now,
+	})
+	var reply int
+	assert.EqualError(t, xc.Call(context.Background(), "Flaky.Do", 1, &reply), "flaky failed")
+	assert.Equal(t, BreakerOpen, xc.Breakers()[addr])
+
+	// 探测调用在服务器响应前超时，既不算成功也不算失败
+	clock.Advance(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := xc.Call(ctx, "Flaky.Sleep", 300, &reply)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Equal(t, BreakerHalfOpen, xc.Breakers()[addr])
+
+	atomic.StoreInt32(&flaky.fail, 0)
+	assert.Nil(t, xc.Call(context.Background(), "Flaky.Do", 1, &reply), "the next call probes")
+	assert.Equal(t, BreakerClosed, xc.Breakers()[addr])
+}
+
+func TestXClient_BreakerFailoverAndBroadcast(t *testing.T) {
+	flaky := &Flaky{fail: 1}
+	bad, badAddr := startFlakyServer(t, flaky)
+	good, goodAddr := startFlakyServer(t, &Flaky{})
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	xc := NewXClient(NewMultiServerDiscovery([]string{badAddr, goodAddr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(BreakerOptions{MaxFailures: 2, Cooldown: time.Minute, IsFailure: anyError, Now: clock.Now})
+
+	for i := 0; i < 4; i++ {
+		_ = xc.Call(context.Background(), "Flaky.Do", 1, new(int))
+	}
+	assert.Equal(t, BreakerOpen, xc.Breakers()[badAddr])
+	badRequests := bad.Stats().TotalRequests
+
+	// 熔断器打开的服务器被跳过，所有调用都成功
+	xc.SetFailover(FailoverPolicy{MaxAttempts: 1})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, xc.Call(context.Background(), "Flaky.Do", 1, new(int)))
+	}
+	assert.Equal(t, badRequests, bad.Stats().TotalRequests)
+
+	results, err := xc.BroadcastDetailed(context.Background(), "Flaky.Do", 1, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[goodAddr].Err)
+	assert.Nil(t, xc.Broadcast(context.Background(), "Flaky.Do", 1, new(int)))
+	assert.Equal(t, badRequests, bad.Stats().TotalRequests)
+
+	// BroadcastOpen 时广播仍然调用
+	goodRequests := good.Stats().TotalRequests
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Minute, IsFailure: anyError, Now: clock.Now, BroadcastOpen: true})
+	_, _ = xc.BroadcastDetailed(context.Background(), "Flaky.Do", 1, nil)
+	assert.Equal(t, BreakerOpen, xc.Breakers()[badAddr])
+	results, err = xc.BroadcastDetailed(context.Background(), "Flaky.Do", 1, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.EqualError(t, results[badAddr].Err, "flaky failed")
+	assert.Equal(t, badRequests+2, bad.Stats().TotalRequests)
+	assert.Equal(t, goodRequests+2, good.Stats().TotalRequests)
+}
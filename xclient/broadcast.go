@@ -0,0 +1,258 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BroadcastResult 广播中一个服务器的调用结果
+type BroadcastResult struct {
+	Addr     string
+	Reply    interface{}   // newReply 为该服务器创建的应答，Err 不为 nil 时内容无意义
+	Err      error         // 调用的错误，ctx 结束时还没有完成的调用为 ctx.Err()
+	Duration time.Duration // 调用的耗时，没有完成的调用为等待的时间
+}
+
+// BroadcastDetailed 向所有服务器调用命名函数，返回每个服务器的结果，键为服务器地址
+// newReply 为每个服务器创建独立的应答，为 nil 时不解码应答；只有所有服务器都失败时才返回错误。
+// 熔断器打开的服务器默认不参与广播，不出现在结果中。
+// ctx 结束或超过 BroadcastOptions.Timeout 时不再等待没有完成的调用，这些服务器的结果记为 ctx.Err()
+func (xc *XClient) BroadcastDetailed(ctx context.Context, serviceMethod string, args interface{}, newReply func() interface{}) (map[string]BroadcastResult, error) {
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	servers = xc.broadcastServers(servers)
+	if len(servers) == 0 {
+		return nil, errors.New("rpc discovery: no available servers")
+	}
+	opts, ctx, cancel := xc.broadcastContext(ctx)
+	defer cancel()
+	sem := newSemaphore(opts.MaxConcurrency)
+	start := time.Now()
+	ch := make(chan BroadcastResult, len(servers)) // 带缓冲，不再等待后仍未完成的调用也能退出
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			if !sem.acquire(ctx) {
+				return // 由下面等待 ctx 结束的分支记录
+			}
+			defer sem.release()
+			var reply interface{}
+			if newReply != nil {
+				reply = newReply()
+			}
+			begin := time.Now()
+			err := xc.callBroadcast(rpcAddr, ctx, serviceMethod, args, reply)
+			ch <- BroadcastResult{Addr: rpcAddr, Reply: reply, Err: err, Duration: time.Since(begin)}
+		}(rpcAddr)
+	}
+
+	results := make(map[string]BroadcastResult, len(servers))
+	var firstErr error
+	failed := 0
+	for len(results) < len(servers) {
+		select {
+		case r := <-ch:
+			results[r.Addr] = r
+			if r.Err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = r.Err
+				}
+			}
+		case <-ctx.Done():
+			for _, rpcAddr := range servers {
+				if _, ok := results[rpcAddr]; !ok {
+					results[rpcAddr] = BroadcastResult{Addr: rpcAddr, Err: ctx.Err(), Duration: time.Since(start)}
+					failed++
+					if firstErr == nil {
+						firstErr = ctx.Err()
+					}
+				}
+			}
+		}
+	}
+	if failed == len(servers) {
+		return results, fmt.Errorf("rpc client: broadcast %s: all %d servers failed: %w", serviceMethod, failed, firstErr)
+	}
+	return results, nil
+}
+
+// BroadcastOptions Broadcast 与 BroadcastDetailed 的选项
+type BroadcastOptions struct {
+	MaxConcurrency int           // 同时进行的调用数的上限，0表示不限制
+	Timeout        time.Duration // 整个广播的超时时间，与调用的 ctx 先结束者生效，0表示不限制
+	// FailFast 为 true 时 Broadcast 的第一个错误取消其余的调用，为 false 时等待所有调用完成，默认为 true
+	// BroadcastDetailed 总是等待所有调用完成
+	FailFast bool
+	// CancelAfterQuorum 为 true 时 BroadcastQuorum 达到法定数量后取消其余的调用，默认其余的调用在后台继续
+	CancelAfterQuorum bool
+}
+
+// SetBroadcastOptions 设置广播的选项，默认不限制并发数与超时时间，第一个错误取消其余的调用
+func (xc *XClient) SetBroadcastOptions(opts BroadcastOptions) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.broadcast = opts
+}
+
+// broadcastContext 返回广播使用的选项与 ctx，设置了 Timeout 时为 ctx 加上超时
+func (xc *XClient) broadcastContext(ctx context.Context) (BroadcastOptions, context.Context, context.CancelFunc) {
+	xc.mu.Lock()
+	opts := xc.broadcast
+	xc.mu.Unlock()
+	if opts.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		return opts, ctx, cancel
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return opts, ctx, cancel
+}
+
+// semaphore 限制同时进行的调用数，为 nil 时不限制
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire 获取一个名额，ctx 先结束时返回 false
+func (s semaphore) acquire(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// BroadcastError 广播中失败的调用，Errors 的键为服务器地址；errors.Is 匹配其中任意一个错误
+type BroadcastError struct {
+	Method string
+	Errors map[string]error
+}
+
+func (e *BroadcastError) Error() string {
+	addrs := make([]string, 0, len(e.Errors))
+	for addr := range e.Errors {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = addr + ": " + e.Errors[addr].Error()
+	}
+	return fmt.Sprintf("rpc client: broadcast %s: %d servers failed: %s", e.Method, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *BroadcastError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// BroadcastQuorum 向所有服务器调用命名函数，k 个服务器成功后立即返回，将第一个成功的应答写入 reply；
+// 失败的服务器多于 len(servers)-k 时返回 *BroadcastError。其余的调用默认在后台继续，
+// 设置了 BroadcastOptions.CancelAfterQuorum 时被取消
+func (xc *XClient) BroadcastQuorum(ctx context.Context, serviceMethod string, args, reply interface{}, k int) error {
+	xc.mu.Lock()
+	cancelRest := xc.broadcast.CancelAfterQuorum
+	xc.mu.Unlock()
+	return xc.quorum(ctx, serviceMethod, args, reply, k, cancelRest)
+}
+
+// CallAny 同时调用所有服务器，返回第一个成功的应答并取消其余的调用，所有服务器都失败时返回 *BroadcastError
+func (xc *XClient) CallAny(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return xc.quorum(ctx, serviceMethod, args, reply, 1, true)
+}
+
+// quorumResult 一个服务器的结果，reply 是该服务器单独的应答
+type quorumResult struct {
+	addr  string
+	reply reflect.Value
+	err   error
+}
+
+// quorum 等待 k 个服务器成功，每个服务器的应答解码到单独的 reply 中，只有第一个成功的应答写入 reply
+func (xc *XClient) quorum(ctx context.Context, serviceMethod string, args, reply interface{}, k int, cancelRest bool) error {
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return err
+	}
+	servers = xc.broadcastServers(servers)
+	if len(servers) == 0 {
+		return errors.New("rpc discovery: no available servers")
+	}
+	if k <= 0 || k > len(servers) {
+		return fmt.Errorf("rpc client: quorum %d out of range for %d servers", k, len(servers))
+	}
+	opts, ctx, cancel := xc.broadcastContext(ctx)
+	sem := newSemaphore(opts.MaxConcurrency)
+	ch := make(chan quorumResult, len(servers)) // 带缓冲，返回后仍在进行的调用也能退出
+	var wg sync.WaitGroup
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			if !sem.acquire(ctx) {
+				ch <- quorumResult{addr: rpcAddr, err: ctx.Err()}
+				return
+			}
+			defer sem.release()
+			var r reflect.Value
+			var clonedReply interface{}
+			if reply != nil {
+				r = reflect.New(reflect.TypeOf(reply).Elem())
+				clonedReply = r.Interface()
+			}
+			err := xc.callBroadcast(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			ch <- quorumResult{addr: rpcAddr, reply: r, err: err}
+		}(rpcAddr)
+	}
+	if cancelRest {
+		defer cancel()
+	} else {
+		go func() {
+			wg.Wait()
+			cancel()
+		}()
+	}
+
+	errs := make(map[string]error)
+	for succeeded := 0; succeeded < k; {
+		r := <-ch
+		if r.err != nil {
+			errs[r.addr] = r.err
+			if len(errs) > len(servers)-k {
+				return &BroadcastError{Method: serviceMethod, Errors: errs}
+			}
+			continue
+		}
+		if succeeded == 0 && reply != nil {
+			reflect.ValueOf(reply).Elem().Set(r.reply.Elem())
+		}
+		succeeded++
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// Echo 返回服务器名字的服务，fail 为 true 时返回错误，每次调用耗时 lag
+type Echo struct {
+	name string
+	fail bool
+	lag  time.Duration
+}
+
+func (e *Echo) Name(args int, reply *string) error {
+	time.Sleep(e.lag)
+	if e.fail {
+		return errors.New(e.name + " failed")
+	}
+	*reply = e.name
+	return nil
+}
+
+func startEchoServer(t *testing.T, e *Echo) string {
+	server, addr := startServer(t, geerpc.WorkerPool{})
+	assert.Nil(t, server.Register(e))
+	return addr
+}
+
+func TestXClient_BroadcastDetailed(t *testing.T) {
+	okAddr := startEchoServer(t, &Echo{name: "ok"})
+	failAddr := startEchoServer(t, &Echo{name: "bad", fail: true})
+	slowAddr := startEchoServer(t, &Echo{name: "slow", lag: 300 * time.Millisecond})
+	xc := NewXClient(NewMultiServerDiscovery([]string{okAddr, failAddr, slowAddr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	newReply := func() interface{} { return new(string) }
+
+	results, err := xc.BroadcastDetailed(context.Background(), "Echo.Name", 0, newReply)
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Nil(t, results[okAddr].Err)
+	assert.Equal(t, "ok", *results[okAddr].Reply.(*string))
+	assert.Equal(t, okAddr, results[okAddr].Addr)
+	assert.EqualError(t, results[failAddr].Err, "bad failed")
+	assert.Nil(t, results[slowAddr].Err)
+	assert.Equal(t, "slow", *results[slowAddr].Reply.(*string))
+	assert.GreaterOrEqual(t, int64(results[slowAddr].Duration), int64(300*time.Millisecond))
+
+	// ctx 结束时不等待慢的服务器
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, err = xc.BroadcastDetailed(ctx, "Echo.Name", 0, newReply)
+	assert.Nil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(250*time.Millisecond))
+	assert.Nil(t, results[okAddr].Err)
+	assert.True(t, errors.Is(results[slowAddr].Err, context.DeadlineExceeded))
+
+	// 所有服务器都失败
+	xc2 := NewXClient(NewMultiServerDiscovery([]string{failAddr, deadAddr(t)}), RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	results, err = xc2.BroadcastDetailed(context.Background(), "Echo.Name", 0, nil)
+	assert.NotNil(t, err)
+	assert.Len(t, results, 2)
+
+	xc3 := NewXClient(NewMultiServerDiscovery(nil), RandomSelect, nil)
+	_, err = xc3.BroadcastDetailed(context.Background(), "Echo.Name", 0, nil)
+	assert.NotNil(t, err)
+}
+
+// Gauge 统计所有测试服务器上同时处理的调用数
+type Gauge struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	total    int
+}
+
+func (g *Gauge) Work(lag time.Duration, reply *int) error {
+	g.mu.Lock()
+	g.inFlight++
+	g.total++
+	if g.inFlight > g.max {
+		g.max = g.inFlight
+	}
+	g.mu.Unlock()
+	time.Sleep(lag)
+	g.mu.Lock()
+	g.inFlight--
+	g.mu.Unlock()
+	return nil
+}
+
+func TestXClient_BroadcastConcurrency(t *testing.T) {
+	g := new(Gauge)
+	var servers []string
+	for i := 0; i < 20; i++ {
+		server, addr := startServer(t, geerpc.WorkerPool{})
+		assert.Nil(t, server.Register(g))
+		servers = append(servers, addr)
+	}
+	xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBroadcastOptions(BroadcastOptions{MaxConcurrency: 5, FailFast: true})
+
+	assert.Nil(t, xc.Broadcast(context.Background(), "Gauge.Work", 20*time.Millisecond, new(int)))
+	g.mu.Lock()
+	assert.Equal(t, 20, g.total)
+	assert.LessOrEqual(t, g.max, 5)
+	assert.Equal(t, 5, g.max)
+	g.max, g.total = 0, 0
+	g.mu.Unlock()
+
+	results, err := xc.BroadcastDetailed(context.Background(), "Gauge.Work", 20*time.Millisecond, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 20)
+	g.mu.Lock()
+	assert.Equal(t, 20, g.total)
+	assert.LessOrEqual(t, g.max, 5)
+	g.mu.Unlock()
+
+	// 整体超时与调用的 ctx 先结束者生效，等待名额的调用不再发出
+	xc.SetBroadcastOptions(BroadcastOptions{MaxConcurrency: 5, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	err = xc.Broadcast(context.Background(), "Gauge.Work", 200*time.Millisecond, new(int))
+	assert.NotNil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(150*time.Millisecond))
+	results, err = xc.BroadcastDetailed(context.Background(), "Gauge.Work", 200*time.Millisecond, nil)
+	assert.NotNil(t, err)
+	assert.Len(t, results, 20)
+}
+
+func TestXClient_BroadcastFailFast(t *testing.T) {
+	failAddr := startEchoServer(t, &Echo{name: "bad", fail: true})
+	slowAddr := startEchoServer(t, &Echo{name: "slow", lag: 300 * time.Millisecond})
+	xc := NewXClient(NewMultiServerDiscovery([]string{failAddr, slowAddr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 默认第一个错误取消其余调用
+	start := time.Now()
+	var reply string
+	assert.EqualError(t, xc.Broadcast(context.Background(), "Echo.Name", 0, &reply), "bad failed")
+	assert.Less(t, int64(time.Since(start)), int64(150*time.Millisecond))
+	assert.Equal(t, "", reply)
+
+	// 关闭后等待所有调用完成，成功的应答仍然写入 reply
+	xc.SetBroadcastOptions(BroadcastOptions{})
+	start = time.Now()
+	assert.EqualError(t, xc.Broadcast(context.Background(), "Echo.Name", 0, &reply), "bad failed")
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+	assert.Equal(t, "slow", reply)
+}
+
+func TestXClient_BroadcastQuorum(t *testing.T) {
+	fastAddr := startEchoServer(t, &Echo{name: "fast", lag: 10 * time.Millisecond})
+	okAddr := startEchoServer(t, &Echo{name: "ok", lag: 50 * time.Millisecond})
+	slowAddr := startEchoServer(t, &Echo{name: "slow", lag: 300 * time.Millisecond})
+	badAddr := startEchoServer(t, &Echo{name: "bad", fail: true})
+	xc := NewXClient(NewMultiServerDiscovery([]string{fastAddr, okAddr, slowAddr, badAddr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 两个服务器成功后立即返回，其余的调用在后台继续，之后的应答不会写入 reply
+	var reply string
+	start := time.Now()
+	assert.Nil(t, xc.BroadcastQuorum(context.Background(), "Echo.Name", 0, &reply, 2))
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	assert.Equal(t, "fast", reply)
+	assert.Eventually(t, func() bool {
+		s := xc.Stats()[slowAddr]
+		return s.Calls == 1 && s.InFlight == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Zero(t, xc.Stats()[slowAddr].Errors)
+	assert.Equal(t, "fast", reply)
+
+	// 设置 CancelAfterQuorum 时取消其余的调用
+	xc.SetBroadcastOptions(BroadcastOptions{CancelAfterQuorum: true})
+	assert.Nil(t, xc.BroadcastQuorum(context.Background(), "Echo.Name", 0, &reply, 2))
+	assert.Eventually(t, func() bool {
+		s := xc.Stats()[slowAddr]
+		return s.Calls == 2 && s.InFlight == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(1), xc.Stats()[slowAddr].Errors)
+
+	// 不可能达到法定数量时立即返回
+	start = time.Now()
+	err := xc.BroadcastQuorum(context.Background(), "Echo.Name", 0, &reply, 4)
+	var be *BroadcastError
+	assert.True(t, errors.As(err, &be))
+	assert.Equal(t, []string{badAddr}, keys(be.Errors))
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+	assert.NotNil(t, xc.BroadcastQuorum(context.Background(), "Echo.Name", 0, &reply, 5))
+	assert.NotNil(t, xc.BroadcastQuorum(context.Background(), "Echo.Name", 0, &reply, 0))
+}
+
+func TestXClient_CallAny(t *testing.T) {
+	fastAddr := startEchoServer(t, &Echo{name: "fast", lag: 20 * time.Millisecond})
+	slowAddr := startEchoServer(t, &Echo{name: "slow", lag: 300 * time.Millisecond})
+	badAddr := startEchoServer(t, &Echo{name: "bad", fail: true})
+	d := NewMultiServerDiscovery([]string{fastAddr, slowAddr, badAddr})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply string
+	start := time.Now()
+	assert.Nil(t, xc.CallAny(context.Background(), "Echo.Name", 0, &reply))
+	assert.Equal(t, "fast", reply)
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	// 其余的调用被取消
+	assert.Eventually(t, func() bool {
+		s := xc.Stats()[slowAddr]
+		return s.Calls == 1 && s.Errors == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// 所有服务器都失败时返回所有错误
+	bad2Addr := startEchoServer(t, &Echo{name: "bad2", fail: true})
+	assert.Nil(t, d.Update([]string{badAddr, bad2Addr}))
+	err := xc.CallAny(context.Background(), "Echo.Name", 0, &reply)
+	var be *BroadcastError
+	assert.True(t, errors.As(err, &be))
+	assert.ElementsMatch(t, []string{badAddr, bad2Addr}, keys(be.Errors))
+	assert.Contains(t, err.Error(), "bad failed")
+	assert.Contains(t, err.Error(), "bad2 failed")
+	assert.Equal(t, "fast", reply)
+
+	// errors.Is 匹配其中任意一个错误
+	be = &BroadcastError{Method: "Echo.Name", Errors: map[string]error{
+		"tcp@a": &DialError{Addr: "tcp@a", Err: context.DeadlineExceeded},
+		"tcp@b": errors.New("boom"),
+	}}
+	assert.True(t, errors.Is(be, context.DeadlineExceeded))
+	assert.False(t, errors.Is(be, context.Canceled))
+	assert.Equal(t, "rpc client: broadcast Echo.Name: 2 servers failed: "+
+		"tcp@a: rpc client: dial tcp@a: context deadline exceeded; tcp@b: boom", be.Error())
+}
+
+func keys(m map[string]error) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
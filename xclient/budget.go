@@ -0,0 +1,97 @@
+package xclient
+
+import (
+	"context"
+	"time"
+)
+
+// BudgetStrategy 决定每次尝试可以使用的时间，remaining 为 ctx 剩余的时间，ctx 没有截止时间时为0，
+// attemptsLeft 为包括本次在内最多还会尝试的次数；返回值不大于0时本次尝试不单独设置截止时间
+type BudgetStrategy func(remaining time.Duration, attemptsLeft int) time.Duration
+
+// EvenBudget 默认的策略，将剩余的时间平分给剩余的尝试；较早的尝试提前失败时，之后的尝试分到更多的时间
+func EvenBudget(remaining time.Duration, attemptsLeft int) time.Duration {
+	if remaining <= 0 || attemptsLeft <= 1 {
+		return remaining
+	}
+	return remaining / time.Duration(attemptsLeft)
+}
+
+// FixedBudget 每次尝试最多使用 d，不超过剩余的时间，ctx 没有截止时间时同样生效
+func FixedBudget(d time.Duration) BudgetStrategy {
+	return func(remaining time.Duration, attemptsLeft int) time.Duration {
+		if remaining > 0 && remaining < d {
+			return remaining
+		}
+		return d
+	}
+}
+
+// Attempt Call 的一次尝试
+type Attempt struct {
+	Addr     string
+	Start    time.Time
+	Budget   time.Duration // 本次尝试可以使用的时间，0表示只受调用的 ctx 限制
+	Duration time.Duration
+	Err      error
+}
+
+// CallInfo 记录 Call 的每次尝试，用于调试，对冲请求不会记录
+type CallInfo struct {
+	Attempts []Attempt
+}
+
+type callInfoKey struct{}
+
+// WithCallInfo 返回携带 info 的 ctx，使用该 ctx 的 Call 将每次尝试追加到 info.Attempts；info 不能被并发的调用共用
+func WithCallInfo(ctx context.Context, info *CallInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, info)
+}
+
+// attemptContext 按 FailoverPolicy.Budget 为一次尝试设置截止时间，返回本次尝试可以使用的时间，
+// 分得的时间不少于剩余的时间时直接使用 ctx
+func (p *FailoverPolicy) attemptContext(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc, time.Duration) {
+	var remaining time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining = deadline.Sub(p.now()); remaining <= 0 {
+			// ctx 即将结束，不再单独限制
+			return ctx, func() {}, 0
+		}
+	}
+	budget := p.Budget
+	if budget == nil {
+		budget = EvenBudget
+	}
+	share := budget(remaining, attemptsLeft)
+	if share <= 0 {
+		return ctx, func() {}, 0
+	}
+	if remaining > 0 && share >= remaining {
+		return ctx, func() {}, remaining
+	}
+	actx, cancel := context.WithTimeout(ctx, share)
+	return actx, cancel, share
+}
+
+func (p *FailoverPolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// attemptsLeft 返回包括本次在内最多还会尝试的次数，受 MaxAttempts 与没有尝试过的服务器数量限制
+func (xc *XClient) attemptsLeft(ctx context.Context, p FailoverPolicy, attempt int, tried map[string]bool) int {
+	left := 1
+	if servers, err := xc.servers(ctx); err == nil {
+		for _, addr := range servers {
+			if !tried[addr] {
+				left++
+			}
+		}
+	}
+	if p.MaxAttempts > 0 && p.MaxAttempts-attempt+1 < left {
+		left = p.MaxAttempts - attempt + 1
+	}
+	return left
+}
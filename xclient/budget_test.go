@@ -0,0 +1,138 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// budgets 返回每次尝试分得的时间
+func budgets(info *CallInfo) []time.Duration {
+	var ds []time.Duration
+	for _, a := range info.Attempts {
+		ds = append(ds, a.Budget)
+	}
+	return ds
+}
+
+// failingDials 让 xc 的每次连接在假时钟上经过 cost 后失败
+func failingDials(xc *XClient, clock *fakeClock, cost time.Duration) {
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		clock.Advance(cost)
+		return nil, errors.New("connection refused")
+	}
+}
+
+func TestXClient_BudgetSplit(t *testing.T) {
+	servers := []string{"tcp@a", "tcp@b", "tcp@c"}
+	tests := []struct {
+		name   string
+		cost   time.Duration // 每次连接失败前经过的时间
+		budget BudgetStrategy
+		total  time.Duration // 0表示 ctx 没有截止时间
+		want   []time.Duration
+	}{
+		{"slow failures", 100 * time.Millisecond, nil, 600 * time.Millisecond,
+			[]time.Duration{200 * time.Millisecond, 250 * time.Millisecond, 400 * time.Millisecond}},
+		{"instant failures", 0, nil, 600 * time.Millisecond,
+			[]time.Duration{200 * time.Millisecond, 300 * time.Millisecond, 600 * time.Millisecond}},
+		{"fixed", 100 * time.Millisecond, FixedBudget(150 * time.Millisecond), 350 * time.Millisecond,
+			[]time.Duration{150 * time.Millisecond, 150 * time.Millisecond, 150 * time.Millisecond}},
+		{"fixed without deadline", 0, FixedBudget(150 * time.Millisecond), 0,
+			[]time.Duration{150 * time.Millisecond, 150 * time.Millisecond, 150 * time.Millisecond}},
+		{"no deadline", 0, nil, 0, []time.Duration{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+			defer func() { _ = xc.Close() }()
+			xc.SetFailover(FailoverPolicy{MaxAttempts: 3, Budget: tt.budget, Now: clock.Now})
+			failingDials(xc, clock, tt.cost)
+
+			ctx := context.Background()
+			if tt.total > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, clock.Now().Add(tt.total))
+				defer cancel()
+			}
+			info := new(CallInfo)
+			var de *DialError
+			assert.True(t, errors.As(xc.Call(WithCallInfo(ctx, info), "Foo.Sum", Args{}, new(int)), &de))
+			assert.Equal(t, tt.want, budgets(info))
+			for _, a := range info.Attempts {
+				assert.Equal(t, tt.cost, a.Duration)
+			}
+		})
+	}
+}
+
+func TestXClient_BudgetMaxAttempts(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c", "tcp@d"}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailover(FailoverPolicy{MaxAttempts: 2, Now: clock.Now})
+	failingDials(xc, clock, 0)
+
+	ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Second))
+	defer cancel()
+	info := new(CallInfo)
+	assert.NotNil(t, xc.Call(WithCallInfo(ctx, info), "Foo.Sum", Args{}, new(int)))
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, budgets(info))
+}
+
+func TestXClient_BudgetHungServer(t *testing.T) {
+	_, okAddr := startServer(t, geerpc.WorkerPool{})
+	hung := "tcp@hung"
+	xc := NewXClient(NewMultiServerDiscovery([]string{hung, okAddr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	block := make(chan struct{})
+	defer close(block)
+	dial := xc.xdial
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		if rpcAddr == hung {
+			<-block
+			return nil, errors.New("unreachable")
+		}
+		return dial(rpcAddr, opts...)
+	}
+
+	// 连接没有完成的服务器只用掉一半的时间，之后的尝试仍然成功
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+		info := new(CallInfo)
+		var reply int
+		assert.Nil(t, xc.Call(WithCallInfo(ctx, info), "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply))
+		assert.Equal(t, 2, reply)
+		cancel()
+		if info.Attempts[0].Addr == hung {
+			assert.Len(t, info.Attempts, 2)
+			assert.True(t, errors.Is(info.Attempts[0].Err, context.DeadlineExceeded))
+			assert.InDelta(t, 200*time.Millisecond, info.Attempts[0].Budget, float64(20*time.Millisecond))
+		}
+	}
+}
+
+func TestXClient_BudgetSentRequest(t *testing.T) {
+	_, a := startServer(t, geerpc.WorkerPool{})
+	_, b := startServer(t, geerpc.WorkerPool{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 请求已经发出的尝试超时后不重试，除非调用被标记为幂等
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	info := new(CallInfo)
+	assert.NotNil(t, xc.Call(WithCallInfo(ctx, info), "Foo.Sleep", time.Second, new(int)))
+	assert.Len(t, info.Attempts, 1)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	info = new(CallInfo)
+	assert.NotNil(t, xc.Call(WithCallInfo(WithIdempotent(ctx), info), "Foo.Sleep", time.Second, new(int)))
+	assert.Len(t, info.Attempts, 2)
+}
@@ -0,0 +1,265 @@
+// Package consul 提供基于 Consul 的服务发现与注册，通过 Consul 的 HTTP API 访问 agent，不依赖 Consul 的 Go 客户端
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+const (
+	defaultWaitTime      = 5 * time.Minute
+	defaultRetryInterval = time.Second
+	// requestTimeout 非阻塞请求的超时时间，阻塞查询在 WaitTime 之外再加上这段时间
+	requestTimeout = 10 * time.Second
+)
+
+// Options NewConsulDiscovery 的选项
+type Options struct {
+	Tag           string        // 不为空时只返回带有该标签的实例
+	Datacenter    string        // 为空时使用 agent 所在的数据中心
+	Token         string        // ACL 令牌，通过 X-Consul-Token 发送
+	WaitTime      time.Duration // 阻塞查询的最长等待时间，默认5分钟
+	RetryInterval time.Duration // agent 不可用时重试的间隔，默认1秒
+}
+
+// Discovery 基于 Consul 的 Discovery，只包含健康检查通过的实例
+// 后台通过阻塞查询（X-Consul-Index）监听变更，Get 与 GetAll 只读取缓存；
+// agent 不可用时继续使用最后一次获取到的列表，Stale 返回 true，直到重新查询成功
+type Discovery struct {
+	*xclient.MultiServersDiscovery
+	addr    string
+	service string
+	opts    Options
+	client  *http.Client
+
+	mu    sync.Mutex          // protect following
+	tags  map[string][]string // 服务器地址到 Consul 标签
+	index uint64              // 最近一次查询的 X-Consul-Index
+	stale bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ xclient.KeyedDiscovery = (*Discovery)(nil)
+
+// NewConsulDiscovery 从 consulAddr（如 "127.0.0.1:8500" 或 "http://consul:8500"）的 agent 发现名为 serviceName 的服务
+// opts 可以为 nil。首次查询失败时返回错误；不再使用时需要调用 Close 停止后台查询
+func NewConsulDiscovery(consulAddr, serviceName string, opts *Options) (*Discovery, error) {
+	d := &Discovery{
+		MultiServersDiscovery: xclient.NewMultiServerDiscovery(nil),
+		addr:                  agentURL(consulAddr),
+		service:               serviceName,
+		client:                &http.Client{},
+		done:                  make(chan struct{}),
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.WaitTime <= 0 {
+		d.opts.WaitTime = defaultWaitTime
+	}
+	if d.opts.RetryInterval <= 0 {
+		d.opts.RetryInterval = defaultRetryInterval
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(context.Background())
+	go d.watch(ctx)
+	return d, nil
+}
+
+// agentURL 补全 agent 地址的协议
+func agentURL(addr string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimRight(addr, "/")
+}
+
+// Refresh 立即查询健康检查通过的实例，失败时保留原有列表并标记为过期
+func (d *Discovery) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return d.query(ctx, 0)
+}
+
+// Update 不支持手动更新，服务器列表以 Consul 为准
+func (d *Discovery) Update(servers []string) error {
+	return errors.New("rpc discovery: consul discovery does not support Update")
+}
+
+// Stale 返回缓存的列表是否可能已经过期，即最近一次查询 agent 失败
+func (d *Discovery) Stale() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stale
+}
+
+// Tags 返回服务器在 Consul 中注册的标签，服务器不在列表中时返回 nil
+func (d *Discovery) Tags(server string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.tags[server]...)
+}
+
+// watch 循环发起阻塞查询，失败时等待 RetryInterval 后重试
+func (d *Discovery) watch(ctx context.Context) {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		index := d.index
+		d.mu.Unlock()
+		qctx, cancel := context.WithTimeout(ctx, d.opts.WaitTime+d.opts.WaitTime/16+requestTimeout)
+		err := d.query(qctx, index)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		log.Println(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.opts.RetryInterval):
+		}
+	}
+}
+
+// healthEntry /v1/health/service 返回的一个实例，只解码用到的字段
+type healthEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// query 查询健康检查通过的实例，index 不为0时为阻塞查询，直到列表变化或超过 WaitTime
+func (d *Discovery) query(ctx context.Context, index uint64) error {
+	q := url.Values{"passing": {"true"}}
+	if d.opts.Tag != "" {
+		q.Set("tag", d.opts.Tag)
+	}
+	if d.opts.Datacenter != "" {
+		q.Set("dc", d.opts.Datacenter)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.FormatInt(int64(d.opts.WaitTime/time.Millisecond), 10)+"ms")
+	}
+	var entries []healthEntry
+	newIndex, err := d.get(ctx, "/v1/health/service/"+url.PathEscape(d.service)+"?"+q.Encode(), &entries)
+	if err != nil {
+		d.mu.Lock()
+		d.stale = true
+		d.mu.Unlock()
+		return fmt.Errorf("rpc discovery: consul query %s: %v", d.service, err)
+	}
+
+	tags := make(map[string][]string, len(entries))
+	instances := make([]xclient.ServerInstance, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addr := "tcp@" + net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+		if _, ok := tags[addr]; ok {
+			continue
+		}
+		tags[addr] = e.Service.Tags
+		instances = append(instances, xclient.ServerInstance{
+			Addr:   addr,
+			Weight: e.Service.Weights.Passing,
+			Tags:   instanceTags(e.Service.Tags, e.Service.Meta),
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// index 变小说明 agent 的状态被重置，需要从头开始阻塞查询
+	if newIndex < d.index || newIndex == 0 {
+		newIndex = 1
+	}
+	d.tags, d.index, d.stale = tags, newIndex, false
+	return d.MultiServersDiscovery.UpdateInstances(instances)
+}
+
+// instanceTags 合并 Consul 的服务元数据与标签作为 ServerInstance.Tags：
+// 形如 key=value 的标签拆分为键值，其他标签的值为空字符串，同名时元数据优先
+func instanceTags(tags []string, meta map[string]string) map[string]string {
+	if len(tags) == 0 && len(meta) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags)+len(meta))
+	for _, tag := range tags {
+		if i := strings.Index(tag, "="); i > 0 {
+			m[tag[:i]] = tag[i+1:]
+		} else {
+			m[tag] = ""
+		}
+	}
+	for k, v := range meta {
+		m[k] = v
+	}
+	return m
+}
+
+// get 发送 GET 请求并解码 JSON 响应，返回 X-Consul-Index
+func (d *Discovery) get(ctx context.Context, path string, v interface{}) (uint64, error) {
+	req, err := http.NewRequest(http.MethodGet, d.addr+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if d.opts.Token != "" {
+		req.Header.Set("X-Consul-Token", d.opts.Token)
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return 0, fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, err
+	}
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return index, nil
+}
+
+// Close 停止后台查询
+func (d *Discovery) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
@@ -0,0 +1,273 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+// fakeService fakeConsul 中注册的实例
+type fakeService struct {
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Weight  int
+	Passing bool
+}
+
+// fakeConsul 模拟 Consul agent 的 HTTP API，支持阻塞查询，down 为 true 时返回 500
+type fakeConsul struct {
+	mu       sync.Mutex
+	services map[string]*fakeService
+	index    uint64
+	changed  chan struct{} // 每次变更时关闭并替换
+	down     bool
+	queries  int
+}
+
+func newFakeConsul() (*fakeConsul, *httptest.Server) {
+	f := &fakeConsul{services: make(map[string]*fakeService), index: 1, changed: make(chan struct{})}
+	return f, httptest.NewServer(f)
+}
+
+// set 修改实例并唤醒阻塞查询，fn 在持有 f.mu 时调用
+func (f *fakeConsul) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		http.Error(w, "agent unavailable", http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/agent/service/register":
+		var body struct {
+			ID, Name, Address string
+			Port              int
+			Tags              []string
+			Check             struct{ TCP string }
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Check.TCP == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		f.set(func() {
+			f.services[body.ID] = &fakeService{Name: body.Name, Address: body.Address, Port: body.Port, Tags: body.Tags, Passing: true}
+		})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		f.set(func() { delete(f.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")) })
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		f.health(w, r, strings.TrimPrefix(r.URL.Path, "/v1/health/service/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsul) health(w http.ResponseWriter, r *http.Request, name string) {
+	q := r.URL.Query()
+	if q.Get("passing") != "true" {
+		http.Error(w, "passing expected", http.StatusBadRequest)
+		return
+	}
+	index, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(q.Get("wait"))
+	f.mu.Lock()
+	f.queries++
+	if index >= f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	type entry struct {
+		Node    struct{ Address string }
+		Service struct {
+			Address string
+			Port    int
+			Tags    []string
+			Meta    map[string]string
+			Weights struct{ Passing int }
+		}
+	}
+	entries := []entry{}
+	for _, s := range f.services {
+		if s.Name != name || !s.Passing {
+			continue
+		}
+		if tag := q.Get("tag"); tag != "" && !contains(s.Tags, tag) {
+			continue
+		}
+		var e entry
+		e.Node.Address = "10.0.0.1"
+		e.Service.Address, e.Service.Port, e.Service.Tags = s.Address, s.Port, s.Tags
+		e.Service.Meta, e.Service.Weights.Passing = s.Meta, s.Weight
+		entries = append(entries, e)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func waitServers(t *testing.T, d xclient.Discovery, want []string) {
+	t.Helper()
+	var servers []string
+	for i := 0; i < 200; i++ {
+		servers, _ = d.GetAll()
+		if len(servers) == len(want) && (len(want) == 0 || assert.ObjectsAreEqual(want, servers)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, want, servers)
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	f, ts := newFakeConsul()
+	defer ts.Close()
+	f.set(func() {
+		f.services["a"] = &fakeService{Name: "geerpc", Address: "10.0.0.2", Port: 9999, Tags: []string{"v1", "zone=a"},
+			Meta: map[string]string{"codec": "gob"}, Weight: 3, Passing: true}
+		f.services["b"] = &fakeService{Name: "geerpc", Address: "10.0.0.3", Port: 9999, Passing: false}
+		f.services["c"] = &fakeService{Name: "other", Address: "10.0.0.4", Port: 9999, Passing: true}
+		f.services["d"] = &fakeService{Name: "geerpc", Port: 8888, Passing: true}
+	})
+
+	d, err := NewConsulDiscovery(ts.URL, "geerpc", &Options{RetryInterval: 10 * time.Millisecond})
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	waitServers(t, d, []string{"tcp@10.0.0.1:8888", "tcp@10.0.0.2:9999"})
+	assert.Equal(t, []string{"v1", "zone=a"}, d.Tags("tcp@10.0.0.2:9999"))
+	assert.Nil(t, d.Tags("tcp@10.0.0.3:9999"))
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []xclient.ServerInstance{
+		{Addr: "tcp@10.0.0.1:8888", Weight: 1},
+		{Addr: "tcp@10.0.0.2:9999", Weight: 3, Tags: map[string]string{"v1": "", "zone": "a", "codec": "gob"}},
+	}, instances)
+
+	// 阻塞查询在变更后立即返回
+	f.set(func() { f.services["b"].Passing = true })
+	waitServers(t, d, []string{"tcp@10.0.0.1:8888", "tcp@10.0.0.2:9999", "tcp@10.0.0.3:9999"})
+	f.mu.Lock()
+	queries := f.queries
+	f.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		_, err = d.Get(xclient.RoundRobinSelect)
+		assert.Nil(t, err)
+	}
+	f.mu.Lock()
+	assert.Equal(t, queries, f.queries, "Get serves from the cache")
+	f.mu.Unlock()
+
+	// agent 不可用时保留最后一次的列表
+	f.mu.Lock()
+	f.down = true
+	f.mu.Unlock()
+	assert.NotNil(t, d.Refresh())
+	assert.True(t, d.Stale())
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Len(t, servers, 3)
+
+	f.mu.Lock()
+	f.down = false
+	f.mu.Unlock()
+	f.set(func() { delete(f.services, "d") })
+	waitServers(t, d, []string{"tcp@10.0.0.2:9999", "tcp@10.0.0.3:9999"})
+	assert.False(t, d.Stale())
+	assert.NotNil(t, d.Update(nil))
+}
+
+func TestConsulDiscovery_Tag(t *testing.T) {
+	f, ts := newFakeConsul()
+	defer ts.Close()
+	f.set(func() {
+		f.services["a"] = &fakeService{Name: "geerpc", Address: "10.0.0.2", Port: 1, Tags: []string{"canary"}, Passing: true}
+		f.services["b"] = &fakeService{Name: "geerpc", Address: "10.0.0.3", Port: 1, Passing: true}
+	})
+	d, err := NewConsulDiscovery(ts.URL, "geerpc", &Options{Tag: "canary"})
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	waitServers(t, d, []string{"tcp@10.0.0.2:1"})
+
+	ts.Close()
+	_, err = NewConsulDiscovery(ts.URL, "geerpc", nil)
+	assert.NotNil(t, err)
+}
+
+type Foo int
+
+func (f Foo) Sum(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func TestRegisterServer(t *testing.T) {
+	_, ts := newFakeConsul()
+	defer ts.Close()
+
+	server := geerpc.NewServer()
+	assert.Nil(t, server.Register(new(Foo)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+
+	_, err = Register(ts.URL, Service{Name: "geerpc", Addr: "http@" + l.Addr().String()})
+	assert.NotNil(t, err)
+	_, err = Register(ts.URL, Service{Name: "geerpc", Addr: "no-port"})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, RegisterServer(server, ts.URL, Service{Name: "geerpc", Addr: addr, Tags: []string{"v1"}}))
+	d, err := NewConsulDiscovery(ts.URL, "geerpc", nil)
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	waitServers(t, d, []string{addr})
+	assert.Equal(t, []string{"v1"}, d.Tags(addr))
+
+	xc := xclient.NewXClient(d, xclient.RandomSelect, nil)
+	var reply int
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", [2]int{1, 2}, &reply))
+	assert.Equal(t, 3, reply)
+	_ = xc.Close()
+
+	// 服务器关闭时注销
+	assert.Nil(t, server.Shutdown(context.Background()))
+	waitServers(t, d, []string{})
+}
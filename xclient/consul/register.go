@@ -0,0 +1,126 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// Service 向 Consul 注册的服务实例
+type Service struct {
+	ID            string        // 实例 ID，为空时使用 Name-地址
+	Name          string        // 服务名，即 NewConsulDiscovery 的 serviceName
+	Addr          string        // 服务器地址，形如 "host:port" 或 "tcp@host:port"
+	Tags          []string      // Consul 标签
+	Token         string        // ACL 令牌
+	CheckInterval time.Duration // TCP 健康检查的间隔，默认10秒
+	// DeregisterAfter 健康检查持续失败超过这段时间后由 Consul 删除实例，为0时不自动删除
+	DeregisterAfter time.Duration
+}
+
+// Register 向 consulAddr 的 agent 注册 svc，并带有对 svc.Addr 的 TCP 健康检查，返回注销实例的函数
+func Register(consulAddr string, svc Service) (deregister func() error, err error) {
+	addr := svc.Addr
+	if i := strings.Index(addr, "@"); i >= 0 {
+		if protocol := addr[:i]; protocol != "tcp" {
+			return nil, fmt.Errorf("rpc registry: consul: unsupported protocol %q", protocol)
+		}
+		addr = addr[i+1:]
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("rpc registry: consul: invalid address %q: %v", svc.Addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("rpc registry: consul: invalid port %q", portStr)
+	}
+	if svc.ID == "" {
+		svc.ID = svc.Name + "-" + addr
+	}
+	if svc.CheckInterval <= 0 {
+		svc.CheckInterval = 10 * time.Second
+	}
+	check := map[string]string{
+		"TCP":      addr,
+		"Interval": svc.CheckInterval.String(),
+		"Timeout":  svc.CheckInterval.String(),
+	}
+	if svc.DeregisterAfter > 0 {
+		check["DeregisterCriticalServiceAfter"] = svc.DeregisterAfter.String()
+	}
+	body := map[string]interface{}{
+		"ID":      svc.ID,
+		"Name":    svc.Name,
+		"Address": host,
+		"Port":    port,
+		"Tags":    svc.Tags,
+		"Check":   check,
+	}
+	base := agentURL(consulAddr)
+	if err = put(base+"/v1/agent/service/register", svc.Token, body); err != nil {
+		return nil, fmt.Errorf("rpc registry: consul register %s: %v", svc.ID, err)
+	}
+	return func() error {
+		if err := put(base+"/v1/agent/service/deregister/"+url.PathEscape(svc.ID), svc.Token, nil); err != nil {
+			return fmt.Errorf("rpc registry: consul deregister %s: %v", svc.ID, err)
+		}
+		return nil
+	}, nil
+}
+
+// RegisterServer 与 Register 相同，并通过 Server.OnShutdown 在服务器关闭时注销实例
+func RegisterServer(server *geerpc.Server, consulAddr string, svc Service) error {
+	deregister, err := Register(consulAddr, svc)
+	if err != nil {
+		return err
+	}
+	server.OnShutdown(func() {
+		if err := deregister(); err != nil {
+			log.Println(err)
+		}
+	})
+	return nil
+}
+
+// put 向 agent 发送 PUT 请求
+func put(u, token string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPut, u, r)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
@@ -11,8 +12,13 @@ import (
 type SelectMode int
 
 const (
-	RandomSelect     SelectMode = iota // 随机选择
-	RoundRobinSelect                   // 基于round robin的轮询选择
+	RandomSelect             SelectMode = iota // 随机选择
+	RoundRobinSelect                           // 基于round robin的轮询选择
+	ConsistentHashSelect                       // 一致性哈希，相同的 key 选择相同的服务器，没有 key 时随机选择
+	LeastActiveSelect                          // 选择未完成调用最少的服务器，由 XClient 统计，Discovery 单独使用时随机选择
+	PowerOfTwoSelect                           // 随机选择两个服务器中负载较低的一个，负载由 XClient 统计，Discovery 单独使用时随机选择
+	WeightedRoundRobinSelect                   // 按 ServerInstance.Weight 的平滑加权轮询，没有权重时与轮询相同
+	LeastLoadedSelect                          // 随机选择两个服务器中注册中心上报的负载较低的一个，上报过期时使用 XClient 的统计，Discovery 单独使用时随机选择
 )
 
 type Discovery interface {
@@ -29,26 +35,64 @@ type Discovery interface {
 	GetAll() ([]string, error)
 }
 
+// KeyedDiscovery 支持按 key 选择服务实例的 Discovery，XClient 通过 WithHashKey 传递 key
+type KeyedDiscovery interface {
+	Discovery
+
+	// GetWithKey 与 Get 相同，mode 为 ConsistentHashSelect 且 key 不为空时按 key 的一致性哈希选择
+	GetWithKey(mode SelectMode, key string) (string, error)
+}
+
+// Watcher 支持订阅服务器列表变化的 Discovery，为可选接口，使用方通过类型断言判断是否支持
+// 内嵌 MultiServersDiscovery 的 Discovery 在列表变化时都会通知订阅者
+type Watcher interface {
+	// Subscribe 订阅服务器列表的变化，列表（不计顺序）每次变化时通过返回的 channel 发送完整的列表，
+	// 订阅者处理不及时时只保留最新的列表；调用 cancel 取消订阅并关闭 channel，可以多次调用
+	Subscribe() (updates <-chan []string, cancel func())
+}
+
 // MultiServersDiscovery 是对没有注册中心的多服务器发现
 // 用户需提供明确可寻址的服务器地址
 type MultiServersDiscovery struct {
-	r       *rand.Rand // 生成随机数
-	mu      sync.Mutex // protect following
-	servers []string   // 存放多个server
-	index   int        // 记录robin算法的选择位置
+	r        *rand.Rand // 生成随机数
+	mu       sync.Mutex // protect following
+	servers  []string   // 存放多个server
+	index    int        // 记录robin算法的选择位置
+	replicas int        // 一致性哈希中每个服务器的虚拟节点数
+	ring     *hashRing  // servers 对应的一致性哈希环
+	subs     map[chan []string]struct{}
+
+	instances []ServerInstance // 与 servers 一一对应的元数据
+	current   []int            // 平滑加权轮询中各个服务器的当前权重
 }
 
 // NewMultiServerDiscovery ...
 func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
 	d := &MultiServersDiscovery{
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
-		servers: servers,
+		r:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		replicas: defaultVirtualNodes,
 	}
 	d.index = d.r.Intn(math.MaxInt32 - 1)
+	d.setInstances(instancesOf(servers))
 	return d
 }
 
-var _ Discovery = (*MultiServersDiscovery)(nil)
+var (
+	_ KeyedDiscovery = (*MultiServersDiscovery)(nil)
+	_ Watcher        = (*MultiServersDiscovery)(nil)
+)
+
+// SetVirtualNodes 设置一致性哈希中每个服务器的虚拟节点数，n 不大于0时使用默认值160
+// 虚拟节点越多，key 在服务器之间的分布越均匀
+func (d *MultiServersDiscovery) SetVirtualNodes(n int) {
+	if n <= 0 {
+		n = defaultVirtualNodes
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.replicas = n
+	d.ring = newHashRing(n, d.servers)
+}
 
 // Refresh doesn't make sense for MultiServersDiscovery, so ignore it
 func (d *MultiServersDiscovery) Refresh() error {
@@ -56,15 +100,71 @@ func (d *MultiServersDiscovery) Refresh() error {
 }
 
 // Update the servers of discovery dynamically if needed
+// 服务器的权重为1且没有标签，需要元数据时使用 UpdateInstances
 func (d *MultiServersDiscovery) Update(servers []string) error {
+	return d.UpdateInstances(instancesOf(servers))
+}
+
+// sameServers 判断两个服务器列表是否包含相同的服务器，不计顺序
+func sameServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscribe 订阅服务器列表的变化，见 Watcher
+func (d *MultiServersDiscovery) Subscribe() (<-chan []string, func()) {
+	ch := make(chan []string, 1)
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.servers = servers
-	return nil
+	if d.subs == nil {
+		d.subs = make(map[chan []string]struct{})
+	}
+	d.subs[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.subs[ch]; ok {
+			delete(d.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify 向所有订阅者发送当前的列表，需要持有 d.mu
+// channel 中还有未读取的列表时用新的列表替换，发送不会阻塞
+func (d *MultiServersDiscovery) notify() {
+	for ch := range d.subs {
+		servers := make([]string, len(d.servers))
+		copy(servers, d.servers)
+		select {
+		case ch <- servers:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- servers
+		}
+	}
 }
 
 // Get a server according to me
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetWithKey(mode, "")
+}
+
+// GetWithKey 按负载均衡策略选择服务实例，ConsistentHashSelect 使用 key 的一致性哈希，key 为空时随机选择
+func (d *MultiServersDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	n := len(d.servers)
@@ -72,13 +172,20 @@ func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 		return "", errors.New("rpc discovery: no available servers")
 	}
 	switch mode {
-	case RandomSelect:
+	case ConsistentHashSelect:
+		if key != "" {
+			return d.ring.get(key), nil
+		}
+		return d.servers[d.r.Intn(n)], nil
+	case RandomSelect, LeastActiveSelect, PowerOfTwoSelect, LeastLoadedSelect:
 		return d.servers[d.r.Intn(n)], nil
 	case RoundRobinSelect:
 		// servers could be updated, so mode n to ensure safety
 		s := d.servers[d.index%n]
 		d.index = (d.index + 1) % n
 		return s, nil
+	case WeightedRoundRobinSelect:
+		return d.nextWeighted(), nil
 	default:
 		return "", errors.New("rpc discovery: not supported select mode")
 	}
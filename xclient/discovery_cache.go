@@ -0,0 +1,231 @@
+package xclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxStale 刷新持续失败多久之后返回 ErrStaleServers
+	defaultMaxStale = time.Minute
+	// defaultMinRetry 后台刷新失败后第一次重试前默认等待的时间
+	defaultMinRetry = time.Second
+)
+
+// ErrStaleServers 注册中心持续不可用超过 CacheOptions.MaxStale，RegistryDiscovery 的 Get 等方法
+// 在返回之前的列表的同时返回包装了该错误的错误，调用方可以决定是否继续使用
+var ErrStaleServers = errors.New("rpc discovery: server list is stale")
+
+// CacheOptions RegistryDiscovery 缓存服务器列表的配置
+type CacheOptions struct {
+	// Path 不为空时将最近一次成功获取的列表保存到该文件，SetCache 时从该文件恢复，
+	// 使刚启动的客户端在第一次刷新成功之前也能使用之前的列表
+	Path string
+	// MaxStale 刷新持续失败超过该时间后，Get 等方法在返回之前的列表的同时返回 ErrStaleServers，默认1分钟
+	MaxStale time.Duration
+	// MinRetry 后台刷新失败后第一次重试前等待的时间，之后每次翻倍，最长为 refreshInterval，默认1秒
+	MinRetry time.Duration
+}
+
+// cacheFile 缓存文件的内容
+type cacheFile struct {
+	Updated time.Time        `json:"updated"`
+	Servers []ServerInstance `json:"servers"`
+}
+
+// SetCache 设置缓存服务器列表的方式，opts.Path 不为空且还没有获取过列表时从该文件恢复，
+// 文件不存在时返回 nil，文件损坏时返回错误，之后仍然可以正常使用
+func (d *RegistryDiscovery) SetCache(opts CacheOptions) error {
+	if opts.MaxStale <= 0 {
+		opts.MaxStale = defaultMaxStale
+	}
+	if opts.MinRetry <= 0 {
+		opts.MinRetry = defaultMinRetry
+	}
+	d.mu.Lock()
+	d.cache = opts
+	fetched := !d.lastUpdate.IsZero()
+	d.mu.Unlock()
+	if opts.Path == "" || fetched {
+		return nil
+	}
+	data, err := ioutil.ReadFile(opts.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("rpc discovery: load cache: %v", err)
+	}
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("rpc discovery: load cache %s: %v", opts.Path, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.lastUpdate.IsZero() {
+		return nil
+	}
+	// 以保存的时间作为更新的时间，列表已经过期时第一次 Get 在后台刷新
+	d.setInstances(copyInstances(f.Servers))
+	d.notify()
+	d.lastUpdate, d.saved = f.Updated, f.Servers
+	return nil
+}
+
+// copyInstances 复制 instances，并将缺省的权重设为1
+func copyInstances(instances []ServerInstance) []ServerInstance {
+	copied := make([]ServerInstance, len(instances))
+	for i, in := range instances {
+		copied[i] = cloneInstance(in)
+	}
+	return copied
+}
+
+// saveCache 列表与上一次保存的不同时写入缓存文件，失败时只记录日志
+func (d *RegistryDiscovery) saveCache(instances []ServerInstance) {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	d.mu.Lock()
+	path, saved := d.cache.Path, d.saved
+	d.mu.Unlock()
+	if path == "" || (saved != nil && reflect.DeepEqual(saved, instances)) {
+		return
+	}
+	data, _ := json.Marshal(cacheFile{Updated: time.Now(), Servers: instances})
+	if err := writeCacheFile(path, data); err != nil {
+		log.Println("rpc discovery: save cache:", err)
+		return
+	}
+	d.mu.Lock()
+	d.saved = instances
+	d.mu.Unlock()
+}
+
+// writeCacheFile 先写入临时文件再重命名，避免崩溃时留下不完整的文件
+func writeCacheFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// refreshFailed 记录一次失败的刷新，列表从第一次失败开始变得陈旧
+func (d *RegistryDiscovery) refreshFailed(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.staleSince.IsZero() {
+		d.staleSince = time.Now()
+	}
+	d.lastErr = err
+}
+
+// serve 准备 Get 等方法使用的列表：还没有列表时同步刷新并返回刷新的错误；
+// 列表超过 refreshInterval 没有更新时立即使用之前的列表，同时在后台刷新，失败时按指数退避重试直到成功；
+// 刷新持续失败超过 MaxStale 时返回 ErrStaleServers，此时仍然可以使用之前的列表。
+// 后台刷新或 watch 时只在还没有列表时刷新
+func (d *RegistryDiscovery) serve() error {
+	background := d.auto.enabled() || d.watching()
+	d.mu.Lock()
+	fetched := !d.lastUpdate.IsZero()
+	fresh := d.lastUpdate.Add(d.refreshInterval).After(time.Now())
+	minRetry := d.cache.MinRetry
+	d.mu.Unlock()
+	if !fetched {
+		return d.Refresh()
+	}
+	if !fresh && !background {
+		d.revalidating.start(d.Refresh, minRetry, d.refreshInterval)
+	}
+	return d.staleErr()
+}
+
+// staleErr 刷新持续失败超过 MaxStale 时返回包装了 ErrStaleServers 与最后一次刷新的错误的错误
+func (d *RegistryDiscovery) staleErr() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.staleSince.IsZero() {
+		return nil
+	}
+	if age := time.Since(d.staleSince); age > d.cache.MaxStale {
+		return fmt.Errorf("%w: refresh failing for %v: %v", ErrStaleServers, age.Round(time.Millisecond), d.lastErr)
+	}
+	return nil
+}
+
+// revalidation 后台刷新陈旧列表的 goroutine，同一时间只有一个，零值可用
+type revalidation struct {
+	mu   sync.Mutex // protect following
+	stop chan struct{}
+	done chan struct{}
+}
+
+// start 没有正在进行的刷新时在后台调用 refresh，失败时从 minWait 开始按指数退避重试，最长等待 maxWait，直到成功或 close
+func (v *revalidation) start(refresh func() error, minWait, maxWait time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	v.stop, v.done = stop, done
+	go func() {
+		defer close(done)
+		defer func() {
+			v.mu.Lock()
+			if v.done == done {
+				v.stop, v.done = nil, nil
+			}
+			v.mu.Unlock()
+		}()
+		wait := minWait
+		for {
+			err := refresh()
+			if err == nil {
+				return
+			}
+			log.Println(err, "(keeping previous servers)")
+			timer := time.NewTimer(jitter(wait))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if wait *= 2; wait > maxWait {
+				wait = maxWait
+			}
+		}
+	}()
+}
+
+// close 停止后台刷新并等待 goroutine 退出
+func (v *revalidation) close() {
+	v.mu.Lock()
+	stop, done := v.stop, v.done
+	v.stop, v.done = nil, nil
+	v.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,124 @@
+package xclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/registry"
+)
+
+// flakyRegistry 可以模拟不可用的注册中心，记录收到的 GET 请求数
+type flakyRegistry struct {
+	*httptest.Server
+	down int32
+	gets int32
+}
+
+func newFlakyRegistry(r *registry.Registry) *flakyRegistry {
+	f := &flakyRegistry{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			atomic.AddInt32(&f.gets, 1)
+			if atomic.LoadInt32(&f.down) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		r.ServeHTTP(w, req)
+	}))
+	return f
+}
+
+func (f *flakyRegistry) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&f.down, v)
+}
+
+func TestRegistryDiscovery_StaleWhileRevalidate(t *testing.T) {
+	r := registry.New(time.Minute)
+	reg := newFlakyRegistry(r)
+	defer reg.Close()
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@a", nil, time.Minute, nil))
+
+	d := NewRegistryDiscovery(reg.URL, 50*time.Millisecond)
+	defer func() { _ = d.Close() }()
+	assert.Nil(t, d.SetCache(CacheOptions{MaxStale: 200 * time.Millisecond, MinRetry: 10 * time.Millisecond}))
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a"}, servers)
+
+	// 注册中心不可用时立即返回之前的列表，后台按指数退避重试
+	reg.setDown(true)
+	time.Sleep(60 * time.Millisecond)
+	start := time.Now()
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a"}, servers)
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Millisecond), "callers are not blocked")
+	time.Sleep(100 * time.Millisecond)
+	gets := atomic.LoadInt32(&reg.gets)
+	assert.Less(t, gets, int32(10), "retries back off")
+
+	// 超过 MaxStale 后同时返回之前的列表与错误
+	assert.Eventually(t, func() bool {
+		servers, err = d.GetAll()
+		return errors.Is(err, ErrStaleServers)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"tcp@a"}, servers)
+	addr, err := d.Get(RandomSelect)
+	assert.True(t, errors.Is(err, ErrStaleServers))
+	assert.Equal(t, "tcp@a", addr)
+
+	// 恢复后重试成功，更新列表并清除错误
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@b", nil, time.Minute, nil))
+	reg.setDown(false)
+	assert.Eventually(t, func() bool {
+		servers, err = d.GetAll()
+		return err == nil && len(servers) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRegistryDiscovery_CacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	r := registry.New(time.Minute)
+	reg := newFlakyRegistry(r)
+	defer reg.Close()
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@a", &registry.Metadata{Weight: 2}, time.Minute, nil))
+
+	d := NewRegistryDiscovery(reg.URL, time.Minute)
+	assert.Nil(t, d.SetCache(CacheOptions{Path: path}))
+	_, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	// 注册中心在客户端启动时不可用，使用上一次保存的列表
+	reg.setDown(true)
+	restarted := NewRegistryDiscovery(reg.URL, time.Minute)
+	defer func() { _ = restarted.Close() }()
+	assert.Nil(t, restarted.SetCache(CacheOptions{Path: path, MaxStale: 50 * time.Millisecond}))
+	instances, err := restarted.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{{Addr: "tcp@a", Weight: 2}}, instances)
+
+	// 没有缓存文件时第一次获取列表失败
+	fresh := NewRegistryDiscovery(reg.URL, time.Minute)
+	defer func() { _ = fresh.Close() }()
+	assert.Nil(t, fresh.SetCache(CacheOptions{Path: filepath.Join(t.TempDir(), "missing.json")}))
+	_, err = fresh.GetAll()
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrStaleServers))
+
+	// 损坏的缓存文件
+	assert.Nil(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	assert.NotNil(t, NewRegistryDiscovery(reg.URL, time.Minute).SetCache(CacheOptions{Path: path}))
+}
@@ -0,0 +1,173 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver DNSDiscovery 使用的 DNS 查询，*net.Resolver 实现了该接口
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSOptions NewDNSDiscovery 的选项
+type DNSOptions struct {
+	Resolver Resolver      // 为 nil 时使用 net.DefaultResolver
+	Timeout  time.Duration // 单次查询的超时时间，默认5秒
+}
+
+// DNSDiscovery 通过 DNS 获取服务器列表的 Discovery，如 Kubernetes 的 headless service
+// name 形如 _service._proto.domain 时查询 SRV 记录，使用记录中的主机与端口，记录的权重作为 ServerInstance.Weight，
+// 优先级作为标签 "priority"；否则查询 A/AAAA 记录，使用固定的 port。
+// 列表超过 refresh 没有查询时，Get 与 GetAll 会先重新查询，调用 EnableAutoRefresh 后改为在后台查询；
+// 查询失败或结果为空时保留原有列表
+type DNSDiscovery struct {
+	*MultiServersDiscovery
+	name     string
+	port     int
+	refresh  time.Duration
+	resolver Resolver
+	timeout  time.Duration
+	lastTry  time.Time // 最近一次查询的时间，受 MultiServersDiscovery.mu 保护
+	auto     autoRefresh
+}
+
+var _ KeyedDiscovery = (*DNSDiscovery)(nil)
+
+// NewDNSDiscovery 创建解析 name 的 Discovery，refresh 为0时使用默认值10秒，opts 可以为 nil
+// 创建时不会查询，第一次 Get 或 GetAll 时查询
+func NewDNSDiscovery(name string, port int, refresh time.Duration, opts *DNSOptions) *DNSDiscovery {
+	if refresh == 0 {
+		refresh = defaultRefreshInterval
+	}
+	d := &DNSDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(nil),
+		name:                  name,
+		port:                  port,
+		refresh:               refresh,
+		resolver:              net.DefaultResolver,
+		timeout:               5 * time.Second,
+	}
+	if opts != nil {
+		if opts.Resolver != nil {
+			d.resolver = opts.Resolver
+		}
+		if opts.Timeout > 0 {
+			d.timeout = opts.Timeout
+		}
+	}
+	return d
+}
+
+// isSRVName 判断 name 是否为 _service._proto.domain 的形式
+func isSRVName(name string) bool {
+	parts := strings.SplitN(name, ".", 3)
+	return len(parts) == 3 && len(parts[0]) > 1 && len(parts[1]) > 1 &&
+		parts[0][0] == '_' && parts[1][0] == '_'
+}
+
+// Refresh 重新查询 DNS，结果为空或查询失败时返回错误并保留原有列表
+// 结果会被打乱，避免所有客户端都从第一条记录开始轮询
+func (d *DNSDiscovery) Refresh() error {
+	d.mu.Lock()
+	d.lastTry = time.Now()
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	var instances []ServerInstance
+	if isSRVName(d.name) {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return fmt.Errorf("rpc discovery: lookup SRV %s: %v", d.name, err)
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			instances = append(instances, ServerInstance{
+				Addr:   "tcp@" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight: int(srv.Weight),
+				Tags:   map[string]string{"priority": strconv.Itoa(int(srv.Priority))},
+			})
+		}
+	} else {
+		addrs, err := d.resolver.LookupIPAddr(ctx, d.name)
+		if err != nil {
+			return fmt.Errorf("rpc discovery: lookup %s: %v", d.name, err)
+		}
+		for _, addr := range addrs {
+			instances = append(instances, ServerInstance{Addr: "tcp@" + net.JoinHostPort(addr.String(), strconv.Itoa(d.port))})
+		}
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("rpc discovery: lookup %s: no records", d.name)
+	}
+
+	d.mu.Lock()
+	d.r.Shuffle(len(instances), func(i, j int) { instances[i], instances[j] = instances[j], instances[i] })
+	d.mu.Unlock()
+	return d.MultiServersDiscovery.UpdateInstances(instances)
+}
+
+// refreshIfStale 超过 refresh 没有查询时重新查询；查询失败但仍有之前的列表时继续使用
+// 后台查询时只在列表为空时查询
+func (d *DNSDiscovery) refreshIfStale() error {
+	d.mu.Lock()
+	fresh := d.lastTry.Add(d.refresh).After(time.Now())
+	n := len(d.servers)
+	d.mu.Unlock()
+	if fresh || (n > 0 && d.auto.enabled()) {
+		return nil
+	}
+	if err := d.Refresh(); err != nil && n == 0 {
+		return err
+	}
+	return nil
+}
+
+// Get 按负载均衡策略选择服务器，需要时先重新查询
+func (d *DNSDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetWithKey(mode, "")
+}
+
+// GetWithKey 按 key 选择服务器，需要时先重新查询
+func (d *DNSDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
+	if err := d.refreshIfStale(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetWithKey(mode, key)
+}
+
+// GetAll 返回所有服务器，需要时先重新查询
+func (d *DNSDiscovery) GetAll() ([]string, error) {
+	if err := d.refreshIfStale(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+// GetAllInstances 返回所有服务器及其元数据，需要时先重新查询
+func (d *DNSDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	if err := d.refreshIfStale(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllInstances()
+}
+
+// EnableAutoRefresh 在后台每隔 interval 重新查询，interval 不大于0时使用 refresh
+func (d *DNSDiscovery) EnableAutoRefresh(interval time.Duration) {
+	if interval <= 0 {
+		interval = d.refresh
+	}
+	d.auto.start(interval, d.Refresh)
+}
+
+// Close 停止后台查询
+func (d *DNSDiscovery) Close() error {
+	d.auto.close()
+	return nil
+}
@@ -0,0 +1,152 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver 返回预设的记录，err 不为 nil 时查询失败
+type stubResolver struct {
+	mu      sync.Mutex
+	srv     []*net.SRV
+	ips     []net.IPAddr
+	err     error
+	lookups int
+}
+
+func (r *stubResolver) set(srv []*net.SRV, ips []net.IPAddr, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.srv, r.ips, r.err = srv, ips, err
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return name, r.srv, r.err
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return r.ips, r.err
+}
+
+func TestDNSDiscovery_A(t *testing.T) {
+	r := &stubResolver{}
+	r.set(nil, []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("fd00::1")}}, nil)
+	d := NewDNSDiscovery("geerpc.default.svc", 9999, 50*time.Millisecond, &DNSOptions{Resolver: r})
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"tcp@10.0.0.1:9999", "tcp@[fd00::1]:9999"}, servers)
+
+	// 有效期内不重新查询
+	for i := 0; i < 5; i++ {
+		_, err = d.Get(RoundRobinSelect)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, r.lookups)
+
+	r.set(nil, []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}}, nil)
+	time.Sleep(60 * time.Millisecond)
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@10.0.0.2:9999"}, servers)
+
+	// 结果为空或查询失败时保留原有列表
+	r.set(nil, nil, nil)
+	assert.NotNil(t, d.Refresh())
+	r.set(nil, nil, errors.New("timeout"))
+	assert.NotNil(t, d.Refresh())
+	time.Sleep(60 * time.Millisecond)
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@10.0.0.2:9999"}, servers)
+
+	// 没有任何列表时返回查询错误
+	d = NewDNSDiscovery("geerpc.default.svc", 9999, time.Second, &DNSOptions{Resolver: r})
+	_, err = d.Get(RandomSelect)
+	assert.NotNil(t, err)
+}
+
+func TestDNSDiscovery_SRV(t *testing.T) {
+	r := &stubResolver{}
+	r.set([]*net.SRV{
+		{Target: "pod-0.geerpc.default.svc.", Port: 8001, Priority: 1, Weight: 3},
+		{Target: "pod-1.geerpc.default.svc.", Port: 8002, Priority: 1},
+	}, nil, nil)
+	d := NewDNSDiscovery("_rpc._tcp.geerpc.default.svc", 0, time.Second, &DNSOptions{Resolver: r})
+	assert.Nil(t, d.Refresh())
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"tcp@pod-0.geerpc.default.svc:8001", "tcp@pod-1.geerpc.default.svc:8002"}, servers)
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []ServerInstance{
+		{Addr: "tcp@pod-0.geerpc.default.svc:8001", Weight: 3, Tags: map[string]string{"priority": "1"}},
+		{Addr: "tcp@pod-1.geerpc.default.svc:8002", Weight: 1, Tags: map[string]string{"priority": "1"}},
+	}, instances)
+
+	assert.True(t, isSRVName("_rpc._tcp.example.com"))
+	assert.False(t, isSRVName("rpc.tcp.example.com"))
+	assert.False(t, isSRVName("_._tcp.example.com"))
+}
+
+func TestDNSDiscovery_Shuffle(t *testing.T) {
+	r := &stubResolver{}
+	var ips []net.IPAddr
+	for i := 1; i <= 8; i++ {
+		ips = append(ips, net.IPAddr{IP: net.IPv4(10, 0, 0, byte(i))})
+	}
+	r.set(nil, ips, nil)
+	first := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		d := NewDNSDiscovery("geerpc", 1, time.Second, &DNSOptions{Resolver: r})
+		servers, err := d.GetAll()
+		assert.Nil(t, err)
+		assert.Len(t, servers, 8)
+		first[servers[0]] = true
+	}
+	assert.Greater(t, len(first), 1, "clients should not all start from the first record")
+}
+
+var _ Resolver = net.DefaultResolver
+
+func TestDNSDiscovery_AutoRefresh(t *testing.T) {
+	r := &stubResolver{}
+	r.set(nil, []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil)
+	d := NewDNSDiscovery("geerpc.default.svc", 9999, time.Hour, &DNSOptions{Resolver: r})
+	d.EnableAutoRefresh(20 * time.Millisecond)
+	defer func() { _ = d.Close() }()
+
+	// 不调用 Get 也会更新列表
+	r.set(nil, []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}}, nil)
+	assert.Eventually(t, func() bool {
+		servers, _ := d.MultiServersDiscovery.GetAll()
+		return len(servers) == 1 && servers[0] == "tcp@10.0.0.2:9999"
+	}, time.Second, 5*time.Millisecond)
+
+	// 查询失败时继续使用之前的列表
+	r.set(nil, nil, errors.New("timeout"))
+	time.Sleep(50 * time.Millisecond)
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@10.0.0.2:9999"}, servers)
+
+	assert.Nil(t, d.Close())
+	r.mu.Lock()
+	lookups := r.lookups
+	r.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	r.mu.Lock()
+	assert.Equal(t, lookups, r.lookups)
+	r.mu.Unlock()
+}
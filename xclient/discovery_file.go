@@ -0,0 +1,148 @@
+package xclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPollInterval FileDiscovery 默认检查文件变化的间隔
+const defaultPollInterval = 5 * time.Second
+
+// FileDiscovery 从 JSON 文件读取服务器列表的 Discovery，文件修改后自动重新加载
+// 文件格式如下，weight 与 tags 可以省略：
+//
+//	{"servers": [
+//		{"addr": "tcp@10.0.0.1:9999", "weight": 2, "tags": {"zone": "a"}},
+//		{"addr": "tcp@10.0.0.2:9999"}
+//	]}
+//
+// 通过定期比较文件的修改时间与大小发现变化，新内容无效时记录日志并保留原有列表
+type FileDiscovery struct {
+	*MultiServersDiscovery
+	path string
+
+	fileMu  sync.Mutex // protect following
+	modTime time.Time  // 最近一次加载时文件的修改时间
+	size    int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+var _ KeyedDiscovery = (*FileDiscovery)(nil)
+
+// fileDocument 服务器列表文件的格式
+type fileDocument struct {
+	Servers []ServerInstance `json:"servers"`
+}
+
+// NewFileDiscovery 从 path 加载服务器列表，并每隔 pollInterval 检查文件是否变化，pollInterval 为0时使用默认值5秒
+// 首次加载失败时返回错误；不再使用时需要调用 Close 停止检查
+func NewFileDiscovery(path string, pollInterval time.Duration) (*FileDiscovery, error) {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	d := &FileDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(nil),
+		path:                  path,
+		stop:                  make(chan struct{}),
+		done:                  make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.poll(pollInterval)
+	return d, nil
+}
+
+// parseServerFile 解析服务器列表文件，地址必须为 protocol@addr 的形式且不能重复
+func parseServerFile(data []byte) ([]ServerInstance, error) {
+	var doc fileDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(doc.Servers))
+	for i, s := range doc.Servers {
+		if parts := strings.SplitN(s.Addr, "@", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("servers[%d]: address %q must be of the form protocol@addr", i, s.Addr)
+		}
+		if seen[s.Addr] {
+			return nil, fmt.Errorf("servers[%d]: duplicate address %q", i, s.Addr)
+		}
+		if s.Weight < 0 {
+			return nil, fmt.Errorf("servers[%d]: negative weight %d", i, s.Weight)
+		}
+		seen[s.Addr] = true
+	}
+	return doc.Servers, nil
+}
+
+// Refresh 立即重新加载文件，文件无法读取或内容无效时返回错误并保留原有列表
+func (d *FileDiscovery) Refresh() error {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return fmt.Errorf("rpc discovery: load %s: %v", d.path, err)
+	}
+	// 记录读取前的修改时间，读取期间文件再次变化时下一次检查会重新加载
+	d.modTime, d.size = info.ModTime(), info.Size()
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return fmt.Errorf("rpc discovery: load %s: %v", d.path, err)
+	}
+	instances, err := parseServerFile(data)
+	if err != nil {
+		return fmt.Errorf("rpc discovery: load %s: %v", d.path, err)
+	}
+	return d.MultiServersDiscovery.UpdateInstances(instances)
+}
+
+// Update 不支持手动更新，服务器列表以文件为准
+func (d *FileDiscovery) Update(servers []string) error {
+	return errors.New("rpc discovery: file discovery does not support Update")
+}
+
+// changed 返回文件的修改时间或大小是否与最近一次加载时不同
+func (d *FileDiscovery) changed() bool {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false
+	}
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	return !info.ModTime().Equal(d.modTime) || info.Size() != d.size
+}
+
+func (d *FileDiscovery) poll(interval time.Duration) {
+	defer close(d.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		if !d.changed() {
+			continue
+		}
+		if err := d.Refresh(); err != nil {
+			log.Println(err, "(keeping previous servers)")
+		}
+	}
+}
+
+// Close 停止检查文件
+func (d *FileDiscovery) Close() error {
+	d.once.Do(func() { close(d.stop) })
+	<-d.done
+	return nil
+}
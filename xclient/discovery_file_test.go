@@ -0,0 +1,93 @@
+package xclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeServerFile 写入文件并修改其修改时间，避免文件系统的时间精度导致变化被忽略
+func writeServerFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	assert.Nil(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	now := time.Now()
+	writeServerFile(t, path, `{"servers": [{"addr": "tcp@a:1", "weight": 2, "tags": {"zone": "a"}}, {"addr": "tcp@b:1"}]}`, now)
+
+	d, err := NewFileDiscovery(path, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a:1", "tcp@b:1"}, servers)
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{
+		{Addr: "tcp@a:1", Weight: 2, Tags: map[string]string{"zone": "a"}},
+		{Addr: "tcp@b:1", Weight: 1},
+	}, instances)
+	assert.NotNil(t, d.Update(nil))
+	updates, cancel := d.Subscribe()
+	defer cancel()
+
+	// 选择过程中修改文件，新服务器开始被选中，被删除的服务器不再被选中
+	writeServerFile(t, path, `{"servers": [{"addr": "tcp@b:1"}, {"addr": "tcp@c:1"}]}`, now.Add(time.Second))
+	var chosen map[string]bool
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		chosen = make(map[string]bool)
+		for i := 0; i < 4; i++ {
+			addr, err := d.Get(RoundRobinSelect)
+			assert.Nil(t, err)
+			chosen[addr] = true
+		}
+		if chosen["tcp@c:1"] && !chosen["tcp@a:1"] {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, map[string]bool{"tcp@b:1": true, "tcp@c:1": true}, chosen)
+	assert.Equal(t, []string{"tcp@b:1", "tcp@c:1"}, <-updates)
+
+	// 无效的内容被拒绝，保留原有列表
+	for i, content := range []string{
+		`{"servers": [`,
+		`{"servers": [{"addr": "c:1"}]}`,
+		`{"servers": [{"addr": "tcp@c:1"}, {"addr": "tcp@c:1"}]}`,
+		`{"servers": [{"addr": "tcp@c:1", "weight": -1}]}`,
+	} {
+		writeServerFile(t, path, content, now.Add(time.Duration(i+2)*time.Second))
+		assert.NotNil(t, d.Refresh(), content)
+	}
+	time.Sleep(30 * time.Millisecond)
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@b:1", "tcp@c:1"}, servers)
+	select {
+	case servers = <-updates:
+		t.Fatalf("rejected file should not notify, got %v", servers)
+	default:
+	}
+
+	// 文件恢复有效后重新加载
+	writeServerFile(t, path, `{"servers": [{"addr": "tcp@d:1"}]}`, now.Add(10*time.Second))
+	for i := 0; i < 100; i++ {
+		if servers, _ = d.GetAll(); len(servers) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"tcp@d:1"}, servers)
+}
+
+func TestFileDiscovery_Missing(t *testing.T) {
+	_, err := NewFileDiscovery(filepath.Join(t.TempDir(), "missing.json"), 0)
+	assert.NotNil(t, err)
+}
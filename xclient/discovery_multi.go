@@ -0,0 +1,167 @@
+package xclient
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DiscoveryErrors 多个 Discovery 全部失败时返回的错误，按 Discovery 的顺序保存各自的错误
+type DiscoveryErrors []error
+
+func (e DiscoveryErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "rpc discovery: all discoveries failed: " + strings.Join(msgs, "; ")
+}
+
+// Is 任意一个 Discovery 的错误与 target 匹配时返回 true
+func (e DiscoveryErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiDiscovery 合并多个 Discovery 的服务器列表，用于服务器分布在多个来源时（如迁移注册中心期间）。
+// 服务器按地址去重，元数据以先传入的 Discovery 为准；部分 Discovery 失败时使用其余 Discovery 的服务器，
+// 全部失败时才返回 DiscoveryErrors
+type MultiDiscovery struct {
+	ds     []Discovery
+	merged *MultiServersDiscovery // 合并后的服务器，负责按负载均衡策略选择与通知订阅者
+
+	unwatch []func()       // 取消对实现了 Watcher 的 Discovery 的订阅
+	wg      sync.WaitGroup // 订阅的 goroutine
+	once    sync.Once
+}
+
+var (
+	_ KeyedDiscovery    = (*MultiDiscovery)(nil)
+	_ InstanceDiscovery = (*MultiDiscovery)(nil)
+	_ Watcher           = (*MultiDiscovery)(nil)
+	_ io.Closer         = (*MultiDiscovery)(nil)
+)
+
+// NewMultiDiscovery 合并 ds，每次 Get 与 GetAll 时从所有 Discovery 读取服务器列表；
+// 订阅实现了 Watcher 的 Discovery，其中任意一个变化时向订阅者发送合并后的列表，不再使用时调用 Close 取消订阅
+func NewMultiDiscovery(ds ...Discovery) *MultiDiscovery {
+	d := &MultiDiscovery{ds: ds, merged: NewMultiServerDiscovery(nil)}
+	for _, child := range ds {
+		w, ok := child.(Watcher)
+		if !ok {
+			continue
+		}
+		updates, cancel := w.Subscribe()
+		d.unwatch = append(d.unwatch, cancel)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for range updates {
+				_ = d.sync()
+			}
+		}()
+	}
+	return d
+}
+
+// sync 用所有 Discovery 的服务器的并集更新 merged，全部失败时返回 DiscoveryErrors 且不更新
+func (d *MultiDiscovery) sync() error {
+	var errs DiscoveryErrors
+	var merged []ServerInstance
+	seen := make(map[string]bool)
+	for _, child := range d.ds {
+		instances, err := GetAllInstances(child)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, in := range instances {
+			if !seen[in.Addr] {
+				seen[in.Addr] = true
+				merged = append(merged, in)
+			}
+		}
+	}
+	if len(d.ds) > 0 && len(errs) == len(d.ds) {
+		return errs
+	}
+	// 来源的顺序不影响合并后的列表
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Addr < merged[j].Addr })
+	syncInstances(d.merged, merged)
+	return nil
+}
+
+// forEach 对每个 Discovery 调用 f，全部失败时返回 DiscoveryErrors
+func (d *MultiDiscovery) forEach(f func(Discovery) error) error {
+	var errs DiscoveryErrors
+	for _, child := range d.ds {
+		if err := f(child); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(d.ds) > 0 && len(errs) == len(d.ds) {
+		return errs
+	}
+	return nil
+}
+
+// Refresh 刷新所有 Discovery 的服务器列表
+func (d *MultiDiscovery) Refresh() error {
+	return d.forEach(Discovery.Refresh)
+}
+
+// Update 以 servers 更新所有支持手动更新的 Discovery
+func (d *MultiDiscovery) Update(servers []string) error {
+	return d.forEach(func(child Discovery) error { return child.Update(servers) })
+}
+
+// Get 从合并后的服务器中选择一个
+func (d *MultiDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetWithKey(mode, "")
+}
+
+// GetWithKey 从合并后的服务器中按 key 选择一个
+func (d *MultiDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
+	if err := d.sync(); err != nil {
+		return "", err
+	}
+	return d.merged.GetWithKey(mode, key)
+}
+
+// GetAll 返回合并后的服务器，按地址排序
+func (d *MultiDiscovery) GetAll() ([]string, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.merged.GetAll()
+}
+
+// GetAllInstances 返回合并后的服务器及其元数据
+func (d *MultiDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.merged.GetAllInstances()
+}
+
+// Subscribe 订阅合并后的服务器列表的变化，见 Watcher
+func (d *MultiDiscovery) Subscribe() (<-chan []string, func()) {
+	return d.merged.Subscribe()
+}
+
+// Close 取消对各个 Discovery 的订阅，不会关闭它们
+func (d *MultiDiscovery) Close() error {
+	d.once.Do(func() {
+		for _, cancel := range d.unwatch {
+			cancel()
+		}
+		d.wg.Wait()
+	})
+	return nil
+}
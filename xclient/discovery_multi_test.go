@@ -0,0 +1,130 @@
+package xclient
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistryDiscovery 模拟注册中心，设置 err 后 GetAll 失败
+type fakeRegistryDiscovery struct {
+	*MultiServersDiscovery
+	mu  sync.Mutex
+	err error
+}
+
+func newFakeRegistryDiscovery(servers ...string) *fakeRegistryDiscovery {
+	return &fakeRegistryDiscovery{MultiServersDiscovery: NewMultiServerDiscovery(servers)}
+}
+
+func (d *fakeRegistryDiscovery) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+}
+
+func (d *fakeRegistryDiscovery) GetAll() ([]string, error) {
+	d.mu.Lock()
+	err := d.err
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func (d *fakeRegistryDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	d.mu.Lock()
+	err := d.err
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllInstances()
+}
+
+func TestMultiDiscovery_Union(t *testing.T) {
+	static := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+	reg := newFakeRegistryDiscovery("tcp@c", "tcp@b")
+	d := NewMultiDiscovery(static, reg)
+	defer func() { _ = d.Close() }()
+
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a", "tcp@b", "tcp@c"}, servers)
+
+	picked := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		addr, err := d.Get(RoundRobinSelect)
+		assert.Nil(t, err)
+		picked[addr]++
+	}
+	assert.Equal(t, map[string]int{"tcp@a": 2, "tcp@b": 2, "tcp@c": 2}, picked)
+
+	// Update 转发给所有 Discovery
+	assert.Nil(t, d.Update([]string{"tcp@d"}))
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@d"}, servers)
+}
+
+func TestMultiDiscovery_PartialFailure(t *testing.T) {
+	static := NewMultiServerDiscovery([]string{"tcp@a"})
+	reg := newFakeRegistryDiscovery("tcp@b")
+	d := NewMultiDiscovery(static, reg)
+	defer func() { _ = d.Close() }()
+
+	unavailable := errors.New("registry unavailable")
+	reg.fail(unavailable)
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a"}, servers)
+	addr, err := d.Get(RandomSelect)
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp@a", addr)
+
+	// 全部失败时返回所有的错误
+	d2 := NewMultiDiscovery(&fakeRegistryDiscovery{MultiServersDiscovery: NewMultiServerDiscovery(nil), err: errors.New("down")}, reg)
+	defer func() { _ = d2.Close() }()
+	_, err = d2.GetAll()
+	var errs DiscoveryErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.True(t, errors.Is(err, unavailable))
+	assert.EqualError(t, err, "rpc discovery: all discoveries failed: down; registry unavailable")
+
+	// 恢复后重新合并
+	reg.fail(nil)
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a", "tcp@b"}, servers)
+}
+
+func TestMultiDiscovery_Subscribe(t *testing.T) {
+	static := NewMultiServerDiscovery([]string{"tcp@a"})
+	reg := newFakeRegistryDiscovery("tcp@b")
+	d := NewMultiDiscovery(static, reg)
+	defer func() { _ = d.Close() }()
+	_, _ = d.GetAll()
+	updates, cancel := d.Subscribe()
+	defer cancel()
+
+	assert.Nil(t, reg.Update([]string{"tcp@b", "tcp@c"}))
+	select {
+	case servers := <-updates:
+		assert.Equal(t, []string{"tcp@a", "tcp@b", "tcp@c"}, servers)
+	case <-time.After(time.Second):
+		t.Fatal("expect a merged update")
+	}
+
+	assert.Nil(t, static.Update(nil))
+	select {
+	case servers := <-updates:
+		assert.Equal(t, []string{"tcp@b", "tcp@c"}, servers)
+	case <-time.After(time.Second):
+		t.Fatal("expect a merged update")
+	}
+}
@@ -0,0 +1,344 @@
+package xclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/registry"
+)
+
+const (
+	// defaultRefreshInterval RegistryDiscovery 默认的服务器列表有效期
+	defaultRefreshInterval = 10 * time.Second
+	// defaultWatchWait 每个 watch 请求默认的等待时间
+	defaultWatchWait = 30 * time.Second
+	// watchRetryInterval watch 请求失败后，等待该时间再尝试下一个注册中心
+	watchRetryInterval = time.Second
+)
+
+// RegistryDiscovery 从 registry 包的注册中心获取服务器列表的 Discovery
+// Get 与 GetAll 总是使用缓存的列表，列表超过 refreshInterval 没有更新时在后台刷新，刷新失败时继续使用之前的列表，
+// 持续失败超过 CacheOptions.MaxStale 后同时返回 ErrStaleServers，见 SetCache；
+// 调用 EnableAutoRefresh 后改为定期刷新，调用 EnableWatch 后改为等待注册中心推送变化。
+// 服务器上报的元数据中，Weight 与 Load 作为 ServerInstance 的同名字段，Zone 与 Codec 分别作为 "zone" 与 "codec" 标签
+type RegistryDiscovery struct {
+	*MultiServersDiscovery
+	registries      []string
+	refreshInterval time.Duration
+	lastUpdate      time.Time // 最近一次更新列表的时间，受 MultiServersDiscovery.mu 保护
+	current         int       // 最近一次刷新成功的注册中心，受 MultiServersDiscovery.mu 保护
+	token           string    // 注册中心的读令牌，受 MultiServersDiscovery.mu 保护
+	client          *http.Client
+	auto            autoRefresh
+	watch           registryWatch
+	revalidating    revalidation
+
+	cache      CacheOptions     // 受 MultiServersDiscovery.mu 保护
+	staleSince time.Time        // 刷新开始失败的时间，刷新成功后清零，受 MultiServersDiscovery.mu 保护
+	lastErr    error            // 最近一次刷新的错误，受 MultiServersDiscovery.mu 保护
+	saved      []ServerInstance // 最近一次写入缓存文件的列表，受 MultiServersDiscovery.mu 保护
+	saveMu     sync.Mutex       // 保证缓存文件按顺序写入
+}
+
+// registryWatch 后台 watch 的 goroutine，零值可用
+type registryWatch struct {
+	mu     sync.Mutex // protect following
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	_ KeyedDiscovery    = (*RegistryDiscovery)(nil)
+	_ InstanceDiscovery = (*RegistryDiscovery)(nil)
+)
+
+// NewRegistryDiscovery 创建从 registryURL 获取服务器列表的 Discovery，refreshInterval 为0时使用默认值10秒
+func NewRegistryDiscovery(registryURL string, refreshInterval time.Duration) *RegistryDiscovery {
+	return NewRegistryDiscoveryURLs([]string{registryURL}, refreshInterval)
+}
+
+// NewRegistryDiscoveryURLs 创建从互为对端的多个注册中心（见 registry.Registry.SetPeers）获取服务器列表的 Discovery，
+// 刷新时使用上一次成功的注册中心，失败时依次尝试其他注册中心
+func NewRegistryDiscoveryURLs(registryURLs []string, refreshInterval time.Duration) *RegistryDiscovery {
+	if refreshInterval == 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	return &RegistryDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(nil),
+		registries:            registryURLs,
+		refreshInterval:       refreshInterval,
+		client:                &http.Client{Timeout: 10 * time.Second},
+		cache:                 CacheOptions{MaxStale: defaultMaxStale, MinRetry: defaultMinRetry},
+	}
+}
+
+// Update 手动更新服务器列表，并视为一次刷新
+func (d *RegistryDiscovery) Update(servers []string) error {
+	return d.UpdateInstances(instancesOf(servers))
+}
+
+// UpdateInstances 手动更新服务器及其元数据，并视为一次成功的刷新
+func (d *RegistryDiscovery) UpdateInstances(instances []ServerInstance) error {
+	if err := d.MultiServersDiscovery.UpdateInstances(instances); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.lastUpdate = time.Now()
+	d.staleSince, d.lastErr = time.Time{}, nil
+	d.mu.Unlock()
+	d.saveCache(copyInstances(instances))
+	return nil
+}
+
+// SetToken 设置注册中心的读令牌，见 registry.Registry.SetAuth
+func (d *RegistryDiscovery) SetToken(token string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.token = token
+}
+
+// Refresh 从注册中心获取服务器列表，所有注册中心都失败时保留之前的列表，返回最后一个错误
+func (d *RegistryDiscovery) Refresh() error {
+	if len(d.registries) == 0 {
+		return errors.New("rpc discovery: no registry")
+	}
+	d.mu.Lock()
+	start, token := d.current, d.token
+	d.mu.Unlock()
+	var err error
+	for i := 0; i < len(d.registries); i++ {
+		n := (start + i) % len(d.registries)
+		var instances []ServerInstance
+		if instances, err = d.fetch(d.registries[n], token); err != nil {
+			continue
+		}
+		d.mu.Lock()
+		d.current = n
+		d.mu.Unlock()
+		return d.UpdateInstances(instances)
+	}
+	d.refreshFailed(err)
+	return err
+}
+
+// fetch 从 registryURL 获取服务器列表，注册中心不返回元数据时（旧版本）只使用 ServersHeader 中的地址
+func (d *RegistryDiscovery) fetch(registryURL, token string) ([]ServerInstance, error) {
+	req, err := http.NewRequest(http.MethodGet, registryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rpc discovery: refresh from registry: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rpc discovery: refresh from registry: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc discovery: refresh from registry: unexpected response %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var servers []registry.Server
+		if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+			return nil, fmt.Errorf("rpc discovery: refresh from registry: %v", err)
+		}
+		return registryInstances(servers), nil
+	}
+	var servers []string
+	for _, server := range strings.Split(resp.Header.Get(registry.ServersHeader), ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return instancesOf(servers), nil
+}
+
+// registryInstances 将注册中心返回的服务器转换为 ServerInstance
+func registryInstances(servers []registry.Server) []ServerInstance {
+	instances := make([]ServerInstance, len(servers))
+	for i, s := range servers {
+		tags := make(map[string]string, len(s.Tags)+2)
+		for k, v := range s.Tags {
+			tags[k] = v
+		}
+		if s.Zone != "" {
+			tags["zone"] = s.Zone
+		}
+		if s.Codec != "" {
+			tags["codec"] = s.Codec
+		}
+		instances[i] = ServerInstance{Addr: s.Addr, Weight: s.Weight, Tags: tags, Load: s.Load}
+		if s.Load != 0 {
+			// 没有上报负载的服务器不以心跳时间作为上报的时间，选择时使用本地统计
+			instances[i].LoadTime = s.Heartbeat
+		}
+	}
+	return instances
+}
+
+// EnableAutoRefresh 在后台每隔 interval 从注册中心刷新，interval 不大于0时使用 refreshInterval
+func (d *RegistryDiscovery) EnableAutoRefresh(interval time.Duration) {
+	if interval <= 0 {
+		interval = d.refreshInterval
+	}
+	d.auto.start(interval, d.Refresh)
+}
+
+// EnableWatch 在后台通过注册中心的 watch 接口（见 registry.WatchPath）等待列表变化，
+// 列表变化后立即更新并通知订阅者；每个请求最多等待 wait，不大于0时使用30秒。
+// 请求失败时记录日志，继续使用之前的列表，等待1秒后尝试下一个注册中心。再次调用时以新的等待时间重启
+func (d *RegistryDiscovery) EnableWatch(wait time.Duration) {
+	if wait <= 0 {
+		wait = defaultWatchWait
+	}
+	d.watch.mu.Lock()
+	defer d.watch.mu.Unlock()
+	d.stopWatchLocked()
+	if len(d.registries) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	d.watch.cancel, d.watch.done = cancel, done
+	go d.watchLoop(ctx, wait, done)
+}
+
+// watching 返回是否正在后台 watch
+func (d *RegistryDiscovery) watching() bool {
+	d.watch.mu.Lock()
+	defer d.watch.mu.Unlock()
+	return d.watch.cancel != nil
+}
+
+func (d *RegistryDiscovery) stopWatchLocked() {
+	if d.watch.cancel == nil {
+		return
+	}
+	d.watch.cancel()
+	<-d.watch.done
+	d.watch.cancel, d.watch.done = nil, nil
+}
+
+// watchLoop 依次发送 watch 请求，第一个请求以及切换注册中心后的第一个请求立即返回当前的列表
+func (d *RegistryDiscovery) watchLoop(ctx context.Context, wait time.Duration, done chan struct{}) {
+	defer close(done)
+	var since uint64
+	watched := false
+	for {
+		d.mu.Lock()
+		n, token := d.current, d.token
+		d.mu.Unlock()
+		resp, err := d.watchOnce(ctx, d.registries[n], token, since, watched, wait)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println(err, "(keeping previous servers)")
+			d.refreshFailed(err)
+			watched = false
+			d.mu.Lock()
+			d.current = (n + 1) % len(d.registries)
+			d.mu.Unlock()
+			timer := time.NewTimer(jitter(watchRetryInterval))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		_ = d.UpdateInstances(registryInstances(resp.Servers))
+		since, watched = resp.Index, true
+	}
+}
+
+// watchOnce 向 registryURL 发送一次 watch 请求，watched 为 false 时不携带 since，注册中心立即返回
+func (d *RegistryDiscovery) watchOnce(ctx context.Context, registryURL, token string, since uint64, watched bool, wait time.Duration) (*registry.WatchResponse, error) {
+	query := url.Values{"timeout": {wait.String()}}
+	if watched {
+		query.Set("since", strconv.FormatUint(since, 10))
+	}
+	// 等待时间之外再留出 client.Timeout 用于建立连接与传输
+	ctx, cancel := context.WithTimeout(ctx, wait+d.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(registryURL, "/")+registry.WatchPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("rpc discovery: watch registry: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rpc discovery: watch registry: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc discovery: watch registry: unexpected response %s", resp.Status)
+	}
+	var watch registry.WatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&watch); err != nil {
+		return nil, fmt.Errorf("rpc discovery: watch registry: %v", err)
+	}
+	return &watch, nil
+}
+
+// Close 停止后台刷新与 watch
+func (d *RegistryDiscovery) Close() error {
+	d.auto.close()
+	d.revalidating.close()
+	d.watch.mu.Lock()
+	d.stopWatchLocked()
+	d.watch.mu.Unlock()
+	return nil
+}
+
+// Get 按负载均衡策略从缓存的列表中选择服务器，见 serve
+func (d *RegistryDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetWithKey(mode, "")
+}
+
+// GetWithKey 按 key 从缓存的列表中选择服务器，列表陈旧时同时返回选择的服务器与 ErrStaleServers
+func (d *RegistryDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
+	err := d.serve()
+	if err != nil && !errors.Is(err, ErrStaleServers) {
+		return "", err
+	}
+	addr, gerr := d.MultiServersDiscovery.GetWithKey(mode, key)
+	if gerr != nil {
+		return "", gerr
+	}
+	return addr, err
+}
+
+// GetAll 返回缓存的所有服务器，列表陈旧时同时返回列表与 ErrStaleServers
+func (d *RegistryDiscovery) GetAll() ([]string, error) {
+	err := d.serve()
+	if err != nil && !errors.Is(err, ErrStaleServers) {
+		return nil, err
+	}
+	servers, _ := d.MultiServersDiscovery.GetAll()
+	return servers, err
+}
+
+// GetAllInstances 返回缓存的所有服务器及其元数据，列表陈旧时同时返回列表与 ErrStaleServers
+func (d *RegistryDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	err := d.serve()
+	if err != nil && !errors.Is(err, ErrStaleServers) {
+		return nil, err
+	}
+	instances, _ := d.MultiServersDiscovery.GetAllInstances()
+	return instances, err
+}
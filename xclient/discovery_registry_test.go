@@ -0,0 +1,230 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/registry"
+)
+
+func TestRegistryDiscovery(t *testing.T) {
+	reg := httptest.NewServer(registry.New(200 * time.Millisecond))
+	defer reg.Close()
+
+	_, addr1 := startServer(t, geerpc.WorkerPool{})
+	server2, addr2 := startServer(t, geerpc.WorkerPool{})
+	stop1, stop2 := make(chan struct{}), make(chan struct{})
+	defer close(stop1)
+	assert.Nil(t, registry.Heartbeat(reg.URL, addr1, nil, 50*time.Millisecond, stop1))
+	assert.Nil(t, registry.Heartbeat(reg.URL, addr2, nil, 50*time.Millisecond, stop2))
+
+	d := NewRegistryDiscovery(reg.URL, 50*time.Millisecond)
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{addr1, addr2}, servers)
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 3, reply)
+
+	// 服务器停止后不再发送心跳，超过 TTL 后从列表中移除
+	close(stop2)
+	_ = server2.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if servers, err = d.GetAll(); err == nil && len(servers) == 1 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, []string{addr1}, servers)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		assert.Equal(t, i+1, reply)
+	}
+
+	// 注册中心不可用时继续使用之前的列表，持续失败超过 MaxStale 后同时返回错误
+	assert.Nil(t, d.SetCache(CacheOptions{MaxStale: 100 * time.Millisecond, MinRetry: 10 * time.Millisecond}))
+	reg.Close()
+	time.Sleep(60 * time.Millisecond)
+	addr, err := d.Get(RandomSelect)
+	assert.Nil(t, err)
+	assert.Equal(t, addr1, addr)
+	assert.Eventually(t, func() bool {
+		addr, err = d.Get(RandomSelect)
+		return errors.Is(err, ErrStaleServers)
+	}, time.Second, 10*time.Millisecond, "stale list cannot be refreshed")
+	assert.Equal(t, addr1, addr)
+	assert.Nil(t, d.Close())
+}
+
+func TestRegistryDiscovery_AutoRefresh(t *testing.T) {
+	var gets int32
+	reg := registry.New(time.Minute)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	_, addr1 := startServer(t, geerpc.WorkerPool{})
+	_, addr2 := startServer(t, geerpc.WorkerPool{})
+	stop := make(chan struct{})
+	defer close(stop)
+	assert.Nil(t, registry.Heartbeat(srv.URL, addr1, nil, time.Minute, stop))
+
+	d := NewRegistryDiscovery(srv.URL, time.Hour)
+	d.EnableAutoRefresh(20 * time.Millisecond)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{addr1}, servers)
+
+	// 不调用 Get 也会更新列表
+	assert.Nil(t, registry.Heartbeat(srv.URL, addr2, nil, time.Minute, stop))
+	assert.Eventually(t, func() bool {
+		servers, _ := d.MultiServersDiscovery.GetAll()
+		return len(servers) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// Close 之后不再刷新
+	assert.Nil(t, d.Close())
+	n := atomic.LoadInt32(&gets)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&gets))
+
+	// 刷新失败时继续使用之前的列表
+	d.EnableAutoRefresh(20 * time.Millisecond)
+	srv.Close()
+	time.Sleep(60 * time.Millisecond)
+	servers, err = d.GetAll()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{addr1, addr2}, servers)
+}
+
+func TestRegistryDiscovery_Metadata(t *testing.T) {
+	reg := httptest.NewServer(registry.New(time.Minute))
+	defer reg.Close()
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@heavy", &registry.Metadata{Weight: 3, Zone: "a", Codec: "application/gob"}, time.Minute, nil))
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@light", &registry.Metadata{Tags: map[string]string{"canary": "true"}}, time.Minute, nil))
+
+	d := NewRegistryDiscovery(reg.URL, time.Minute)
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{
+		{Addr: "tcp@heavy", Weight: 3, Tags: map[string]string{"zone": "a", "codec": "application/gob"}},
+		{Addr: "tcp@light", Weight: 1, Tags: map[string]string{"canary": "true"}},
+	}, instances)
+
+	picks := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		addr, err := d.Get(WeightedRoundRobinSelect)
+		assert.Nil(t, err)
+		picks[addr]++
+	}
+	assert.Equal(t, map[string]int{"tcp@heavy": 6, "tcp@light": 2}, picks)
+}
+
+func TestRegistryDiscovery_LegacyRegistry(t *testing.T) {
+	// 旧版本的注册中心只在响应头中返回地址
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(registry.ServersHeader, "tcp@a, tcp@b")
+	}))
+	defer srv.Close()
+
+	d := NewRegistryDiscovery(srv.URL, time.Minute)
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{{Addr: "tcp@a", Weight: 1}, {Addr: "tcp@b", Weight: 1}}, instances)
+}
+
+func TestRegistryDiscovery_Peers(t *testing.T) {
+	r1, r2 := registry.New(time.Minute), registry.New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r1.SetPeers(registry.PeerOptions{Peers: []string{ts2.URL}, SyncInterval: 50 * time.Millisecond})
+	r2.SetPeers(registry.PeerOptions{Peers: []string{ts1.URL}, SyncInterval: 50 * time.Millisecond})
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	assert.Nil(t, registry.Heartbeat(ts1.URL, addr, nil, time.Minute, nil))
+
+	// 只向 ts1 发送心跳，从 ts2 也能发现
+	d := NewRegistryDiscovery(ts2.URL, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		servers, err := d.GetAll()
+		return err == nil && len(servers) == 1 && servers[0] == addr
+	}, 100*time.Millisecond, 5*time.Millisecond)
+
+	// 第一个注册中心不可用时使用下一个
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	failover := NewRegistryDiscoveryURLs([]string{dead.URL, ts2.URL}, time.Minute)
+	servers, err := failover.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{addr}, servers)
+	assert.Equal(t, 1, failover.current)
+
+	_, err = NewRegistryDiscoveryURLs([]string{dead.URL}, time.Minute).GetAll()
+	assert.NotNil(t, err)
+}
+
+func TestRegistryDiscovery_Token(t *testing.T) {
+	r := registry.New(time.Minute)
+	r.SetAuth(registry.AuthOptions{WriteTokens: []string{"w"}, ReadTokens: []string{"r"}})
+	reg := httptest.NewServer(r)
+	defer reg.Close()
+	assert.Nil(t, registry.HeartbeatWithOptions(reg.URL, "tcp@a", registry.HeartbeatOptions{Interval: time.Minute, Token: "w"}))
+
+	d := NewRegistryDiscovery(reg.URL, time.Minute)
+	_, err := d.GetAll()
+	assert.NotNil(t, err)
+	d.SetToken("r")
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a"}, servers)
+}
+
+func TestRegistryDiscovery_Watch(t *testing.T) {
+	reg := httptest.NewServer(registry.New(time.Minute))
+	defer reg.Close()
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@a", nil, time.Minute, nil))
+
+	// 刷新间隔很长，只能通过 watch 及时得知变化
+	d := NewRegistryDiscovery(reg.URL, time.Hour)
+	updates, cancel := d.Subscribe()
+	defer cancel()
+	d.EnableWatch(time.Second)
+	defer func() { _ = d.Close() }()
+	select {
+	case servers := <-updates:
+		assert.Equal(t, []string{"tcp@a"}, servers)
+	case <-time.After(time.Second):
+		t.Fatal("expect the initial list")
+	}
+
+	start := time.Now()
+	assert.Nil(t, registry.Heartbeat(reg.URL, "tcp@b", nil, time.Minute, nil))
+	select {
+	case servers := <-updates:
+		assert.ElementsMatch(t, []string{"tcp@a", "tcp@b"}, servers)
+		assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	case <-time.After(time.Second):
+		t.Fatal("expect an update pushed by the registry")
+	}
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"tcp@a", "tcp@b"}, servers)
+}
@@ -0,0 +1,134 @@
+package xclient
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mapKeys(t *testing.T, d KeyedDiscovery, keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		server, err := d.GetWithKey(ConsistentHashSelect, key)
+		assert.Nil(t, err)
+		m[key] = server
+	}
+	return m
+}
+
+func TestMultiServersDiscovery_ConsistentHash(t *testing.T) {
+	servers := []string{"tcp@10.0.0.1:9999", "tcp@10.0.0.2:9999", "tcp@10.0.0.3:9999", "tcp@10.0.0.4:9999"}
+	d := NewMultiServerDiscovery(servers)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+	before := mapKeys(t, d, keys)
+	assert.Equal(t, before, mapKeys(t, d, keys), "same key, same server")
+	counts := make(map[string]int)
+	for _, s := range before {
+		counts[s]++
+	}
+	assert.Equal(t, len(servers), len(counts))
+	for s, n := range counts {
+		assert.True(t, n > 100, "%s got %d keys", s, n)
+	}
+
+	// 加入服务器：只有被分配到新服务器的 key 会变化，约占 1/N
+	added := "tcp@10.0.0.5:9999"
+	assert.Nil(t, d.Update(append(append([]string(nil), servers...), added)))
+	moved := 0
+	for key, s := range mapKeys(t, d, keys) {
+		if s != before[key] {
+			assert.Equal(t, added, s, key)
+			moved++
+		}
+	}
+	assert.True(t, moved > 100 && moved < 350, "moved %d keys", moved)
+
+	// 移除服务器：只有原本在该服务器上的 key 会变化
+	removed := servers[0]
+	assert.Nil(t, d.Update(servers[1:]))
+	for key, s := range mapKeys(t, d, keys) {
+		if before[key] != removed {
+			assert.Equal(t, before[key], s, key)
+		} else {
+			assert.NotEqual(t, removed, s, key)
+		}
+	}
+
+	// 服务器列表不变时重新设置不影响映射
+	assert.Nil(t, d.Update(servers))
+	assert.Equal(t, before, mapKeys(t, d, keys))
+}
+
+func TestMultiServersDiscovery_ConsistentHashEdgeCases(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"a"})
+	d.SetVirtualNodes(1)
+	for _, key := range []string{"x", "y", "z"} {
+		s, err := d.GetWithKey(ConsistentHashSelect, key)
+		assert.Nil(t, err)
+		assert.Equal(t, "a", s, "single server ring")
+	}
+
+	assert.Nil(t, d.Update([]string{"a", "b"}))
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		s, err := d.Get(ConsistentHashSelect)
+		assert.Nil(t, err)
+		seen[s] = true
+	}
+	assert.Equal(t, 2, len(seen), "empty key falls back to random")
+
+	assert.Nil(t, d.Update(nil))
+	_, err := d.GetWithKey(ConsistentHashSelect, "x")
+	assert.EqualError(t, err, "rpc discovery: no available servers")
+}
+
+func TestMultiServersDiscovery_Subscribe(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	d := NewMultiServerDiscovery([]string{"tcp@a:1", "tcp@b:1"})
+	updates, cancel := d.Subscribe()
+	updates2, cancel2 := d.Subscribe()
+
+	// 顺序变化不算列表变化
+	assert.Nil(t, d.Update([]string{"tcp@b:1", "tcp@a:1"}))
+	select {
+	case servers := <-updates:
+		t.Fatalf("unexpected notification %v", servers)
+	default:
+	}
+
+	assert.Nil(t, d.Update([]string{"tcp@a:1", "tcp@c:1"}))
+	assert.Nil(t, d.Update([]string{"tcp@c:1", "tcp@a:1"}))
+	assert.Equal(t, []string{"tcp@a:1", "tcp@c:1"}, <-updates)
+	select {
+	case servers := <-updates:
+		t.Fatalf("expected exactly one notification, got another %v", servers)
+	default:
+	}
+
+	// 没有及时读取时只保留最新的列表
+	assert.Nil(t, d.Update([]string{"tcp@d:1"}))
+	assert.Equal(t, []string{"tcp@d:1"}, <-updates2)
+	select {
+	case servers := <-updates2:
+		t.Fatalf("superseded list should be dropped, got %v", servers)
+	default:
+	}
+	assert.Equal(t, []string{"tcp@d:1"}, <-updates)
+
+	cancel()
+	cancel()
+	_, ok := <-updates
+	assert.False(t, ok, "cancel closes the channel")
+	assert.Nil(t, d.Update(nil))
+	assert.Equal(t, []string{}, <-updates2)
+	cancel2()
+	assert.Nil(t, d.Update([]string{"tcp@e:1"}))
+	time.Sleep(10 * time.Millisecond)
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+}
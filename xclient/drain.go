@@ -0,0 +1,40 @@
+package xclient
+
+import (
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+const (
+	defaultDrainTimeout = 10 * time.Second
+	drainPollInterval   = 10 * time.Millisecond
+)
+
+// SetDrainTimeout 设置服务器从列表中移除后，等待到它的进行中的调用完成的最长时间，超时后关闭连接；
+// d 小于0时立即关闭，默认为10秒
+func (xc *XClient) SetDrainTimeout(d time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.drainTimeout = d
+}
+
+// drainLocked 在后台等待 client 进行中的调用完成或超过 drainTimeout 后关闭 client，需要持有 xc.mu
+func (xc *XClient) drainLocked(client *geerpc.Client) {
+	delete(xc.expires, client)
+	if xc.drainTimeout < 0 || client.Pending() == 0 {
+		_ = client.Close()
+		return
+	}
+	xc.draining[client] = struct{}{}
+	deadline := time.Now().Add(xc.drainTimeout)
+	go func() {
+		for client.Pending() > 0 && time.Now().Before(deadline) {
+			time.Sleep(drainPollInterval)
+		}
+		_ = client.Close()
+		xc.mu.Lock()
+		delete(xc.draining, client)
+		xc.mu.Unlock()
+	}()
+}
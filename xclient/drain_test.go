@@ -0,0 +1,96 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+func TestXClient_DrainOnRemoval(t *testing.T) {
+	_, removed := startServer(t, geerpc.WorkerPool{})
+	_, kept := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{removed, kept})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- xc.CallServer(context.Background(), removed, "Foo.Sleep", 200*time.Millisecond, new(int))
+	}()
+	assert.Eventually(t, func() bool { return xc.Stats()[removed].InFlight == 1 }, time.Second, 5*time.Millisecond)
+	xc.mu.Lock()
+	client := xc.clients[removed]
+	xc.mu.Unlock()
+
+	// 移除后不再选择该服务器，进行中的调用正常完成
+	assert.Nil(t, d.Update([]string{kept}))
+	assert.Eventually(t, func() bool {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		_, ok := xc.clients[removed]
+		return !ok
+	}, time.Second, 5*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+	}
+	stats := xc.Stats()[removed]
+	assert.Equal(t, int64(1), stats.InFlight)
+	assert.Zero(t, stats.Calls)
+	assert.True(t, client.IsAvailable())
+	assert.Nil(t, <-done)
+
+	// 调用完成后关闭连接
+	assert.Eventually(t, func() bool { return !client.IsAvailable() }, time.Second, 5*time.Millisecond)
+	xc.mu.Lock()
+	assert.Empty(t, xc.draining)
+	xc.mu.Unlock()
+}
+
+func TestXClient_DrainTimeout(t *testing.T) {
+	_, removed := startServer(t, geerpc.WorkerPool{})
+	_, kept := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{removed, kept})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetDrainTimeout(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- xc.CallServer(context.Background(), removed, "Foo.Sleep", time.Second, new(int))
+	}()
+	assert.Eventually(t, func() bool { return xc.Stats()[removed].InFlight == 1 }, time.Second, 5*time.Millisecond)
+
+	// 超过 drainTimeout 后关闭连接，进行中的调用失败
+	start := time.Now()
+	assert.Nil(t, d.Update([]string{kept}))
+	assert.NotNil(t, <-done)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestXClient_DrainClose(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClient(d, RandomSelect, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- xc.CallServer(context.Background(), addr, "Foo.Sleep", time.Second, new(int))
+	}()
+	assert.Eventually(t, func() bool { return xc.Stats()[addr].InFlight == 1 }, time.Second, 5*time.Millisecond)
+	assert.Nil(t, d.Update(nil))
+	assert.Eventually(t, func() bool {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return len(xc.draining) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Close 同样关闭等待中的连接
+	start := time.Now()
+	assert.Nil(t, xc.Close())
+	assert.NotNil(t, <-done)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
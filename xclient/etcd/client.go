@@ -0,0 +1,260 @@
+// Package etcd 提供基于 etcd 的服务发现与注册
+// 默认通过 etcd v3 的 JSON 网关（/v3/kv/range、/v3/watch 等）访问 etcd，不依赖 etcd 的 Go 客户端；
+// 也可以实现 Client 接口接入其他客户端
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// KeyValue etcd 中的一个键值对
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Event 监听到的一次变更
+type Event struct {
+	Delete bool // true 表示键被删除（包括租约过期），此时 Value 为空
+	KeyValue
+}
+
+// WatchResponse 监听返回的一批变更，Err 不为 nil 时监听已经结束
+type WatchResponse struct {
+	Revision int64
+	Events   []Event
+	Err      error
+}
+
+// Client Discovery 与 Register 使用的 etcd 操作
+type Client interface {
+	// Range 返回以 prefix 开头的所有键值对及当前的 revision
+	Range(ctx context.Context, prefix string) ([]KeyValue, int64, error)
+	// Watch 从 revision 开始监听以 prefix 开头的键的变更，ctx 结束或连接断开时关闭返回的 channel
+	Watch(ctx context.Context, prefix string, revision int64) (<-chan WatchResponse, error)
+	// Grant 创建租约
+	Grant(ctx context.Context, ttl time.Duration) (int64, error)
+	// KeepAlive 续约一次，租约已经过期时 alive 为 false
+	KeepAlive(ctx context.Context, lease int64) (alive bool, err error)
+	// Put 写入键值对，lease 不为0时键随租约过期
+	Put(ctx context.Context, key, value string, lease int64) error
+	// Revoke 撤销租约，租约下的键会被删除
+	Revoke(ctx context.Context, lease int64) error
+}
+
+// gatewayClient 通过 etcd 的 JSON 网关实现 Client，请求失败时依次尝试下一个 endpoint
+type gatewayClient struct {
+	endpoints []string
+	current   uint32 // 当前使用的 endpoint，原子访问
+	client    *http.Client
+}
+
+// NewClient 返回通过 JSON 网关访问 etcd 的 Client，endpoint 形如 "http://127.0.0.1:2379"，省略协议时使用 http
+func NewClient(endpoints []string) (Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("rpc discovery: no etcd endpoints")
+	}
+	c := &gatewayClient{client: &http.Client{}}
+	for _, ep := range endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = "http://" + ep
+		}
+		c.endpoints = append(c.endpoints, strings.TrimRight(ep, "/"))
+	}
+	return c, nil
+}
+
+// do 发送请求，返回响应由调用方关闭；网络错误时换下一个 endpoint 重试
+func (c *gatewayClient) do(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for i := 0; i < len(c.endpoints); i++ {
+		n := atomic.LoadUint32(&c.current)
+		req, err := http.NewRequest(http.MethodPost, c.endpoints[int(n)%len(c.endpoints)]+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if err == nil {
+			if resp.StatusCode == http.StatusOK {
+				return resp, nil
+			}
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("rpc discovery: etcd %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		atomic.CompareAndSwapUint32(&c.current, n, n+1)
+	}
+	return nil, fmt.Errorf("rpc discovery: etcd %s: %v", path, lastErr)
+}
+
+// call 发送请求并解码非流式的响应
+func (c *gatewayClient) call(ctx context.Context, path string, body, reply interface{}) error {
+	resp, err := c.do(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// 网关的 JSON 格式：bytes 字段为 base64，int64 字段为字符串
+type (
+	gwHeader struct {
+		Revision int64 `json:"revision,string"`
+	}
+	gwKeyValue struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+	gwRangeRequest struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}
+	gwRangeResponse struct {
+		Header gwHeader     `json:"header"`
+		Kvs    []gwKeyValue `json:"kvs"`
+	}
+	gwWatchRequest struct {
+		CreateRequest struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			StartRevision int64  `json:"start_revision,string"`
+		} `json:"create_request"`
+	}
+	gwWatchResponse struct {
+		Result *struct {
+			Header   gwHeader `json:"header"`
+			Canceled bool     `json:"canceled"`
+			Events   []struct {
+				Type string     `json:"type"`
+				Kv   gwKeyValue `json:"kv"`
+			} `json:"events"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	gwLease struct {
+		ID  int64 `json:"ID,string"`
+		TTL int64 `json:"TTL,string"`
+	}
+	gwPutRequest struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+		Lease int64  `json:"lease,string"`
+	}
+)
+
+// prefixEnd 返回前缀查询的 range_end，即 prefix 的最后一个可以加1的字节加1
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // 前缀全为 0xff 时查询所有大于等于 prefix 的键
+}
+
+func (c *gatewayClient) Range(ctx context.Context, prefix string) ([]KeyValue, int64, error) {
+	var resp gwRangeResponse
+	if err := c.call(ctx, "/v3/kv/range", gwRangeRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix)}, &resp); err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, KeyValue{Key: string(kv.Key), Value: string(kv.Value)})
+	}
+	return kvs, resp.Header.Revision, nil
+}
+
+func (c *gatewayClient) Watch(ctx context.Context, prefix string, revision int64) (<-chan WatchResponse, error) {
+	var req gwWatchRequest
+	req.CreateRequest.Key, req.CreateRequest.RangeEnd = []byte(prefix), prefixEnd(prefix)
+	req.CreateRequest.StartRevision = revision
+	resp, err := c.do(ctx, "/v3/watch", req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan WatchResponse)
+	go func() {
+		defer close(ch)
+		defer func() { _ = resp.Body.Close() }()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var msg gwWatchResponse
+			err := dec.Decode(&msg)
+			if err == nil && msg.Error != nil {
+				err = errors.New("rpc discovery: etcd watch: " + msg.Error.Message)
+			}
+			if err == nil && (msg.Result == nil || msg.Result.Canceled) {
+				err = errors.New("rpc discovery: etcd watch canceled")
+			}
+			if err != nil {
+				select {
+				case ch <- WatchResponse{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			wr := WatchResponse{Revision: msg.Result.Header.Revision}
+			for _, e := range msg.Result.Events {
+				wr.Events = append(wr.Events, Event{
+					Delete:   e.Type == "DELETE",
+					KeyValue: KeyValue{Key: string(e.Kv.Key), Value: string(e.Kv.Value)},
+				})
+			}
+			select {
+			case ch <- wr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *gatewayClient) Grant(ctx context.Context, ttl time.Duration) (int64, error) {
+	var resp gwLease
+	if err := c.call(ctx, "/v3/lease/grant", gwLease{TTL: int64(ttl / time.Second)}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+func (c *gatewayClient) KeepAlive(ctx context.Context, lease int64) (bool, error) {
+	var resp struct {
+		Result gwLease `json:"result"`
+	}
+	if err := c.call(ctx, "/v3/lease/keepalive", gwLease{ID: lease}, &resp); err != nil {
+		return false, err
+	}
+	return resp.Result.TTL > 0, nil
+}
+
+func (c *gatewayClient) Put(ctx context.Context, key, value string, lease int64) error {
+	return c.call(ctx, "/v3/kv/put", gwPutRequest{Key: []byte(key), Value: []byte(value), Lease: lease}, &struct{}{})
+}
+
+func (c *gatewayClient) Revoke(ctx context.Context, lease int64) error {
+	return c.call(ctx, "/v3/lease/revoke", gwLease{ID: lease}, &struct{}{})
+}
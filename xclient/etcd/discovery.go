@@ -0,0 +1,274 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+const (
+	// retryInterval 与 etcd 的连接断开后重新获取列表的间隔
+	retryInterval = time.Second
+	// requestTimeout 单次请求 etcd 的超时时间，不包括监听
+	requestTimeout = 5 * time.Second
+)
+
+// Discovery 基于 etcd 的 Discovery，服务器地址保存在 servicePrefix 下的键中（键为 servicePrefix+地址，值为地址）
+// 后台的 goroutine 监听前缀下的变更并更新缓存，Get 与 GetAll 只读取缓存，不会每次调用都访问 etcd；
+// 与 etcd 的连接断开时继续使用最后一次获取到的列表，Stale 返回 true，直到重新连接成功
+type Discovery struct {
+	*xclient.MultiServersDiscovery
+	client Client
+	prefix string
+
+	mu      sync.Mutex        // protect following
+	servers map[string]string // 键到服务器地址
+	rev     int64             // 缓存对应的 revision
+	stale   bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ xclient.KeyedDiscovery = (*Discovery)(nil)
+
+// NewEtcdDiscovery 通过 JSON 网关连接 endpoints 中的 etcd，发现 servicePrefix 下注册的服务器
+// 首次获取列表失败时返回错误；不再使用时需要调用 Close 停止监听
+func NewEtcdDiscovery(endpoints []string, servicePrefix string) (*Discovery, error) {
+	c, err := NewClient(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiscoveryWithClient(c, servicePrefix)
+}
+
+// NewDiscoveryWithClient 与 NewEtcdDiscovery 相同，但使用指定的 Client
+func NewDiscoveryWithClient(c Client, servicePrefix string) (*Discovery, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Discovery{
+		MultiServersDiscovery: xclient.NewMultiServerDiscovery(nil),
+		client:                c,
+		prefix:                servicePrefix,
+		cancel:                cancel,
+		done:                  make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go d.watch(ctx)
+	return d, nil
+}
+
+// Refresh 从 etcd 重新获取前缀下的所有服务器，失败时保留原有列表并标记为过期
+func (d *Discovery) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	kvs, rev, err := d.client.Range(ctx, d.prefix)
+	if err != nil {
+		d.mu.Lock()
+		d.stale = true
+		d.mu.Unlock()
+		return err
+	}
+	servers := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		servers[kv.Key] = kv.Value
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers, d.rev, d.stale = servers, rev, false
+	return d.update()
+}
+
+// update 用缓存更新 MultiServersDiscovery 的服务器列表，需要持有 d.mu
+func (d *Discovery) update() error {
+	list := make([]string, 0, len(d.servers))
+	for _, addr := range d.servers {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+	return d.MultiServersDiscovery.Update(list)
+}
+
+// Update 不支持手动更新，服务器列表以 etcd 为准
+func (d *Discovery) Update(servers []string) error {
+	return errors.New("rpc discovery: etcd discovery does not support Update")
+}
+
+// Stale 返回缓存的列表是否可能已经过期，即与 etcd 的连接断开后还没有重新获取
+func (d *Discovery) Stale() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stale
+}
+
+// watch 监听前缀下的变更，连接断开后定期重新获取列表并从新的 revision 继续监听
+func (d *Discovery) watch(ctx context.Context) {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		rev := d.rev
+		d.mu.Unlock()
+		ch, err := d.client.Watch(ctx, d.prefix, rev+1)
+		if err == nil {
+			err = d.apply(ctx, ch)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("rpc discovery: etcd watch error:", err)
+		d.mu.Lock()
+		d.stale = true
+		d.mu.Unlock()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			if d.Refresh() == nil {
+				break
+			}
+		}
+	}
+}
+
+// apply 将监听到的变更应用到缓存，返回监听结束的原因
+func (d *Discovery) apply(ctx context.Context, ch <-chan WatchResponse) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wr, ok := <-ch:
+			if !ok {
+				return errors.New("rpc discovery: etcd watch closed")
+			}
+			if wr.Err != nil {
+				return wr.Err
+			}
+			d.mu.Lock()
+			for _, e := range wr.Events {
+				if !strings.HasPrefix(e.Key, d.prefix) {
+					continue
+				}
+				if e.Delete {
+					delete(d.servers, e.Key)
+				} else {
+					d.servers[e.Key] = e.Value
+				}
+			}
+			if wr.Revision > d.rev {
+				d.rev = wr.Revision
+			}
+			_ = d.update()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Close 停止监听
+func (d *Discovery) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// Registration Register 创建的注册，Close 时撤销租约并删除键
+type Registration struct {
+	client Client
+	key    string
+	addr   string
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	lease  int64
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Register 通过 JSON 网关连接 etcd，将 addr 注册到 servicePrefix 下，键随 ttl 的租约过期，后台定期续约
+// 服务器关闭时调用 Registration.Close，如通过 Server.OnShutdown 注册
+func Register(endpoints []string, servicePrefix, addr string, ttl time.Duration) (*Registration, error) {
+	c, err := NewClient(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return RegisterWithClient(c, servicePrefix, addr, ttl)
+}
+
+// RegisterWithClient 与 Register 相同，但使用指定的 Client；ttl 小于1秒时使用1秒
+func RegisterWithClient(c Client, servicePrefix, addr string, ttl time.Duration) (*Registration, error) {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Registration{client: c, key: servicePrefix + addr, addr: addr, ttl: ttl, cancel: cancel, done: make(chan struct{})}
+	if err := r.register(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	go r.keepAlive(ctx)
+	return r, nil
+}
+
+// register 创建租约并写入键
+func (r *Registration) register(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	lease, err := r.client.Grant(ctx, r.ttl)
+	if err != nil {
+		return err
+	}
+	if err = r.client.Put(ctx, r.key, r.addr, lease); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.lease = lease
+	r.mu.Unlock()
+	return nil
+}
+
+// keepAlive 每隔 ttl/3 续约一次，租约已经过期（如 etcd 长时间不可用）时重新注册
+func (r *Registration) keepAlive(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		lease := r.lease
+		r.mu.Unlock()
+		kctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		alive, err := r.client.KeepAlive(kctx, lease)
+		cancel()
+		if err == nil && !alive {
+			err = r.register(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Println("rpc registry: etcd keep alive error:", err)
+		}
+	}
+}
+
+// Close 停止续约并撤销租约，键会立即被删除
+func (r *Registration) Close() error {
+	r.cancel()
+	<-r.done
+	r.mu.Lock()
+	lease := r.lease
+	r.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return r.client.Revoke(ctx, lease)
+}
@@ -0,0 +1,233 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/xclient"
+)
+
+// fakeClient 内存中的 etcd，down 为 true 时所有请求失败并结束监听
+type fakeClient struct {
+	mu       sync.Mutex
+	kvs      map[string]KeyValue
+	leases   map[int64][]string // 租约下的键
+	rev      int64
+	nextID   int64
+	down     bool
+	watchers []chan WatchResponse
+	history  []WatchResponse // 所有变更，Watch 时从指定的 revision 开始重放
+	ranges   int             // Range 的调用次数
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{kvs: make(map[string]KeyValue), leases: make(map[int64][]string)}
+}
+
+var errDown = errors.New("etcd unavailable")
+
+func (f *fakeClient) Range(ctx context.Context, prefix string) ([]KeyValue, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ranges++
+	if f.down {
+		return nil, 0, errDown
+	}
+	var kvs []KeyValue
+	for k, kv := range f.kvs {
+		if strings.HasPrefix(k, prefix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, f.rev, nil
+}
+
+func (f *fakeClient) Watch(ctx context.Context, prefix string, revision int64) (<-chan WatchResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errDown
+	}
+	ch := make(chan WatchResponse, 64)
+	for _, wr := range f.history {
+		if wr.Revision >= revision {
+			ch <- wr
+		}
+	}
+	f.watchers = append(f.watchers, ch)
+	return ch, nil
+}
+
+// notify 通知所有监听者，需要持有 f.mu
+func (f *fakeClient) notify(e Event) {
+	f.rev++
+	wr := WatchResponse{Revision: f.rev, Events: []Event{e}}
+	f.history = append(f.history, wr)
+	for _, ch := range f.watchers {
+		ch <- wr
+	}
+}
+
+func (f *fakeClient) Grant(ctx context.Context, ttl time.Duration) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return 0, errDown
+	}
+	f.nextID++
+	f.leases[f.nextID] = nil
+	return f.nextID, nil
+}
+
+func (f *fakeClient) KeepAlive(ctx context.Context, lease int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return false, errDown
+	}
+	_, ok := f.leases[lease]
+	return ok, nil
+}
+
+func (f *fakeClient) Put(ctx context.Context, key, value string, lease int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errDown
+	}
+	f.kvs[key] = KeyValue{Key: key, Value: value}
+	if lease != 0 {
+		f.leases[lease] = append(f.leases[lease], key)
+	}
+	f.notify(Event{KeyValue: KeyValue{Key: key, Value: value}})
+	return nil
+}
+
+func (f *fakeClient) Revoke(ctx context.Context, lease int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errDown
+	}
+	for _, key := range f.leases[lease] {
+		delete(f.kvs, key)
+		f.notify(Event{Delete: true, KeyValue: KeyValue{Key: key}})
+	}
+	delete(f.leases, lease)
+	return nil
+}
+
+// setDown 模拟与 etcd 的连接断开或恢复
+func (f *fakeClient) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+	if down {
+		for _, ch := range f.watchers {
+			ch <- WatchResponse{Err: errDown}
+			close(ch)
+		}
+		f.watchers = nil
+	}
+}
+
+func waitServers(t *testing.T, d xclient.Discovery, want []string) {
+	t.Helper()
+	var servers []string
+	for i := 0; i < 200; i++ {
+		servers, _ = d.GetAll()
+		if len(servers) == len(want) && (len(want) == 0 || assert.ObjectsAreEqual(want, servers)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, want, servers)
+}
+
+func TestDiscovery(t *testing.T) {
+	f := newFakeClient()
+	_ = f.Put(context.Background(), "/other/tcp@c:1", "tcp@c:1", 0)
+	a, err := RegisterWithClient(f, "/geerpc/", "tcp@a:1", 3*time.Second)
+	assert.Nil(t, err)
+	d, err := NewDiscoveryWithClient(f, "/geerpc/")
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	waitServers(t, d, []string{"tcp@a:1"})
+
+	b, err := RegisterWithClient(f, "/geerpc/", "tcp@b:1", 3*time.Second)
+	assert.Nil(t, err)
+	waitServers(t, d, []string{"tcp@a:1", "tcp@b:1"})
+
+	f.mu.Lock()
+	ranges := f.ranges
+	f.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		_, err = d.Get(xclient.RoundRobinSelect)
+		assert.Nil(t, err)
+	}
+	f.mu.Lock()
+	assert.Equal(t, ranges, f.ranges, "Get serves from the cache")
+	f.mu.Unlock()
+
+	assert.Nil(t, b.Close())
+	waitServers(t, d, []string{"tcp@a:1"})
+
+	// 连接断开时继续使用缓存，并标记为过期
+	f.setDown(true)
+	for i := 0; i < 100 && !d.Stale(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, d.Stale())
+	addr, err := d.Get(xclient.RandomSelect)
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp@a:1", addr)
+
+	// 断开期间的变更在恢复后重新获取
+	f.mu.Lock()
+	f.kvs["/geerpc/tcp@d:1"] = KeyValue{Key: "/geerpc/tcp@d:1", Value: "tcp@d:1"}
+	f.rev++
+	f.down = false
+	f.mu.Unlock()
+	waitServers(t, d, []string{"tcp@a:1", "tcp@d:1"})
+	assert.False(t, d.Stale())
+	_ = f.Put(context.Background(), "/geerpc/tcp@e:1", "tcp@e:1", 0)
+	waitServers(t, d, []string{"tcp@a:1", "tcp@d:1", "tcp@e:1"})
+
+	assert.Nil(t, a.Close())
+	waitServers(t, d, []string{"tcp@d:1", "tcp@e:1"})
+	assert.NotNil(t, d.Update(nil))
+}
+
+func TestRegistration_ReRegister(t *testing.T) {
+	f := newFakeClient()
+	r, err := RegisterWithClient(f, "/geerpc/", "tcp@a:1", time.Second)
+	assert.Nil(t, err)
+	defer func() { _ = r.Close() }()
+
+	// 租约过期后续约失败，重新注册
+	f.mu.Lock()
+	for id := range f.leases {
+		delete(f.leases, id)
+	}
+	delete(f.kvs, "/geerpc/tcp@a:1")
+	f.mu.Unlock()
+	for i := 0; i < 200; i++ {
+		kvs, _, _ := f.Range(context.Background(), "/geerpc/")
+		if len(kvs) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("registration was not renewed")
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("/geerpc0"), prefixEnd("/geerpc/"))
+	assert.Equal(t, []byte("b"), prefixEnd("a\xff"))
+	assert.Equal(t, []byte{0}, prefixEnd("\xff"))
+}
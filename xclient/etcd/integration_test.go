@@ -0,0 +1,36 @@
+package etcd
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEtcd_Integration 需要可用的 etcd，通过 ETCD_ENDPOINTS（逗号分隔）指定，未设置时跳过
+func TestEtcd_Integration(t *testing.T) {
+	env := os.Getenv("ETCD_ENDPOINTS")
+	if env == "" {
+		t.Skip("ETCD_ENDPOINTS not set")
+	}
+	endpoints := strings.Split(env, ",")
+	prefix := "/geerpc-test/" + time.Now().Format("150405.000") + "/"
+
+	a, err := Register(endpoints, prefix, "tcp@127.0.0.1:1", 5*time.Second)
+	assert.Nil(t, err)
+	d, err := NewEtcdDiscovery(endpoints, prefix)
+	assert.Nil(t, err)
+	defer func() { _ = d.Close() }()
+	waitServers(t, d, []string{"tcp@127.0.0.1:1"})
+
+	b, err := Register(endpoints, prefix, "tcp@127.0.0.1:2", 5*time.Second)
+	assert.Nil(t, err)
+	waitServers(t, d, []string{"tcp@127.0.0.1:1", "tcp@127.0.0.1:2"})
+
+	assert.Nil(t, a.Close())
+	assert.Nil(t, b.Close())
+	waitServers(t, d, []string{})
+	assert.False(t, d.Stale())
+}
@@ -0,0 +1,114 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// DialError 连接服务器失败，调用没有发出
+type DialError struct {
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return "rpc client: dial " + e.Addr + ": " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// FailoverPolicy 调用失败时换其他服务器重试的策略，重试时不会选择已经尝试过的服务器
+// 每次尝试使用由调用的 ctx 派生、按 Budget 设置截止时间的 ctx，调用的 ctx 结束后不再重试
+type FailoverPolicy struct {
+	// MaxAttempts 最多尝试的次数（包括第一次），为0时尝试所有服务器，为1时不重试
+	MaxAttempts int
+	// Retryable 判断错误是否可以重试，为 nil 时使用 IsRetryable
+	Retryable func(err error) bool
+	// Budget 每次尝试可以使用的时间，为 nil 时使用 EvenBudget
+	Budget BudgetStrategy
+	// Now 返回当前时间，为 nil 时使用 time.Now，用于测试
+	Now func() time.Time
+}
+
+// FailureReporter 接收 XClient 报告的服务器故障的 Discovery，为可选接口
+// 调用因连接失败或连接断开而失败时，XClient 调用 ReportFailure，包括重试、广播与 CallServer 中的调用
+type FailureReporter interface {
+	ReportFailure(addr string, err error)
+}
+
+// SuccessReporter 接收 XClient 报告的成功调用的 Discovery，为可选接口
+type SuccessReporter interface {
+	ReportSuccess(addr string)
+}
+
+// IsRetryable 默认的可重试错误：连接失败、连接已经关闭、熔断器打开、服务器繁忙或正在停止，这些情况下请求没有被处理
+// 方法返回的错误与 ctx 结束不会重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var de *DialError
+	return errors.As(err, &de) ||
+		errors.Is(err, geerpc.ErrShutdown) ||
+		errors.Is(err, ErrBreakerOpen) ||
+		errors.Is(err, geerpc.ErrServerBusy) ||
+		errors.Is(err, geerpc.ErrServerDraining)
+}
+
+// isConnFailure 判断错误是否说明服务器不可达，需要报告给 FailureReporter
+// 等待连接时 ctx 结束（如对冲请求中落后的调用）不说明服务器有故障
+func isConnFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var de *DialError
+	return errors.As(err, &de) || errors.Is(err, geerpc.ErrShutdown)
+}
+
+// SetFailover 设置失败重试的策略，默认尝试所有服务器并使用 IsRetryable
+func (xc *XClient) SetFailover(p FailoverPolicy) {
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.failover = p
+}
+
+// report 将调用的结果报告给实现了 FailureReporter 或 SuccessReporter 的 Discovery
+func (xc *XClient) report(addr string, err error) {
+	if err == nil {
+		if r, ok := xc.d.(SuccessReporter); ok {
+			r.ReportSuccess(addr)
+		}
+		return
+	}
+	if r, ok := xc.d.(FailureReporter); ok && isConnFailure(err) {
+		r.ReportFailure(addr, err)
+	}
+}
+
+// next 从满足过滤条件、没有尝试过的服务器中随机选择一个
+func (xc *XClient) next(ctx context.Context, tried map[string]bool) (string, bool) {
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", false
+	}
+	var candidates []string
+	for _, addr := range servers {
+		if !tried[addr] {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return candidates[xc.r.Intn(len(candidates))], true
+}
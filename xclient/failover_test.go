@@ -0,0 +1,147 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// Failing 总是返回错误的服务
+type Failing int
+
+func (f Failing) Fail(args Args, reply *int) error {
+	return errors.New("boom")
+}
+
+// deadAddr 返回没有服务器监听的地址
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := "tcp@" + l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+// reportingDiscovery 记录 XClient 报告的故障
+type reportingDiscovery struct {
+	*MultiServersDiscovery
+	mu      sync.Mutex
+	reports []string
+}
+
+func (d *reportingDiscovery) ReportFailure(addr string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reports = append(d.reports, addr)
+}
+
+func TestXClient_Failover(t *testing.T) {
+	live, liveAddr := startServer(t, geerpc.WorkerPool{})
+	dead := deadAddr(t)
+	d := &reportingDiscovery{MultiServersDiscovery: NewMultiServerDiscovery([]string{dead, liveAddr})}
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		assert.Equal(t, i+1, reply)
+	}
+	// 轮询中一半的调用先选中 dead，各重试一次
+	assert.Equal(t, uint64(4), live.Stats().TotalRequests)
+	assert.Equal(t, []string{dead, dead}, d.reports)
+
+	// 不重试时返回连接错误
+	xc.SetFailover(FailoverPolicy{MaxAttempts: 1})
+	var errs []error
+	for i := 0; i < 2; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{}, &reply); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1)
+	var de *DialError
+	assert.True(t, errors.As(errs[0], &de))
+	assert.Equal(t, dead, de.Addr)
+
+	// 所有服务器都不可用
+	xc2 := NewXClient(NewMultiServerDiscovery([]string{dead, deadAddr(t)}), RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	assert.True(t, errors.As(xc2.Call(context.Background(), "Foo.Sum", Args{}, new(int)), &de))
+}
+
+func TestXClient_DialCanceledNotFailure(t *testing.T) {
+	d := &reportingDiscovery{MultiServersDiscovery: NewMultiServerDiscovery([]string{"tcp@a"})}
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Minute})
+	release := make(chan struct{})
+	defer close(release)
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		<-release
+		return nil, errors.New("connection refused")
+	}
+
+	// 等待连接期间 ctx 超时，不计入熔断器，也不报告给 Discovery
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := xc.Call(ctx, "Foo.Sum", Args{}, new(int))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Empty(t, d.reports)
+	assert.Equal(t, BreakerClosed, xc.Breakers()["tcp@a"])
+}
+
+func TestXClient_FailoverNotRetryable(t *testing.T) {
+	a, aAddr := startServer(t, geerpc.WorkerPool{})
+	b, bAddr := startServer(t, geerpc.WorkerPool{})
+	assert.Nil(t, a.Register(new(Failing)))
+	assert.Nil(t, b.Register(new(Failing)))
+	xc := NewXClient(NewMultiServerDiscovery([]string{aAddr, bAddr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 方法返回的错误不重试
+	err := xc.Call(context.Background(), "Failing.Fail", Args{}, new(int))
+	assert.NotNil(t, err)
+	assert.False(t, IsRetryable(err))
+	assert.Equal(t, uint64(1), a.Stats().TotalRequests+b.Stats().TotalRequests)
+
+	// ctx 超时不重试
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = xc.Call(ctx, "Foo.Sleep", 200*time.Millisecond, new(int))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(2), a.Stats().TotalRequests+b.Stats().TotalRequests)
+
+	assert.True(t, IsRetryable(geerpc.ErrShutdown))
+	assert.True(t, IsRetryable(geerpc.ErrServerBusy))
+	assert.False(t, IsRetryable(context.Canceled))
+}
+
+func TestXClient_FailoverEjects(t *testing.T) {
+	_, liveAddr := startServer(t, geerpc.WorkerPool{})
+	dead := deadAddr(t)
+	events := make(chan healthEvent, 1)
+	d := NewHealthCheckDiscovery(NewMultiServerDiscovery([]string{dead, liveAddr}), HealthCheckOptions{
+		Interval:    time.Hour,
+		MaxFailures: 1,
+		Probe:       func(string, time.Duration) error { return nil },
+		OnChange:    func(addr string, healthy bool) { events <- healthEvent{addr, healthy} },
+	})
+	defer func() { _ = d.Close() }()
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{}, new(int)))
+	}
+	assert.Equal(t, healthEvent{dead, false}, <-events)
+	servers, _ := d.GetAll()
+	assert.Equal(t, []string{liveAddr}, servers)
+}
@@ -0,0 +1,86 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNoMatchingServers 有服务器但都不满足 ServerFilter
+var ErrNoMatchingServers = errors.New("rpc discovery: no servers match the filter")
+
+// ServerFilter 判断服务器是否可以被选择，如只选择 Tags["canary"] 不为 "true" 的服务器
+type ServerFilter func(ServerInstance) bool
+
+type serverFilterKey struct{}
+
+// WithServerFilter 返回携带 filter 的 ctx，使用该 ctx 的 Call 与广播只选择满足 filter 的服务器，
+// 代替 SetServerFilter 设置的默认条件；filter 为 nil 时不过滤
+func WithServerFilter(ctx context.Context, filter ServerFilter) context.Context {
+	return context.WithValue(ctx, serverFilterKey{}, filter)
+}
+
+// SetServerFilter 设置默认的过滤条件，在负载均衡策略之前应用，重试与广播同样只选择满足条件的服务器
+func (xc *XClient) SetServerFilter(filter ServerFilter) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.filter = filter
+}
+
+// filterFor 返回 ctx 使用的过滤条件，ctx 中没有时使用默认条件
+func (xc *XClient) filterFor(ctx context.Context) ServerFilter {
+	if filter, ok := ctx.Value(serverFilterKey{}).(ServerFilter); ok {
+		return filter
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.filter
+}
+
+// instances 返回满足 filter 的服务器，filter 为 nil 时返回所有服务器；有服务器但都不满足时返回 ErrNoMatchingServers
+func (xc *XClient) instances(filter ServerFilter) ([]ServerInstance, error) {
+	instances, err := GetAllInstances(xc.d)
+	if err != nil || filter == nil {
+		return instances, err
+	}
+	matched := make([]ServerInstance, 0, len(instances))
+	for _, in := range instances {
+		if filter(in) {
+			matched = append(matched, in)
+		}
+	}
+	if len(matched) == 0 && len(instances) > 0 {
+		return nil, ErrNoMatchingServers
+	}
+	return matched, nil
+}
+
+// servers 返回 ctx 可以使用的服务器地址
+func (xc *XClient) servers(ctx context.Context) ([]string, error) {
+	filter := xc.filterFor(ctx)
+	if filter == nil {
+		return xc.d.GetAll()
+	}
+	instances, err := xc.instances(filter)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, len(instances))
+	for i, in := range instances {
+		servers[i] = in.Addr
+	}
+	return servers, nil
+}
+
+// filteredPicker 在满足过滤条件的服务器中按负载均衡策略选择，服务器不变时保留轮询与加权轮询的状态
+type filteredPicker struct {
+	mu sync.Mutex
+	d  *MultiServersDiscovery
+}
+
+func (p *filteredPicker) pick(ctx context.Context, xc *XClient, instances []ServerInstance) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	syncInstances(p.d, instances)
+	return xc.pickFrom(ctx, p.d)
+}
@@ -0,0 +1,85 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+func canary(in ServerInstance) bool { return in.Tags["canary"] == "true" }
+
+func stable(in ServerInstance) bool { return in.Tags["canary"] != "true" }
+
+func TestXClient_ServerFilter(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	var instances []ServerInstance
+	for _, name := range []string{"stable1", "stable2", "canary"} {
+		tag := "false"
+		if name == "canary" {
+			tag = "true"
+		}
+		addr := startEchoServer(t, &Echo{name: name})
+		instances = append(instances, ServerInstance{Addr: addr, Tags: map[string]string{"canary": tag}})
+	}
+	assert.Nil(t, d.UpdateInstances(instances))
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetServerFilter(stable)
+
+	// 默认不选择金丝雀服务器，满足条件的服务器之间仍然轮询
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		var reply string
+		assert.Nil(t, xc.Call(context.Background(), "Echo.Name", 0, &reply))
+		counts[reply]++
+	}
+	assert.Equal(t, map[string]int{"stable1": 5, "stable2": 5}, counts)
+
+	// 单次调用只发往金丝雀服务器
+	ctx := WithServerFilter(context.Background(), canary)
+	for i := 0; i < 3; i++ {
+		var reply string
+		assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+		assert.Equal(t, "canary", reply)
+	}
+
+	// 广播同样只发往满足条件的服务器
+	results, err := xc.BroadcastDetailed(ctx, "Echo.Name", 0, func() interface{} { return new(string) })
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	results, err = xc.BroadcastDetailed(context.Background(), "Echo.Name", 0, func() interface{} { return new(string) })
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	results, err = xc.BroadcastDetailed(WithServerFilter(context.Background(), nil), "Echo.Name", 0, func() interface{} { return new(string) })
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+
+	// 没有满足条件的服务器
+	none := WithServerFilter(context.Background(), func(ServerInstance) bool { return false })
+	assert.Equal(t, ErrNoMatchingServers, xc.Call(none, "Echo.Name", 0, new(string)))
+	assert.Equal(t, ErrNoMatchingServers, xc.Broadcast(none, "Echo.Name", 0, new(string)))
+}
+
+func TestXClient_ServerFilterFailover(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	okAddr := startEchoServer(t, &Echo{name: "canary"})
+	_, stableAddr := startServer(t, geerpc.WorkerPool{})
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: deadAddr(t), Tags: map[string]string{"canary": "true"}},
+		{Addr: okAddr, Tags: map[string]string{"canary": "true"}},
+		{Addr: stableAddr},
+	}))
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 重试时也只选择满足条件的服务器
+	ctx := WithServerFilter(context.Background(), canary)
+	for i := 0; i < 5; i++ {
+		var reply string
+		assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+		assert.Equal(t, "canary", reply)
+	}
+	assert.Zero(t, xc.Stats()[stableAddr].Calls)
+}
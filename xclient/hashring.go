@@ -0,0 +1,43 @@
+package xclient
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes 一致性哈希中每个服务器默认的虚拟节点数
+const defaultVirtualNodes = 160
+
+// hashRing 一致性哈希环，每个服务器对应 replicas 个虚拟节点，增删一个服务器只会影响约 1/N 的 key
+// 创建后只读，服务器列表变化时重新创建
+type hashRing struct {
+	keys  []uint32          // 虚拟节点的哈希值，升序排列
+	nodes map[uint32]string // 虚拟节点对应的服务器
+}
+
+func newHashRing(replicas int, servers []string) *hashRing {
+	r := &hashRing{nodes: make(map[uint32]string, replicas*len(servers))}
+	for _, server := range servers {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + server))
+			if _, ok := r.nodes[h]; ok {
+				continue // 哈希冲突时保留先加入的节点
+			}
+			r.keys = append(r.keys, h)
+			r.nodes[h] = server
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+	return r
+}
+
+// get 返回 key 顺时针方向的第一个虚拟节点对应的服务器，环为空时返回空字符串
+func (r *hashRing) get(key string) string {
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	return r.nodes[r.keys[i%len(r.keys)]]
+}
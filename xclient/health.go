@@ -0,0 +1,263 @@
+package xclient
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeMaxFailures = 3
+)
+
+// HealthCheckOptions HealthCheckDiscovery 的配置
+type HealthCheckOptions struct {
+	Interval    time.Duration // 探测间隔，0表示使用默认值1秒
+	Timeout     time.Duration // 单次探测的超时时间，0表示与 Interval 相同
+	MaxFailures int           // 连续探测失败达到该次数后剔除服务器，0表示使用默认值3
+
+	// Probe 探测服务器是否可用，为 nil 时使用 geerpc.XDial 建立连接后立即关闭
+	Probe func(addr string, timeout time.Duration) error
+	// OnChange 服务器被剔除（healthy 为 false）或恢复时的回调，在探测的 goroutine 中调用
+	OnChange func(addr string, healthy bool)
+}
+
+// HealthCheckDiscovery 为任意 Discovery 增加健康检查：后台定期探测 inner 的所有服务器，
+// Get 与 GetAll 只返回健康的服务器，被剔除的服务器仍会继续探测，恢复后重新加入
+// inner 的服务器列表在每轮探测以及调用 Refresh、Update 后同步，inner 实现了 Watcher 时在其列表变化后立即同步
+type HealthCheckDiscovery struct {
+	inner   Discovery
+	opts    HealthCheckOptions
+	healthy *MultiServersDiscovery // 健康的服务器，负责按负载均衡策略选择
+
+	mu        sync.Mutex // protect following
+	failures  map[string]int
+	unhealthy map[string]bool
+
+	unwatch func() // 取消对 inner 的订阅
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+var (
+	_ KeyedDiscovery    = (*HealthCheckDiscovery)(nil)
+	_ Watcher           = (*HealthCheckDiscovery)(nil)
+	_ InstanceDiscovery = (*HealthCheckDiscovery)(nil)
+	_ FailureReporter   = (*HealthCheckDiscovery)(nil)
+	_ io.Closer         = (*HealthCheckDiscovery)(nil)
+)
+
+// NewHealthCheckDiscovery 包装 inner 并启动后台探测，不再使用时需要调用 Close 停止探测
+func NewHealthCheckDiscovery(inner Discovery, opts HealthCheckOptions) *HealthCheckDiscovery {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultProbeInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = opts.Interval
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = defaultProbeMaxFailures
+	}
+	if opts.Probe == nil {
+		opts.Probe = dialProbe
+	}
+	d := &HealthCheckDiscovery{
+		inner:     inner,
+		opts:      opts,
+		healthy:   NewMultiServerDiscovery(nil),
+		failures:  make(map[string]int),
+		unhealthy: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	if w, ok := inner.(Watcher); ok {
+		var updates <-chan []string
+		updates, d.unwatch = w.Subscribe()
+		d.wg.Add(1)
+		go d.watchInner(updates)
+	}
+	d.sync()
+	d.wg.Add(1)
+	go d.probeLoop()
+	return d
+}
+
+// watchInner inner 的列表变化时同步健康的服务器列表
+func (d *HealthCheckDiscovery) watchInner(updates <-chan []string) {
+	defer d.wg.Done()
+	for range updates {
+		d.sync()
+	}
+}
+
+// dialProbe 默认的探测方式，能够完成握手即视为健康
+func dialProbe(addr string, timeout time.Duration) error {
+	client, err := geerpc.XDial(addr, &geerpc.Option{MagicNumber: geerpc.MagicNumber, ConnectTimeout: timeout})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+func (d *HealthCheckDiscovery) probeLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		d.probe()
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe 并发探测 inner 的所有服务器，更新健康状态后同步健康的服务器列表
+func (d *HealthCheckDiscovery) probe() {
+	servers, err := d.inner.GetAll()
+	if err != nil {
+		return
+	}
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, addr := range servers {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			errs[i] = d.opts.Probe(addr, d.opts.Timeout)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	type change struct {
+		addr    string
+		healthy bool
+	}
+	var changes []change
+	d.mu.Lock()
+	seen := make(map[string]bool, len(servers))
+	for i, addr := range servers {
+		seen[addr] = true
+		if errs[i] == nil {
+			d.failures[addr] = 0
+			if d.unhealthy[addr] {
+				delete(d.unhealthy, addr)
+				changes = append(changes, change{addr, true})
+			}
+			continue
+		}
+		d.failures[addr]++
+		if d.failures[addr] >= d.opts.MaxFailures && !d.unhealthy[addr] {
+			d.unhealthy[addr] = true
+			changes = append(changes, change{addr, false})
+		}
+	}
+	for addr := range d.failures {
+		if !seen[addr] {
+			delete(d.failures, addr)
+			delete(d.unhealthy, addr)
+		}
+	}
+	d.mu.Unlock()
+
+	d.sync()
+	if d.opts.OnChange != nil {
+		for _, c := range changes {
+			d.opts.OnChange(c.addr, c.healthy)
+		}
+	}
+}
+
+// sync 用 inner 中健康的服务器更新 healthy，保留 inner 提供的元数据
+func (d *HealthCheckDiscovery) sync() {
+	instances, err := GetAllInstances(d.inner)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	alive := make([]ServerInstance, 0, len(instances))
+	for _, in := range instances {
+		if !d.unhealthy[in.Addr] {
+			alive = append(alive, in)
+		}
+	}
+	d.mu.Unlock()
+	_ = d.healthy.UpdateInstances(alive)
+}
+
+// ReportFailure 将调用失败计为一次探测失败，连续失败达到 MaxFailures 时立即剔除服务器，不必等待下一轮探测
+func (d *HealthCheckDiscovery) ReportFailure(addr string, err error) {
+	d.mu.Lock()
+	d.failures[addr]++
+	eject := d.failures[addr] >= d.opts.MaxFailures && !d.unhealthy[addr]
+	if eject {
+		d.unhealthy[addr] = true
+	}
+	d.mu.Unlock()
+	if !eject {
+		return
+	}
+	d.sync()
+	if d.opts.OnChange != nil {
+		d.opts.OnChange(addr, false)
+	}
+}
+
+// Refresh 刷新 inner 的服务器列表
+func (d *HealthCheckDiscovery) Refresh() error {
+	if err := d.inner.Refresh(); err != nil {
+		return err
+	}
+	d.sync()
+	return nil
+}
+
+// Update 更新 inner 的服务器列表，新加入的服务器在探测失败之前视为健康
+func (d *HealthCheckDiscovery) Update(servers []string) error {
+	if err := d.inner.Update(servers); err != nil {
+		return err
+	}
+	d.sync()
+	return nil
+}
+
+// Get 从健康的服务器中选择一个
+func (d *HealthCheckDiscovery) Get(mode SelectMode) (string, error) {
+	return d.healthy.Get(mode)
+}
+
+// GetWithKey 从健康的服务器中按 key 选择一个
+func (d *HealthCheckDiscovery) GetWithKey(mode SelectMode, key string) (string, error) {
+	return d.healthy.GetWithKey(mode, key)
+}
+
+// GetAll 返回所有健康的服务器
+func (d *HealthCheckDiscovery) GetAll() ([]string, error) {
+	return d.healthy.GetAll()
+}
+
+// GetAllInstances 返回所有健康的服务器及其元数据
+func (d *HealthCheckDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	return d.healthy.GetAllInstances()
+}
+
+// Subscribe 订阅健康的服务器列表的变化，服务器被剔除、恢复或 inner 的列表变化时通知
+func (d *HealthCheckDiscovery) Subscribe() (<-chan []string, func()) {
+	return d.healthy.Subscribe()
+}
+
+// Close 停止后台探测并等待正在进行的探测结束
+func (d *HealthCheckDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.done)
+		if d.unwatch != nil {
+			d.unwatch()
+		}
+	})
+	d.wg.Wait()
+	return nil
+}
@@ -0,0 +1,92 @@
+package xclient
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+type healthEvent struct {
+	addr    string
+	healthy bool
+}
+
+func TestHealthCheckDiscovery(t *testing.T) {
+	_, addr1 := startServer(t, geerpc.WorkerPool{})
+	_, addr2 := startServer(t, geerpc.WorkerPool{})
+	stopped, addr3 := startServer(t, geerpc.WorkerPool{})
+
+	events := make(chan healthEvent, 10)
+	d := NewHealthCheckDiscovery(NewMultiServerDiscovery([]string{addr1, addr2, addr3}), HealthCheckOptions{
+		Interval:    20 * time.Millisecond,
+		MaxFailures: 2,
+		OnChange:    func(addr string, healthy bool) { events <- healthEvent{addr, healthy} },
+	})
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(servers))
+
+	_ = stopped.Close()
+	select {
+	case e := <-events:
+		assert.Equal(t, healthEvent{addr3, false}, e)
+	case <-time.After(time.Second):
+		t.Fatal("stopped server was not ejected")
+	}
+	for i := 0; i < 30; i++ {
+		addr, err := d.Get(RoundRobinSelect)
+		assert.Nil(t, err)
+		assert.NotEqual(t, addr3, addr)
+	}
+	servers, _ = d.GetAll()
+	assert.Equal(t, []string{addr1, addr2}, servers)
+
+	// 在同一地址上重新启动
+	l, err := net.Listen("tcp", strings.TrimPrefix(addr3, "tcp@"))
+	assert.Nil(t, err)
+	restarted := geerpc.NewServer()
+	go restarted.Accept(l)
+	defer func() { _ = l.Close() }()
+	select {
+	case e := <-events:
+		assert.Equal(t, healthEvent{addr3, true}, e)
+	case <-time.After(time.Second):
+		t.Fatal("restarted server was not recovered")
+	}
+	servers, _ = d.GetAll()
+	assert.Equal(t, []string{addr1, addr2, addr3}, servers)
+
+	assert.Nil(t, d.Close())
+	assert.Nil(t, d.Close(), "Close is idempotent")
+}
+
+func TestHealthCheckDiscovery_Watch(t *testing.T) {
+	_, addr1 := startServer(t, geerpc.WorkerPool{})
+	_, addr2 := startServer(t, geerpc.WorkerPool{})
+	inner := NewMultiServerDiscovery(nil)
+	assert.Nil(t, inner.UpdateInstances([]ServerInstance{{Addr: addr1, Weight: 2}}))
+	d := NewHealthCheckDiscovery(inner, HealthCheckOptions{Interval: time.Hour})
+	defer func() { _ = d.Close() }()
+	updates, cancel := d.Subscribe()
+	defer cancel()
+
+	// inner 的列表变化后不需要等待下一轮探测
+	assert.Nil(t, inner.Update([]string{addr1, addr2}))
+	select {
+	case servers := <-updates:
+		assert.Equal(t, []string{addr1, addr2}, servers)
+	case <-time.After(time.Second):
+		t.Fatal("inner change was not propagated")
+	}
+	assert.Nil(t, inner.UpdateInstances([]ServerInstance{{Addr: addr1, Weight: 2}, {Addr: addr2, Weight: 4}}))
+	// 只有元数据变化时不通知订阅者，在下一次同步时更新
+	d.sync()
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{{Addr: addr1, Weight: 2}, {Addr: addr2, Weight: 4}}, instances, "metadata from inner is kept")
+}
@@ -0,0 +1,110 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrHedgingNotIdempotent 调用设置了 WithHedging 但没有通过 WithIdempotent 标记为幂等
+var ErrHedgingNotIdempotent = errors.New("rpc client: hedging requires an idempotent call, see WithIdempotent")
+
+type (
+	hedgingKey    struct{}
+	idempotentKey struct{}
+)
+
+// hedging 对冲请求的配置
+type hedging struct {
+	delay      time.Duration
+	maxServers int
+}
+
+// WithHedging 返回开启对冲请求的 ctx：第一次调用发往负载均衡选择的服务器，delay 内没有成功时
+// 再向另一个服务器发出调用，最多同时调用 maxServers 个服务器，采用最先成功的结果并取消其余的调用。
+// 只能用于通过 WithIdempotent 标记为幂等的调用，maxServers 小于2时不对冲
+func WithHedging(ctx context.Context, delay time.Duration, maxServers int) context.Context {
+	return context.WithValue(ctx, hedgingKey{}, hedging{delay: delay, maxServers: maxServers})
+}
+
+// WithIdempotent 返回将调用标记为幂等的 ctx，幂等的调用可以同时发往多个服务器
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// hedgingFrom 返回 ctx 中的对冲配置，没有设置或 maxServers 小于2时返回 false
+func hedgingFrom(ctx context.Context) (hedging, bool) {
+	h, ok := ctx.Value(hedgingKey{}).(hedging)
+	return h, ok && h.maxServers >= 2
+}
+
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+// hedgeResult 一次对冲调用的结果，reply 是该调用单独的应答
+type hedgeResult struct {
+	reply reflect.Value
+	err   error
+}
+
+// callHedged 从 rpcAddr 开始发出对冲请求，每次调用使用单独的应答，只有成功的应答写入 reply；
+// 每次调用都计入统计与熔断器。某次调用以可以重试的错误失败时立即向下一个服务器发出调用
+func (xc *XClient) callHedged(ctx context.Context, h hedging, rpcAddr, serviceMethod string, args, reply interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	xc.mu.Lock()
+	policy := xc.failover
+	xc.mu.Unlock()
+
+	replyType := reflect.TypeOf(reply).Elem()
+	results := make(chan hedgeResult, h.maxServers)
+	tried := make(map[string]bool)
+	launch := func(addr string) {
+		tried[addr] = true
+		r := reflect.New(replyType)
+		go func() {
+			err := xc.call(addr, ctx, serviceMethod, args, r.Interface())
+			results <- hedgeResult{reply: r, err: err}
+		}()
+	}
+	// hedge 向一个没有调用过的服务器发出调用
+	hedge := func() bool {
+		if len(tried) >= h.maxServers {
+			return false
+		}
+		next, ok := xc.next(ctx, tried)
+		if ok {
+			launch(next)
+		}
+		return ok
+	}
+
+	launch(rpcAddr)
+	inflight := 1
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	var err error
+	for inflight > 0 {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				reflect.ValueOf(reply).Elem().Set(r.reply.Elem())
+				return nil
+			}
+			err = r.err
+			if ctx.Err() == nil && policy.Retryable(err) && hedge() {
+				inflight++
+			}
+		case <-timer.C:
+			if hedge() {
+				inflight++
+				timer.Reset(h.delay)
+			}
+		}
+	}
+	return err
+}
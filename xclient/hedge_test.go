@@ -0,0 +1,61 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXClient_Hedging(t *testing.T) {
+	slowAddr := startEchoServer(t, &Echo{name: "slow", lag: 500 * time.Millisecond})
+	fastAddr := startEchoServer(t, &Echo{name: "fast", lag: 10 * time.Millisecond})
+	xc := NewXClient(NewMultiServerDiscovery([]string{slowAddr, fastAddr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	const delay = 50 * time.Millisecond
+	ctx := WithIdempotent(WithHedging(context.Background(), delay, 2))
+	// 轮询时两次调用分别先发往两个服务器
+	for i := 0; i < 2; i++ {
+		var reply string
+		start := time.Now()
+		assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+		assert.Equal(t, "fast", reply)
+		assert.Less(t, int64(time.Since(start)), int64(delay+200*time.Millisecond))
+	}
+
+	// 被取消的调用同样计入统计
+	assert.Eventually(t, func() bool {
+		stats := xc.Stats()
+		return stats[slowAddr].Calls == 1 && stats[fastAddr].Calls == 2 && stats[slowAddr].InFlight == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(1), xc.Stats()[slowAddr].Errors)
+}
+
+func TestXClient_HedgingFailure(t *testing.T) {
+	badAddr := startEchoServer(t, &Echo{name: "bad", fail: true})
+	okAddr := startEchoServer(t, &Echo{name: "ok", lag: 10 * time.Millisecond})
+	dead := deadAddr(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{badAddr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 没有标记为幂等
+	var reply string
+	assert.Equal(t, ErrHedgingNotIdempotent, xc.Call(WithHedging(context.Background(), time.Millisecond, 2), "Echo.Name", 0, &reply))
+
+	// 所有调用失败时返回错误，服务端的错误不会重试
+	ctx := WithIdempotent(WithHedging(context.Background(), time.Hour, 3))
+	assert.NotNil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+	assert.Equal(t, "", reply)
+
+	// 连接失败时立即向下一个服务器发出调用，不等待 delay
+	assert.Nil(t, xc.d.Update([]string{dead, okAddr}))
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		reply = ""
+		assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+		assert.Equal(t, "ok", reply)
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
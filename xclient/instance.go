@@ -0,0 +1,113 @@
+package xclient
+
+import "time"
+
+// ServerInstance 服务器及其元数据，Weight 不大于0时视为1，Tags 可以为 nil
+// Load 与 LoadTime 为服务器上报的负载及上报的时间，由 RegistryDiscovery 设置，见 LeastLoadedSelect
+type ServerInstance struct {
+	Addr     string            `json:"addr"`
+	Weight   int               `json:"weight,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Load     float64           `json:"-"`
+	LoadTime time.Time         `json:"-"`
+}
+
+// InstanceDiscovery 能够提供服务器元数据的 Discovery，内嵌 MultiServersDiscovery 的 Discovery 都实现了该接口
+type InstanceDiscovery interface {
+	Discovery
+
+	// GetAllInstances 返回所有服务器及其元数据
+	GetAllInstances() ([]ServerInstance, error)
+}
+
+var _ InstanceDiscovery = (*MultiServersDiscovery)(nil)
+
+// instancesOf 将地址列表转换为权重为1、没有标签的 ServerInstance
+func instancesOf(servers []string) []ServerInstance {
+	instances := make([]ServerInstance, len(servers))
+	for i, addr := range servers {
+		instances[i] = ServerInstance{Addr: addr, Weight: 1}
+	}
+	return instances
+}
+
+// GetAllInstances 返回 d 的所有服务器及其元数据，d 没有实现 InstanceDiscovery 时权重为1且没有标签
+func GetAllInstances(d Discovery) ([]ServerInstance, error) {
+	if id, ok := d.(InstanceDiscovery); ok {
+		return id.GetAllInstances()
+	}
+	servers, err := d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return instancesOf(servers), nil
+}
+
+// cloneInstance 复制 ServerInstance，并将缺省的权重设为1
+func cloneInstance(in ServerInstance) ServerInstance {
+	if in.Weight <= 0 {
+		in.Weight = 1
+	}
+	if in.Tags != nil {
+		tags := make(map[string]string, len(in.Tags))
+		for k, v := range in.Tags {
+			tags[k] = v
+		}
+		in.Tags = tags
+	}
+	return in
+}
+
+// UpdateInstances 更新服务器及其元数据，订阅者只在地址列表变化时收到通知
+func (d *MultiServersDiscovery) UpdateInstances(instances []ServerInstance) error {
+	copied := make([]ServerInstance, len(instances))
+	for i, in := range instances {
+		copied[i] = cloneInstance(in)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := d.servers
+	d.setInstances(copied)
+	if !sameServers(old, d.servers) {
+		d.notify()
+	}
+	return nil
+}
+
+// setInstances 替换服务器列表并重建一致性哈希环与加权轮询的状态，需要持有 d.mu
+func (d *MultiServersDiscovery) setInstances(instances []ServerInstance) {
+	servers := make([]string, len(instances))
+	for i, in := range instances {
+		servers[i] = in.Addr
+	}
+	d.instances = instances
+	d.servers = servers
+	d.current = make([]int, len(instances))
+	d.ring = newHashRing(d.replicas, servers)
+}
+
+// GetAllInstances 返回所有服务器及其元数据的副本
+func (d *MultiServersDiscovery) GetAllInstances() ([]ServerInstance, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	instances := make([]ServerInstance, len(d.instances))
+	for i, in := range d.instances {
+		instances[i] = cloneInstance(in)
+	}
+	return instances, nil
+}
+
+// nextWeighted 平滑加权轮询：每次所有服务器的当前权重加上各自的权重，选择当前权重最大的服务器并减去总权重，
+// 权重为 2:1 的服务器按 a a b 的顺序交错选择，而不是连续选择同一个服务器。需要持有 d.mu 且列表不为空
+func (d *MultiServersDiscovery) nextWeighted() string {
+	total, best := 0, 0
+	for i, in := range d.instances {
+		d.current[i] += in.Weight
+		total += in.Weight
+		if d.current[i] > d.current[best] {
+			best = i
+		}
+	}
+	d.current[best] -= total
+	return d.servers[best]
+}
@@ -0,0 +1,79 @@
+package xclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plainDiscovery 只实现了 Discovery 的 Discovery
+type plainDiscovery struct{ Discovery }
+
+func TestMultiServersDiscovery_Instances(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a:1", "tcp@b:1"})
+	instances, err := d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{{Addr: "tcp@a:1", Weight: 1}, {Addr: "tcp@b:1", Weight: 1}}, instances)
+
+	tags := map[string]string{"zone": "a"}
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: "tcp@a:1", Weight: 3, Tags: tags},
+		{Addr: "tcp@c:1"},
+	}))
+	tags["zone"] = "changed"
+	servers, err := d.GetAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp@a:1", "tcp@c:1"}, servers)
+	instances, err = d.GetAllInstances()
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{
+		{Addr: "tcp@a:1", Weight: 3, Tags: map[string]string{"zone": "a"}},
+		{Addr: "tcp@c:1", Weight: 1},
+	}, instances, "missing weight defaults to 1 and tags are copied")
+	instances[0].Tags["zone"] = "changed"
+	instances, _ = d.GetAllInstances()
+	assert.Equal(t, "a", instances[0].Tags["zone"])
+
+	// 字符串的 Update 丢弃元数据
+	assert.Nil(t, d.Update([]string{"tcp@a:1"}))
+	instances, _ = d.GetAllInstances()
+	assert.Equal(t, []ServerInstance{{Addr: "tcp@a:1", Weight: 1}}, instances)
+
+	instances, err = GetAllInstances(plainDiscovery{NewMultiServerDiscovery([]string{"tcp@x:1"})})
+	assert.Nil(t, err)
+	assert.Equal(t, []ServerInstance{{Addr: "tcp@x:1", Weight: 1}}, instances)
+}
+
+func TestMultiServersDiscovery_WeightedRoundRobin(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	_, err := d.Get(WeightedRoundRobinSelect)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: "tcp@a:1", Weight: 5},
+		{Addr: "tcp@b:1", Weight: 1},
+		{Addr: "tcp@c:1", Weight: 1},
+	}))
+	var seq []string
+	counts := make(map[string]int)
+	for i := 0; i < 70; i++ {
+		addr, err := d.Get(WeightedRoundRobinSelect)
+		assert.Nil(t, err)
+		counts[addr]++
+		if i < 7 {
+			seq = append(seq, addr)
+		}
+	}
+	assert.Equal(t, map[string]int{"tcp@a:1": 50, "tcp@b:1": 10, "tcp@c:1": 10}, counts)
+	// 平滑加权轮询将权重小的服务器穿插在中间
+	assert.Equal(t, []string{"tcp@a:1", "tcp@a:1", "tcp@b:1", "tcp@a:1", "tcp@c:1", "tcp@a:1", "tcp@a:1"}, seq)
+
+	// 没有权重时与轮询相同
+	assert.Nil(t, d.Update([]string{"tcp@a:1", "tcp@b:1"}))
+	counts = make(map[string]int)
+	for i := 0; i < 10; i++ {
+		addr, _ := d.Get(WeightedRoundRobinSelect)
+		counts[addr]++
+	}
+	assert.Equal(t, map[string]int{"tcp@a:1": 5, "tcp@b:1": 5}, counts)
+}
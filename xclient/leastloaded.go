@@ -0,0 +1,75 @@
+package xclient
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultLoadStaleAfter 服务器上报的负载默认的有效期
+const defaultLoadStaleAfter = 30 * time.Second
+
+// SetLoadStaleAfter 设置 LeastLoadedSelect 中服务器上报的负载的有效期，d 不大于0时使用默认值30秒。
+// 上报的负载随时间线性衰减，逐渐改为使用 XClient 统计的未完成调用数，超过 d 后只使用本地统计，
+// 服务器的心跳间隔应当明显小于 d
+func (xc *XClient) SetLoadStaleAfter(d time.Duration) {
+	if d <= 0 {
+		d = defaultLoadStaleAfter
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.staleLoad = d
+}
+
+// pickLeastLoaded 按 LeastLoadedSelect 从 d 的所有服务器中选择
+func (xc *XClient) pickLeastLoaded(d Discovery) (string, error) {
+	instances, err := GetAllInstances(d)
+	if err != nil {
+		return "", err
+	}
+	if len(instances) == 0 {
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	xc.mu.Lock()
+	staleAfter := xc.staleLoad
+	xc.mu.Unlock()
+	return xc.load.leastLoaded(instances, staleAfter, time.Now()), nil
+}
+
+// leastLoaded 随机选择两个不同的服务器，返回 loadScore 较低的一个，只有一个服务器时直接返回。
+// 每次只比较随机的两个服务器，避免所有客户端同时涌向上报的负载最低的服务器
+func (t *loadTracker) leastLoaded(instances []ServerInstance, staleAfter time.Duration, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(instances)
+	if n == 1 {
+		return instances[0].Addr
+	}
+	i, j := t.r.Intn(n), t.r.Intn(n-1)
+	if j >= i {
+		j++
+	}
+	if t.loadScore(instances[j], staleAfter, now) < t.loadScore(instances[i], staleAfter, now) {
+		return instances[j].Addr
+	}
+	return instances[i].Addr
+}
+
+// loadScore 以上报的负载与本地统计的未完成调用数按上报的时间加权，刚上报时只使用上报的负载，
+// 超过 staleAfter 或没有上报时只使用本地统计，需要持有 t.mu
+func (t *loadTracker) loadScore(in ServerInstance, staleAfter time.Duration, now time.Time) float64 {
+	var local float64
+	if l := t.loads[in.Addr]; l != nil {
+		local = float64(l.inFlight)
+	}
+	if in.LoadTime.IsZero() {
+		return local
+	}
+	fresh := 1 - float64(now.Sub(in.LoadTime))/float64(staleAfter)
+	switch {
+	case fresh <= 0:
+		return local
+	case fresh > 1: // 时钟偏差导致上报的时间晚于当前时间
+		fresh = 1
+	}
+	return fresh*in.Load + (1-fresh)*local
+}
@@ -0,0 +1,76 @@
+package xclient
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yqchilde/gee-rpc/registry"
+)
+
+// leastLoadedShare 以 LeastLoadedSelect 从 d 中选择 n 次，返回每个服务器被选中的次数
+func leastLoadedShare(t *testing.T, xc *XClient, n int) map[string]int {
+	picked := make(map[string]int)
+	for i := 0; i < n; i++ {
+		addr, err := xc.get(context.Background())
+		assert.Nil(t, err)
+		picked[addr]++
+	}
+	return picked
+}
+
+func TestLeastLoadedSelect_RegistryLoad(t *testing.T) {
+	reg := httptest.NewServer(registry.New(time.Minute))
+	defer reg.Close()
+	for addr, load := range map[string]float64{"tcp@idle": 1, "tcp@busy": 50, "tcp@overloaded": 100} {
+		assert.Nil(t, registry.Heartbeat(reg.URL, addr, &registry.Metadata{Load: load}, time.Minute, nil))
+	}
+	xc := NewXClient(NewRegistryDiscovery(reg.URL, time.Minute), LeastLoadedSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 两两比较时负载最高的服务器不会被选中，负载最低的服务器被选中的次数最多
+	picked := leastLoadedShare(t, xc, 300)
+	assert.Zero(t, picked["tcp@overloaded"])
+	assert.Greater(t, picked["tcp@idle"], picked["tcp@busy"])
+	assert.Greater(t, picked["tcp@busy"], 0, "subset sampling spreads the load")
+}
+
+func TestLeastLoadedSelect_StaleFallback(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	stale := time.Now().Add(-time.Minute)
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: "tcp@a", Load: 0, LoadTime: stale},
+		{Addr: "tcp@b", Load: 100, LoadTime: stale},
+	}))
+	xc := NewXClient(d, LeastLoadedSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetLoadStaleAfter(10 * time.Second)
+
+	// 上报的负载已经过期，按本地统计选择未完成调用较少的 tcp@b
+	for i := 0; i < 3; i++ {
+		done := xc.load.start("tcp@a")
+		defer done(nil)
+	}
+	assert.Equal(t, map[string]int{"tcp@b": 20}, leastLoadedShare(t, xc, 20))
+
+	// 新的上报优先于本地统计
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: "tcp@a", Load: 0, LoadTime: time.Now()},
+		{Addr: "tcp@b", Load: 100, LoadTime: time.Now()},
+	}))
+	assert.Equal(t, map[string]int{"tcp@a": 20}, leastLoadedShare(t, xc, 20))
+}
+
+func TestLoadTracker_LoadScoreDecay(t *testing.T) {
+	tr := newLoadTracker()
+	done := tr.start("tcp@a")
+	defer done(nil)
+	now := time.Now()
+	in := ServerInstance{Addr: "tcp@a", Load: 11, LoadTime: now}
+	assert.Equal(t, 11.0, tr.loadScore(in, 10*time.Second, now))
+	assert.InDelta(t, 6.0, tr.loadScore(in, 10*time.Second, now.Add(5*time.Second)), 1e-9)
+	assert.Equal(t, 1.0, tr.loadScore(in, 10*time.Second, now.Add(time.Minute)))
+	assert.Equal(t, 1.0, tr.loadScore(ServerInstance{Addr: "tcp@a", Load: 11}, 10*time.Second, now), "no report")
+}
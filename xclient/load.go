@@ -0,0 +1,240 @@
+package xclient
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyDecay 延迟 EWMA 中新样本的权重
+	latencyDecay = 0.3
+	// latencyWindow 估计延迟分位数时使用的最近的调用数
+	latencyWindow = 128
+)
+
+// ServerStats 单个服务器的调用统计快照
+type ServerStats struct {
+	InFlight int64         // 已发出、尚未完成的调用数
+	Calls    uint64        // 已完成的调用数，包括失败的调用
+	Errors   uint64        // 失败的调用数，包括连接失败
+	Latency  time.Duration // 调用耗时的指数加权移动平均，还没有完成的调用时为0
+	P50      time.Duration // 最近的调用耗时的中位数
+	P99      time.Duration // 最近的调用耗时的99分位数
+	Recycled uint64        // 因超过 MaxConnAge 而替换的连接数
+	Dials    uint64        // 建立连接的次数，包括失败的连接
+	DialErrs uint64        // 失败的连接数
+}
+
+// serverLoad 单个服务器的负载与统计
+type serverLoad struct {
+	inFlight int64   // 已发出、尚未完成的调用数
+	calls    uint64  // 已完成的调用数
+	errors   uint64  // 失败的调用数
+	latency  float64 // 调用耗时的 EWMA，单位纳秒
+	sampled  bool    // 是否已经有完成的调用
+	recycled uint64  // 替换的连接数
+	dials    uint64  // 建立连接的次数
+	dialErrs uint64  // 失败的连接数
+
+	recent [latencyWindow]time.Duration // 最近的调用耗时，环形缓冲
+}
+
+// observe 以一次完成的调用的耗时与错误更新统计，需要持有 loadTracker.mu
+func (l *serverLoad) observe(elapsed time.Duration, err error) {
+	d := float64(elapsed)
+	l.recent[l.calls%latencyWindow] = elapsed
+	l.calls++
+	if err != nil {
+		l.errors++
+	}
+	if !l.sampled {
+		l.latency, l.sampled = d, true
+	} else {
+		l.latency += latencyDecay * (d - l.latency)
+	}
+}
+
+// percentiles 返回最近的调用耗时的中位数与99分位数
+func (l *serverLoad) percentiles() (p50, p99 time.Duration) {
+	n := l.calls
+	if n > latencyWindow {
+		n = latencyWindow
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, l.recent[:n])
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[(n-1)/2], samples[(n-1)*99/100]
+}
+
+// score P2C 比较使用的负载，延迟越高、未完成的调用越多负载越高；没有样本的服务器负载为0，会被优先尝试
+func (l *serverLoad) score() float64 {
+	if l == nil {
+		return 0
+	}
+	return l.latency * float64(l.inFlight+1)
+}
+
+// loadTracker 记录 XClient 发往各个服务器的调用，供按负载选择服务器的模式使用，也是 XClient.Stats 的数据来源
+// Discovery 无法得知调用的完成情况，因此这些模式由 XClient 从 GetAll 返回的服务器中选择
+type loadTracker struct {
+	mu    sync.Mutex // protect following
+	r     *rand.Rand
+	loads map[string]*serverLoad
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		loads: make(map[string]*serverLoad),
+	}
+}
+
+// entry 返回 addr 的统计，不存在时创建，需要持有 t.mu
+func (t *loadTracker) entry(addr string) *serverLoad {
+	l := t.loads[addr]
+	if l == nil {
+		l = new(serverLoad)
+		t.loads[addr] = l
+	}
+	return l
+}
+
+// start 记录一个发往 addr 的调用，返回的函数在调用完成时以调用的错误调用，并以调用耗时更新统计
+func (t *loadTracker) start(addr string) func(err error) {
+	start := time.Now()
+	t.mu.Lock()
+	l := t.entry(addr)
+	l.inFlight++
+	t.mu.Unlock()
+	return func(err error) {
+		elapsed := time.Since(start)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		l.inFlight--
+		l.observe(elapsed, err)
+	}
+}
+
+// record 记录一个已经完成的发往 addr 的调用，不计入 InFlight
+func (t *loadTracker) record(addr string, d time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(addr).observe(d, err)
+}
+
+// dialed 记录一次到 addr 的连接
+func (t *loadTracker) dialed(addr string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.entry(addr)
+	l.dials++
+	if err != nil {
+		l.dialErrs++
+	}
+}
+
+// recycle 记录一次到 addr 的连接被替换
+func (t *loadTracker) recycle(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(addr).recycled++
+}
+
+// leastActive 返回 servers 中正在处理的调用最少的服务器，有多个时随机选择其中一个
+func (t *loadTracker) leastActive(servers []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var best string
+	var min int64
+	ties := 0
+	for _, s := range servers {
+		var n int64
+		if l := t.loads[s]; l != nil {
+			n = l.inFlight
+		}
+		switch {
+		case ties == 0 || n < min:
+			best, min, ties = s, n, 1
+		case n == min:
+			// 蓄水池抽样，使并列的服务器被选中的概率相同
+			ties++
+			if t.r.Intn(ties) == 0 {
+				best = s
+			}
+		}
+	}
+	return best
+}
+
+// powerOfTwo 随机选择两个不同的服务器，返回其中负载较低的一个，只有一个服务器时直接返回
+func (t *loadTracker) powerOfTwo(servers []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(servers)
+	if n == 1 {
+		return servers[0]
+	}
+	i, j := t.r.Intn(n), t.r.Intn(n-1)
+	if j >= i {
+		j++
+	}
+	if t.loads[servers[j]].score() < t.loads[servers[i]].score() {
+		return servers[j]
+	}
+	return servers[i]
+}
+
+// snapshot 返回所有调用过的服务器的统计
+func (t *loadTracker) snapshot() map[string]ServerStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(map[string]ServerStats, len(t.loads))
+	for addr, l := range t.loads {
+		p50, p99 := l.percentiles()
+		m[addr] = ServerStats{
+			InFlight: l.inFlight,
+			Calls:    l.calls,
+			Errors:   l.errors,
+			Latency:  time.Duration(l.latency),
+			P50:      p50,
+			P99:      p99,
+			Recycled: l.recycled,
+			Dials:    l.dials,
+			DialErrs: l.dialErrs,
+		}
+	}
+	return m
+}
+
+// SetStatsReporter 每隔 interval 以 Stats 的快照调用 report，用于导出到监控系统；
+// 再次调用时替换之前的设置，report 为 nil 时停止，XClient 关闭时也会停止
+func (xc *XClient) SetStatsReporter(interval time.Duration, report func(map[string]ServerStats)) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.reporterStop != nil {
+		close(xc.reporterStop)
+		xc.reporterStop = nil
+	}
+	if report == nil || interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	xc.reporterStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report(xc.Stats())
+			}
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultZoneTag 服务器标签中表示区域的默认键
+const defaultZoneTag = "zone"
+
+// LocalityOptions 按区域优先选择服务器的配置
+type LocalityOptions struct {
+	Zone string // 客户端所在的区域，为空时不启用
+	// TagKey 服务器标签中区域的键，默认为 "zone"，没有该标签的服务器视为其他区域
+	TagKey string
+	// Spillover 本区域有可用服务器时，仍按该比例（0~1）选择其他区域的服务器以平衡负载，默认为0
+	Spillover float64
+}
+
+// localityPicker 将服务器按区域分为两组，在组内按 XClient 的负载均衡策略选择
+// 每组由一个 MultiServersDiscovery 维护，服务器列表不变时保留轮询与加权轮询的状态
+type localityPicker struct {
+	opts LocalityOptions
+
+	mu     sync.Mutex
+	local  *MultiServersDiscovery
+	remote *MultiServersDiscovery
+}
+
+func newLocalityPicker(opts LocalityOptions) *localityPicker {
+	if opts.TagKey == "" {
+		opts.TagKey = defaultZoneTag
+	}
+	return &localityPicker{
+		opts:   opts,
+		local:  NewMultiServerDiscovery(nil),
+		remote: NewMultiServerDiscovery(nil),
+	}
+}
+
+// split 按区域划分 instances，返回两组各自的服务器数量，跳过熔断器打开的本区域服务器，需要持有 p.mu
+func (p *localityPicker) split(instances []ServerInstance, bs *breakerSet) (nLocal, nRemote int) {
+	var in, out []ServerInstance
+	for _, inst := range instances {
+		if inst.Tags[p.opts.TagKey] != p.opts.Zone {
+			out = append(out, inst)
+		} else if !bs.isOpen(inst.Addr) {
+			in = append(in, inst)
+		}
+	}
+	syncInstances(p.local, in)
+	syncInstances(p.remote, out)
+	return len(in), len(out)
+}
+
+// syncInstances 服务器列表或权重变化时更新 d
+func syncInstances(d *MultiServersDiscovery, instances []ServerInstance) {
+	d.mu.Lock()
+	same := len(d.instances) == len(instances)
+	for i := 0; same && i < len(instances); i++ {
+		same = d.instances[i].Addr == instances[i].Addr && d.instances[i].Weight == cloneInstance(instances[i]).Weight
+	}
+	d.mu.Unlock()
+	if !same {
+		_ = d.UpdateInstances(instances)
+	}
+}
+
+// SetLocality 设置客户端所在的区域：优先选择 Tags 中区域相同的服务器，
+// 本区域没有服务器或本区域服务器的熔断器全部打开时才选择其他区域的服务器
+func (xc *XClient) SetLocality(opts LocalityOptions) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if opts.Zone == "" {
+		xc.locality = nil
+		return
+	}
+	xc.locality = newLocalityPicker(opts)
+}
+
+// pickLocal 按区域优先从 instances 中选择服务器，在选中的组内使用 XClient 的负载均衡策略
+func (xc *XClient) pickLocal(ctx context.Context, p *localityPicker, instances []ServerInstance) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nLocal, nRemote := p.split(instances, xc.breakerSet())
+	group := p.local
+	if nLocal == 0 {
+		group = p.remote
+	} else if p.opts.Spillover > 0 && nRemote > 0 {
+		xc.mu.Lock()
+		spill := xc.r.Float64() < p.opts.Spillover
+		xc.mu.Unlock()
+		if spill {
+			group = p.remote
+		}
+	}
+	return xc.pickFrom(ctx, group)
+}
@@ -0,0 +1,83 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func zoned(addr, zone string, weight int) ServerInstance {
+	return ServerInstance{Addr: addr, Weight: weight, Tags: map[string]string{"zone": zone}}
+}
+
+// countPicks 选择 n 次服务器，返回每个服务器被选中的次数
+func countPicks(t *testing.T, xc *XClient, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		addr, err := xc.pick(context.Background())
+		assert.Nil(t, err)
+		counts[addr]++
+	}
+	return counts
+}
+
+func TestXClient_Locality(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		zoned("tcp@a1", "a", 3), zoned("tcp@a2", "a", 1),
+		zoned("tcp@b1", "b", 1), {Addr: "tcp@untagged"},
+	}))
+	xc := NewXClient(d, WeightedRoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetLocality(LocalityOptions{Zone: "a"})
+
+	// 只选择本区域的服务器，组内按权重轮询
+	assert.Equal(t, map[string]int{"tcp@a1": 300, "tcp@a2": 100}, countPicks(t, xc, 400))
+
+	// 本区域的服务器熔断后选择其他区域
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Hour, IsFailure: anyError})
+	xc.breakerSet().record("tcp@a1", errors.New("boom"))
+	assert.Equal(t, map[string]int{"tcp@a2": 10}, countPicks(t, xc, 10))
+	xc.breakerSet().record("tcp@a2", errors.New("boom"))
+	counts := countPicks(t, xc, 10)
+	assert.Equal(t, 10, counts["tcp@b1"]+counts["tcp@untagged"])
+	xc.SetBreaker(BreakerOptions{MaxFailures: -1})
+
+	// 本区域的服务器移除后选择其他区域
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{zoned("tcp@b1", "b", 1), zoned("tcp@b2", "b", 1)}))
+	assert.Equal(t, map[string]int{"tcp@b1": 5, "tcp@b2": 5}, countPicks(t, xc, 10))
+
+	// 不设置区域时从所有服务器中选择
+	xc.SetLocality(LocalityOptions{})
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{zoned("tcp@a1", "a", 1), zoned("tcp@b1", "b", 1)}))
+	assert.Equal(t, map[string]int{"tcp@a1": 5, "tcp@b1": 5}, countPicks(t, xc, 10))
+}
+
+func TestXClient_LocalitySpillover(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		zoned("tcp@a1", "a", 1), zoned("tcp@b1", "b", 1),
+	}))
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetLocality(LocalityOptions{Zone: "a", Spillover: 0.2})
+
+	counts := countPicks(t, xc, 2000)
+	assert.InDelta(t, 400, counts["tcp@b1"], 100)
+	assert.Equal(t, 2000, counts["tcp@a1"]+counts["tcp@b1"])
+}
+
+func TestXClient_LocalityTagKey(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	assert.Nil(t, d.UpdateInstances([]ServerInstance{
+		{Addr: "tcp@east", Tags: map[string]string{"region": "east"}},
+		{Addr: "tcp@west", Tags: map[string]string{"region": "west"}},
+	}))
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetLocality(LocalityOptions{Zone: "west", TagKey: "region"})
+	assert.Equal(t, map[string]int{"tcp@west": 20}, countPicks(t, xc, 20))
+}
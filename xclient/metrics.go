@@ -0,0 +1,157 @@
+package xclient
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MetricsHook 接收 XClient 的调用、连接、服务器列表与熔断器事件，用于导出到外部的监控系统
+// 回调在产生事件的 goroutine 中同步执行，应当尽快返回；回调 panic 时记录日志后忽略，不影响调用
+type MetricsHook interface {
+	// OnCall 发往 addr 的一次调用完成，包括重试、对冲与广播中的每一次调用，d 包括连接的时间
+	OnCall(addr, method string, d time.Duration, err error)
+	// OnDial 到 addr 的一次连接完成
+	OnDial(addr string, d time.Duration, err error)
+	// OnServerListChange Discovery 的服务器列表发生变化，只有 Discovery 实现了 Watcher 时调用
+	OnServerListChange(added, removed []string)
+	// OnBreakerStateChange addr 的熔断器进入 state 状态，state 为 BreakerState.String() 的结果
+	OnBreakerStateChange(addr string, state string)
+}
+
+// hookBox 使 atomic.Value 可以保存 nil 的 MetricsHook
+type hookBox struct {
+	h MetricsHook
+}
+
+// SetMetricsHook 设置接收事件的 MetricsHook，h 为 nil 时取消设置
+func (xc *XClient) SetMetricsHook(h MetricsHook) {
+	xc.hook.Store(hookBox{h})
+}
+
+// metricsHook 返回设置的 MetricsHook，没有设置时为 nil
+func (xc *XClient) metricsHook() MetricsHook {
+	box, _ := xc.hook.Load().(hookBox)
+	return box.h
+}
+
+// safeHook 调用 f，恢复并记录 f 中的 panic
+func safeHook(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rpc client: metrics hook panic: %v", r)
+		}
+	}()
+	f()
+}
+
+// serverListChanged 以 prev 与 servers 的差异调用 OnServerListChange，列表没有变化时不调用
+func (xc *XClient) serverListChanged(prev, servers []string) {
+	h := xc.metricsHook()
+	if h == nil {
+		return
+	}
+	added, removed := diffServers(prev, servers), diffServers(servers, prev)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	safeHook(func() { h.OnServerListChange(added, removed) })
+}
+
+// diffServers 返回在 b 中但不在 a 中的服务器，按地址排序
+func diffServers(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, addr := range a {
+		seen[addr] = true
+	}
+	var diff []string
+	for _, addr := range b {
+		if !seen[addr] {
+			seen[addr] = true
+			diff = append(diff, addr)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// breakerChanged 熔断器状态变化时调用 OnBreakerStateChange
+func (xc *XClient) breakerChanged(addr string, to BreakerState) {
+	if h := xc.metricsHook(); h != nil {
+		safeHook(func() { h.OnBreakerStateChange(addr, to.String()) })
+	}
+}
+
+// StatsHook MetricsHook 的参考实现，与 XClient.Stats 使用相同的统计方式把事件汇总为 ServerStats，
+// 适合同时汇总多个 XClient 的调用；事件中没有进行中的调用，因此 InFlight 始终为0
+type StatsHook struct {
+	load *loadTracker
+
+	mu       sync.Mutex // protect following
+	servers  map[string]struct{}
+	breakers map[string]string
+}
+
+var _ MetricsHook = (*StatsHook)(nil)
+
+func NewStatsHook() *StatsHook {
+	return &StatsHook{
+		load:     newLoadTracker(),
+		servers:  make(map[string]struct{}),
+		breakers: make(map[string]string),
+	}
+}
+
+func (h *StatsHook) OnCall(addr, method string, d time.Duration, err error) {
+	h.load.record(addr, d, err)
+}
+
+func (h *StatsHook) OnDial(addr string, d time.Duration, err error) {
+	h.load.dialed(addr, err)
+}
+
+func (h *StatsHook) OnServerListChange(added, removed []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, addr := range added {
+		h.servers[addr] = struct{}{}
+	}
+	for _, addr := range removed {
+		delete(h.servers, addr)
+	}
+}
+
+func (h *StatsHook) OnBreakerStateChange(addr string, state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.breakers[addr] = state
+}
+
+// Stats 返回各个服务器的统计，键为服务器地址
+func (h *StatsHook) Stats() map[string]ServerStats {
+	return h.load.snapshot()
+}
+
+// Servers 返回根据服务器列表变化得到的当前服务器，按地址排序
+func (h *StatsHook) Servers() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	servers := make([]string, 0, len(h.servers))
+	for addr := range h.servers {
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// Breakers 返回各个服务器熔断器最近一次变化后的状态
+func (h *StatsHook) Breakers() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m := make(map[string]string, len(h.breakers))
+	for addr, state := range h.breakers {
+		m[addr] = state
+	}
+	return m
+}
@@ -0,0 +1,139 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// recordingHook 记录收到的事件
+type recordingHook struct {
+	mu       sync.Mutex
+	calls    []string
+	dials    []string
+	changes  [][2][]string
+	breakers []string
+}
+
+func (h *recordingHook) OnCall(addr, method string, d time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, addr+" "+method+" "+errString(err))
+}
+
+func (h *recordingHook) OnDial(addr string, d time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dials = append(h.dials, addr+" "+errString(err))
+}
+
+func (h *recordingHook) OnServerListChange(added, removed []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changes = append(h.changes, [2][]string{added, removed})
+}
+
+func (h *recordingHook) OnBreakerStateChange(addr string, state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.breakers = append(h.breakers, addr+" "+state)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return "error"
+}
+
+type panickingHook struct{}
+
+func (panickingHook) OnCall(string, string, time.Duration, error) { panic("call") }
+func (panickingHook) OnDial(string, time.Duration, error)         { panic("dial") }
+func (panickingHook) OnServerListChange([]string, []string)       { panic("list") }
+func (panickingHook) OnBreakerStateChange(string, string)         { panic("breaker") }
+
+func TestXClient_MetricsHook(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	dead := deadAddr(t)
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	hook := new(recordingHook)
+	xc.SetMetricsHook(hook)
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Minute})
+
+	var reply int
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.NotNil(t, xc.CallServer(context.Background(), addr, "Foo.Missing", Args{}, &reply))
+	assert.NotNil(t, xc.CallServer(context.Background(), dead, "Foo.Sum", Args{}, &reply))
+
+	assert.Nil(t, d.Update([]string{dead}))
+	assert.Eventually(t, func() bool {
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		return len(hook.changes) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	assert.Equal(t, []string{
+		addr + " Foo.Sum ok",
+		addr + " Foo.Missing error",
+		dead + " Foo.Sum error",
+	}, hook.calls)
+	assert.Equal(t, []string{addr + " ok", dead + " error"}, hook.dials)
+	assert.Equal(t, [2][]string{{dead}, {addr}}, hook.changes[0])
+	assert.Equal(t, []string{dead + " open"}, hook.breakers)
+}
+
+func TestXClient_MetricsHookPanic(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetMetricsHook(panickingHook{})
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, IsFailure: anyError})
+
+	var reply int
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 3, reply)
+	assert.NotNil(t, xc.Call(context.Background(), "Foo.Missing", Args{}, &reply))
+	assert.Nil(t, d.Update([]string{addr, deadAddr(t)}))
+
+	// 取消设置后不再调用
+	xc.SetMetricsHook(nil)
+	assert.Nil(t, xc.metricsHook())
+}
+
+func TestStatsHook(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	hook := NewStatsHook()
+	xc.SetMetricsHook(hook)
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, IsFailure: anyError, Cooldown: time.Minute})
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+	}
+	assert.NotNil(t, xc.Call(context.Background(), "Foo.Missing", Args{}, &reply))
+	dead := deadAddr(t)
+	assert.Nil(t, d.Update([]string{addr, dead}))
+	assert.Eventually(t, func() bool { return len(hook.Servers()) == 1 }, time.Second, 5*time.Millisecond)
+
+	want, got := xc.Stats()[addr], hook.Stats()[addr]
+	assert.Equal(t, want.Calls, got.Calls)
+	assert.Equal(t, want.Errors, got.Errors)
+	assert.Equal(t, uint64(1), got.Dials)
+	assert.Equal(t, want.Dials, got.Dials)
+	assert.Zero(t, got.InFlight)
+	assert.Equal(t, []string{dead}, hook.Servers())
+	assert.Equal(t, map[string]string{addr: "open"}, hook.Breakers())
+}
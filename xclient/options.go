@@ -0,0 +1,56 @@
+package xclient
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// OptionResolver 返回连接 addr 时使用的 Option，返回 nil 时使用 NewXClient 传入的 Option
+type OptionResolver func(addr string) *geerpc.Option
+
+// SetOptionResolver 为每个服务器单独设置连接的 Option，如部分服务器使用 TLS 或 JSON 编解码；
+// 已经缓存的客户端的 Option 与新的解析结果不同时，下次调用会关闭并重新连接
+func (xc *XClient) SetOptionResolver(resolve OptionResolver) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.resolveOpt = resolve
+}
+
+// resolveOption 返回连接 rpcAddr 时使用的 Option 及客户端缓存的键，
+// 使用默认 Option 时键为 rpcAddr，否则为 rpcAddr#<Option 的指纹>
+func (xc *XClient) resolveOption(rpcAddr string) (*geerpc.Option, string) {
+	xc.mu.Lock()
+	resolve := xc.resolveOpt
+	xc.mu.Unlock()
+	if resolve == nil {
+		return xc.opt, rpcAddr
+	}
+	opt := resolve(rpcAddr)
+	if opt == nil {
+		return xc.opt, rpcAddr
+	}
+	return opt, rpcAddr + "#" + optionFingerprint(opt)
+}
+
+// addrOf 返回客户端缓存的键对应的服务器地址
+func addrOf(key string) string {
+	if i := strings.IndexByte(key, '#'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// optionFingerprint 返回 opt 中影响连接的字段的摘要，密钥等内容只参与哈希
+func optionFingerprint(opt *geerpc.Option) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d|%s|%d|%d|%d|%p|%s|%s",
+		opt.MagicNumber, opt.CodecType, opt.ConnectTimeout, opt.HandleTimeout,
+		opt.CompressMinBytes, opt.TLSConfig, opt.ProxyURL, opt.HTTPAuthorization)
+	for _, key := range opt.SigningKeys {
+		_, _ = fmt.Fprintf(h, "|%x", key)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
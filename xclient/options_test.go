@@ -0,0 +1,76 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+	"github.com/yqchilde/gee-rpc/codec"
+)
+
+func TestXClient_OptionResolver(t *testing.T) {
+	_, gobAddr := startServer(t, geerpc.WorkerPool{})
+	_, jsonAddr := startServer(t, geerpc.WorkerPool{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{gobAddr, jsonAddr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var mu sync.Mutex
+	codecs := make(map[string][]codec.Type)
+	dial := xc.xdial
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		typ := geerpc.DefaultOption.CodecType
+		if opts[0] != nil {
+			typ = opts[0].CodecType
+		}
+		mu.Lock()
+		codecs[rpcAddr] = append(codecs[rpcAddr], typ)
+		mu.Unlock()
+		return dial(rpcAddr, opts...)
+	}
+	jsonOpt := &geerpc.Option{MagicNumber: geerpc.MagicNumber, CodecType: codec.JsonType}
+	xc.SetOptionResolver(func(addr string) *geerpc.Option {
+		if addr == jsonAddr {
+			return jsonOpt
+		}
+		return nil
+	})
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		assert.Equal(t, i+1, reply)
+	}
+	assert.Equal(t, map[string][]codec.Type{
+		gobAddr:  {codec.GobType},
+		jsonAddr: {codec.JsonType},
+	}, codecs)
+
+	// 解析结果变化后关闭旧的客户端并重新连接
+	xc.mu.Lock()
+	stale := xc.clients[gobAddr]
+	xc.mu.Unlock()
+	xc.SetOptionResolver(func(addr string) *geerpc.Option { return jsonOpt })
+	for _, addr := range []string{gobAddr, jsonAddr} {
+		var reply int
+		assert.Nil(t, xc.CallServer(context.Background(), addr, "Foo.Sum", Args{Num1: 2, Num2: 3}, &reply))
+		assert.Equal(t, 5, reply)
+	}
+	assert.False(t, stale.IsAvailable())
+	assert.Equal(t, []codec.Type{codec.GobType, codec.JsonType}, codecs[gobAddr])
+	assert.Len(t, codecs[jsonAddr], 1)
+	xc.mu.Lock()
+	assert.Len(t, xc.clients, 2)
+	xc.mu.Unlock()
+}
+
+func TestOptionFingerprint(t *testing.T) {
+	a := &geerpc.Option{MagicNumber: geerpc.MagicNumber, CodecType: codec.GobType}
+	b := *a
+	assert.Equal(t, optionFingerprint(a), optionFingerprint(&b))
+	b.SigningKeys = [][]byte{[]byte("secret")}
+	assert.NotEqual(t, optionFingerprint(a), optionFingerprint(&b))
+	assert.Equal(t, "tcp@localhost:1", addrOf("tcp@localhost:1#"+optionFingerprint(a)))
+	assert.Equal(t, "tcp@localhost:1", addrOf("tcp@localhost:1"))
+}
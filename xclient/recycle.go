@@ -0,0 +1,33 @@
+package xclient
+
+import (
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// SetMaxConnAge 设置缓存的连接的最长使用时间（上下浮动10%），超过后在后台建立新的连接，
+// 新的连接可用之前仍使用旧的连接，之后旧的连接在进行中的调用完成后关闭；d 不大于0时不回收，默认不回收
+func (xc *XClient) SetMaxConnAge(d time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.maxConnAge = d
+}
+
+// expiredLocked 返回 client 是否已经超过最长使用时间，需要持有 xc.mu
+func (xc *XClient) expiredLocked(client *geerpc.Client) bool {
+	expires, ok := xc.expires[client]
+	return ok && !time.Now().Before(expires)
+}
+
+// cacheLocked 将 client 缓存为 key，替换的客户端在进行中的调用完成后关闭并计为一次回收，需要持有 xc.mu
+func (xc *XClient) cacheLocked(key string, client *geerpc.Client) {
+	if old, ok := xc.clients[key]; ok && old != client {
+		xc.drainLocked(old)
+		xc.load.recycle(addrOf(key))
+	}
+	xc.clients[key] = client
+	if xc.maxConnAge > 0 {
+		xc.expires[client] = time.Now().Add(jitter(xc.maxConnAge))
+	}
+}
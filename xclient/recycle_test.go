@@ -0,0 +1,34 @@
+package xclient
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+func TestXClient_MaxConnAge(t *testing.T) {
+	server, addr := startServer(t, geerpc.WorkerPool{})
+	var connects int32
+	server.OnConnect(func(conn geerpc.ConnInfo) (context.Context, error) {
+		atomic.AddInt32(&connects, 1)
+		return context.Background(), nil
+	})
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetMaxConnAge(50 * time.Millisecond)
+
+	// 连接被替换期间的调用都成功
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		assert.Equal(t, i+1, reply)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Greater(t, atomic.LoadInt32(&connects), int32(1))
+	assert.NotZero(t, xc.Stats()[addr].Recycled)
+}
@@ -0,0 +1,83 @@
+package xclient
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// AutoRefresher 支持在后台定期刷新服务器列表的 Discovery，为可选接口
+// 不再使用时需要调用 Close 停止后台刷新
+type AutoRefresher interface {
+	Discovery
+	io.Closer
+
+	// EnableAutoRefresh 启动后台刷新，每隔 interval（上下浮动10%）调用一次 Refresh，再次调用时以新的间隔重启
+	EnableAutoRefresh(interval time.Duration)
+}
+
+var (
+	_ AutoRefresher = (*RegistryDiscovery)(nil)
+	_ AutoRefresher = (*DNSDiscovery)(nil)
+)
+
+// autoRefresh 后台定期刷新的 goroutine，零值可用
+type autoRefresh struct {
+	mu   sync.Mutex // protect following
+	stop chan struct{}
+	done chan struct{}
+}
+
+// start 停止已有的 goroutine 后启动新的 goroutine，立即刷新一次，之后每隔 interval 刷新；刷新失败时记录日志
+func (a *autoRefresh) start(interval time.Duration, refresh func() error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopLocked()
+	stop, done := make(chan struct{}), make(chan struct{})
+	a.stop, a.done = stop, done
+	go func() {
+		defer close(done)
+		for {
+			if err := refresh(); err != nil {
+				log.Println(err, "(keeping previous servers)")
+			}
+			timer := time.NewTimer(jitter(interval))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// enabled 返回是否正在后台刷新
+func (a *autoRefresh) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stop != nil
+}
+
+// close 停止后台刷新并等待 goroutine 退出
+func (a *autoRefresh) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopLocked()
+}
+
+func (a *autoRefresh) stopLocked() {
+	if a.stop == nil {
+		return
+	}
+	close(a.stop)
+	<-a.done
+	a.stop, a.done = nil, nil
+}
+
+// jitter 返回在 d 上下浮动10%的时间，避免大量客户端同时刷新
+func jitter(d time.Duration) time.Duration {
+	return d - d/10 + time.Duration(rand.Int63n(int64(d/5)+1))
+}
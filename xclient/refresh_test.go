@@ -0,0 +1,36 @@
+package xclient
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(100 * time.Millisecond)
+		assert.GreaterOrEqual(t, int64(d), int64(90*time.Millisecond))
+		assert.LessOrEqual(t, int64(d), int64(110*time.Millisecond))
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	var a autoRefresh
+	assert.False(t, a.enabled())
+	var calls int32
+	a.start(10*time.Millisecond, func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	assert.True(t, a.enabled())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 3 }, time.Second, 5*time.Millisecond)
+
+	a.close()
+	assert.False(t, a.enabled())
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&calls))
+	a.close()
+}
@@ -0,0 +1,154 @@
+package xclient
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionTTL = 30 * time.Minute
+	defaultMaxSession = 10000
+)
+
+// SessionOptions 会话粘滞的配置
+type SessionOptions struct {
+	TTL         time.Duration // 会话最后一次调用后绑定保留的时间，默认30分钟
+	MaxSessions int           // 最多保留的绑定数，超过时淘汰最久没有使用的绑定，默认10000
+	// OnRebind 绑定的服务器从 Discovery 中移除或熔断器打开，会话改为绑定到新的服务器时的回调，
+	// 应用可以在其中迁移会话的状态；绑定过期后重新选择不会回调
+	OnRebind func(key, from, to string)
+	// Now 返回当前时间，为 nil 时使用 time.Now，用于测试
+	Now func() time.Time
+}
+
+type sessionKey struct{}
+
+// WithSessionKey 返回携带会话 key 的 ctx，相同 key 的调用在绑定的服务器可用时都发往该服务器
+// 会话第一次调用时按 XClient 的负载均衡策略选择服务器并记录绑定，见 SetSessionOptions
+func WithSessionKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, key)
+}
+
+// sessionEntry 一个会话的绑定
+type sessionEntry struct {
+	key     string
+	addr    string
+	expires time.Time
+}
+
+// sessionTable 会话到服务器的绑定，按最近使用的顺序淘汰
+type sessionTable struct {
+	opts SessionOptions
+
+	mu    sync.Mutex // protect following
+	lru   *list.List // 元素为 *sessionEntry，最近使用的在前
+	items map[string]*list.Element
+}
+
+func newSessionTable(opts SessionOptions) *sessionTable {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultSessionTTL
+	}
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = defaultMaxSession
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &sessionTable{opts: opts, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// lookup 返回 key 绑定的服务器，绑定过期时删除并返回 false
+func (t *sessionTable) lookup(key string) (string, bool) {
+	now := t.opts.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.items[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*sessionEntry)
+	if !now.Before(e.expires) {
+		t.lru.Remove(el)
+		delete(t.items, key)
+		return "", false
+	}
+	return e.addr, true
+}
+
+// bind 将 key 绑定到 addr 并延长有效期，超过 MaxSessions 时淘汰最久没有使用的绑定
+func (t *sessionTable) bind(key, addr string) {
+	expires := t.opts.Now().Add(t.opts.TTL)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.items[key]; ok {
+		e := el.Value.(*sessionEntry)
+		e.addr, e.expires = addr, expires
+		t.lru.MoveToFront(el)
+		return
+	}
+	t.items[key] = t.lru.PushFront(&sessionEntry{key: key, addr: addr, expires: expires})
+	for t.lru.Len() > t.opts.MaxSessions {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.items, oldest.Value.(*sessionEntry).key)
+	}
+}
+
+// len 返回绑定数
+func (t *sessionTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+// SetSessionOptions 设置会话粘滞的配置，已有的绑定被清空
+func (xc *XClient) SetSessionOptions(opts SessionOptions) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.sessions = newSessionTable(opts)
+}
+
+func (xc *XClient) sessionTable() *sessionTable {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.sessions
+}
+
+// getSession 返回会话绑定的服务器，绑定的服务器不可用或没有绑定时重新选择并绑定
+func (xc *XClient) getSession(ctx context.Context, key string) (string, error) {
+	t := xc.sessionTable()
+	old, bound := t.lookup(key)
+	if bound && xc.available(ctx, old) {
+		t.bind(key, old)
+		return old, nil
+	}
+	addr, err := xc.pick(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.bind(key, addr)
+	if bound && t.opts.OnRebind != nil {
+		t.opts.OnRebind(key, old, addr)
+	}
+	return addr, nil
+}
+
+// available 返回 addr 是否仍在 Discovery 中、满足过滤条件且熔断器没有打开
+func (xc *XClient) available(ctx context.Context, addr string) bool {
+	if xc.breakerSet().isOpen(addr) {
+		return false
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return false
+	}
+	for _, s := range servers {
+		if s == addr {
+			return true
+		}
+	}
+	return false
+}
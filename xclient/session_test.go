@@ -0,0 +1,137 @@
+package xclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXClient_SessionPinning(t *testing.T) {
+	addrs := map[string]string{}
+	var servers []string
+	for _, name := range []string{"a", "b", "c"} {
+		addr := startEchoServer(t, &Echo{name: name})
+		addrs[addr] = name
+		servers = append(servers, addr)
+	}
+	d := NewMultiServerDiscovery(servers)
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	type rebind struct{ key, from, to string }
+	var rebinds []rebind
+	xc.SetSessionOptions(SessionOptions{OnRebind: func(key, from, to string) { rebinds = append(rebinds, rebind{key, from, to}) }})
+
+	call := func(key string) string {
+		var reply string
+		assert.Nil(t, xc.Call(WithSessionKey(context.Background(), key), "Echo.Name", 0, &reply))
+		return reply
+	}
+	pinned := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"alice", "bob", "carol"} {
+			name := call(key)
+			if i == 0 {
+				pinned[key] = name
+			}
+			assert.Equal(t, pinned[key], name, "session %s must stay on one server", key)
+		}
+	}
+	// 轮询为三个会话选择了不同的服务器
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, []string{pinned["alice"], pinned["bob"], pinned["carol"]})
+
+	// 绑定的服务器被移除后重新绑定
+	var removed string
+	for addr, name := range addrs {
+		if name == pinned["alice"] {
+			removed = addr
+		}
+	}
+	var rest []string
+	for _, addr := range servers {
+		if addr != removed {
+			rest = append(rest, addr)
+		}
+	}
+	assert.Nil(t, d.Update(rest))
+	moved := call("alice")
+	assert.NotEqual(t, pinned["alice"], moved)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, moved, call("alice"))
+	}
+	assert.Len(t, rebinds, 1)
+	assert.Equal(t, "alice", rebinds[0].key)
+	assert.Equal(t, removed, rebinds[0].from)
+	assert.Equal(t, moved, addrs[rebinds[0].to])
+	assert.Equal(t, pinned["bob"], call("bob"), "other sessions are unaffected")
+}
+
+func TestXClient_SessionBreakerRebind(t *testing.T) {
+	a := startEchoServer(t, &Echo{name: "a"})
+	b := startEchoServer(t, &Echo{name: "b"})
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var from string
+	xc.SetSessionOptions(SessionOptions{OnRebind: func(key, f, to string) { from = f }})
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Hour})
+
+	ctx := WithSessionKey(context.Background(), "alice")
+	var reply string
+	assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+	bound := map[string]string{"a": a, "b": b}[reply]
+	xc.breakerSet().record(bound, &DialError{Addr: bound})
+	assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+	assert.NotEqual(t, bound, map[string]string{"a": a, "b": b}[reply])
+	assert.Equal(t, bound, from)
+}
+
+func TestSessionTable(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	table := newSessionTable(SessionOptions{TTL: time.Minute, MaxSessions: 2, Now: clock.Now})
+	table.bind("a", "tcp@1")
+	clock.Advance(50 * time.Second)
+	addr, ok := table.lookup("a")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp@1", addr)
+
+	// 重新绑定延长有效期
+	table.bind("a", "tcp@1")
+	clock.Advance(50 * time.Second)
+	_, ok = table.lookup("a")
+	assert.True(t, ok)
+	clock.Advance(time.Minute)
+	_, ok = table.lookup("a")
+	assert.False(t, ok, "binding expires after TTL")
+	assert.Equal(t, 0, table.len())
+
+	// 超过上限时淘汰最久没有使用的绑定
+	table.bind("a", "tcp@1")
+	table.bind("b", "tcp@2")
+	table.bind("a", "tcp@1")
+	table.bind("c", "tcp@3")
+	assert.Equal(t, 2, table.len())
+	_, ok = table.lookup("b")
+	assert.False(t, ok)
+	_, ok = table.lookup("a")
+	assert.True(t, ok)
+}
+
+func TestXClient_SessionExpiry(t *testing.T) {
+	a := startEchoServer(t, &Echo{name: "a"})
+	b := startEchoServer(t, &Echo{name: "b"})
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	rebinds := 0
+	xc.SetSessionOptions(SessionOptions{TTL: time.Minute, Now: clock.Now, OnRebind: func(string, string, string) { rebinds++ }})
+
+	ctx := WithSessionKey(context.Background(), "alice")
+	var first, reply string
+	assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &first))
+	clock.Advance(2 * time.Minute)
+	// 过期后按轮询重新选择，得到另一个服务器，不回调
+	assert.Nil(t, xc.Call(ctx, "Echo.Name", 0, &reply))
+	assert.NotEqual(t, first, reply)
+	assert.Equal(t, 0, rebinds)
+}
@@ -0,0 +1,99 @@
+package xclient
+
+import (
+	"context"
+	"log"
+)
+
+// warmUpConcurrency WarmUp 同时进行的连接数
+const warmUpConcurrency = 8
+
+// WarmUp 并发地连接 Discovery 返回的所有服务器并缓存客户端，避免第一次调用承担连接与握手的延迟
+// 返回连接失败的服务器及其错误，全部成功时返回空 map，GetAll 出错时以空字符串为键返回该错误
+// 预热失败不影响之后按需连接
+func (xc *XClient) WarmUp(ctx context.Context) map[string]error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return map[string]error{"": err}
+	}
+	return xc.warm(ctx, servers)
+}
+
+// SetAutoWarmUp 开启后，d 实现了 Watcher 时新加入的服务器会在后台自动预热
+func (xc *XClient) SetAutoWarmUp(enabled bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.autoWarm = enabled
+}
+
+type warmResult struct {
+	addr string
+	err  error
+}
+
+// warm 连接 servers 中的服务器，ctx 结束时尚未完成的服务器记为 ctx.Err()，已经开始的连接在后台继续
+func (xc *XClient) warm(ctx context.Context, servers []string) map[string]error {
+	sem := newSemaphore(warmUpConcurrency)
+	results := make(chan warmResult, len(servers))
+	pending := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		pending[addr] = true
+	}
+	go func() {
+		for _, addr := range servers {
+			if !sem.acquire(ctx) {
+				return
+			}
+			go func(addr string) {
+				defer sem.release()
+				_, err := xc.dial(context.Background(), addr)
+				results <- warmResult{addr: addr, err: err}
+			}(addr)
+		}
+	}()
+
+	errs := make(map[string]error)
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.addr)
+			if r.err != nil {
+				errs[r.addr] = &DialError{Addr: r.addr, Err: r.err}
+			}
+		case <-ctx.Done():
+			for addr := range pending {
+				errs[addr] = ctx.Err()
+			}
+			return errs
+		}
+	}
+	return errs
+}
+
+// autoWarmUp 在后台预热 servers 中还没有连接的服务器
+func (xc *XClient) autoWarmUp(servers []string) {
+	xc.mu.Lock()
+	if !xc.autoWarm || xc.closed {
+		xc.mu.Unlock()
+		return
+	}
+	cached := make(map[string]bool, len(xc.clients))
+	for key := range xc.clients {
+		cached[addrOf(key)] = true
+	}
+	var fresh []string
+	for _, addr := range servers {
+		if !cached[addr] {
+			fresh = append(fresh, addr)
+		}
+	}
+	xc.mu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+	go func() {
+		for addr, err := range xc.warm(context.Background(), fresh) {
+			log.Printf("rpc client: warm up %s error: %v", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+// countDials 统计 xc 建立连接的次数
+func countDials(xc *XClient) *int32 {
+	var n int32
+	dial := xc.xdial
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		atomic.AddInt32(&n, 1)
+		return dial(rpcAddr, opts...)
+	}
+	return &n
+}
+
+func TestXClient_WarmUp(t *testing.T) {
+	var servers []string
+	for i := 0; i < 3; i++ {
+		_, addr := startServer(t, geerpc.WorkerPool{})
+		servers = append(servers, addr)
+	}
+	dead := deadAddr(t)
+	xc := NewXClient(NewMultiServerDiscovery(append(servers, dead)), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	dials := countDials(xc)
+
+	errs := xc.WarmUp(context.Background())
+	assert.Len(t, errs, 1)
+	var de *DialError
+	assert.True(t, errors.As(errs[dead], &de))
+	assert.Equal(t, int32(4), atomic.LoadInt32(dials))
+
+	// 预热之后的调用不再连接
+	for _, addr := range servers {
+		var reply int
+		assert.Nil(t, xc.CallServer(context.Background(), addr, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+		assert.Equal(t, 3, reply)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(dials))
+
+	// 预热失败的服务器之后仍然按需连接
+	assert.NotNil(t, xc.CallServer(context.Background(), dead, "Foo.Sum", Args{}, new(int)))
+	assert.Equal(t, int32(5), atomic.LoadInt32(dials))
+}
+
+func TestXClient_WarmUpCanceled(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	release := make(chan struct{})
+	dial := xc.xdial
+	xc.xdial = func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error) {
+		<-release
+		return dial(rpcAddr, opts...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	errs := xc.WarmUp(ctx)
+	assert.Equal(t, context.DeadlineExceeded, errs[addr])
+
+	// 已经开始的连接在后台完成并被缓存
+	close(release)
+	assert.Eventually(t, func() bool {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return xc.clients[addr] != nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestXClient_AutoWarmUp(t *testing.T) {
+	_, addr := startServer(t, geerpc.WorkerPool{})
+	_, added := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{addr})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	assert.Empty(t, xc.WarmUp(context.Background()))
+	dials := countDials(xc)
+	xc.SetAutoWarmUp(true)
+
+	assert.Nil(t, d.Update([]string{addr, added}))
+	assert.Eventually(t, func() bool {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return xc.clients[added] != nil
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(dials))
+}
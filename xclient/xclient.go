@@ -2,103 +2,425 @@ package xclient
 
 import (
 	"context"
-	. "github.com/yqchilde/gee-rpc"
+	"errors"
 	"io"
+	"math/rand"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	geerpc "github.com/yqchilde/gee-rpc"
 )
 
 type XClient struct {
 	d       Discovery
 	mode    SelectMode
-	opt     *Option
+	opt     *geerpc.Option
 	mu      sync.Mutex
-	clients map[string]*Client
+	clients map[string]*geerpc.Client // 键见 resolveOption
+	dialing map[string]*dialCall      // 正在连接的服务器，同一服务器的并发调用只连接一次
+	// draining 已经从缓存中移除、等待进行中的调用完成后关闭的客户端
+	draining     map[*geerpc.Client]struct{}
+	drainTimeout time.Duration
+	maxConnAge   time.Duration                // 受 mu 保护
+	staleLoad    time.Duration                // 上报的负载的有效期，见 SetLoadStaleAfter，受 mu 保护
+	expires      map[*geerpc.Client]time.Time // 缓存的客户端超过 maxConnAge 的时间
+	closed       bool
+	load         *loadTracker // 发往各个服务器的调用的负载
+
+	// xdial 建立到服务器的连接，测试时替换
+	xdial func(rpcAddr string, opts ...*geerpc.Option) (*geerpc.Client, error)
+
+	failover   FailoverPolicy   // 受 mu 保护
+	broadcast  BroadcastOptions // 受 mu 保护
+	breakers   *breakerSet      // 没有启用熔断器时为 nil，受 mu 保护
+	sessions   *sessionTable    // 会话粘滞的绑定，受 mu 保护
+	r          *rand.Rand       // 重试时选择服务器，受 mu 保护
+	autoWarm   bool             // 自动预热新加入的服务器，受 mu 保护
+	resolveOpt OptionResolver   // 为每个服务器解析连接的 Option，受 mu 保护
+	locality   *localityPicker  // 按区域优先选择服务器，没有设置区域时为 nil，受 mu 保护
+	filter     ServerFilter     // 默认的过滤条件，受 mu 保护
+	filtered   *filteredPicker  // 在满足过滤条件的服务器中选择
+	hook       atomic.Value     // 保存 hookBox，见 SetMetricsHook
+
+	reporterStop chan struct{} // 停止 SetStatsReporter 启动的 goroutine，受 mu 保护
+
+	unwatch   func()        // 取消对 Discovery 的订阅，d 没有实现 Watcher 时为 nil
+	watchDone chan struct{} // 订阅的 goroutine 结束时关闭
 }
 
-var _ io.Closer = (*Client)(nil)
+var (
+	_ io.Closer     = (*XClient)(nil)
+	_ geerpc.Caller = (*XClient)(nil)
+)
+
+// NewXClient d 实现了 Watcher 时，服务器从列表中移除后立即不再选择它，进行中的调用完成后关闭到它的连接
+func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option) *XClient {
+	xc := &XClient{
+		d:            d,
+		mode:         mode,
+		opt:          opt,
+		clients:      make(map[string]*geerpc.Client),
+		dialing:      make(map[string]*dialCall),
+		draining:     make(map[*geerpc.Client]struct{}),
+		expires:      make(map[*geerpc.Client]time.Time),
+		drainTimeout: defaultDrainTimeout,
+		staleLoad:    defaultLoadStaleAfter,
+		xdial:        geerpc.XDial,
+		load:         newLoadTracker(),
+		failover:     FailoverPolicy{Retryable: IsRetryable},
+		broadcast:    BroadcastOptions{FailFast: true},
+		sessions:     newSessionTable(SessionOptions{}),
+		filtered:     &filteredPicker{d: NewMultiServerDiscovery(nil)},
+		r:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if w, ok := d.(Watcher); ok {
+		var updates <-chan []string
+		updates, xc.unwatch = w.Subscribe()
+		xc.watchDone = make(chan struct{})
+		go xc.watch(updates)
+	}
+	return xc
+}
 
-func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{
-		d:       d,
-		mode:    mode,
-		opt:     opt,
-		clients: make(map[string]*Client),
+// watch 不再使用已经不在服务器列表中的服务器的连接，进行中的调用完成后关闭，开启自动预热时连接新加入的服务器
+func (xc *XClient) watch(updates <-chan []string) {
+	defer close(xc.watchDone)
+	// 订阅不会发送当前的列表，以订阅后的列表作为比较服务器列表变化的起点
+	prev, _ := xc.d.GetAll()
+	for servers := range updates {
+		xc.serverListChanged(prev, servers)
+		prev = servers
+		alive := make(map[string]bool, len(servers))
+		for _, addr := range servers {
+			alive[addr] = true
+		}
+		xc.mu.Lock()
+		for key, client := range xc.clients {
+			if !alive[addrOf(key)] {
+				delete(xc.clients, key)
+				xc.drainLocked(client)
+			}
+		}
+		xc.mu.Unlock()
+		xc.autoWarmUp(servers)
 	}
 }
 
 func (xc *XClient) Close() error {
+	if xc.unwatch != nil {
+		xc.unwatch()
+		<-xc.watchDone
+	}
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
+	xc.closed = true
+	if xc.reporterStop != nil {
+		close(xc.reporterStop)
+		xc.reporterStop = nil
+	}
 	for key, client := range xc.clients {
 		// I have no idea how to deal with error, just ignore it.
 		_ = client.Close()
 		delete(xc.clients, key)
 	}
+	for client := range xc.draining {
+		_ = client.Close()
+	}
+	xc.expires = make(map[*geerpc.Client]time.Time)
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+// dialCall 一次正在进行的连接，done 关闭后 client 与 err 有效
+type dialCall struct {
+	done   chan struct{}
+	client *geerpc.Client
+	err    error
+}
+
+// dial 返回到 rpcAddr 的缓存的客户端，客户端不可用（如连接已经断开）时关闭并重新连接，
+// 超过 MaxConnAge 时在后台建立新的连接并继续使用旧的客户端。
+// 连接在单独的 goroutine 中进行，同一服务器的并发调用等待同一次连接，连接的超时时间为 Option.ConnectTimeout；
+// ctx 先结束时返回 ctx.Err()，连接在后台继续，成功后被缓存
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*geerpc.Client, error) {
+	opt, key := xc.resolveOption(rpcAddr)
 	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client, ok := xc.clients[rpcAddr]
-	if ok && !client.IsAvailable() {
+	if xc.closed {
+		xc.mu.Unlock()
+		return nil, geerpc.ErrShutdown
+	}
+	if client, ok := xc.clients[key]; ok {
+		if client.IsAvailable() {
+			if _, dialing := xc.dialing[key]; !dialing && xc.expiredLocked(client) {
+				c := &dialCall{done: make(chan struct{})}
+				xc.dialing[key] = c
+				go xc.finishDial(c, key, rpcAddr, opt)
+			}
+			xc.mu.Unlock()
+			return client, nil
+		}
 		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
+		delete(xc.clients, key)
+		delete(xc.expires, client)
 	}
-	if client == nil {
-		var err error
-		client, err = XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
+	c, ok := xc.dialing[key]
+	if !ok {
+		c = &dialCall{done: make(chan struct{})}
+		xc.dialing[key] = c
+		go xc.finishDial(c, key, rpcAddr, opt)
+	}
+	xc.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.client, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// finishDial 完成 c 对应的连接，成功时缓存客户端
+func (xc *XClient) finishDial(c *dialCall, key, rpcAddr string, opt *geerpc.Option) {
+	start := time.Now()
+	c.client, c.err = xc.xdial(rpcAddr, opt)
+	xc.load.dialed(rpcAddr, c.err)
+	if h := xc.metricsHook(); h != nil {
+		safeHook(func() { h.OnDial(rpcAddr, time.Since(start), c.err) })
+	}
+	xc.mu.Lock()
+	delete(xc.dialing, key)
+	if c.err == nil {
+		if xc.closed {
+			// 连接期间 xc 已经关闭
+			_ = c.client.Close()
+			c.client, c.err = nil, geerpc.ErrShutdown
+		} else {
+			// 关闭使用旧的 Option 连接到同一服务器的客户端
+			for k, client := range xc.clients {
+				if k != key && addrOf(k) == rpcAddr {
+					delete(xc.clients, k)
+					xc.drainLocked(client)
+				}
+			}
+			xc.cacheLocked(key, c.client)
 		}
-		xc.clients[rpcAddr] = client
 	}
-	return client, nil
+	xc.mu.Unlock()
+	close(c.done)
 }
 
+// call 向 rpcAddr 发出调用，熔断器打开时返回 ErrBreakerOpen
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+	bs := xc.breakerSet()
+	if !bs.allow(rpcAddr) {
+		return ErrBreakerOpen
+	}
+	err := xc.invoke(rpcAddr, ctx, serviceMethod, args, reply)
+	bs.record(rpcAddr, err)
+	return err
+}
+
+// CallServer 绕过负载均衡，直接调用 rpcAddr 上的命名函数，rpcAddr 不必在 Discovery 中
+// 与其他调用共用到该服务器的连接，结果同样计入统计与熔断器，但熔断器打开时仍会发出调用，也不会重试
+func (xc *XClient) CallServer(ctx context.Context, rpcAddr, serviceMethod string, args, reply interface{}) error {
+	err := xc.invoke(rpcAddr, ctx, serviceMethod, args, reply)
+	xc.breakerSet().record(rpcAddr, err)
+	return err
+}
+
+// callBroadcast 广播中的调用，设置了 BreakerOptions.BroadcastOpen 时不检查熔断器
+func (xc *XClient) callBroadcast(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	bs := xc.breakerSet()
+	if bs == nil || !bs.opts.BroadcastOpen {
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	}
+	err := xc.invoke(rpcAddr, ctx, serviceMethod, args, reply)
+	bs.record(rpcAddr, err)
+	return err
+}
+
+// invoke 连接 rpcAddr 并发出调用，连接失败也计入统计，结果报告给 Discovery
+func (xc *XClient) invoke(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	start := time.Now()
+	done := xc.load.start(rpcAddr)
+	defer func() {
+		done(err)
+		xc.report(rpcAddr, err)
+		if h := xc.metricsHook(); h != nil {
+			safeHook(func() { h.OnCall(rpcAddr, serviceMethod, time.Since(start), err) })
+		}
+	}()
+	client, err := xc.dial(ctx, rpcAddr)
 	if err != nil {
-		return nil
+		return &DialError{Addr: rpcAddr, Err: err}
 	}
 	return client.Call(ctx, serviceMethod, args, reply)
 }
 
+// Stats 返回 xc 调用过的各个服务器的统计，键为服务器地址，包括重试与广播中的调用
+func (xc *XClient) Stats() map[string]ServerStats {
+	return xc.load.snapshot()
+}
+
+type hashKey struct{}
+
+// WithHashKey 返回携带一致性哈希 key 的 ctx，使用 ConsistentHashSelect 时，相同 key 的调用会选择相同的服务器
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// get 选择调用的服务器，ctx 中有会话 key 时使用会话绑定的服务器
+func (xc *XClient) get(ctx context.Context) (string, error) {
+	if key, ok := ctx.Value(sessionKey{}).(string); ok && key != "" {
+		return xc.getSession(ctx, key)
+	}
+	return xc.pick(ctx)
+}
+
+// pick 按负载均衡策略从满足过滤条件的服务器中选择，设置了区域时优先选择本区域的服务器
+func (xc *XClient) pick(ctx context.Context) (string, error) {
+	filter := xc.filterFor(ctx)
+	xc.mu.Lock()
+	p, fp := xc.locality, xc.filtered
+	xc.mu.Unlock()
+	if filter == nil && p == nil {
+		return xc.pickFrom(ctx, xc.d)
+	}
+	instances, err := xc.instances(filter)
+	if err != nil {
+		return "", err
+	}
+	if p != nil {
+		return xc.pickLocal(ctx, p, instances)
+	}
+	return fp.pick(ctx, xc, instances)
+}
+
+// pickFrom 按负载均衡策略从 d 中选择服务器，d 实现了 KeyedDiscovery 时传递 ctx 中的 key
+// 依赖调用负载的模式由 XClient 从 d 的所有服务器中选择
+func (xc *XClient) pickFrom(ctx context.Context, d Discovery) (string, error) {
+	if xc.mode == LeastLoadedSelect {
+		return xc.pickLeastLoaded(d)
+	}
+	if xc.mode == LeastActiveSelect || xc.mode == PowerOfTwoSelect {
+		servers, err := d.GetAll()
+		if err != nil {
+			return "", err
+		}
+		if len(servers) == 0 {
+			return "", errors.New("rpc discovery: no available servers")
+		}
+		if xc.mode == LeastActiveSelect {
+			return xc.load.leastActive(servers), nil
+		}
+		return xc.load.powerOfTwo(servers), nil
+	}
+	if key, ok := ctx.Value(hashKey{}).(string); ok {
+		if kd, ok := d.(KeyedDiscovery); ok {
+			return kd.GetWithKey(xc.mode, key)
+		}
+	}
+	return d.Get(xc.mode)
+}
+
 // Call 调用命名函数，等待它完成，并返回其错误状态
-// xc 会选择合适的服务器
+// xc 会选择合适的服务器；调用失败且错误可以重试时按 FailoverPolicy 换其他服务器重试，返回最后一次的错误。
+// 熔断器打开的服务器被跳过，不计入尝试次数；ctx 设置了 WithHedging 时发出对冲请求。
+// ctx 有截止时间时，每次尝试按 FailoverPolicy.Budget 分得一部分剩余时间，超时的尝试在没有发出请求
+// 或调用被标记为幂等时重试
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	h, hedged := hedgingFrom(ctx)
+	if hedged && !isIdempotent(ctx) {
+		return ErrHedgingNotIdempotent
+	}
+	rpcAddr, err := xc.get(ctx)
 	if err != nil {
 		return err
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	if hedged {
+		return xc.callHedged(ctx, h, rpcAddr, serviceMethod, args, reply)
+	}
+	xc.mu.Lock()
+	policy := xc.failover
+	xc.mu.Unlock()
+	info, _ := ctx.Value(callInfoKey{}).(*CallInfo)
+	tried := make(map[string]bool)
+	for attempt := 1; ; attempt++ {
+		tried[rpcAddr] = true
+		actx, cancel, budget := policy.attemptContext(ctx, xc.attemptsLeft(ctx, policy, attempt, tried))
+		start := policy.now()
+		err = xc.call(rpcAddr, actx, serviceMethod, args, reply)
+		timedOut := err != nil && actx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if info != nil {
+			info.Attempts = append(info.Attempts, Attempt{
+				Addr: rpcAddr, Start: start, Budget: budget, Duration: policy.now().Sub(start), Err: err,
+			})
+		}
+		if errors.Is(err, ErrBreakerOpen) {
+			attempt--
+			if next, ok := xc.next(ctx, tried); ok {
+				rpcAddr = next
+				continue
+			}
+			return err
+		}
+		if timedOut {
+			var de *DialError
+			if !errors.As(err, &de) && !isIdempotent(ctx) {
+				// 请求可能已经被处理
+				return err
+			}
+		} else if err == nil || ctx.Err() != nil || !policy.Retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+		next, ok := xc.next(ctx, tried)
+		if !ok {
+			return err
+		}
+		rpcAddr = next
+	}
 }
 
+// Broadcast 向所有服务器调用命名函数，任意一个调用成功时将其应答写入 reply，返回第一个错误
+// 并发数、超时时间以及第一个错误是否取消其余的调用见 SetBroadcastOptions
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := xc.d.GetAll()
+	servers, err := xc.servers(ctx)
 	if err != nil {
 		return err
 	}
+	servers = xc.broadcastServers(servers)
+	opts, ctx, cancel := xc.broadcastContext(ctx)
+	defer cancel()
+	sem := newSemaphore(opts.MaxConcurrency)
 	var wg sync.WaitGroup
-	var mu sync.Mutex // protect a and replyDone
+	var mu sync.Mutex // protect e and replyDone
 	var e error
 	replyDone := reply == nil
-	ctx, cancel := context.WithCancel(ctx)
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
+			var err error
 			var clonedReply interface{}
-			if reply != nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			if sem.acquire(ctx) {
+				if reply != nil {
+					clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+				}
+				err = xc.callBroadcast(rpcAddr, ctx, serviceMethod, args, clonedReply)
+				sem.release()
+			} else {
+				err = ctx.Err()
 			}
-			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
 				e = err
-				cancel() // if any call failed, cancel unfinished calls
+				if opts.FailFast {
+					cancel() // if any call failed, cancel unfinished calls
+				}
 			}
 			if err == nil && !replyDone {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
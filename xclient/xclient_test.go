@@ -0,0 +1,344 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	geerpc "github.com/yqchilde/gee-rpc"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func startServer(t *testing.T, pool geerpc.WorkerPool) (*geerpc.Server, string) {
+	server := geerpc.NewServerWithOptions(geerpc.ServerOptions{WorkerPool: pool})
+	_ = server.Register(new(Foo))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, "tcp@" + l.Addr().String()
+}
+
+func TestXClient_BusyFailover(t *testing.T) {
+	busy, busyAddr := startServer(t, geerpc.WorkerPool{Size: 1})
+	_, idleAddr := startServer(t, geerpc.WorkerPool{})
+
+	// 占用 busy 唯一的 worker，之后的请求都会收到 ErrServerBusy
+	client, err := geerpc.XDial(busyAddr)
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+	blocking := client.Go("Foo.Sleep", 300*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{busyAddr, idleAddr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		assert.Equal(t, i+1, reply)
+	}
+	assert.True(t, busy.Stats().TotalErrors >= 1, "the busy server should have been tried")
+	assert.Nil(t, (<-blocking.Done).Error)
+}
+
+func TestXClient_HashKey(t *testing.T) {
+	a, aAddr := startServer(t, geerpc.WorkerPool{})
+	b, bAddr := startServer(t, geerpc.WorkerPool{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{aAddr, bAddr}), ConsistentHashSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	for _, key := range []string{"alice", "bob", "carol"} {
+		before := a.Stats().TotalRequests
+		ctx := WithHashKey(context.Background(), key)
+		for i := 0; i < 5; i++ {
+			var reply int
+			assert.Nil(t, xc.Call(ctx, "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+		}
+		n := a.Stats().TotalRequests - before
+		assert.True(t, n == 0 || n == 5, "all calls with key %q go to one server, a got %d", key, n)
+	}
+	assert.Equal(t, uint64(15), a.Stats().TotalRequests+b.Stats().TotalRequests)
+}
+
+// Lag 每次调用耗时固定的服务
+type Lag time.Duration
+
+func (l Lag) Work(n int, reply *int) error {
+	time.Sleep(time.Duration(l))
+	*reply = n
+	return nil
+}
+
+func startLagServer(t *testing.T, d time.Duration) (*geerpc.Server, string) {
+	server := geerpc.NewServer()
+	assert.Nil(t, server.RegisterName("Lag", Lag(d)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, "tcp@" + l.Addr().String()
+}
+
+// runLoad 并发地通过 xc 发起调用，返回各个服务器收到的请求数
+func runLoad(t *testing.T, xc *XClient, servers []*geerpc.Server) []uint64 {
+	var wg sync.WaitGroup
+	for g := 0; g < 6; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 30; i++ {
+				var reply int
+				assert.Nil(t, xc.Call(context.Background(), "Lag.Work", i, &reply))
+			}
+		}()
+	}
+	wg.Wait()
+	counts := make([]uint64, len(servers))
+	for i, s := range servers {
+		counts[i] = s.Stats().TotalRequests
+	}
+	return counts
+}
+
+func TestXClient_LeastActive(t *testing.T) {
+	slow, slowAddr := startLagServer(t, 40*time.Millisecond)
+	fast1, fast1Addr := startLagServer(t, time.Millisecond)
+	fast2, fast2Addr := startLagServer(t, time.Millisecond)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{slowAddr, fast1Addr, fast2Addr}), LeastActiveSelect, nil)
+	defer func() { _ = xc.Close() }()
+	counts := runLoad(t, xc, []*geerpc.Server{slow, fast1, fast2})
+	assert.Equal(t, uint64(180), counts[0]+counts[1]+counts[2])
+	assert.True(t, counts[0]*3 < counts[1] && counts[0]*3 < counts[2], "slow server should get far fewer calls: %v", counts)
+}
+
+func TestXClient_PowerOfTwo(t *testing.T) {
+	// slowShare 返回慢服务器收到的请求的比例
+	slowShare := func(mode SelectMode) (float64, map[string]ServerStats, string) {
+		slow, slowAddr := startLagServer(t, 40*time.Millisecond)
+		fast1, fast1Addr := startLagServer(t, time.Millisecond)
+		fast2, fast2Addr := startLagServer(t, time.Millisecond)
+		xc := NewXClient(NewMultiServerDiscovery([]string{slowAddr, fast1Addr, fast2Addr}), mode, nil)
+		defer func() { _ = xc.Close() }()
+		counts := runLoad(t, xc, []*geerpc.Server{slow, fast1, fast2})
+		return float64(counts[0]) / float64(counts[0]+counts[1]+counts[2]), xc.Stats(), slowAddr
+	}
+	random, _, _ := slowShare(RandomSelect)
+	p2c, stats, slowAddr := slowShare(PowerOfTwoSelect)
+	assert.True(t, p2c < random/2, "traffic should skew away from the slow server: p2c %.2f, random %.2f", p2c, random)
+	assert.Equal(t, 3, len(stats))
+	for addr, load := range stats {
+		assert.Equal(t, int64(0), load.InFlight, addr)
+		if addr == slowAddr {
+			assert.True(t, load.Latency >= 40*time.Millisecond, "%s: %v", addr, load.Latency)
+		} else {
+			assert.True(t, load.Latency < 40*time.Millisecond, "%s: %v", addr, load.Latency)
+		}
+	}
+
+	single := NewXClient(NewMultiServerDiscovery([]string{"tcp@a"}), PowerOfTwoSelect, nil)
+	addr, err := single.get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp@a", addr, "single server list")
+	two := NewXClient(NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"}), PowerOfTwoSelect, nil)
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		addr, err = two.get(context.Background())
+		assert.Nil(t, err)
+		seen[addr] = true
+	}
+	assert.Equal(t, 2, len(seen), "two servers without load are both chosen")
+}
+
+func TestXClient_WatchClosesRemoved(t *testing.T) {
+	_, aAddr := startServer(t, geerpc.WorkerPool{})
+	_, bAddr := startServer(t, geerpc.WorkerPool{})
+	d := NewMultiServerDiscovery([]string{aAddr, bAddr})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	for i := 0; i < 2; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply))
+	}
+	xc.mu.Lock()
+	removed := xc.clients[bAddr]
+	assert.Len(t, xc.clients, 2)
+	xc.mu.Unlock()
+
+	assert.Nil(t, d.Update([]string{aAddr}))
+	for i := 0; i < 100 && removed.IsAvailable(); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.False(t, removed.IsAvailable(), "connection to the removed server is closed")
+	xc.mu.Lock()
+	_, ok := xc.clients[aAddr]
+	assert.True(t, ok)
+	assert.Len(t, xc.clients, 1)
+	xc.mu.Unlock()
+
+	assert.Nil(t, xc.Close())
+	assert.Nil(t, xc.Close())
+}
+
+func TestXClient_Stats(t *testing.T) {
+	_, fastAddr := startLagServer(t, 5*time.Millisecond)
+	_, slowAddr := startLagServer(t, 30*time.Millisecond)
+	dead := deadAddr(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{fastAddr, slowAddr, dead}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	reports := make(chan map[string]ServerStats, 10)
+	xc.SetStatsReporter(20*time.Millisecond, func(stats map[string]ServerStats) {
+		select {
+		case reports <- stats:
+		default:
+		}
+	})
+
+	for i := 0; i < 9; i++ {
+		var reply int
+		assert.Nil(t, xc.Call(context.Background(), "Lag.Work", 1, &reply))
+	}
+	results, err := xc.BroadcastDetailed(context.Background(), "Lag.Work", 1, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+
+	// 轮询中三次选中 dead，失败后在其他服务器上重试，重试与广播都计入统计
+	stats := xc.Stats()
+	fast, slow := stats[fastAddr], stats[slowAddr]
+	assert.Equal(t, ServerStats{Calls: 4, Errors: 4}, ServerStats{Calls: stats[dead].Calls, Errors: stats[dead].Errors})
+	assert.Equal(t, uint64(11), fast.Calls+slow.Calls)
+	assert.Equal(t, uint64(0), fast.Errors+slow.Errors)
+	assert.True(t, slow.Latency > fast.Latency, "slow %v, fast %v", slow.Latency, fast.Latency)
+	assert.True(t, slow.P50 >= 30*time.Millisecond, "slow p50 %v", slow.P50)
+	assert.True(t, fast.P50 < 30*time.Millisecond, "fast p50 %v", fast.P50)
+	assert.True(t, slow.P99 >= slow.P50)
+
+	select {
+	case stats = <-reports:
+		assert.NotEmpty(t, stats)
+	case <-time.After(time.Second):
+		t.Fatal("stats reporter was not called")
+	}
+	xc.SetStatsReporter(0, nil)
+}
+
+// Whoami 返回服务器自己的监听地址
+type Whoami struct{ addr string }
+
+func (w *Whoami) Addr(args int, reply *string) error {
+	*reply = w.addr
+	return nil
+}
+
+func TestXClient_CallServer(t *testing.T) {
+	var servers []string
+	for i := 0; i < 3; i++ {
+		server, addr := startServer(t, geerpc.WorkerPool{})
+		assert.Nil(t, server.Register(&Whoami{addr: addr}))
+		servers = append(servers, addr)
+	}
+	d := NewMultiServerDiscovery(servers[:2])
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	all, err := d.GetAll()
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		for _, addr := range append(all, servers[2]) {
+			var reply string
+			assert.Nil(t, xc.CallServer(context.Background(), addr, "Whoami.Addr", 0, &reply))
+			assert.Equal(t, addr, reply)
+		}
+	}
+	// 复用连接，调用计入统计
+	xc.mu.Lock()
+	assert.Len(t, xc.clients, 3)
+	xc.mu.Unlock()
+	stats := xc.Stats()
+	for _, addr := range servers {
+		assert.Equal(t, uint64(2), stats[addr].Calls, addr)
+	}
+
+	// 错误计入熔断器，打开后仍可以直接调用
+	xc.SetBreaker(BreakerOptions{MaxFailures: 1, Cooldown: time.Hour})
+	dead := deadAddr(t)
+	var de *DialError
+	assert.True(t, errors.As(xc.CallServer(context.Background(), dead, "Whoami.Addr", 0, new(string)), &de))
+	assert.Equal(t, BreakerOpen, xc.Breakers()[dead])
+	assert.Equal(t, uint64(1), xc.Stats()[dead].Errors)
+	assert.True(t, errors.As(xc.CallServer(context.Background(), dead, "Whoami.Addr", 0, new(string)), &de))
+}
+
+func TestXClient_Redial(t *testing.T) {
+	server := geerpc.NewServer()
+	_ = server.Register(new(Foo))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply))
+	xc.mu.Lock()
+	stale := xc.clients[addr]
+	xc.mu.Unlock()
+
+	// 在同一地址上重启服务器，缓存的客户端断开后应当被替换
+	_ = server.Close()
+	assert.Eventually(t, func() bool { return !stale.IsAvailable() }, time.Second, 5*time.Millisecond)
+	server = geerpc.NewServer()
+	_ = server.Register(new(Foo))
+	l, err = net.Listen("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+
+	assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: 3, Num2: 4}, &reply))
+	assert.Equal(t, 7, reply)
+	xc.mu.Lock()
+	assert.NotSame(t, stale, xc.clients[addr])
+	xc.mu.Unlock()
+}
+
+func TestXClient_DialOnce(t *testing.T) {
+	server, addr := startServer(t, geerpc.WorkerPool{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			assert.Nil(t, xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply))
+			assert.Equal(t, 2*i, reply)
+		}(i)
+	}
+	wg.Wait()
+	// 并发调用只建立一个连接
+	assert.Equal(t, uint64(1), server.Stats().TotalConnections)
+
+	assert.Nil(t, xc.Close())
+	xc.mu.Lock()
+	assert.Empty(t, xc.clients)
+	xc.mu.Unlock()
+	assert.True(t, errors.Is(xc.Call(context.Background(), "Foo.Sum", Args{}, new(int)), geerpc.ErrShutdown))
+}